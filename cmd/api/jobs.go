@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"projeto-vendas/internal/storage"
+)
+
+// stockSnapshotInterval é o intervalo entre fotografias do valor do stock. Cada execução
+// substitui a fotografia do dia, por isso a última antes da meia-noite fica como fecho.
+const stockSnapshotInterval = time.Hour
+
+// startStockSnapshotJob grava a fotografia diária do stock ao arrancar e depois
+// periodicamente, em segundo plano.
+func startStockSnapshotJob(s *storage.Storage) {
+	go func() {
+		for {
+			rows, err := s.TakeStockSnapshot(time.Now())
+			if err != nil {
+				log.Printf("Erro ao gravar a fotografia do stock: %v", err)
+			} else {
+				log.Printf("Fotografia do stock gravada (%d linhas).", rows)
+			}
+			time.Sleep(stockSnapshotInterval)
+		}
+	}()
+}
+
+// scheduledPriceInterval é o intervalo entre verificações dos preços agendados; uma
+// alteração para hoje entra em vigor, no máximo, este tempo depois da meia-noite.
+const scheduledPriceInterval = 15 * time.Minute
+
+// startScheduledPriceJob aplica em segundo plano as alterações de preço agendadas cuja
+// data efetiva já chegou.
+func startScheduledPriceJob(s *storage.Storage) {
+	go func() {
+		for {
+			applied, err := s.ApplyScheduledPriceChanges(time.Now())
+			if err != nil {
+				log.Printf("Erro ao aplicar os preços agendados: %v", err)
+			} else if applied > 0 {
+				log.Printf("Preços agendados aplicados: %d.", applied)
+			}
+			time.Sleep(scheduledPriceInterval)
+		}
+	}()
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"projeto-vendas/internal/handlers"
+	"projeto-vendas/internal/images"
 	"projeto-vendas/internal/storage"
 )
 
@@ -63,12 +64,27 @@ func main() {
 	}
 	defer storageLayer.Dbpool.Close()
 
+	startStockSnapshotJob(storageLayer)
+	startScheduledPriceJob(storageLayer)
+
 	h := handlers.NewHandler(storageLayer)
 
+	// Imagens dos produtos em disco local (IMAGES_DIR), servidas em /imagens/produtos.
+	imagesDir := os.Getenv("IMAGES_DIR")
+	if imagesDir == "" {
+		imagesDir = "data/imagens"
+	}
+	imageStore, err := images.NewLocalStore(imagesDir, "/imagens/produtos")
+	if err != nil {
+		log.Fatalf("Falha ao inicializar o armazenamento de imagens: %v", err)
+	}
+	h.Images = imageStore
+
 	router := gin.Default()
 	store := cookie.NewStore([]byte("super-secret-key"))
 	router.Use(sessions.Sessions("mysession", store))
 	router.StaticFS("/static", http.Dir("web/static"))
+	router.Static("/imagens/produtos", imagesDir)
 
 	router.SetFuncMap(map[string]interface{}{
 		"dict": func(values ...interface{}) (map[string]interface{}, error) {
@@ -102,6 +118,18 @@ func main() {
 	{
 		stockRoutes.GET("/dashboard", h.ShowEstoquistaDashboard)
 		stockRoutes.POST("/add", h.HandleAddStockItem)
+		stockRoutes.GET("/contagens", h.ShowInventoryCountsPage)
+		stockRoutes.POST("/contagens/nova", h.HandleCreateInventoryCount)
+		stockRoutes.GET("/contagens/:id", h.ShowInventoryCountEntryPage)
+		stockRoutes.POST("/contagens/:id/lancar", h.HandleAddInventoryCountEntry)
+		stockRoutes.POST("/contagens/:id/folha", h.HandleAddInventoryCountSheet)
+		stockRoutes.POST("/contagens/:id/fechar", h.HandleCloseInventoryCount)
+		stockRoutes.GET("/validades", h.ShowExpiryReportPage)
+		stockRoutes.GET("/parado", h.ShowSlowMovingPage)
+		stockRoutes.GET("/etiquetas", h.HandlePrintLabels)
+		stockRoutes.GET("/etiquetas/codigo/:id", h.HandleBarcodeImage)
+		stockRoutes.GET("/ajustes", h.ShowStockAdjustmentsPage)
+		stockRoutes.POST("/ajustes/novo", h.HandleCreateStockAdjustment)
 	}
 	
 	salesRoutes := router.Group("/vendas")
@@ -123,15 +151,67 @@ func main() {
 		adminRoutes.POST("/socios/delete/:id", h.HandleDeleteSocio)
 		adminRoutes.POST("/socios/edit/:id", h.HandleEditSocio)
 		adminRoutes.POST("/users/add", h.HandleAddUser)
+		adminRoutes.POST("/users/archive/:id", h.HandleArchiveUser)
+		adminRoutes.POST("/users/restore/:id", h.HandleRestoreUser)
 		adminRoutes.POST("/users/delete/:id", h.HandleDeleteUser)
 		adminRoutes.POST("/users/edit/:id", h.HandleEditUser)
 		adminRoutes.POST("/products/add", h.HandleAddProduct)
+		adminRoutes.POST("/products/archive/:id", h.HandleArchiveProduct)
+		adminRoutes.POST("/products/restore/:id", h.HandleRestoreProduct)
 		adminRoutes.POST("/products/delete/:id", h.HandleDeleteProduct)
 		adminRoutes.POST("/products/edit/:id", h.HandleEditProduct)
+		adminRoutes.POST("/products/imagem/:id", h.HandleUploadProductImage)
+		adminRoutes.POST("/products/imagem/:id/delete", h.HandleDeleteProductImage)
+		adminRoutes.GET("/products/variantes/:id", h.ShowVariantsPage)
+		adminRoutes.POST("/products/variantes/:id", h.HandleAddVariant)
+		adminRoutes.POST("/variantes/edit/:id", h.HandleEditVariant)
+		adminRoutes.GET("/products/codigos/:id", h.ShowProductBarcodesPage)
+		adminRoutes.POST("/products/codigos/:id", h.HandleAddProductBarcode)
+		adminRoutes.POST("/products/codigos/:id/delete", h.HandleDeleteProductBarcode)
+		adminRoutes.GET("/products/fornecedores/:id", h.ShowProductSuppliersPage)
+		adminRoutes.POST("/products/fornecedores/:id", h.HandleSaveProductSupplier)
+		adminRoutes.POST("/products/fornecedores/:id/delete", h.HandleRemoveProductSupplier)
+		adminRoutes.GET("/products/kit/:id", h.ShowKitPage)
+		adminRoutes.POST("/products/kit/:id", h.HandleSetKitComponent)
+		adminRoutes.POST("/products/kit/:id/delete", h.HandleRemoveKitComponent)
 		adminRoutes.POST("/stock/update", h.HandleUpdateStock)
 		adminRoutes.POST("/stock/add", h.HandleAddStockItem)
+		adminRoutes.GET("/api/pricing/preview", h.HandlePricePreview)
+		adminRoutes.GET("/api/ncm", h.HandleSearchNCM)
 		adminRoutes.GET("/api/products/:id/stock", h.HandleGetProductStock)
 		adminRoutes.POST("/api/stock/set", h.HandleSetStock)
+		adminRoutes.GET("/api/stock/value", h.HandleGetStockValueAt)
+		adminRoutes.POST("/api/stock/adjust", h.HandleAPIStockAdjust)
+		adminRoutes.GET("/reposicao", h.ShowReplenishmentPage)
+		adminRoutes.POST("/reposicao/encomendas", h.HandleCreatePurchaseOrderDrafts)
+		adminRoutes.GET("/encomendas", h.ShowPurchaseOrdersPage)
+		adminRoutes.POST("/encomendas/delete/:id", h.HandleDeletePurchaseOrderDraft)
+		adminRoutes.GET("/api/forecast", h.HandleGetForecast)
+		adminRoutes.GET("/api/forecast/backtest", h.HandleGetForecastBacktest)
+		adminRoutes.GET("/abc", h.ShowABCPage)
+		adminRoutes.POST("/abc/calcular", h.HandleRunABC)
+		adminRoutes.GET("/abc/export", h.HandleExportABC)
+		adminRoutes.GET("/precos", h.ShowBranchPricesPage)
+		adminRoutes.POST("/precos/ajuste", h.HandleApplyBranchPriceChange)
+		adminRoutes.POST("/precos/produto/:id", h.HandleSaveBranchPrices)
+		adminRoutes.GET("/precos/agenda", h.ShowPriceSchedulePage)
+		adminRoutes.POST("/precos/agendar", h.HandleSchedulePriceChange)
+		adminRoutes.POST("/precos/agenda/cancelar/:id", h.HandleCancelScheduledPriceChange)
+		adminRoutes.GET("/api/products/:id/price-history", h.HandleGetPriceHistory)
+		adminRoutes.GET("/categorias", h.ShowCategoriesPage)
+		adminRoutes.POST("/categorias", h.HandleAddCategory)
+		adminRoutes.POST("/categorias/rename/:id", h.HandleRenameCategory)
+		adminRoutes.POST("/categorias/delete/:id", h.HandleDeleteCategory)
+		adminRoutes.POST("/categorias/estrategia/:id", h.HandleSetCategoryPricingStrategy)
+		adminRoutes.GET("/produtos/importar", h.ShowProductImportPage)
+		adminRoutes.POST("/produtos/importar", h.HandleProductImport)
+		adminRoutes.GET("/api/produtos/importar/:id", h.HandleProductImportStatus)
+		adminRoutes.GET("/produtos/exportar", h.HandleProductExport)
+		adminRoutes.GET("/contagens/:id", h.ShowInventoryCountReviewPage)
+		adminRoutes.POST("/contagens/:id/aprovar", h.HandleApproveInventoryCount)
+		adminRoutes.POST("/contagens/:id/cancelar", h.HandleCancelInventoryCount)
+		adminRoutes.POST("/ajustes/:id/aprovar", h.HandleApproveStockAdjustment)
+		adminRoutes.POST("/ajustes/:id/rejeitar", h.HandleRejectStockAdjustment)
 	}
 
 	salesApiRoutes := router.Group("/api/sales")
@@ -151,6 +231,8 @@ func main() {
 		apiRoutes.GET("/products/filter", h.HandleFilterProducts)
 		apiRoutes.GET("/stock/low", h.HandleGetLowStockProducts) // NOVA ROTA
 		apiRoutes.GET("/products/details", h.HandleGetProductDetails) // NOVA ROTA
+		apiRoutes.GET("/stock/expiring", h.HandleGetExpiringLots)
+		apiRoutes.GET("/stock/slow-moving", h.HandleGetSlowMovingStock)
 		apiRoutes.POST("/chat", h.HandleAIChat)
 	}
 
@@ -8,10 +8,16 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/joho/godotenv"
+	"projeto-vendas/internal/catalog"
+	"projeto-vendas/internal/fiscal"
+	"projeto-vendas/internal/forecast"
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/storage"
 )
 
 // O script SQL para inicializar o banco de dados com a estrutura multi-filial.
@@ -135,10 +141,622 @@ CREATE TABLE IF NOT EXISTS socios (
         ON DELETE CASCADE
 );
 
+-- NOVAS TABELAS PARA CONTAGEM DE INVENTÁRIO --
+
+-- Sessões de contagem física por filial
+CREATE TABLE IF NOT EXISTS contagens_inventario (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    filial_id UUID NOT NULL,
+    descricao TEXT,
+    secao VARCHAR(100),
+    status VARCHAR(20) NOT NULL DEFAULT 'aberta' CHECK (status IN ('aberta', 'em_revisao', 'aprovada', 'cancelada')),
+    criado_por UUID NOT NULL,
+    aprovado_por UUID,
+    motivo_ajuste TEXT,
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_aprovacao TIMESTAMPTZ,
+    CONSTRAINT fk_filial_contagem
+        FOREIGN KEY(filial_id)
+        REFERENCES filiais(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_criador_contagem
+        FOREIGN KEY(criado_por)
+        REFERENCES usuarios(id)
+        ON DELETE RESTRICT,
+    CONSTRAINT fk_aprovador_contagem
+        FOREIGN KEY(aprovado_por)
+        REFERENCES usuarios(id)
+        ON DELETE SET NULL
+);
+
+-- Fotografia (snapshot) das quantidades esperadas no momento da abertura da contagem
+CREATE TABLE IF NOT EXISTS contagem_itens (
+    contagem_id UUID NOT NULL,
+    produto_id UUID NOT NULL,
+    quantidade_esperada INT NOT NULL,
+    custo_unitario DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    PRIMARY KEY (contagem_id, produto_id),
+    CONSTRAINT fk_contagem_item
+        FOREIGN KEY(contagem_id)
+        REFERENCES contagens_inventario(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_produto_contagem_item
+        FOREIGN KEY(produto_id)
+        REFERENCES produtos(id)
+        ON DELETE CASCADE
+);
+
+-- Lançamentos cegos feitos pelos estoquistas (vários por produto são somados)
+CREATE TABLE IF NOT EXISTS contagem_lancamentos (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    contagem_id UUID NOT NULL,
+    produto_id UUID NOT NULL,
+    usuario_id UUID NOT NULL,
+    secao VARCHAR(100),
+    quantidade INT NOT NULL CHECK (quantidade >= 0),
+    data_lancamento TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_contagem_lancamento
+        FOREIGN KEY(contagem_id, produto_id)
+        REFERENCES contagem_itens(contagem_id, produto_id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_usuario_lancamento
+        FOREIGN KEY(usuario_id)
+        REFERENCES usuarios(id)
+        ON DELETE RESTRICT
+);
+
+-- NOVA TABELA PARA LOTES E VALIDADES --
+
+-- Lotes recebidos por produto e filial; a soma dos lotes nunca excede o saldo em estoque_filiais
+CREATE TABLE IF NOT EXISTS lotes_estoque (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    produto_id UUID NOT NULL,
+    filial_id UUID NOT NULL,
+    numero_lote VARCHAR(50),
+    data_validade DATE,
+    quantidade INT NOT NULL CHECK (quantidade >= 0),
+    data_entrada TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_estoque_lote
+        FOREIGN KEY(produto_id, filial_id)
+        REFERENCES estoque_filiais(produto_id, filial_id)
+        ON DELETE CASCADE
+);
+
+-- CUSTO MÉDIO PONDERADO --
+
+-- Custo médio móvel, recalculado em cada entrada de stock (global e por filial)
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS custo_medio DECIMAL(12, 4);
+ALTER TABLE estoque_filiais ADD COLUMN IF NOT EXISTS custo_medio DECIMAL(12, 4);
+-- Custo de entrada de cada lote e custo no momento da venda (para o CMV)
+ALTER TABLE lotes_estoque ADD COLUMN IF NOT EXISTS custo_unitario DECIMAL(12, 4);
+ALTER TABLE itens_venda ADD COLUMN IF NOT EXISTS custo_unitario DECIMAL(12, 4);
+-- Quando ativo, a valorização usa o custo médio de cada filial em vez do global
+ALTER TABLE empresa ADD COLUMN IF NOT EXISTS custo_medio_por_filial BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- CONTROLO DE CONCORRÊNCIA DO STOCK --
+
+-- Incrementada em cada alteração da quantidade; edições manuais só gravam se a versão lida
+-- ainda for a atual. Registos inexistentes são lidos como versão 0, por isso começa em 1.
+ALTER TABLE estoque_filiais ADD COLUMN IF NOT EXISTS versao INT NOT NULL DEFAULT 1;
+
+-- FOTOGRAFIAS DIÁRIAS DO VALOR DO STOCK --
+
+-- Uma linha por dia, filial e categoria; a última fotografia do dia representa o fecho
+CREATE TABLE IF NOT EXISTS estoque_snapshots (
+    data DATE NOT NULL,
+    filial_id UUID NOT NULL,
+    categoria VARCHAR(100) NOT NULL,
+    quantidade BIGINT NOT NULL,
+    valor DECIMAL(14, 2) NOT NULL,
+    data_registo TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (data, filial_id, categoria),
+    CONSTRAINT fk_filial_snapshot
+        FOREIGN KEY(filial_id)
+        REFERENCES filiais(id)
+        ON DELETE CASCADE
+);
+
+-- AJUSTES DE STOCK (QUEBRAS E PERDAS) --
+
+ALTER TABLE empresa ADD COLUMN IF NOT EXISTS limite_aprovacao_ajuste DECIMAL(10, 2) NOT NULL DEFAULT 200;
+
+-- Baixas de stock com motivo; as que excedem o limite ficam pendentes de aprovação
+CREATE TABLE IF NOT EXISTS ajustes_estoque (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    produto_id UUID NOT NULL,
+    filial_id UUID NOT NULL,
+    quantidade INT NOT NULL CHECK (quantidade > 0),
+    motivo VARCHAR(20) NOT NULL CHECK (motivo IN ('quebra', 'furto', 'validade', 'uso_interno')),
+    observacao TEXT,
+    custo_unitario DECIMAL(12, 4) NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pendente' CHECK (status IN ('pendente', 'aprovado', 'rejeitado')),
+    solicitado_por UUID NOT NULL,
+    aprovado_por UUID,
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_decisao TIMESTAMPTZ,
+    CONSTRAINT fk_produto_ajuste
+        FOREIGN KEY(produto_id)
+        REFERENCES produtos(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_filial_ajuste
+        FOREIGN KEY(filial_id)
+        REFERENCES filiais(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_solicitante_ajuste
+        FOREIGN KEY(solicitado_por)
+        REFERENCES usuarios(id)
+        ON DELETE RESTRICT,
+    CONSTRAINT fk_aprovador_ajuste
+        FOREIGN KEY(aprovado_por)
+        REFERENCES usuarios(id)
+        ON DELETE SET NULL
+);
+
+-- CLASSIFICAÇÃO ABC (PARETO) --
+
+-- Resultado do último cálculo por âmbito: filial_id NULL é a classificação geral.
+-- Classes por participação acumulada na receita e na margem de contribuição do período.
+CREATE TABLE IF NOT EXISTS classificacao_abc (
+    produto_id UUID NOT NULL,
+    filial_id UUID,
+    data_inicio DATE NOT NULL,
+    data_fim DATE NOT NULL,
+    quantidade BIGINT NOT NULL DEFAULT 0,
+    receita DECIMAL(14, 2) NOT NULL DEFAULT 0,
+    margem DECIMAL(14, 2) NOT NULL DEFAULT 0,
+    participacao_receita DECIMAL(9, 6) NOT NULL DEFAULT 0,
+    acumulado_receita DECIMAL(9, 6) NOT NULL DEFAULT 0,
+    classe_receita CHAR(1) NOT NULL CHECK (classe_receita IN ('A', 'B', 'C')),
+    participacao_margem DECIMAL(9, 6) NOT NULL DEFAULT 0,
+    acumulado_margem DECIMAL(9, 6) NOT NULL DEFAULT 0,
+    classe_margem CHAR(1) NOT NULL CHECK (classe_margem IN ('A', 'B', 'C')),
+    data_calculo TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_produto_abc
+        FOREIGN KEY(produto_id)
+        REFERENCES produtos(id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_filial_abc
+        FOREIGN KEY(filial_id)
+        REFERENCES filiais(id)
+        ON DELETE CASCADE
+);
+
+-- ETIQUETAS --
+
+-- Última alteração do preço de venda, para reimprimir só as etiquetas de prateleira afetadas
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS data_alteracao_preco TIMESTAMPTZ;
+
+-- VARIANTES DE PRODUTO --
+
+-- Uma variante (tamanho, cor, ...) é um produto com produto_pai_id: tem código de barras,
+-- preço e stock próprios, e o produto pai agrupa-as nos relatórios. Há um só nível.
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS produto_pai_id UUID REFERENCES produtos(id) ON DELETE CASCADE;
+-- Lista ordenada de atributos, p.ex. [{"nome": "Tamanho", "valor": "M"}, {"nome": "Cor", "valor": "Azul"}]
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS atributos_variante JSONB NOT NULL DEFAULT '[]';
+-- Preço próprio da variante; NULL segue o preço do produto pai. O preco_sugerido da
+-- variante é sempre o preço efetivo, COALESCE(preco_variante, preço do pai): o gatilho
+-- leva as alterações de preço do pai às variantes sem preço próprio.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'produtos' AND column_name = 'preco_variante') THEN
+        ALTER TABLE produtos ADD COLUMN preco_variante DECIMAL(10, 2) CHECK (preco_variante >= 0);
+        -- Até aqui o preço da variante era uma cópia: as que diferem do pai têm preço próprio.
+        UPDATE produtos v SET preco_variante = v.preco_sugerido
+        FROM produtos pai
+        WHERE v.produto_pai_id = pai.id AND v.preco_sugerido <> pai.preco_sugerido;
+    END IF;
+END $$;
+
+CREATE OR REPLACE FUNCTION propagar_preco_variantes() RETURNS trigger AS $$
+BEGIN
+    UPDATE produtos
+    SET preco_sugerido = NEW.preco_sugerido, data_alteracao_preco = NOW(), data_atualizacao = NOW()
+    WHERE produto_pai_id = NEW.id AND preco_variante IS NULL AND preco_sugerido <> NEW.preco_sugerido;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_preco_variantes ON produtos;
+CREATE TRIGGER trg_preco_variantes AFTER UPDATE OF preco_sugerido ON produtos
+    FOR EACH ROW WHEN (NEW.produto_pai_id IS NULL AND NEW.preco_sugerido IS DISTINCT FROM OLD.preco_sugerido)
+    EXECUTE FUNCTION propagar_preco_variantes();
+
+-- CÓDIGOS DE BARRAS ADICIONAIS --
+
+-- Outros códigos do mesmo produto (EAN de outro fornecedor, DUN-14 da caixa). O multiplicador
+-- é o número de unidades que uma leitura do código representa, p.ex. 12 numa caixa de 12.
+-- O código principal continua em produtos.codigo_barras.
+CREATE TABLE IF NOT EXISTS produto_codigos_barras (
+    codigo VARCHAR(100) PRIMARY KEY,
+    produto_id UUID NOT NULL,
+    multiplicador INT NOT NULL DEFAULT 1 CHECK (multiplicador > 0),
+    descricao VARCHAR(100),
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_produto_codigo FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE
+);
+
+-- Um código lido no terminal tem de levar a um único produto: o código principal de um
+-- produto não pode ser o adicional de outro, e vice-versa. As chaves únicas só cobrem
+-- cada tabela, por isso a verificação cruzada fica num gatilho nas duas.
+CREATE OR REPLACE FUNCTION verificar_codigo_barras_unico() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME = 'produtos' THEN
+        IF TG_OP = 'UPDATE' THEN
+            IF NEW.codigo_barras IS NOT DISTINCT FROM OLD.codigo_barras THEN
+                RETURN NEW;
+            END IF;
+        END IF;
+        IF EXISTS (SELECT 1 FROM produto_codigos_barras WHERE codigo = NEW.codigo_barras) THEN
+            RAISE EXCEPTION 'o código de barras % já está associado a um produto', NEW.codigo_barras
+                USING ERRCODE = 'unique_violation', CONSTRAINT = 'codigo_barras_repetido';
+        END IF;
+    ELSIF EXISTS (SELECT 1 FROM produtos WHERE codigo_barras = NEW.codigo) THEN
+        RAISE EXCEPTION 'o código de barras % já está associado a um produto', NEW.codigo
+            USING ERRCODE = 'unique_violation', CONSTRAINT = 'codigo_barras_repetido';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_codigo_barras_produtos ON produtos;
+CREATE TRIGGER trg_codigo_barras_produtos BEFORE INSERT OR UPDATE OF codigo_barras ON produtos
+    FOR EACH ROW EXECUTE FUNCTION verificar_codigo_barras_unico();
+DROP TRIGGER IF EXISTS trg_codigo_barras_adicionais ON produto_codigos_barras;
+CREATE TRIGGER trg_codigo_barras_adicionais BEFORE INSERT OR UPDATE OF codigo ON produto_codigos_barras
+    FOR EACH ROW EXECUTE FUNCTION verificar_codigo_barras_unico();
+
+-- PREÇOS POR FILIAL --
+
+-- Preço de venda próprio de uma filial; sem registo, a filial vende a preco_sugerido.
+CREATE TABLE IF NOT EXISTS precos_filiais (
+    produto_id UUID NOT NULL,
+    filial_id UUID NOT NULL,
+    preco DECIMAL(10, 2) NOT NULL CHECK (preco >= 0),
+    data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (produto_id, filial_id),
+    CONSTRAINT fk_produto_preco FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE,
+    CONSTRAINT fk_filial_preco FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE
+);
+
+-- HISTÓRICO E AGENDAMENTO DE PREÇOS --
+
+-- Cada alteração do preço de venda, do custo ou do preço próprio de uma filial. O registo é
+-- feito por trigger; quem altera indica o utilizador e a origem com set_config('app.usuario_id')
+-- e set_config('app.origem_preco') na mesma transação.
+CREATE TABLE IF NOT EXISTS historico_precos (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    produto_id UUID NOT NULL,
+    filial_id UUID, -- NULL: preço de catálogo ou custo
+    campo VARCHAR(20) NOT NULL, -- 'preco', 'preco_custo', 'custo_medio' ou 'preco_filial'
+    valor_anterior DECIMAL(12, 4), -- NULL: sem preço próprio antes
+    valor_novo DECIMAL(12, 4), -- NULL: preço próprio removido
+    usuario_id UUID,
+    origem VARCHAR(30) NOT NULL,
+    data_alteracao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_produto_historico FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE,
+    CONSTRAINT fk_filial_historico FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE,
+    CONSTRAINT fk_usuario_historico FOREIGN KEY(usuario_id) REFERENCES usuarios(id) ON DELETE SET NULL
+);
+
+CREATE OR REPLACE FUNCTION registar_historico_precos() RETURNS trigger AS $$
+DECLARE
+    usuario UUID := NULLIF(current_setting('app.usuario_id', true), '')::uuid;
+    origem TEXT := COALESCE(NULLIF(current_setting('app.origem_preco', true), ''), 'sistema');
+BEGIN
+    IF TG_TABLE_NAME = 'produtos' THEN
+        IF NEW.preco_sugerido IS DISTINCT FROM OLD.preco_sugerido THEN
+            INSERT INTO historico_precos (produto_id, campo, valor_anterior, valor_novo, usuario_id, origem)
+            VALUES (NEW.id, 'preco', OLD.preco_sugerido, NEW.preco_sugerido, usuario, origem);
+        END IF;
+        IF NEW.preco_custo IS DISTINCT FROM OLD.preco_custo THEN
+            INSERT INTO historico_precos (produto_id, campo, valor_anterior, valor_novo, usuario_id, origem)
+            VALUES (NEW.id, 'preco_custo', OLD.preco_custo, NEW.preco_custo, usuario, origem);
+        END IF;
+        IF NEW.custo_medio IS DISTINCT FROM OLD.custo_medio THEN
+            INSERT INTO historico_precos (produto_id, campo, valor_anterior, valor_novo, usuario_id, origem)
+            VALUES (NEW.id, 'custo_medio', OLD.custo_medio, NEW.custo_medio, usuario, origem);
+        END IF;
+    ELSIF TG_OP = 'DELETE' THEN
+        -- Ao apagar o produto ou a filial, o histórico vai com eles.
+        IF EXISTS (SELECT 1 FROM produtos WHERE id = OLD.produto_id) AND EXISTS (SELECT 1 FROM filiais WHERE id = OLD.filial_id) THEN
+            INSERT INTO historico_precos (produto_id, filial_id, campo, valor_anterior, valor_novo, usuario_id, origem)
+            VALUES (OLD.produto_id, OLD.filial_id, 'preco_filial', OLD.preco, NULL, usuario, origem);
+        END IF;
+    ELSIF TG_OP = 'INSERT' OR NEW.preco IS DISTINCT FROM OLD.preco THEN
+        INSERT INTO historico_precos (produto_id, filial_id, campo, valor_anterior, valor_novo, usuario_id, origem)
+        VALUES (NEW.produto_id, NEW.filial_id, 'preco_filial', CASE WHEN TG_OP = 'UPDATE' THEN OLD.preco END, NEW.preco, usuario, origem);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS trg_historico_precos_produtos ON produtos;
+CREATE TRIGGER trg_historico_precos_produtos AFTER UPDATE OF preco_sugerido, preco_custo, custo_medio ON produtos
+    FOR EACH ROW EXECUTE FUNCTION registar_historico_precos();
+DROP TRIGGER IF EXISTS trg_historico_precos_filiais ON precos_filiais;
+CREATE TRIGGER trg_historico_precos_filiais AFTER INSERT OR UPDATE OF preco OR DELETE ON precos_filiais
+    FOR EACH ROW EXECUTE FUNCTION registar_historico_precos();
+
+-- Alterações de preço com data futura, aplicadas pela tarefa periódica da API.
+-- filial_id NULL altera o preço de catálogo; caso contrário o preço próprio da filial.
+CREATE TABLE IF NOT EXISTS precos_agendados (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    produto_id UUID NOT NULL,
+    filial_id UUID,
+    preco DECIMAL(10, 2) NOT NULL CHECK (preco >= 0),
+    data_efetiva DATE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pendente', -- 'pendente', 'aplicada', 'cancelada' ou 'falhou'
+    erro TEXT, -- motivo da falha, com status 'falhou'
+    usuario_id UUID NOT NULL,
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    data_aplicacao TIMESTAMPTZ,
+    CONSTRAINT fk_produto_agendado FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE,
+    CONSTRAINT fk_filial_agendado FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE,
+    CONSTRAINT fk_usuario_agendado FOREIGN KEY(usuario_id) REFERENCES usuarios(id) ON DELETE RESTRICT
+);
+
+-- CATEGORIAS HIERÁRQUICAS --
+
+-- Departamento (nível 1) > categoria (2) > subcategoria (3). caminho guarda o nome completo,
+-- p.ex. "Mercearia > Bebidas > Sucos", e é recalculado pela aplicação quando um nome muda.
+CREATE TABLE IF NOT EXISTS categorias (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    nome VARCHAR(100) NOT NULL,
+    pai_id UUID,
+    nivel SMALLINT NOT NULL DEFAULT 1 CHECK (nivel BETWEEN 1 AND 3),
+    caminho TEXT NOT NULL,
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_categoria_pai FOREIGN KEY(pai_id) REFERENCES categorias(id) ON DELETE RESTRICT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_categorias_pai_nome ON categorias(COALESCE(pai_id, '00000000-0000-0000-0000-000000000000'), lower(nome));
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS categoria_id UUID REFERENCES categorias(id) ON DELETE RESTRICT;
+-- Secção de uma contagem limitada a uma categoria (e às suas subcategorias)
+ALTER TABLE contagens_inventario ADD COLUMN IF NOT EXISTS categoria_id UUID REFERENCES categorias(id) ON DELETE SET NULL;
+-- As fotografias do stock guardam o caminho completo da categoria
+ALTER TABLE estoque_snapshots ALTER COLUMN categoria TYPE TEXT;
+
+-- A categoria indicada e todas as descendentes; os filtros por categoria incluem as subcategorias.
+CREATE OR REPLACE FUNCTION categorias_descendentes(raiz UUID) RETURNS SETOF UUID AS $$
+    WITH RECURSIVE arvore AS (
+        SELECT id FROM categorias WHERE id = raiz
+        UNION ALL
+        SELECT c.id FROM categorias c JOIN arvore a ON c.pai_id = a.id
+    )
+    SELECT id FROM arvore;
+$$ LANGUAGE sql STABLE;
+
+-- Migração das categorias em texto livre (produtos.categoria e contagens_inventario.secao):
+-- cada nome distinto passa a departamento. Só corre enquanto as colunas antigas existirem.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'produtos' AND column_name = 'categoria') THEN
+        INSERT INTO categorias (nome, caminho)
+        SELECT DISTINCT ON (lower(nome)) nome, nome
+        FROM (
+            SELECT btrim(categoria) AS nome FROM produtos
+            UNION ALL
+            SELECT btrim(secao) FROM contagens_inventario
+        ) antigas
+        WHERE COALESCE(nome, '') <> ''
+        ON CONFLICT DO NOTHING;
+        UPDATE produtos p SET categoria_id = c.id
+        FROM categorias c
+        WHERE c.pai_id IS NULL AND lower(c.nome) = lower(btrim(p.categoria));
+        UPDATE contagens_inventario ci SET categoria_id = c.id
+        FROM categorias c
+        WHERE c.pai_id IS NULL AND lower(c.nome) = lower(btrim(ci.secao));
+        ALTER TABLE produtos DROP COLUMN categoria;
+        ALTER TABLE contagens_inventario DROP COLUMN secao;
+    END IF;
+END $$;
+
+-- PESQUISA DE PRODUTOS --
+
+-- Pesquisa sem acentos: full-text em português (tsvector) e trigramas para nomes
+-- escritos só em parte ou com erros.
+CREATE EXTENSION IF NOT EXISTS unaccent;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- unaccent é STABLE; esta versão IMMUTABLE pode ser usada em índices.
+CREATE OR REPLACE FUNCTION sem_acentos(texto TEXT) RETURNS TEXT AS $$
+    SELECT public.unaccent('public.unaccent'::regdictionary, texto);
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT;
+
+-- Configuração de texto em português que ignora os acentos ("feijao" encontra "Feijão").
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'pt_sem_acentos') THEN
+        CREATE TEXT SEARCH CONFIGURATION pt_sem_acentos (COPY = portuguese);
+        ALTER TEXT SEARCH CONFIGURATION pt_sem_acentos
+            ALTER MAPPING FOR hword, hword_part, word WITH unaccent, portuguese_stem;
+    END IF;
+END $$;
+
+-- O nome pesa mais do que a descrição na ordenação por relevância.
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS pesquisa TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('pt_sem_acentos', nome), 'A') ||
+    setweight(to_tsvector('pt_sem_acentos', COALESCE(descricao, '')), 'B')
+) STORED;
+
+-- ARQUIVO DE PRODUTOS E UTILIZADORES --
+
+-- Em vez de apagados, os produtos e os utilizadores são arquivados: deixam de aparecer no
+-- terminal, no login e nas listas, mas as vendas e os relatórios continuam a referi-los.
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS data_arquivamento TIMESTAMPTZ;
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS data_arquivamento TIMESTAMPTZ;
+
+-- IMAGENS DOS PRODUTOS --
+
+-- Chave da imagem do produto; os ficheiros (imagem e miniatura) ficam no armazenamento de
+-- imagens, fora da base de dados. As variantes sem imagem usam a do produto pai.
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS imagem VARCHAR(100);
+
+-- KITS --
+
+-- Um kit (cesta de presentes, "kit limpeza") é um produto composto por outros produtos.
+-- Não tem stock próprio: a disponibilidade vem do stock dos componentes na filial e a
+-- venda de um kit dá baixa nos componentes. Um componente não pode ser outro kit.
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS kit BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS kit_componentes (
+    kit_id UUID NOT NULL,
+    componente_id UUID NOT NULL,
+    quantidade INT NOT NULL CHECK (quantidade > 0),
+    PRIMARY KEY (kit_id, componente_id),
+    CHECK (kit_id <> componente_id),
+    CONSTRAINT fk_kit FOREIGN KEY(kit_id) REFERENCES produtos(id) ON DELETE CASCADE,
+    CONSTRAINT fk_kit_componente FOREIGN KEY(componente_id) REFERENCES produtos(id) ON DELETE RESTRICT
+);
+
+-- Componentes consumidos em cada item de venda de um kit, com o custo unitário de cada um
+-- na data da venda. O custo do item do kit é a soma destes custos.
+CREATE TABLE IF NOT EXISTS itens_venda_componentes (
+    item_venda_id UUID NOT NULL,
+    produto_id UUID NOT NULL,
+    quantidade INT NOT NULL CHECK (quantidade > 0),
+    custo_unitario DECIMAL(12, 4),
+    PRIMARY KEY (item_venda_id, produto_id),
+    CONSTRAINT fk_item_venda_kit FOREIGN KEY(item_venda_id) REFERENCES itens_venda(id) ON DELETE CASCADE,
+    CONSTRAINT fk_item_venda_componente FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE RESTRICT
+);
+
+-- ESTRATÉGIAS DE PREÇO --
+
+-- Forma de calcular o preço sugerido (markup ou margem, impostos por fora ou por dentro,
+-- arredondamento e margem mínima), em JSON. NULL herda: o produto usa a da categoria e a
+-- categoria a da categoria acima; sem nenhuma, vale o markup com impostos por fora.
+ALTER TABLE categorias ADD COLUMN IF NOT EXISTS estrategia_preco JSONB;
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS estrategia_preco JSONB;
+
+-- Estratégia efetiva de uma categoria: a dela ou a da categoria mais próxima acima.
+CREATE OR REPLACE FUNCTION estrategia_preco_categoria(categoria UUID) RETURNS JSONB AS $$
+    WITH RECURSIVE acima AS (
+        SELECT id, pai_id, estrategia_preco, 0 AS distancia FROM categorias WHERE id = categoria
+        UNION ALL
+        SELECT c.id, c.pai_id, c.estrategia_preco, a.distancia + 1
+        FROM categorias c JOIN acima a ON c.id = a.pai_id
+    )
+    SELECT estrategia_preco FROM acima WHERE estrategia_preco IS NOT NULL ORDER BY distancia LIMIT 1;
+$$ LANGUAGE sql STABLE;
+
+-- CÓDIGOS DE BARRAS INTERNOS --
+
+-- Numeração dos códigos internos (prefixo 200, circulação restrita GS1) dos produtos sem
+-- código do fabricante. A sequência nunca repete um número, mesmo com pedidos em simultâneo.
+CREATE SEQUENCE IF NOT EXISTS codigos_barras_internos_seq MAXVALUE 999999999;
+
+-- CLASSIFICAÇÃO FISCAL --
+
+-- Tabela NCM de referência, só com os códigos de 8 dígitos, carregada do ficheiro JSON do
+-- Siscomex com -carregar-ncm: a consulta e a validação dos NCM funcionam sem internet.
+CREATE TABLE IF NOT EXISTS ncm (
+    codigo VARCHAR(8) PRIMARY KEY CHECK (codigo ~ '^[0-9]{8}$'),
+    descricao TEXT NOT NULL,
+    data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Classificação fiscal de cada produto, com os códigos só com dígitos. O NCM tem de existir
+-- na tabela NCM; o formato dos restantes é validado pela aplicação (pacote fiscal).
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS ncm VARCHAR(8) CONSTRAINT fk_produto_ncm REFERENCES ncm(codigo);
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS cest VARCHAR(7);
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS cfop VARCHAR(4);
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS origem SMALLINT NOT NULL DEFAULT 0 CHECK (origem BETWEEN 0 AND 8);
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS cst_icms VARCHAR(3);
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS cst_pis VARCHAR(2);
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS cst_cofins VARCHAR(2);
+
+-- UNIDADES DE MEDIDA --
+
+-- O stock e as vendas contam-se na unidade de venda; as compras podem vir na unidade de
+-- compra, que vale fator_compra unidades de venda (p.ex. CX com 12 UN). A conversão é
+-- feita na entrada de stock.
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS unidade VARCHAR(2) NOT NULL DEFAULT 'UN' CHECK (unidade IN ('UN', 'CX', 'KG', 'L'));
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS unidade_compra VARCHAR(2) CHECK (unidade_compra IN ('UN', 'CX', 'KG', 'L'));
+ALTER TABLE produtos ADD COLUMN IF NOT EXISTS fator_compra INT NOT NULL DEFAULT 1 CHECK (fator_compra > 0);
+
+-- FORNECEDORES --
+
+CREATE TABLE IF NOT EXISTS fornecedores (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    nome VARCHAR(150) UNIQUE NOT NULL,
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Catálogo de cada fornecedor: o mesmo produto pode vir de vários, cada um com o seu código,
+-- custo e condições. O custo é por unidade de venda do produto (atualizado nas entradas do
+-- fornecedor) e a quantidade mínima está na unidade de compra. O preferencial, no máximo um
+-- por produto, é o usado na reposição.
+CREATE TABLE IF NOT EXISTS produto_fornecedores (
+    produto_id UUID NOT NULL,
+    fornecedor_id UUID NOT NULL,
+    codigo_fornecedor VARCHAR(60),
+    ultimo_custo DECIMAL(12, 4),
+    prazo_entrega_dias INT NOT NULL DEFAULT 0 CHECK (prazo_entrega_dias >= 0),
+    quantidade_minima INT NOT NULL DEFAULT 1 CHECK (quantidade_minima > 0),
+    preferencial BOOLEAN NOT NULL DEFAULT FALSE,
+    data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (produto_id, fornecedor_id),
+    CONSTRAINT fk_produto_fornecedor FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE,
+    CONSTRAINT fk_fornecedor_produto FOREIGN KEY(fornecedor_id) REFERENCES fornecedores(id) ON DELETE CASCADE
+);
+-- Fornecedor de cada entrada de stock, quando indicado
+ALTER TABLE lotes_estoque ADD COLUMN IF NOT EXISTS fornecedor_id UUID REFERENCES fornecedores(id) ON DELETE SET NULL;
+
+-- Rascunhos de encomenda criados na reposição: um por fornecedor e filial, com o código, o
+-- último custo e a quantidade (na unidade de venda) de cada produto ao fornecedor preferencial.
+CREATE TABLE IF NOT EXISTS encomendas (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    fornecedor_id UUID NOT NULL,
+    filial_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'rascunho', -- por agora só 'rascunho'
+    criado_por UUID,
+    data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_fornecedor_encomenda FOREIGN KEY(fornecedor_id) REFERENCES fornecedores(id) ON DELETE CASCADE,
+    CONSTRAINT fk_filial_encomenda FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE,
+    CONSTRAINT fk_criador_encomenda FOREIGN KEY(criado_por) REFERENCES usuarios(id) ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_encomenda_rascunho ON encomendas(fornecedor_id, filial_id) WHERE status = 'rascunho';
+CREATE TABLE IF NOT EXISTS itens_encomenda (
+    encomenda_id UUID NOT NULL,
+    produto_id UUID NOT NULL,
+    codigo_fornecedor VARCHAR(60),
+    quantidade INT NOT NULL CHECK (quantidade > 0),
+    custo_unitario DECIMAL(12, 4),
+    PRIMARY KEY (encomenda_id, produto_id),
+    CONSTRAINT fk_encomenda_item FOREIGN KEY(encomenda_id) REFERENCES encomendas(id) ON DELETE CASCADE,
+    CONSTRAINT fk_produto_encomenda FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE
+);
+
 -- Índices para melhorar a performance
 CREATE INDEX IF NOT EXISTS idx_usuarios_email ON usuarios(email);
 CREATE INDEX IF NOT EXISTS idx_estoque_filial_id ON estoque_filiais(filial_id);
 CREATE INDEX IF NOT EXISTS idx_vendas_filial_id ON vendas(filial_id);
+CREATE INDEX IF NOT EXISTS idx_contagens_filial_id ON contagens_inventario(filial_id);
+CREATE INDEX IF NOT EXISTS idx_contagem_lancamentos_contagem ON contagem_lancamentos(contagem_id);
+CREATE INDEX IF NOT EXISTS idx_lotes_estoque_produto_filial ON lotes_estoque(produto_id, filial_id);
+CREATE INDEX IF NOT EXISTS idx_lotes_estoque_validade ON lotes_estoque(data_validade) WHERE quantidade > 0;
+CREATE INDEX IF NOT EXISTS idx_ajustes_estoque_filial_data ON ajustes_estoque(filial_id, data_criacao);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_classificacao_abc_produto_ambito ON classificacao_abc(produto_id, COALESCE(filial_id, '00000000-0000-0000-0000-000000000000'));
+CREATE INDEX IF NOT EXISTS idx_classificacao_abc_receita ON classificacao_abc(filial_id, classe_receita);
+CREATE INDEX IF NOT EXISTS idx_classificacao_abc_margem ON classificacao_abc(filial_id, classe_margem);
+CREATE INDEX IF NOT EXISTS idx_produtos_alteracao_preco ON produtos(data_alteracao_preco);
+CREATE INDEX IF NOT EXISTS idx_kit_componentes_componente ON kit_componentes(componente_id);
+CREATE INDEX IF NOT EXISTS idx_produtos_pai ON produtos(produto_pai_id) WHERE produto_pai_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_produto_codigos_produto ON produto_codigos_barras(produto_id);
+CREATE INDEX IF NOT EXISTS idx_precos_filiais_filial ON precos_filiais(filial_id);
+CREATE INDEX IF NOT EXISTS idx_historico_precos_produto ON historico_precos(produto_id, data_alteracao);
+CREATE INDEX IF NOT EXISTS idx_historico_precos_data ON historico_precos(data_alteracao);
+CREATE INDEX IF NOT EXISTS idx_precos_agendados_pendentes ON precos_agendados(data_efetiva) WHERE status = 'pendente';
+CREATE INDEX IF NOT EXISTS idx_produtos_categoria ON produtos(categoria_id);
+CREATE INDEX IF NOT EXISTS idx_categorias_pai ON categorias(pai_id);
+CREATE INDEX IF NOT EXISTS idx_produtos_pesquisa ON produtos USING GIN (pesquisa);
+CREATE INDEX IF NOT EXISTS idx_produtos_nome_trgm ON produtos USING GIN (sem_acentos(lower(nome)) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_produtos_codigo_barras_prefixo ON produtos (codigo_barras text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_produtos_arquivados ON produtos(data_arquivamento) WHERE data_arquivamento IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_produtos_ncm ON produtos(ncm) WHERE ncm IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_ncm_descricao_trgm ON ncm USING GIN (sem_acentos(lower(descricao)) gin_trgm_ops);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_produto_fornecedor_preferencial ON produto_fornecedores(produto_id) WHERE preferencial;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_fornecedor_codigo ON produto_fornecedores(fornecedor_id, codigo_fornecedor);
 `
 
 func main() {
@@ -147,6 +765,17 @@ func main() {
 	createFilial := flag.String("filial", "", "Cria uma nova filial com o nome especificado.")
 	filialEndereco := flag.String("endereco", "", "Endereço da nova filial (opcional, usado com -filial).")
 	listFiliais := flag.Bool("list-filiais", false, "Lista todas as filiais existentes com os seus IDs.") // NOVA FLAG
+	snapshot := flag.Bool("snapshot", false, "Grava a fotografia de hoje do valor do stock por filial e categoria.")
+	backtest := flag.Bool("backtest", false, "Avalia a precisão dos modelos de previsão contra o histórico de vendas.")
+	backtestFilial := flag.String("backtest-filial", "", "ID da filial a avaliar (opcional, usado com -backtest).")
+	backtestHorizon := flag.Int("backtest-dias", 7, "Dias previstos em cada origem do backtest.")
+	backtestHistory := flag.Int("backtest-historico", 180, "Dias de histórico usados no backtest.")
+	backtestLimit := flag.Int("backtest-produtos", 20, "Número de pares produto/filial mais vendidos a avaliar.")
+	importProducts := flag.String("importar-produtos", "", "Importa o catálogo de produtos de um ficheiro CSV ou XLSX.")
+	importDryRun := flag.Bool("simular", false, "Com -importar-produtos, mostra o que seria criado, atualizado ou rejeitado sem gravar.")
+	importChunk := flag.Int("bloco", storage.DefaultImportChunkSize, "Linhas gravadas por transação na importação.")
+	exportProducts := flag.String("exportar-produtos", "", "Exporta o catálogo de produtos para um ficheiro CSV ou XLSX.")
+	loadNCM := flag.String("carregar-ncm", "", "Carrega a tabela NCM do ficheiro JSON publicado pelo Siscomex.")
 
 	flag.Parse()
 
@@ -161,8 +790,23 @@ func main() {
 	} else if *listFiliais {
 		log.Println("Flag -list-filiais detectada. A listar as filiais...")
 		runListFiliais()
+	} else if *snapshot {
+		log.Println("Flag -snapshot detectada. A gravar a fotografia do stock...")
+		runStockSnapshot()
+	} else if *backtest {
+		log.Println("Flag -backtest detectada. A avaliar os modelos de previsão...")
+		runForecastBacktest(*backtestFilial, *backtestHorizon, *backtestHistory, *backtestLimit)
+	} else if *importProducts != "" {
+		log.Printf("Flag -importar-produtos detectada. A importar '%s'...", *importProducts)
+		runImportProducts(*importProducts, *importDryRun, *importChunk)
+	} else if *exportProducts != "" {
+		log.Printf("Flag -exportar-produtos detectada. A exportar para '%s'...", *exportProducts)
+		runExportProducts(*exportProducts)
+	} else if *loadNCM != "" {
+		log.Printf("Flag -carregar-ncm detectada. A carregar a tabela NCM de '%s'...", *loadNCM)
+		runLoadNCM(*loadNCM)
 	} else {
-		log.Println("Nenhuma ação especificada. Use -init, -filial, ou -list-filiais.")
+		log.Println("Nenhuma ação especificada. Use -init, -filial, -list-filiais, -snapshot, -backtest, -importar-produtos, -exportar-produtos ou -carregar-ncm.")
 		flag.Usage()
 	}
 }
@@ -209,6 +853,82 @@ func runListFiliais() {
 	fmt.Println()
 }
 
+// runStockSnapshot grava a fotografia diária do stock; pode ser agendada no cron
+// como alternativa ao job do servidor da API.
+func runStockSnapshot() {
+	s, err := storage.NewStorage()
+	if err != nil {
+		log.Fatalf("Não foi possível conectar ao banco de dados: %v\n", err)
+	}
+	defer s.Dbpool.Close()
+
+	rows, err := s.TakeStockSnapshot(time.Now())
+	if err != nil {
+		log.Fatalf("Falha ao gravar a fotografia do stock: %v\n", err)
+	}
+	log.Printf("✅ Fotografia do stock gravada com sucesso (%d linhas).\n", rows)
+}
+
+// runForecastBacktest corre o backtest de cada modelo nos pares produto/filial mais
+// vendidos e imprime o WAPE de cada um e o agregado por modelo.
+func runForecastBacktest(filialID string, horizon, historyDays, limit int) {
+	s, err := storage.NewStorage()
+	if err != nil {
+		log.Fatalf("Não foi possível conectar ao banco de dados: %v\n", err)
+	}
+	defer s.Dbpool.Close()
+
+	items, err := s.GetBestSellingStockItems(filialID, historyDays, limit)
+	if err != nil {
+		log.Fatalf("Falha ao obter os produtos mais vendidos: %v\n", err)
+	}
+	if len(items) == 0 {
+		fmt.Println("Nenhuma venda no período; nada a avaliar.")
+		return
+	}
+
+	y, m, d := time.Now().Date()
+	end := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	absErr := make([]float64, len(forecast.Methods))
+	actual := make([]float64, len(forecast.Methods))
+
+	fmt.Printf("\n--- Backtest: previsão a %d dias, %d dias de histórico ---\n", horizon, historyDays)
+	fmt.Printf("%-30s | %-20s | %12s | %12s | %12s\n", "PRODUTO", "FILIAL", "MÉDIA MÓVEL", "SUAVIZAÇÃO", "SAZONAL")
+	fmt.Println("----------------------------------------------------------------------------------------------------")
+	for _, item := range items {
+		history, err := s.GetDailyUnitSales(item.ProdutoID.String(), item.FilialID.String(), end.AddDate(0, 0, -historyDays), historyDays)
+		if err != nil {
+			log.Fatalf("Falha ao obter o histórico de %s: %v\n", item.ProdutoNome, err)
+		}
+		cells := make([]string, len(forecast.Methods))
+		for i, method := range forecast.Methods {
+			acc, err := forecast.Backtest(history, end, horizon, historyDays/2, method, forecast.Options{})
+			if err != nil {
+				cells[i] = "n/d"
+				continue
+			}
+			cells[i] = fmt.Sprintf("%.1f%%", acc.WAPE)
+			// Os dias avaliados são os que seguem o treino mínimo (metade do histórico).
+			absErr[i] += acc.MAE * float64(acc.DiasAvaliados)
+			for _, v := range history[historyDays/2 : historyDays/2+acc.DiasAvaliados] {
+				actual[i] += v
+			}
+		}
+		fmt.Printf("%-30.30s | %-20.20s | %12s | %12s | %12s\n", item.ProdutoNome, item.FilialNome, cells[0], cells[1], cells[2])
+	}
+	fmt.Println("----------------------------------------------------------------------------------------------------")
+	fmt.Printf("%-53s | %12s | %12s | %12s\n", "WAPE AGREGADO", aggregateWAPE(absErr[0], actual[0]), aggregateWAPE(absErr[1], actual[1]), aggregateWAPE(absErr[2], actual[2]))
+	fmt.Println()
+}
+
+// aggregateWAPE é o erro absoluto total sobre as vendas reais de todos os produtos avaliados.
+func aggregateWAPE(absErr, actual float64) string {
+	if actual == 0 {
+		return "n/d"
+	}
+	return fmt.Sprintf("%.1f%%", absErr/actual*100)
+}
+
 func runCreateFilial(nome string, endereco string) {
 	conn := connectToDB()
 	defer conn.Close(context.Background())
@@ -273,6 +993,80 @@ func runInitScript() {
 	log.Println("Tabelas e dados iniciais criados/verificados com sucesso!")
 }
 
+// runImportProducts importa o catálogo de um ficheiro e imprime as linhas rejeitadas e o
+// resumo. O histórico de preços fica sem utilizador.
+func runImportProducts(path string, dryRun bool, chunkSize int) {
+	format, err := catalog.FormatFromName(path)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Não foi possível ler o ficheiro: %v\n", err)
+	}
+	records, err := catalog.ReadRecords(data, format)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	rows, rejected, err := catalog.Parse(records)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	s, err := storage.NewStorage()
+	if err != nil {
+		log.Fatalf("Não foi possível conectar ao banco de dados: %v\n", err)
+	}
+	defer s.Dbpool.Close()
+
+	progress := func(done int) { log.Printf("%d de %d linhas tratadas.", done, len(rows)) }
+	report, err := s.ImportProducts(rows, "", dryRun, chunkSize, progress)
+	catalog.AddRejected(&report, rejected)
+	for _, r := range report.Resultados {
+		if r.Acao == models.ImportActionReject {
+			fmt.Printf("Linha %d (%s): %s\n", r.Linha, r.CodigoBarras, r.Erro)
+		}
+	}
+	if err != nil {
+		log.Fatalf("🚨 A importação parou: %v (criados %d, atualizados %d antes do erro)\n", err, report.Criados, report.Atualizados)
+	}
+	if dryRun {
+		log.Printf("Simulação: seriam criados %d, atualizados %d e rejeitados %d produtos. Nada foi gravado.\n", report.Criados, report.Atualizados, report.Rejeitados)
+		return
+	}
+	log.Printf("✅ Importação concluída: %d criados, %d atualizados, %d rejeitados.\n", report.Criados, report.Atualizados, report.Rejeitados)
+}
+
+// runExportProducts grava o catálogo num ficheiro, no formato da extensão.
+func runExportProducts(path string) {
+	format, err := catalog.FormatFromName(path)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	s, err := storage.NewStorage()
+	if err != nil {
+		log.Fatalf("Não foi possível conectar ao banco de dados: %v\n", err)
+	}
+	defer s.Dbpool.Close()
+
+	products, err := s.GetProductsForExport()
+	if err != nil {
+		log.Fatalf("Falha ao obter o catálogo: %v\n", err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Não foi possível criar o ficheiro: %v\n", err)
+	}
+	if err := catalog.Write(f, format, products); err != nil {
+		f.Close()
+		log.Fatalf("Falha ao escrever o ficheiro: %v\n", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Falha ao gravar o ficheiro: %v\n", err)
+	}
+	log.Printf("✅ %d produtos exportados para %s.\n", len(products), path)
+}
+
 func connectToDB() *pgx.Conn {
 	err := godotenv.Load()
 	if err != nil {
@@ -292,3 +1086,32 @@ func connectToDB() *pgx.Conn {
 	}
 	return conn
 }
+
+// runLoadNCM carrega a tabela NCM de referência do ficheiro JSON do Siscomex ("Tabela NCM
+// vigente"). Pode voltar a ser corrida a cada nova versão da tabela.
+func runLoadNCM(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Não foi possível ler o ficheiro: %v\n", err)
+	}
+	defer f.Close()
+	table, err := fiscal.ParseNCMTable(f)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	s, err := storage.NewStorage()
+	if err != nil {
+		log.Fatalf("Não foi possível conectar ao banco de dados: %v\n", err)
+	}
+	defer s.Dbpool.Close()
+
+	removed, inUse, err := s.LoadNCMTable(table)
+	if err != nil {
+		log.Fatalf("Falha ao carregar a tabela NCM: %v\n", err)
+	}
+	for _, code := range inUse {
+		fmt.Printf("NCM %s extinto, mas ainda usado por produtos: corrija a classificação desses produtos.\n", fiscal.FormatNCM(code))
+	}
+	log.Printf("✅ Tabela NCM carregada: %d códigos, %d extintos removidos.\n", len(table), removed)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	"golang.org/x/crypto/bcrypt"
+	"projeto-vendas/internal/gtin"
 )
 
 // Estruturas para representar os nossos dados
@@ -122,8 +123,13 @@ func main() {
 	log.Printf("✅ Garantida a existência de %d vendedores.", len(vendedores))
 
 	// Passo 4: Produtos
+	err = createCategorias(dbpool)
+	if err != nil { log.Fatalf("🚨 Erro ao criar departamentos: %v", err) }
+	log.Printf("✅ Garantida a existência de %d departamentos.", len(categorias))
 	log.Printf("⚙️ A gerar %d produtos variados...", numProdutos)
-	produtos := generateProdutos(numProdutos)
+	codigos, err := reserveInternalBarcodes(dbpool, numProdutos)
+	if err != nil { log.Fatalf("🚨 Erro ao reservar códigos de barras: %v", err) }
+	produtos := generateProdutos(codigos)
 	log.Println("✅ Lista de produtos gerada.")
 	log.Println("⏳ A inserir produtos no banco de dados...")
 	err = insertProdutos(dbpool, produtos)
@@ -295,7 +301,7 @@ func createSales(dbpool *pgxpool.Pool, vendedores []User) error {
 		_, err = tx.Exec(context.Background(), sqlItemVenda, vendaID, item.ProdutoID, quantidadeVenda, item.PrecoSugerido)
 		if err != nil { tx.Rollback(context.Background()); continue }
 
-		sqlStock := `UPDATE estoque_filiais SET quantidade = quantidade - $1 WHERE produto_id = $2 AND filial_id = $3`
+		sqlStock := `UPDATE estoque_filiais SET quantidade = quantidade - $1, versao = versao + 1 WHERE produto_id = $2 AND filial_id = $3`
 		_, err = tx.Exec(context.Background(), sqlStock, quantidadeVenda, item.ProdutoID, item.FilialID)
 		if err != nil { tx.Rollback(context.Background()); continue }
 
@@ -366,7 +372,33 @@ func createOrGetFiliais(dbpool *pgxpool.Pool) ([]Filial, error) {
 	return filiais, nil
 }
 
-func generateProdutos(count int) []Produto {
+// reserveInternalBarcodes tira da sequência dos códigos internos os números de count
+// produtos e devolve os respetivos EAN-13, os mesmos que a aplicação gera para os produtos
+// sem código do fabricante. Como vêm da sequência, não se repetem entre execuções.
+func reserveInternalBarcodes(dbpool *pgxpool.Pool, count int) ([]string, error) {
+	rows, err := dbpool.Query(context.Background(), `SELECT nextval('codigos_barras_internos_seq') FROM generate_series(1, $1)`, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	codigos := make([]string, 0, count)
+	for rows.Next() {
+		var sequence int64
+		if err := rows.Scan(&sequence); err != nil {
+			return nil, err
+		}
+		codigo, err := gtin.Internal(sequence)
+		if err != nil {
+			return nil, err
+		}
+		codigos = append(codigos, codigo)
+	}
+	return codigos, rows.Err()
+}
+
+// generateProdutos gera um produto aleatório por cada código de barras indicado.
+func generateProdutos(codigos []string) []Produto {
+	count := len(codigos)
 	produtos := make([]Produto, count)
 	for i := 0; i < count; i++ {
 		catKeys := make([]string, 0, len(categorias))
@@ -397,7 +429,7 @@ func generateProdutos(count int) []Produto {
 			Nome:            nomeCompleto,
 			Descricao:       fmt.Sprintf("Descrição para %s.", nomeCompleto),
 			Categoria:       categoriaNome,
-			CodigoBarras:    strconv.FormatInt(time.Now().UnixNano()+int64(i), 10),
+			CodigoBarras:    codigos[i],
 			CodigoCNAE:      strconv.Itoa(cnaeAleatorio),
 			PrecoCusto:      custo,
 			PercentualLucro: lucro,
@@ -409,10 +441,24 @@ func generateProdutos(count int) []Produto {
 	return produtos
 }
 
+// createCategorias garante um departamento por cada categoria de produtos gerados.
+func createCategorias(dbpool *pgxpool.Pool) error {
+	for nome := range categorias {
+		_, err := dbpool.Exec(context.Background(), `
+			INSERT INTO categorias (nome, nivel, caminho) VALUES ($1, 1, $1)
+			ON CONFLICT DO NOTHING
+		`, nome)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func insertProdutos(dbpool *pgxpool.Pool, produtos []Produto) error {
 	sqlStatement := `
-		INSERT INTO produtos (id, nome, descricao, categoria, codigo_barras, codigo_cnae, preco_custo, percentual_lucro, imposto_estadual, imposto_federal, preco_sugerido) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) 
+		INSERT INTO produtos (id, nome, descricao, categoria_id, codigo_barras, codigo_cnae, preco_custo, percentual_lucro, imposto_estadual, imposto_federal, preco_sugerido) 
+		VALUES ($1, $2, $3, (SELECT id FROM categorias WHERE pai_id IS NULL AND lower(nome) = lower($4)), $5, $6, $7, $8, $9, $10, $11) 
 		ON CONFLICT (nome) DO NOTHING
 	`
 	jobs := make(chan Produto, len(produtos))
@@ -443,7 +489,11 @@ func insertProdutos(dbpool *pgxpool.Pool, produtos []Produto) error {
 // NOVO: Busca todos os produtos que foram realmente inseridos no banco.
 func getInsertedProducts(dbpool *pgxpool.Pool) ([]Produto, error) {
 	var products []Produto
-	sql := `SELECT id, nome, descricao, categoria, codigo_barras, codigo_cnae, preco_custo, percentual_lucro, imposto_estadual, imposto_federal, preco_sugerido FROM produtos`
+	sql := `
+		SELECT p.id, p.nome, p.descricao, COALESCE(c.caminho, ''), p.codigo_barras, p.codigo_cnae, p.preco_custo, p.percentual_lucro, p.imposto_estadual, p.imposto_federal, p.preco_sugerido
+		FROM produtos p
+		LEFT JOIN categorias c ON c.id = p.categoria_id
+	`
 	rows, err := dbpool.Query(context.Background(), sql)
 	if err != nil {
 		return nil, err
@@ -460,7 +510,7 @@ func getInsertedProducts(dbpool *pgxpool.Pool) ([]Produto, error) {
 }
 
 func populateEstoque(dbpool *pgxpool.Pool, produtos []Produto, filiais []Filial) error {
-	sqlStatement := `INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, $3) ON CONFLICT (produto_id, filial_id) DO UPDATE SET quantidade = EXCLUDED.quantidade`
+	sqlStatement := `INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, $3) ON CONFLICT (produto_id, filial_id) DO UPDATE SET quantidade = EXCLUDED.quantidade, versao = estoque_filiais.versao + 1`
 	type estoqueJob struct {
 		produtoID uuid.UUID
 		filialID  uuid.UUID
@@ -0,0 +1,337 @@
+// Package catalog lê e escreve o catálogo de produtos em CSV e XLSX para a importação e
+// a exportação em massa. A validação das linhas é feita aqui; a gravação fica no storage.
+package catalog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"projeto-vendas/internal/fiscal"
+	"projeto-vendas/internal/gtin"
+	"projeto-vendas/internal/models"
+)
+
+// Format é o formato de um ficheiro do catálogo.
+type Format string
+
+const (
+	CSV  Format = "csv"
+	XLSX Format = "xlsx"
+)
+
+// Columns são as colunas do ficheiro, pela ordem da exportação. Na importação a ordem é
+// livre e todas têm de existir, exceto as FiscalColumns; categoria é o caminho completo,
+// p.ex. "Mercearia > Bebidas", e preco_sugerido em branco é calculado a partir do custo.
+var Columns = []string{
+	"codigo_barras", "nome", "descricao", "categoria", "codigo_cnae",
+	"ncm", "cest", "cfop", "origem", "cst_icms", "cst_pis", "cst_cofins",
+	"preco_custo", "percentual_lucro", "imposto_estadual", "imposto_federal", "preco_sugerido",
+}
+
+// FiscalColumns são as colunas da classificação fiscal. Os ficheiros anteriores não as têm:
+// sem nenhuma, a importação não mexe na classificação dos produtos existentes; com alguma,
+// têm de estar todas.
+var FiscalColumns = []string{"ncm", "cest", "cfop", "origem", "cst_icms", "cst_pis", "cst_cofins"}
+
+// firstNumericColumn é a posição de preco_custo em Columns: daí em diante as colunas são
+// numéricas.
+const firstNumericColumn = 12
+
+// Limites das colunas da tabela produtos.
+const (
+	maxNome         = 150
+	maxCodigoBarras = 100
+	maxCodigoCNAE   = 15
+	maxPreco        = 99999999.99 // DECIMAL(10, 2)
+	maxPercentual   = 999.99      // DECIMAL(5, 2)
+)
+
+// FormatFromName deduz o formato pela extensão do ficheiro.
+func FormatFromName(name string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".txt":
+		return CSV, nil
+	case ".xlsx":
+		return XLSX, nil
+	}
+	return "", fmt.Errorf("formato não suportado: %q (use CSV ou XLSX)", filepath.Ext(name))
+}
+
+// ReadRecords devolve as linhas do ficheiro, cabeçalho incluído. O CSV pode usar ';' ou
+// ',' como separador; do XLSX lê-se a primeira folha.
+func ReadRecords(data []byte, format Format) ([][]string, error) {
+	switch format {
+	case CSV:
+		return readCSV(data)
+	case XLSX:
+		return readXLSX(data)
+	}
+	return nil, fmt.Errorf("formato não suportado: %q", format)
+}
+
+func readCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return nil, errors.New("o ficheiro CSV tem de estar em UTF-8")
+	}
+	header := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		header = data[:i]
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = ';'
+	if bytes.Count(header, []byte(",")) > bytes.Count(header, []byte(";")) {
+		r.Comma = ','
+	}
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSV inválido: %w", err)
+	}
+	return records, nil
+}
+
+// Parse valida as linhas lidas do ficheiro. Devolve as linhas válidas e, para as
+// restantes, o resultado com o motivo da rejeição. O erro só é devolvido quando o
+// cabeçalho não tem as colunas esperadas.
+func Parse(records [][]string) ([]models.ProductImportRow, []models.ProductImportResult, error) {
+	if len(records) == 0 {
+		return nil, nil, errors.New("o ficheiro está vazio")
+	}
+	index := make(map[string]int)
+	for i, name := range records[0] {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	withFiscal := false
+	for _, col := range FiscalColumns {
+		if _, ok := index[col]; ok {
+			withFiscal = true
+		}
+	}
+	var missing []string
+	for _, col := range Columns {
+		if _, ok := index[col]; !ok && (withFiscal || !isFiscalColumn(col)) {
+			missing = append(missing, col)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("faltam colunas no cabeçalho: %s", strings.Join(missing, ", "))
+	}
+
+	var rows []models.ProductImportRow
+	var rejected []models.ProductImportResult
+	seenBarcode := make(map[string]int)
+	seenName := make(map[string]int)
+	for n, record := range records[1:] {
+		line := n + 2
+		get := func(col string) string {
+			if i := index[col]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if isBlank(record) {
+			continue
+		}
+		p, err := parseProduct(get, withFiscal)
+		if err == nil {
+			if prev, ok := seenBarcode[p.CodigoBarras]; ok {
+				err = fmt.Errorf("código de barras repetido (linha %d)", prev)
+			} else if prev, ok := seenName[strings.ToLower(p.Nome)]; ok {
+				err = fmt.Errorf("nome repetido (linha %d)", prev)
+			}
+		}
+		if err != nil {
+			rejected = append(rejected, models.ProductImportResult{
+				Linha: line, CodigoBarras: get("codigo_barras"), Nome: get("nome"),
+				Acao: models.ImportActionReject, Erro: err.Error(),
+			})
+			continue
+		}
+		seenBarcode[p.CodigoBarras] = line
+		seenName[strings.ToLower(p.Nome)] = line
+		row := models.ProductImportRow{Linha: line, Produto: p, ComFiscal: withFiscal}
+		if err := gtin.Validate(p.CodigoBarras); err != nil {
+			row.ErroCodigoBarras = err.Error()
+		}
+		rows = append(rows, row)
+	}
+	return rows, rejected, nil
+}
+
+func isBlank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func isFiscalColumn(col string) bool {
+	for _, c := range FiscalColumns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+func parseProduct(get func(string) string, withFiscal bool) (models.Product, error) {
+	p := models.Product{
+		CodigoBarras: get("codigo_barras"),
+		Nome:         get("nome"),
+		Descricao:    get("descricao"),
+		Categoria:    normalizePath(get("categoria")),
+		CodigoCNAE:   get("codigo_cnae"),
+	}
+	switch {
+	case p.CodigoBarras == "":
+		return p, errors.New("o código de barras é obrigatório")
+	case utf8.RuneCountInString(p.CodigoBarras) > maxCodigoBarras:
+		return p, fmt.Errorf("o código de barras tem mais de %d caracteres", maxCodigoBarras)
+	case p.Nome == "":
+		return p, errors.New("o nome é obrigatório")
+	case utf8.RuneCountInString(p.Nome) > maxNome:
+		return p, fmt.Errorf("o nome tem mais de %d caracteres", maxNome)
+	case utf8.RuneCountInString(p.CodigoCNAE) > maxCodigoCNAE:
+		return p, fmt.Errorf("o código CNAE tem mais de %d caracteres", maxCodigoCNAE)
+	}
+	if withFiscal {
+		origin, err := fiscal.ParseOrigin(get("origem"))
+		if err != nil {
+			return p, err
+		}
+		p.FiscalClassification = models.FiscalClassification{
+			NCM: get("ncm"), CEST: get("cest"), CFOP: get("cfop"), Origem: origin,
+			CSTICMS: get("cst_icms"), CSTPIS: get("cst_pis"), CSTCOFINS: get("cst_cofins"),
+		}
+		if err := fiscal.Validate(&p.FiscalClassification); err != nil {
+			return p, err
+		}
+	}
+
+	numbers := []struct {
+		col string
+		dst *float64
+		max float64
+	}{
+		{"preco_custo", &p.PrecoCusto, maxPreco},
+		{"percentual_lucro", &p.PercentualLucro, maxPercentual},
+		{"imposto_estadual", &p.ImpostoEstadual, maxPercentual},
+		{"imposto_federal", &p.ImpostoFederal, maxPercentual},
+	}
+	for _, n := range numbers {
+		v, err := parseDecimal(get(n.col))
+		if err != nil || v < 0 || v > n.max {
+			return p, fmt.Errorf("valor inválido em %s: %q", n.col, get(n.col))
+		}
+		*n.dst = v
+	}
+	if raw := get("preco_sugerido"); raw != "" {
+		v, err := parseDecimal(raw)
+		if err != nil || v < 0 || v > maxPreco {
+			return p, fmt.Errorf("valor inválido em preco_sugerido: %q", raw)
+		}
+		p.PrecoSugerido = v
+	} else {
+		// A mesma fórmula do formulário de produtos.
+		p.PrecoSugerido = round2(p.PrecoCusto * (1 + (p.PercentualLucro+p.ImpostoEstadual+p.ImpostoFederal)/100))
+	}
+	return p, nil
+}
+
+// parseDecimal aceita vírgula ou ponto decimal e o ponto como separador de milhares
+// ("1.234,56"). Vazio vale zero.
+func parseDecimal(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if strings.Contains(s, ",") {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("número inválido: %q", s)
+	}
+	return round2(v), nil
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// normalizePath uniformiza os espaços à volta dos separadores do caminho da categoria.
+func normalizePath(path string) string {
+	if path == "" {
+		return ""
+	}
+	parts := strings.Split(path, ">")
+	for i, part := range parts {
+		parts[i] = strings.Join(strings.Fields(part), " ")
+	}
+	return strings.Join(parts, " > ")
+}
+
+// Write exporta os produtos no formato indicado. No CSV usa ';' e vírgula decimal, para
+// abrir diretamente no Excel em português; no XLSX os valores ficam numéricos.
+func Write(w io.Writer, format Format, products []models.Product) error {
+	switch format {
+	case CSV:
+		cw := csv.NewWriter(w)
+		cw.Comma = ';'
+		if err := cw.Write(Columns); err != nil {
+			return err
+		}
+		for _, p := range products {
+			record := productRecord(p)
+			for i := firstNumericColumn; i < len(record); i++ {
+				record[i] = strings.Replace(record[i], ".", ",", 1)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case XLSX:
+		records := [][]string{Columns}
+		for _, p := range products {
+			records = append(records, productRecord(p))
+		}
+		// As colunas a partir de preco_custo são numéricas; as fiscais ficam como texto,
+		// para não perder os zeros à esquerda do NCM.
+		return writeXLSX(w, records, firstNumericColumn)
+	}
+	return fmt.Errorf("formato não suportado: %q", format)
+}
+
+func productRecord(p models.Product) []string {
+	decimal := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	return []string{
+		p.CodigoBarras, p.Nome, p.Descricao, p.Categoria, p.CodigoCNAE,
+		p.NCM, p.CEST, p.CFOP, strconv.Itoa(p.Origem), p.CSTICMS, p.CSTPIS, p.CSTCOFINS,
+		decimal(p.PrecoCusto), decimal(p.PercentualLucro), decimal(p.ImpostoEstadual),
+		decimal(p.ImpostoFederal), decimal(p.PrecoSugerido),
+	}
+}
+
+// AddRejected junta ao relatório da gravação as linhas rejeitadas na validação do
+// ficheiro, mantendo os resultados pela ordem das linhas.
+func AddRejected(report *models.ProductImportReport, rejected []models.ProductImportResult) {
+	report.Rejeitados += len(rejected)
+	report.Resultados = append(report.Resultados, rejected...)
+	sort.SliceStable(report.Resultados, func(i, j int) bool {
+		return report.Resultados[i].Linha < report.Resultados[j].Linha
+	})
+}
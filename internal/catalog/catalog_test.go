@@ -0,0 +1,197 @@
+package catalog
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"projeto-vendas/internal/models"
+)
+
+var sampleProducts = []models.Product{
+	{CodigoBarras: "0789000000011", Nome: "Água Mineral 1,5L", Descricao: "Garrafa \"sem gás\"; PET", Categoria: "Mercearia > Bebidas",
+		PrecoCusto: 1.2, PercentualLucro: 40, ImpostoEstadual: 18, ImpostoFederal: 9.25, PrecoSugerido: 2.01,
+		FiscalClassification: models.FiscalClassification{NCM: "02011000", CEST: "0300100", CFOP: "5405", Origem: 0, CSTICMS: "60", CSTPIS: "04", CSTCOFINS: "04"}},
+	{CodigoBarras: "7891000100103", Nome: "Sabão <Neutro> & Cia", CodigoCNAE: "4711-3/02", PrecoCusto: 1234.5, PrecoSugerido: 1500},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []Format{CSV, XLSX} {
+		var buf bytes.Buffer
+		if err := Write(&buf, format, sampleProducts); err != nil {
+			t.Fatalf("%s: erro ao escrever: %v", format, err)
+		}
+		records, err := ReadRecords(buf.Bytes(), format)
+		if err != nil {
+			t.Fatalf("%s: erro ao ler: %v", format, err)
+		}
+		rows, rejected, err := Parse(records)
+		if err != nil || len(rejected) != 0 {
+			t.Fatalf("%s: esperava todas as linhas válidas, obteve %v (%v)", format, rejected, err)
+		}
+		if !rows[0].ComFiscal {
+			t.Errorf("%s: esperava as colunas fiscais presentes", format)
+		}
+		if len(rows) != len(sampleProducts) {
+			t.Fatalf("%s: esperava %d linhas, obteve %d", format, len(sampleProducts), len(rows))
+		}
+		for i, row := range rows {
+			if row.Linha != i+2 {
+				t.Errorf("%s: linha %d numerada como %d", format, i+2, row.Linha)
+			}
+			if !reflect.DeepEqual(row.Produto, sampleProducts[i]) {
+				t.Errorf("%s: esperava %+v, obteve %+v", format, sampleProducts[i], row.Produto)
+			}
+		}
+	}
+}
+
+func TestParseRejectsInvalidRows(t *testing.T) {
+	csv := "codigo_barras,nome,descricao,categoria,codigo_cnae,preco_custo,percentual_lucro,imposto_estadual,imposto_federal,preco_sugerido\n" +
+		"96385074,Produto A,,Mercearia  >Bebidas,,10.00,50,10,0,\n" +
+		",Sem código,,,,1,0,0,0,1\n" +
+		"333,Preço errado,,,,abc,0,0,0,1\n" +
+		"96385074,Repetido,,,,1,0,0,0,1\n" +
+		",,,,,,,,,\n" +
+		"444,Negativo,,,,1,0,0,0,-2\n" +
+		"7891000100104,Dígito errado,,,,1,0,0,0,1\n"
+	records, err := ReadRecords([]byte(csv), CSV)
+	if err != nil {
+		t.Fatalf("Erro ao ler o CSV: %v", err)
+	}
+	rows, rejected, err := Parse(records)
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Esperava 2 linhas válidas, obteve %d", len(rows))
+	}
+	if p := rows[0].Produto; p.PrecoSugerido != 16 || p.Categoria != "Mercearia > Bebidas" {
+		t.Errorf("Esperava o preço calculado de 16,00 e o caminho normalizado, obteve %.2f e %q", p.PrecoSugerido, p.Categoria)
+	}
+	// O código que não é GTIN fica assinalado: só é rejeitado se a linha criar um produto.
+	if rows[0].ErroCodigoBarras != "" || rows[1].Linha != 8 || !strings.Contains(rows[1].ErroCodigoBarras, "dígito de controlo") {
+		t.Errorf("Esperava só a linha 8 com o código assinalado, obteve %+v", rows)
+	}
+	lines := map[int]bool{}
+	for _, r := range rejected {
+		if r.Acao != models.ImportActionReject || r.Erro == "" {
+			t.Errorf("Rejeição sem motivo: %+v", r)
+		}
+		lines[r.Linha] = true
+	}
+	for _, line := range []int{3, 4, 5, 7} {
+		if !lines[line] {
+			t.Errorf("Esperava a linha %d rejeitada: %+v", line, rejected)
+		}
+	}
+	if len(rejected) != 4 {
+		t.Errorf("Esperava 4 rejeições (a linha em branco é ignorada), obteve %d", len(rejected))
+	}
+
+	if _, _, err := Parse([][]string{{"codigo_barras", "nome"}}); err == nil || !strings.Contains(err.Error(), "preco_custo") {
+		t.Errorf("Esperava erro com as colunas em falta, obteve %v", err)
+	}
+	if rows[0].ComFiscal {
+		t.Error("Esperava a importação sem colunas fiscais")
+	}
+}
+
+func TestParseFiscalColumns(t *testing.T) {
+	header := "codigo_barras,nome,descricao,categoria,codigo_cnae,ncm,cest,cfop,origem,cst_icms,cst_pis,cst_cofins,preco_custo,percentual_lucro,imposto_estadual,imposto_federal,preco_sugerido\n"
+	csv := header +
+		"96385074,Refrigerante,,,,2202.10.00,03.007.00,5.405,0,60,04,04,3,0,0,0,5\n" +
+		"7891000100103,NCM curto,,,,2202.10,,,0,,,,1,0,0,0,1\n" +
+		"0789000000011,Origem errada,,,,,,,X,,,,1,0,0,0,1\n"
+	records, err := ReadRecords([]byte(csv), CSV)
+	if err != nil {
+		t.Fatalf("Erro ao ler o CSV: %v", err)
+	}
+	rows, rejected, err := Parse(records)
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if len(rows) != 1 || len(rejected) != 2 {
+		t.Fatalf("Esperava 1 linha válida e 2 rejeitadas, obteve %+v e %+v", rows, rejected)
+	}
+	if f := rows[0].Produto.FiscalClassification; f.NCM != "22021000" || f.CEST != "0300700" || f.CFOP != "5405" {
+		t.Errorf("Esperava os códigos fiscais normalizados, obteve %+v", f)
+	}
+
+	// Só algumas colunas fiscais: faltam as outras.
+	if _, _, err := Parse([][]string{append(strings.Split(strings.TrimSpace(header), ",")[:5], "ncm", "preco_custo", "percentual_lucro", "imposto_estadual", "imposto_federal", "preco_sugerido")}); err == nil || !strings.Contains(err.Error(), "cfop") {
+		t.Errorf("Esperava erro com as colunas fiscais em falta, obteve %v", err)
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	cases := map[string]float64{"": 0, "12": 12, "12.5": 12.5, "12,5": 12.5, "1.234,56": 1234.56, "0.1049": 0.1}
+	for in, want := range cases {
+		if got, err := parseDecimal(in); err != nil || got != want {
+			t.Errorf("parseDecimal(%q) = %v, %v; esperava %v", in, got, err, want)
+		}
+	}
+}
+
+func TestColumnNames(t *testing.T) {
+	for col, name := range map[int]string{0: "A", 25: "Z", 26: "AA", 701: "ZZ", 702: "AAA", 16383: "XFD"} {
+		if got := columnName(col); got != name {
+			t.Errorf("columnName(%d) = %s, esperava %s", col, got, name)
+		}
+		if got, err := columnIndex(name + "12"); err != nil || got != col {
+			t.Errorf("columnIndex(%s12) = %d, %v; esperava %d", name, got, err, col)
+		}
+	}
+	for _, ref := range []string{"XFE1", "ZZZZZZZZZZZZZZZ1"} {
+		if _, err := columnIndex(ref); err == nil {
+			t.Errorf("columnIndex(%s) devia falhar depois da coluna XFD", ref)
+		}
+	}
+}
+
+// xlsxWithSheet devolve um XLSX válido com a folha substituída pelo XML indicado.
+func xlsxWithSheet(t *testing.T, sheetData string) []byte {
+	var base bytes.Buffer
+	if err := writeXLSX(&base, nil, 0); err != nil {
+		t.Fatalf("erro ao escrever XLSX: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(base.Bytes()), int64(base.Len()))
+	if err != nil {
+		t.Fatalf("erro ao ler XLSX: %v", err)
+	}
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	for _, f := range zr.File {
+		w, _ := zw.Create(f.Name)
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			io.WriteString(w, `<worksheet xmlns="`+nsSpreadsheet+`"><sheetData>`+sheetData+`</sheetData></worksheet>`)
+			continue
+		}
+		rc, _ := f.Open()
+		io.Copy(w, rc)
+		rc.Close()
+	}
+	zw.Close()
+	return out.Bytes()
+}
+
+func TestReadXLSXLimits(t *testing.T) {
+	// Uma linha vazia a meio mantém a numeração da folha.
+	records, err := readXLSX(xlsxWithSheet(t, `<row r="1"><c r="A1"><v>1</v></c></row><row r="3"><c r="B3"><v>2</v></c></row>`))
+	if err != nil || len(records) != 3 || records[1] != nil || !reflect.DeepEqual(records[2], []string{"", "2"}) {
+		t.Errorf("esperava 3 linhas com a 2 vazia, obteve %q (%v)", records, err)
+	}
+
+	cases := map[string]string{
+		"linha muito além das lidas": `<row r="1"><c r="A1"><v>1</v></c></row><row r="1048576"><c r="A1048576"><v>2</v></c></row>`,
+		"coluna depois de XFD":       `<row r="1"><c r="XFE1"><v>1</v></c></row>`,
+	}
+	for name, sheet := range cases {
+		if _, err := readXLSX(xlsxWithSheet(t, sheet)); err == nil {
+			t.Errorf("%s: esperava erro", name)
+		}
+	}
+}
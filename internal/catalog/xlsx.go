@@ -0,0 +1,288 @@
+package catalog
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Leitura e escrita do mínimo do formato XLSX (Office Open XML) necessário para uma
+// folha com uma tabela: sem estilos, fórmulas nem várias folhas.
+
+const (
+	nsSpreadsheet   = "http://schemas.openxmlformats.org/spreadsheetml/2006/main"
+	nsRelationships = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+)
+
+// Limites da leitura, para um ficheiro pequeno não se expandir em memória: o tamanho
+// descomprimido de cada parte XML, as colunas do Excel (até XFD) e as linhas vazias
+// seguidas que a numeração da folha pode saltar.
+const (
+	maxXLSXPartSize  = 64 << 20
+	maxXLSXColumns   = 16384
+	maxXLSXBlankRows = 1000
+)
+
+// xlsxText é o texto de uma célula: simples em <t> ou formatado em vários <r><t>.
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (x xlsxText) String() string {
+	var s strings.Builder
+	s.WriteString(x.T)
+	for _, r := range x.R {
+		s.WriteString(r.T)
+	}
+	return s.String()
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Ref   int `xml:"r,attr"`
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readXLSX(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("o ficheiro não é um XLSX válido")
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	decode := func(name string, v interface{}) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("XLSX sem %s", name)
+		}
+		if f.UncompressedSize64 > maxXLSXPartSize {
+			return fmt.Errorf("%s excede %d MB descomprimido", name, maxXLSXPartSize>>20)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		// O tamanho declarado no zip pode mentir; o leitor corta no limite na mesma.
+		return xml.NewDecoder(io.LimitReader(rc, maxXLSXPartSize)).Decode(v)
+	}
+
+	sheetPath, err := firstSheetPath(decode)
+	if err != nil {
+		return nil, err
+	}
+	var shared []string
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			Items []xlsxText `xml:"si"`
+		}
+		if err := decode("xl/sharedStrings.xml", &sst); err != nil {
+			return nil, fmt.Errorf("XLSX inválido: %w", err)
+		}
+		for _, si := range sst.Items {
+			shared = append(shared, si.String())
+		}
+	}
+	var sheet xlsxWorksheet
+	if err := decode(sheetPath, &sheet); err != nil {
+		return nil, fmt.Errorf("XLSX inválido: %w", err)
+	}
+
+	records := make([][]string, 0, len(sheet.Rows))
+	for _, row := range sheet.Rows {
+		// As linhas vazias não aparecem no ficheiro; mantém-se a numeração da folha.
+		if row.Ref > len(records)+1+maxXLSXBlankRows {
+			return nil, fmt.Errorf("XLSX inválido: a linha %d está mais de %d linhas depois da %d", row.Ref, maxXLSXBlankRows, len(records))
+		}
+		for row.Ref > len(records)+1 {
+			records = append(records, nil)
+		}
+		var record []string
+		for i, cell := range row.Cells {
+			col := i
+			if cell.Ref != "" {
+				if col, err = columnIndex(cell.Ref); err != nil {
+					return nil, err
+				}
+			}
+			var value string
+			switch cell.Type {
+			case "s":
+				n, err := strconv.Atoi(cell.Value)
+				if err != nil || n < 0 || n >= len(shared) {
+					return nil, fmt.Errorf("XLSX inválido: texto partilhado %q inexistente", cell.Value)
+				}
+				value = shared[n]
+			case "inlineStr":
+				value = cell.Inline.String()
+			case "", "n":
+				value = cell.Value
+				// Números grandes (códigos de barras) podem vir em notação científica.
+				if strings.ContainsAny(value, "eE") {
+					if f, err := strconv.ParseFloat(value, 64); err == nil {
+						value = strconv.FormatFloat(f, 'f', -1, 64)
+					}
+				}
+			default: // "str" (resultado de fórmula), "b", "e"
+				value = cell.Value
+			}
+			for len(record) < col {
+				record = append(record, "")
+			}
+			if col < len(record) {
+				record[col] = value
+			} else {
+				record = append(record, value)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// firstSheetPath encontra no livro o ficheiro da primeira folha.
+func firstSheetPath(decode func(string, interface{}) error) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decode("xl/workbook.xml", &workbook); err != nil {
+		return "", fmt.Errorf("XLSX inválido: %w", err)
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", errors.New("o XLSX não tem folhas")
+	}
+	var rels struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", fmt.Errorf("XLSX inválido: %w", err)
+	}
+	for _, rel := range rels.Items {
+		if rel.ID == workbook.Sheets[0].RelID {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+	}
+	return "", errors.New("XLSX inválido: folha sem ficheiro")
+}
+
+// columnIndex converte a referência de uma célula ("C7") no índice da coluna (2). Rejeita
+// colunas depois de XFD, a última do Excel.
+func columnIndex(ref string) (int, error) {
+	col := 0
+	for i, r := range ref {
+		if r >= 'A' && r <= 'Z' {
+			col = col*26 + int(r-'A'+1)
+			if col > maxXLSXColumns {
+				return 0, fmt.Errorf("XLSX inválido: a célula %q está depois da coluna XFD", ref)
+			}
+			continue
+		}
+		if i == 0 {
+			break
+		}
+		return col - 1, nil
+	}
+	return 0, fmt.Errorf("XLSX inválido: referência de célula %q", ref)
+}
+
+// columnName é o inverso de columnIndex, sem a linha.
+func columnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name
+}
+
+// writeXLSX escreve os registos numa única folha. As colunas a partir de numericFrom
+// ficam como números (exceto na primeira linha, o cabeçalho).
+func writeXLSX(w io.Writer, records [][]string, numericFrom int) error {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="` + nsSpreadsheet + `" xmlns:r="` + nsRelationships + `">` +
+			`<sheets><sheet name="Produtos" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	buf.WriteString(`<worksheet xmlns="` + nsSpreadsheet + `"><sheetData>`)
+	for r, record := range records {
+		fmt.Fprintf(&buf, `<row r="%d">`, r+1)
+		for c, value := range record {
+			ref := columnName(c) + strconv.Itoa(r+1)
+			if r > 0 && c >= numericFrom && value != "" {
+				fmt.Fprintf(&buf, `<c r="%s"><v>%s</v></c>`, ref, value)
+				continue
+			}
+			fmt.Fprintf(&buf, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			xml.EscapeText(&buf, []byte(value))
+			buf.WriteString(`</t></is></c>`)
+		}
+		buf.WriteString(`</row>`)
+		// Escreve por blocos para não acumular catálogos grandes em memória.
+		if buf.Len() > 64*1024 {
+			if _, err := buf.WriteTo(f); err != nil {
+				return err
+			}
+		}
+	}
+	buf.WriteString(`</sheetData></worksheet>`)
+	if _, err := buf.WriteTo(f); err != nil {
+		return err
+	}
+	return zw.Close()
+}
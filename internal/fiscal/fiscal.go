@@ -0,0 +1,148 @@
+// Package fiscal valida a classificação fiscal dos produtos (NCM, CEST, CFOP, origem e os
+// CST do ICMS, do PIS e da COFINS) e lê a tabela NCM publicada pelo Siscomex, para que a
+// consulta dos códigos funcione sem acesso à internet.
+package fiscal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"projeto-vendas/internal/models"
+)
+
+// Option é um código de uma tabela fiscal com a sua descrição, para as listas dos formulários.
+type Option struct {
+	Codigo    string
+	Descricao string
+}
+
+// Origins é a tabela A do CST do ICMS: a origem da mercadoria.
+var Origins = []Option{
+	{"0", "Nacional, exceto as indicadas nos códigos 3, 4, 5 e 8"},
+	{"1", "Estrangeira - importação direta, exceto a indicada no código 6"},
+	{"2", "Estrangeira - adquirida no mercado interno, exceto a indicada no código 7"},
+	{"3", "Nacional, com conteúdo de importação superior a 40% e inferior ou igual a 70%"},
+	{"4", "Nacional, produzida conforme os processos produtivos básicos"},
+	{"5", "Nacional, com conteúdo de importação inferior ou igual a 40%"},
+	{"6", "Estrangeira - importação direta, sem similar nacional, em lista da CAMEX"},
+	{"7", "Estrangeira - adquirida no mercado interno, sem similar nacional, em lista da CAMEX"},
+	{"8", "Nacional, com conteúdo de importação superior a 70%"},
+}
+
+// ICMSSituations são os CST do ICMS do regime normal (2 dígitos) e os CSOSN do Simples
+// Nacional (3 dígitos).
+var ICMSSituations = []Option{
+	{"00", "Tributada integralmente"},
+	{"10", "Tributada e com cobrança do ICMS por substituição tributária"},
+	{"20", "Com redução de base de cálculo"},
+	{"30", "Isenta ou não tributada e com cobrança do ICMS por substituição tributária"},
+	{"40", "Isenta"},
+	{"41", "Não tributada"},
+	{"50", "Suspensão"},
+	{"51", "Diferimento"},
+	{"60", "ICMS cobrado anteriormente por substituição tributária"},
+	{"70", "Com redução de base de cálculo e cobrança do ICMS por substituição tributária"},
+	{"90", "Outras"},
+	{"101", "Simples Nacional: tributada com permissão de crédito"},
+	{"102", "Simples Nacional: tributada sem permissão de crédito"},
+	{"103", "Simples Nacional: isenção para faixa de receita bruta"},
+	{"201", "Simples Nacional: com permissão de crédito e cobrança do ICMS por substituição tributária"},
+	{"202", "Simples Nacional: sem permissão de crédito e com cobrança do ICMS por substituição tributária"},
+	{"203", "Simples Nacional: isenção para faixa de receita bruta e cobrança do ICMS por substituição tributária"},
+	{"300", "Simples Nacional: imune"},
+	{"400", "Simples Nacional: não tributada"},
+	{"500", "Simples Nacional: ICMS cobrado anteriormente por substituição tributária ou antecipação"},
+	{"900", "Simples Nacional: outros"},
+}
+
+// PISCOFINSSituations são os CST do PIS e da COFINS das operações de saída, os que servem
+// de padrão nas vendas.
+var PISCOFINSSituations = []Option{
+	{"01", "Tributável com alíquota básica"},
+	{"02", "Tributável com alíquota diferenciada"},
+	{"03", "Tributável com alíquota por unidade de medida"},
+	{"04", "Tributável monofásica - revenda a alíquota zero"},
+	{"05", "Tributável por substituição tributária"},
+	{"06", "Tributável a alíquota zero"},
+	{"07", "Isenta da contribuição"},
+	{"08", "Sem incidência da contribuição"},
+	{"09", "Com suspensão da contribuição"},
+	{"49", "Outras operações de saída"},
+	{"99", "Outras operações"},
+}
+
+// Digits tira a pontuação com que os códigos costumam ser escritos ("2202.10.00",
+// "03.002.00", "5.102") e os espaços à volta.
+func Digits(code string) string {
+	return strings.NewReplacer(".", "", "-", "", " ", "").Replace(strings.TrimSpace(code))
+}
+
+// Validate normaliza os códigos da classificação (só dígitos) e confirma o formato de
+// cada um. Os campos vazios são aceites: a classificação pode ser preenchida aos poucos.
+// A existência do NCM na tabela de referência é confirmada pelo storage.
+func Validate(f *models.FiscalClassification) error {
+	f.NCM, f.CEST, f.CFOP = Digits(f.NCM), Digits(f.CEST), Digits(f.CFOP)
+	f.CSTICMS, f.CSTPIS, f.CSTCOFINS = Digits(f.CSTICMS), Digits(f.CSTPIS), Digits(f.CSTCOFINS)
+
+	if f.NCM != "" && !isDigits(f.NCM, 8) {
+		return fmt.Errorf("NCM inválido: %q (são 8 dígitos, p.ex. 2202.10.00)", f.NCM)
+	}
+	if f.CEST != "" && !isDigits(f.CEST, 7) {
+		return fmt.Errorf("CEST inválido: %q (são 7 dígitos, p.ex. 03.002.00)", f.CEST)
+	}
+	if f.CEST != "" && f.NCM == "" {
+		return fmt.Errorf("o CEST %s exige o NCM do produto", f.CEST)
+	}
+	// O CFOP padrão é o das vendas: 5 (dentro do estado), 6 (fora) ou 7 (exterior).
+	if f.CFOP != "" && (!isDigits(f.CFOP, 4) || !strings.ContainsRune("567", rune(f.CFOP[0]))) {
+		return fmt.Errorf("CFOP inválido: %q (um CFOP de saída tem 4 dígitos e começa por 5, 6 ou 7)", f.CFOP)
+	}
+	if f.Origem < 0 || f.Origem >= len(Origins) {
+		return fmt.Errorf("origem da mercadoria inválida: %d (de 0 a 8)", f.Origem)
+	}
+	if f.CSTICMS != "" && !known(ICMSSituations, f.CSTICMS) {
+		return fmt.Errorf("CST/CSOSN do ICMS inválido: %q", f.CSTICMS)
+	}
+	if f.CSTPIS != "" && !known(PISCOFINSSituations, f.CSTPIS) {
+		return fmt.Errorf("CST do PIS inválido: %q", f.CSTPIS)
+	}
+	if f.CSTCOFINS != "" && !known(PISCOFINSSituations, f.CSTCOFINS) {
+		return fmt.Errorf("CST da COFINS inválido: %q", f.CSTCOFINS)
+	}
+	return nil
+}
+
+// ParseOrigin lê a origem da mercadoria de um formulário ou ficheiro; vazio é 0 (nacional).
+func ParseOrigin(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	origin, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("origem da mercadoria inválida: %q (de 0 a 8)", s)
+	}
+	return origin, nil
+}
+
+func known(options []Option, code string) bool {
+	for _, o := range options {
+		if o.Codigo == code {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigits(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package fiscal
+
+import (
+	"strings"
+	"testing"
+
+	"projeto-vendas/internal/models"
+)
+
+func TestValidateNormalizesCodes(t *testing.T) {
+	f := models.FiscalClassification{NCM: "2202.10.00", CEST: "03.002.00", CFOP: "5.102", Origem: 0, CSTICMS: "60", CSTPIS: "04", CSTCOFINS: "04"}
+	if err := Validate(&f); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if f.NCM != "22021000" || f.CEST != "0300200" || f.CFOP != "5102" {
+		t.Errorf("Esperava os códigos só com dígitos, obteve %+v", f)
+	}
+	// Uma classificação vazia é válida.
+	if err := Validate(&models.FiscalClassification{}); err != nil {
+		t.Errorf("Erro inesperado numa classificação vazia: %v", err)
+	}
+}
+
+func TestValidateRejectsInvalidCodes(t *testing.T) {
+	cases := map[string]models.FiscalClassification{
+		"NCM com 7 dígitos":       {NCM: "2202100"},
+		"NCM com letras":          {NCM: "2202.10.0A"},
+		"CEST sem NCM":            {CEST: "0300200"},
+		"CEST com 6 dígitos":      {NCM: "22021000", CEST: "030020"},
+		"CFOP de entrada":         {CFOP: "1102"},
+		"CFOP com 3 dígitos":      {CFOP: "510"},
+		"origem fora da tabela":   {Origem: 9},
+		"CST do ICMS inexistente": {CSTICMS: "55"},
+		"CSOSN inexistente":       {CSTICMS: "104"},
+		"CST do PIS de entrada":   {CSTPIS: "50"},
+		"CST da COFINS inválido":  {CSTCOFINS: "1"},
+	}
+	for name, f := range cases {
+		if err := Validate(&f); err == nil {
+			t.Errorf("%s: esperava erro", name)
+		}
+	}
+}
+
+func TestParseNCMTable(t *testing.T) {
+	data := "\xef\xbb\xbf" + `{"Data_Ultima_Atualizacao_NCM": "Vigente", "Nomenclaturas": [
+		{"Codigo": "22", "Descricao": "Bebidas, líquidos alcoólicos e vinagres."},
+		{"Codigo": "22.02", "Descricao": "Águas, incluindo as águas minerais, adicionadas de açúcar:"},
+		{"Codigo": "2202.10.00", "Descricao": "- Águas, incluindo as águas minerais e as águas gaseificadas, adicionadas de açúcar"},
+		{"Codigo": "2202.9", "Descricao": "- Outras:"},
+		{"Codigo": "2202.99.00", "Descricao": "-- <i>Outras</i>"}
+	]}`
+	table, err := ParseNCMTable(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if len(table) != 2 {
+		t.Fatalf("Esperava os 2 códigos de 8 dígitos, obteve %+v", table)
+	}
+	if table[1].Codigo != "22029900" || table[1].Descricao != "Águas, incluindo as águas minerais, adicionadas de açúcar > Outras > Outras" {
+		t.Errorf("Descrição inesperada: %+v", table[1])
+	}
+	if _, err := ParseNCMTable(strings.NewReader(`{"Nomenclaturas": []}`)); err == nil {
+		t.Error("Esperava erro numa tabela sem códigos")
+	}
+	if FormatNCM("22021000") != "2202.10.00" {
+		t.Errorf("FormatNCM inesperado: %s", FormatNCM("22021000"))
+	}
+}
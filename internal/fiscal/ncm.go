@@ -0,0 +1,84 @@
+package fiscal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"projeto-vendas/internal/models"
+)
+
+// ncmFile é o ficheiro JSON da tabela NCM vigente publicado no portal do Siscomex. Traz
+// todos os níveis da nomenclatura: capítulos ("01"), posições ("01.01"), subposições
+// ("0101.2", "0101.21") e itens ("0101.21.00"), estes com 8 dígitos.
+type ncmFile struct {
+	Nomenclaturas []struct {
+		Codigo    string `json:"Codigo"`
+		Descricao string `json:"Descricao"`
+	} `json:"Nomenclaturas"`
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// ParseNCMTable lê a tabela NCM do Siscomex e devolve os códigos de 8 dígitos, os únicos
+// que um produto pode ter. A descrição de cada um junta as da posição e das subposições
+// acima, porque a do item sozinha ("-- Outros") não diz nada.
+func ParseNCMTable(r io.Reader) ([]models.NCM, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var file ncmFile
+	if err := json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &file); err != nil {
+		return nil, fmt.Errorf("tabela NCM inválida: %w", err)
+	}
+
+	descriptions := make(map[string]string, len(file.Nomenclaturas))
+	var codes []string
+	for _, n := range file.Nomenclaturas {
+		code := Digits(n.Codigo)
+		if code == "" {
+			continue
+		}
+		descriptions[code] = cleanDescription(n.Descricao)
+		if len(code) == 8 {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return nil, errors.New("a tabela NCM não tem nenhum código de 8 dígitos")
+	}
+
+	table := make([]models.NCM, 0, len(codes))
+	for _, code := range codes {
+		var parts []string
+		for size := 4; size <= 8; size++ {
+			if d, ok := descriptions[code[:size]]; ok && d != "" {
+				parts = append(parts, d)
+			}
+		}
+		table = append(table, models.NCM{Codigo: code, Descricao: strings.Join(parts, " > ")})
+	}
+	return table, nil
+}
+
+// cleanDescription tira as marcas de nível ("-- "), as etiquetas HTML e os dois pontos
+// finais das descrições da tabela.
+func cleanDescription(s string) string {
+	s = htmlTag.ReplaceAllString(s, "")
+	s = strings.TrimLeft(strings.TrimSpace(s), "- ")
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.TrimSuffix(s, ":")
+}
+
+// FormatNCM escreve o NCM com a pontuação habitual: 2202.10.00.
+func FormatNCM(code string) string {
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "." + code[4:6] + "." + code[6:]
+}
@@ -0,0 +1,65 @@
+package forecast
+
+import (
+	"math"
+	"time"
+)
+
+// Accuracy resume o erro de um modelo num backtest.
+type Accuracy struct {
+	Metodo        Method  `json:"metodo"`
+	Origens       int     `json:"origens"`
+	DiasAvaliados int     `json:"dias_avaliados"`
+	MAE           float64 `json:"mae"`       // erro absoluto médio, em unidades por dia
+	RMSE          float64 `json:"rmse"`      // raiz do erro quadrático médio
+	WAPE          float64 `json:"wape"`      // erro absoluto total sobre as vendas reais, em %
+	Vies          float64 `json:"vies"`      // (previsto - real) sobre o real, em %; positivo = sobrestima
+	Cobertura     float64 `json:"cobertura"` // % dos dias reais dentro dos limites de confiança
+}
+
+// Backtest avalia um modelo com origem móvel: treina com history[:origem], prevê os
+// `horizon` dias seguintes e compara com o que foi realmente vendido, avançando a
+// origem `horizon` dias de cada vez a partir de minTrain. history tem o mesmo
+// significado que em Predict (termina na véspera de `end`).
+func Backtest(history []float64, end time.Time, horizon, minTrain int, method Method, opts Options) (Accuracy, error) {
+	acc := Accuracy{Metodo: method}
+	if horizon <= 0 || minTrain <= 0 {
+		return acc, ErrNotEnoughHistory
+	}
+	first := truncateDay(end).AddDate(0, 0, -len(history))
+
+	var absErr, sqErr, bias, actualSum float64
+	var covered int
+	for origin := minTrain; origin+horizon <= len(history); origin += horizon {
+		f, err := Predict(history[:origin], first.AddDate(0, 0, origin), horizon, method, opts)
+		if err != nil {
+			return acc, err
+		}
+		acc.Origens++
+		for h, p := range f.Pontos {
+			actual := history[origin+h]
+			e := p.Previsao - actual
+			absErr += math.Abs(e)
+			sqErr += e * e
+			bias += e
+			actualSum += actual
+			if actual >= p.LimiteInferior && actual <= p.LimiteSuperior {
+				covered++
+			}
+			acc.DiasAvaliados++
+		}
+	}
+	if acc.DiasAvaliados == 0 {
+		return acc, ErrNotEnoughHistory
+	}
+
+	n := float64(acc.DiasAvaliados)
+	acc.MAE = absErr / n
+	acc.RMSE = math.Sqrt(sqErr / n)
+	acc.Cobertura = float64(covered) / n * 100
+	if actualSum > 0 {
+		acc.WAPE = absErr / actualSum * 100
+		acc.Vies = bias / actualSum * 100
+	}
+	return acc, nil
+}
@@ -0,0 +1,261 @@
+// Package forecast calcula previsões de procura a partir do histórico diário de
+// unidades vendidas de um produto (numa filial ou em todas). Não acede à base de
+// dados: recebe a série já agregada por dia, com zeros nos dias sem vendas.
+package forecast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Method identifica o modelo de previsão.
+type Method string
+
+const (
+	// MovingAverage prevê a média das últimas Window observações para todos os dias.
+	MovingAverage Method = "media_movel"
+	// ExponentialSmoothing é a suavização exponencial simples (nível sem tendência).
+	ExponentialSmoothing Method = "suavizacao_exponencial"
+	// WeeklySeasonal aplica índices por dia da semana sobre um nível suavizado.
+	WeeklySeasonal Method = "sazonal_semanal"
+)
+
+// Methods são todos os modelos disponíveis, pela ordem em que são apresentados.
+var Methods = []Method{MovingAverage, ExponentialSmoothing, WeeklySeasonal}
+
+// ErrNotEnoughHistory indica que a série é curta demais para o modelo pedido.
+var ErrNotEnoughHistory = errors.New("histórico insuficiente para a previsão")
+
+// minSeasonalHistory é o histórico mínimo (duas semanas, em dias) para estimar os índices semanais.
+const minSeasonalHistory = 14
+
+// ParseMethod converte o nome usado na API num Method. Uma string vazia devolve o modelo sazonal.
+func ParseMethod(name string) (Method, error) {
+	if name == "" {
+		return WeeklySeasonal, nil
+	}
+	for _, m := range Methods {
+		if string(m) == name {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("método de previsão desconhecido: %s", name)
+}
+
+// Options afina os modelos. Valores a zero são substituídos pelos de DefaultOptions.
+type Options struct {
+	Window int     // janela da média móvel, em dias
+	Alpha  float64 // constante de suavização (0 < Alpha <= 1)
+	Z      float64 // multiplicador do desvio padrão nos limites de confiança
+}
+
+// DefaultOptions devolve uma janela de 4 semanas, alpha 0,3 e limites de ~95%.
+func DefaultOptions() Options {
+	return Options{Window: 28, Alpha: 0.3, Z: 1.96}
+}
+
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.Window <= 0 {
+		o.Window = d.Window
+	}
+	if o.Alpha <= 0 || o.Alpha > 1 {
+		o.Alpha = d.Alpha
+	}
+	if o.Z <= 0 {
+		o.Z = d.Z
+	}
+	return o
+}
+
+// Point é a previsão de um dia.
+type Point struct {
+	Data           time.Time `json:"data"`
+	Previsao       float64   `json:"previsao"`
+	LimiteInferior float64   `json:"limite_inferior"`
+	LimiteSuperior float64   `json:"limite_superior"`
+}
+
+// Forecast é o resultado de Predict para um horizonte de dias.
+type Forecast struct {
+	Metodo         Method  `json:"metodo"`
+	Pontos         []Point `json:"pontos"`
+	Total          float64 `json:"total"`
+	TotalInferior  float64 `json:"total_inferior"`
+	TotalSuperior  float64 `json:"total_superior"`
+	DesvioResidual float64 `json:"desvio_residual"`
+}
+
+// Predict prevê os `horizon` dias a partir de `start` (inclusive). history[i] são as
+// unidades vendidas no dia start-len(history)+i, ou seja, a série termina na véspera.
+//
+// Os limites de confiança assumem erros independentes com o desvio padrão dos erros
+// de previsão a um passo no histórico: ±Z·σ por dia e ±Z·σ·√horizon no total.
+// Os limites inferiores nunca são negativos.
+func Predict(history []float64, start time.Time, horizon int, method Method, opts Options) (Forecast, error) {
+	if horizon <= 0 {
+		return Forecast{}, errors.New("o horizonte da previsão tem de ser positivo")
+	}
+	opts = opts.withDefaults()
+	fitted, future, err := fit(history, start, horizon, method, opts)
+	if err != nil {
+		return Forecast{}, err
+	}
+
+	sigma := residualStdDev(history, fitted)
+	result := Forecast{Metodo: method, DesvioResidual: sigma}
+	day := truncateDay(start)
+	for h, v := range future {
+		v = math.Max(v, 0)
+		result.Pontos = append(result.Pontos, Point{
+			Data:           day.AddDate(0, 0, h),
+			Previsao:       v,
+			LimiteInferior: math.Max(v-opts.Z*sigma, 0),
+			LimiteSuperior: v + opts.Z*sigma,
+		})
+		result.Total += v
+	}
+	spread := opts.Z * sigma * math.Sqrt(float64(horizon))
+	result.TotalInferior = math.Max(result.Total-spread, 0)
+	result.TotalSuperior = result.Total + spread
+	return result, nil
+}
+
+// fit devolve as previsões a um passo para cada dia do histórico (NaN quando o modelo
+// ainda não tem dados) e as previsões para os dias futuros.
+func fit(history []float64, start time.Time, horizon int, method Method, opts Options) ([]float64, []float64, error) {
+	if len(history) == 0 {
+		return nil, nil, ErrNotEnoughHistory
+	}
+	switch method {
+	case MovingAverage:
+		return fitMovingAverage(history, horizon, opts.Window)
+	case ExponentialSmoothing:
+		return fitExponentialSmoothing(history, horizon, opts.Alpha)
+	case WeeklySeasonal:
+		if len(history) < minSeasonalHistory {
+			return nil, nil, ErrNotEnoughHistory
+		}
+		return fitWeeklySeasonal(history, start, horizon, opts.Alpha)
+	}
+	return nil, nil, fmt.Errorf("método de previsão desconhecido: %s", method)
+}
+
+func fitMovingAverage(history []float64, horizon, window int) ([]float64, []float64, error) {
+	fitted := make([]float64, len(history))
+	var sum float64
+	for i := range history {
+		n := i
+		if n > window {
+			n = window
+			sum -= history[i-window-1]
+		}
+		if n == 0 {
+			fitted[i] = math.NaN()
+		} else {
+			fitted[i] = sum / float64(n)
+		}
+		sum += history[i]
+	}
+	n := len(history)
+	if n > window {
+		n = window
+	}
+	var last float64
+	for _, v := range history[len(history)-n:] {
+		last += v
+	}
+	return fitted, constant(last/float64(n), horizon), nil
+}
+
+func fitExponentialSmoothing(history []float64, horizon int, alpha float64) ([]float64, []float64, error) {
+	fitted := make([]float64, len(history))
+	fitted[0] = math.NaN()
+	level := history[0]
+	for i := 1; i < len(history); i++ {
+		fitted[i] = level
+		level = alpha*history[i] + (1-alpha)*level
+	}
+	return fitted, constant(level, horizon), nil
+}
+
+// fitWeeklySeasonal usa índices multiplicativos por dia da semana (média do dia da
+// semana sobre a média global), suaviza exponencialmente a série dessazonalizada e
+// volta a aplicar o índice do dia previsto. Os índices são estimados com o histórico
+// completo, por isso os erros no histórico são ligeiramente otimistas.
+func fitWeeklySeasonal(history []float64, start time.Time, horizon int, alpha float64) ([]float64, []float64, error) {
+	first := truncateDay(start).AddDate(0, 0, -len(history))
+	weekday := func(i int) int { return int(first.AddDate(0, 0, i).Weekday()) }
+
+	var sums, counts [7]float64
+	var total float64
+	for i, v := range history {
+		sums[weekday(i)] += v
+		counts[weekday(i)]++
+		total += v
+	}
+	mean := total / float64(len(history))
+	var index [7]float64
+	for d := range index {
+		index[d] = 1
+		if mean > 0 && counts[d] > 0 {
+			index[d] = (sums[d] / counts[d]) / mean
+		}
+	}
+
+	// O nível parte da média global, mais estável do que a primeira observação.
+	fitted := make([]float64, len(history))
+	level := mean
+	for i := range history {
+		idx := index[weekday(i)]
+		fitted[i] = level * idx
+		level = alpha*deseasonalize(history[i], idx, level) + (1-alpha)*level
+	}
+
+	future := make([]float64, horizon)
+	for h := range future {
+		future[h] = level * index[weekday(len(history)+h)]
+	}
+	return fitted, future, nil
+}
+
+// deseasonalize divide pelo índice do dia; num dia da semana sem vendas no histórico
+// (índice 0) a observação não informa o nível e devolve-se o valor de recurso.
+func deseasonalize(value, index, fallback float64) float64 {
+	if index == 0 {
+		return fallback
+	}
+	return value / index
+}
+
+func residualStdDev(history, fitted []float64) float64 {
+	var sumSq float64
+	var n int
+	for i, f := range fitted {
+		if math.IsNaN(f) {
+			continue
+		}
+		e := history[i] - f
+		sumSq += e * e
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Sqrt(sumSq / float64(n))
+}
+
+func constant(v float64, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = v
+	}
+	return out
+}
+
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,113 @@
+package forecast
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// weeklySeries gera `days` dias terminando na véspera de `end`, com as vendas de cada
+// dia da semana dadas por `pattern` (indexado por time.Weekday).
+func weeklySeries(end time.Time, days int, pattern [7]float64) []float64 {
+	first := end.AddDate(0, 0, -days)
+	series := make([]float64, days)
+	for i := range series {
+		series[i] = pattern[first.AddDate(0, 0, i).Weekday()]
+	}
+	return series
+}
+
+func TestPredictConstantSeries(t *testing.T) {
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	history := make([]float64, 60)
+	for i := range history {
+		history[i] = 5
+	}
+	for _, m := range Methods {
+		f, err := Predict(history, start, 7, m, Options{})
+		if err != nil {
+			t.Fatalf("%s: erro inesperado: %v", m, err)
+		}
+		if len(f.Pontos) != 7 {
+			t.Fatalf("%s: esperava 7 pontos, obteve %d", m, len(f.Pontos))
+		}
+		if math.Abs(f.Total-35) > 1e-9 {
+			t.Errorf("%s: esperava um total de 35, obteve %.4f", m, f.Total)
+		}
+		if f.DesvioResidual > 1e-9 || f.TotalSuperior-f.TotalInferior > 1e-9 {
+			t.Errorf("%s: uma série constante não devia ter incerteza (σ=%.4f)", m, f.DesvioResidual)
+		}
+		if !f.Pontos[0].Data.Equal(start) || !f.Pontos[6].Data.Equal(start.AddDate(0, 0, 6)) {
+			t.Errorf("%s: datas da previsão erradas: %v .. %v", m, f.Pontos[0].Data, f.Pontos[6].Data)
+		}
+	}
+}
+
+func TestPredictWeeklySeasonality(t *testing.T) {
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC) // segunda-feira
+	pattern := [7]float64{time.Saturday: 30, time.Sunday: 20, time.Monday: 5, time.Tuesday: 5, time.Wednesday: 5, time.Thursday: 5, time.Friday: 10}
+	history := weeklySeries(start, 8*7, pattern)
+
+	f, err := Predict(history, start, 7, WeeklySeasonal, Options{})
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	for _, p := range f.Pontos {
+		want := pattern[p.Data.Weekday()]
+		if math.Abs(p.Previsao-want) > 0.01 {
+			t.Errorf("%s: esperava %.2f, obteve %.2f", p.Data.Weekday(), want, p.Previsao)
+		}
+	}
+}
+
+func TestPredictBoundsAreNonNegative(t *testing.T) {
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	history := []float64{0, 0, 9, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0, 0, 0, 2}
+	f, err := Predict(history, start, 14, ExponentialSmoothing, Options{})
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	for _, p := range f.Pontos {
+		if p.LimiteInferior < 0 || p.LimiteSuperior < p.Previsao || p.Previsao < p.LimiteInferior {
+			t.Errorf("Limites inválidos em %v: %.2f <= %.2f <= %.2f", p.Data, p.LimiteInferior, p.Previsao, p.LimiteSuperior)
+		}
+	}
+}
+
+func TestPredictNotEnoughHistory(t *testing.T) {
+	start := time.Now()
+	if _, err := Predict(nil, start, 7, MovingAverage, Options{}); !errors.Is(err, ErrNotEnoughHistory) {
+		t.Errorf("Esperava ErrNotEnoughHistory sem histórico, obteve %v", err)
+	}
+	if _, err := Predict(make([]float64, 10), start, 7, WeeklySeasonal, Options{}); !errors.Is(err, ErrNotEnoughHistory) {
+		t.Errorf("Esperava ErrNotEnoughHistory com 10 dias no modelo sazonal, obteve %v", err)
+	}
+	if _, err := ParseMethod("arima"); err == nil {
+		t.Error("Esperava erro para um método desconhecido")
+	}
+}
+
+func TestBacktestPrefersSeasonalModelOnWeeklyPattern(t *testing.T) {
+	end := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	pattern := [7]float64{time.Saturday: 40, time.Sunday: 25, time.Monday: 4, time.Tuesday: 6, time.Wednesday: 5, time.Thursday: 7, time.Friday: 15}
+	history := weeklySeries(end, 20*7, pattern)
+
+	seasonal, err := Backtest(history, end, 7, 28, WeeklySeasonal, Options{})
+	if err != nil {
+		t.Fatalf("Erro inesperado no backtest sazonal: %v", err)
+	}
+	average, err := Backtest(history, end, 7, 28, MovingAverage, Options{})
+	if err != nil {
+		t.Fatalf("Erro inesperado no backtest da média móvel: %v", err)
+	}
+	if seasonal.Origens != 16 || seasonal.DiasAvaliados != 112 {
+		t.Errorf("Esperava 16 origens e 112 dias avaliados, obteve %d e %d", seasonal.Origens, seasonal.DiasAvaliados)
+	}
+	if seasonal.WAPE >= average.WAPE {
+		t.Errorf("Esperava que o modelo sazonal errasse menos (WAPE %.2f%%) do que a média móvel (%.2f%%)", seasonal.WAPE, average.WAPE)
+	}
+	if seasonal.WAPE > 1 {
+		t.Errorf("Num padrão semanal perfeito o WAPE sazonal devia ser ~0, obteve %.2f%%", seasonal.WAPE)
+	}
+}
@@ -0,0 +1,79 @@
+// Package gtin valida os códigos de barras GS1 (EAN-8, UPC-A, EAN-13 e GTIN-14) e gera os
+// códigos internos dos produtos sem código do fabricante, como os vendidos a granel.
+package gtin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// InternalPrefix é o prefixo reservado aos códigos internos. Os prefixos GS1 20 a 29 são de
+// circulação restrita: nunca aparecem em produtos do fabricante.
+const InternalPrefix = "200"
+
+// maxInternalSequence é o maior número que cabe nos 9 dígitos entre o prefixo e o dígito de
+// controlo de um código interno.
+const maxInternalSequence = 999999999
+
+// ErrInvalid é devolvido (embrulhado) por Validate para um código numérico que não é um GTIN.
+var ErrInvalid = errors.New("código de barras inválido")
+
+// CheckDigit calcula o dígito de controlo GS1 dos dígitos indicados (o código sem o último
+// dígito): da direita para a esquerda, os dígitos são multiplicados alternadamente por 3 e 1.
+func CheckDigit(body string) byte {
+	sum := 0
+	for i := len(body) - 1; i >= 0; i-- {
+		d := int(body[i] - '0')
+		if (len(body)-1-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Validate confirma um código de barras. Um código só com dígitos tem de ser um GTIN (8, 12,
+// 13 ou 14 dígitos) com o dígito de controlo certo, o que apanha a maior parte dos erros de
+// digitação. Códigos com letras são códigos livres, impressos em Code 128, e são aceites.
+func Validate(code string) error {
+	if code == "" {
+		return fmt.Errorf("%w: vazio", ErrInvalid)
+	}
+	if !isDigits(code) {
+		return nil
+	}
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return fmt.Errorf("%w: %q tem %d dígitos (um EAN-8, UPC, EAN-13 ou GTIN-14 tem 8, 12, 13 ou 14)", ErrInvalid, code, len(code))
+	}
+	last := len(code) - 1
+	if check := CheckDigit(code[:last]); code[last] != check {
+		return fmt.Errorf("%w: o dígito de controlo de %q devia ser %c", ErrInvalid, code, check)
+	}
+	return nil
+}
+
+// Internal devolve o código interno EAN-13 do número de sequência indicado: o prefixo
+// reservado, o número com 9 dígitos e o dígito de controlo.
+func Internal(sequence int64) (string, error) {
+	if sequence < 1 || sequence > maxInternalSequence {
+		return "", fmt.Errorf("número de código interno fora do intervalo: %d", sequence)
+	}
+	body := fmt.Sprintf("%s%09d", InternalPrefix, sequence)
+	return body + string(CheckDigit(body)), nil
+}
+
+// IsInternal diz se o código é um código interno gerado por Internal.
+func IsInternal(code string) bool {
+	return len(code) == 13 && code[:len(InternalPrefix)] == InternalPrefix && Validate(code) == nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
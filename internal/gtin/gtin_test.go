@@ -0,0 +1,52 @@
+package gtin
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []string{
+		"96385074",       // EAN-8
+		"036000291452",   // UPC-A
+		"7891000100103",  // EAN-13
+		"17891000100100", // GTIN-14 da caixa do EAN-13 acima
+		"SKU-GRANEL-01",  // código livre
+	}
+	for _, code := range valid {
+		if err := Validate(code); err != nil {
+			t.Errorf("Esperava %q válido, obteve %v", code, err)
+		}
+	}
+	invalid := []string{
+		"",
+		"7891000100104", // dígito de controlo trocado
+		"7891000010103", // dígitos trocados
+		"789100010010",  // um dígito a menos
+		"1234567",
+	}
+	for _, code := range invalid {
+		if err := Validate(code); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Esperava ErrInvalid para %q, obteve %v", code, err)
+		}
+	}
+}
+
+func TestInternal(t *testing.T) {
+	code, err := Internal(42)
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if code != "2000000000428" {
+		t.Errorf("Esperava 2000000000428, obteve %s", code)
+	}
+	if !IsInternal(code) || IsInternal("7891000100103") {
+		t.Error("IsInternal não distingue os códigos internos")
+	}
+	if _, err := Internal(0); err == nil {
+		t.Error("Esperava erro para o número 0")
+	}
+	if _, err := Internal(maxInternalSequence + 1); err == nil {
+		t.Error("Esperava erro para um número fora do intervalo")
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/storage"
+)
+
+const (
+	// abcPageLimit é o número de produtos por página no relatório ABC.
+	abcPageLimit = 50
+	// defaultABCPeriod são os dias de vendas usados quando o cálculo não indica outro.
+	defaultABCPeriod = 90
+)
+
+// abcSummaryView acrescenta ao resumo de uma classe a sua participação no total.
+type abcSummaryView struct {
+	models.ABCSummary
+	Participacao float64
+}
+
+// abcParams lê o âmbito, o critério e a classe do pedido; critérios desconhecidos passam a "receita".
+func abcParams(c *gin.Context) (filialID, criterio, classe string) {
+	filialID = c.Query("filial_id")
+	criterio = c.DefaultQuery("criterio", "receita")
+	if criterio != "margem" {
+		criterio = "receita"
+	}
+	classe = strings.ToUpper(c.Query("classe"))
+	if classe != "A" && classe != "B" && classe != "C" {
+		classe = ""
+	}
+	return filialID, criterio, classe
+}
+
+func (h *Handler) ShowABCPage(c *gin.Context) {
+	session := sessions.Default(c)
+	filialID, criterio, classe := abcParams(c)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+
+	run, err := h.Storage.GetABCRun(filialID)
+	if err != nil {
+		log.Printf("Erro ao obter o último cálculo ABC: %v", err)
+	}
+	summary, err := h.Storage.GetABCSummary(filialID, criterio)
+	if err != nil {
+		log.Printf("Erro ao obter o resumo ABC: %v", err)
+	}
+	var total float64
+	for _, s := range summary {
+		if criterio == "margem" {
+			total += math.Max(s.Margem, 0)
+		} else {
+			total += s.Receita
+		}
+	}
+	summaryView := make([]abcSummaryView, 0, len(summary))
+	for _, s := range summary {
+		value := s.Receita
+		if criterio == "margem" {
+			value = math.Max(s.Margem, 0)
+		}
+		view := abcSummaryView{ABCSummary: s}
+		if total > 0 {
+			view.Participacao = value / total * 100
+		}
+		summaryView = append(summaryView, view)
+	}
+
+	totalItems, _ := h.Storage.CountABCItems(filialID, criterio, classe)
+	items, err := h.Storage.GetABCItems(filialID, criterio, classe, abcPageLimit, (page-1)*abcPageLimit)
+	if err != nil {
+		log.Printf("Erro ao listar a classificação ABC: %v", err)
+	}
+	totalPages := int(math.Ceil(float64(totalItems) / float64(abcPageLimit)))
+	filiais, _ := h.Storage.GetAllFiliais()
+
+	data := getFlashes(c)
+	data["title"] = "Classificação ABC"
+	data["run"] = run
+	data["summary"] = summaryView
+	data["items"] = items
+	data["filiais"] = filiais
+	data["FilialID"] = filialID
+	data["Criterio"] = criterio
+	data["Classe"] = classe
+	data["PeriodoPadrao"] = defaultABCPeriod
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "abc"
+	data["Pagination"] = models.PaginationData{
+		HasPrev:     page > 1,
+		HasNext:     page < totalPages,
+		PrevPage:    page - 1,
+		NextPage:    page + 1,
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		FilterID:    filialID,
+	}
+	c.HTML(http.StatusOK, "abc.html", data)
+}
+
+// HandleRunABC recalcula a classificação do âmbito escolhido com as vendas dos últimos N dias.
+func (h *Handler) HandleRunABC(c *gin.Context) {
+	session := sessions.Default(c)
+	filialID := c.PostForm("filial_id")
+	redirect := "/admin/abc?filial_id=" + url.QueryEscape(filialID)
+
+	days, err := strconv.Atoi(c.DefaultPostForm("dias", strconv.Itoa(defaultABCPeriod)))
+	if err != nil || days <= 0 {
+		session.AddFlash("Período inválido.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+	thresholdA := storage.DefaultABCThresholdA
+	thresholdB := storage.DefaultABCThresholdB
+	if v, err := strconv.ParseFloat(c.PostForm("limite_a"), 64); err == nil {
+		thresholdA = v / 100
+	}
+	if v, err := strconv.ParseFloat(c.PostForm("limite_b"), 64); err == nil {
+		thresholdB = v / 100
+	}
+
+	to := today()
+	from := to.AddDate(0, 0, -(days - 1))
+	count, err := h.Storage.RunABCClassification(filialID, from, to, thresholdA, thresholdB)
+	if err != nil {
+		log.Printf("Erro ao calcular a classificação ABC: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao calcular a classificação ABC: %v", err), "error")
+	} else {
+		session.AddFlash(fmt.Sprintf("Classificação ABC calculada para %d produtos.", count), "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// HandleExportABC exporta a classificação guardada em CSV (separador ';' e vírgula
+// decimal, para abrir diretamente no Excel em português).
+func (h *Handler) HandleExportABC(c *gin.Context) {
+	filialID, criterio, classe := abcParams(c)
+	items, err := h.Storage.GetABCItems(filialID, criterio, classe, 0, 0)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Falha ao exportar a classificação ABC.")
+		return
+	}
+
+	filename := fmt.Sprintf("abc_%s_%s.csv", criterio, time.Now().Format("20060102"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	w := csv.NewWriter(c.Writer)
+	w.Comma = ';'
+	w.Write([]string{"Produto", "Código de Barras", "Categoria", "Quantidade", "Receita", "Margem",
+		"% Receita", "% Receita Acumulada", "Classe Receita", "% Margem", "% Margem Acumulada", "Classe Margem"})
+	for _, it := range items {
+		w.Write([]string{
+			it.ProdutoNome, it.CodigoBarras, it.Categoria, strconv.FormatInt(it.Quantidade, 10),
+			csvDecimal(it.Receita), csvDecimal(it.Margem),
+			csvDecimal(it.ParticipacaoReceita), csvDecimal(it.AcumuladoReceita), it.ClasseReceita,
+			csvDecimal(it.ParticipacaoMargem), csvDecimal(it.AcumuladoMargem), it.ClasseMargem,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Erro ao escrever o CSV ABC: %v", err)
+	}
+}
+
+// csvDecimal formata um valor com duas casas e vírgula decimal.
+func csvDecimal(v float64) string {
+	return strings.Replace(strconv.FormatFloat(v, 'f', 2, 64), ".", ",", 1)
+}
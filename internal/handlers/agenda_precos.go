@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/models"
+)
+
+// priceHistoryLimit é o número máximo de alterações devolvidas pelo histórico de um produto.
+const priceHistoryLimit = 200
+
+// priceChangeRow é uma linha do relatório de alterações do dia, com os valores já sem
+// ponteiros; falta o anterior quando a filial passou a ter preço próprio e o novo quando
+// voltou ao preço de catálogo.
+type priceChangeRow struct {
+	models.PriceHistoryEntry
+	Anterior, Novo       float64
+	TemAnterior, TemNovo bool
+}
+
+// HandleGetPriceHistory devolve em JSON as alterações de preço e de custo de um produto.
+func (h *Handler) HandleGetPriceHistory(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limite", strconv.Itoa(priceHistoryLimit)))
+	if err != nil || limit < 1 || limit > priceHistoryLimit {
+		limit = priceHistoryLimit
+	}
+	history, err := h.Storage.GetPriceHistory(c.Param("id"), limit)
+	if err != nil {
+		log.Printf("Erro ao obter o histórico de preços: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao obter o histórico de preços."})
+		return
+	}
+	if history == nil {
+		history = []models.PriceHistoryEntry{}
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// ShowPriceSchedulePage mostra as alterações de preço de um dia (data, por omissão hoje),
+// com a ligação para reimprimir as etiquetas, e as alterações agendadas ainda pendentes.
+func (h *Handler) ShowPriceSchedulePage(c *gin.Context) {
+	session := sessions.Default(c)
+	day := today()
+	if value := c.Query("data"); value != "" {
+		if parsed, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+			day = parsed
+		}
+	}
+	filialID := c.Query("filial_id")
+
+	filiais, _ := h.Storage.GetAllFiliais()
+	changes, err := h.Storage.GetPriceChangesOn(day, filialID)
+	if err != nil {
+		log.Printf("Erro ao obter as alterações de preço do dia: %v", err)
+	}
+	pending, err := h.Storage.GetPendingPriceChanges(nil)
+	if err != nil {
+		log.Printf("Erro ao obter os preços agendados: %v", err)
+	}
+
+	// Etiquetas de preço dos produtos alterados no dia, sem repetir produtos.
+	query := url.Values{"modelo": {"preco"}}
+	if filialID != "" {
+		query.Set("filial_id", filialID)
+	}
+	seen := make(map[uuid.UUID]bool)
+	rows := make([]priceChangeRow, 0, len(changes))
+	for _, change := range changes {
+		row := priceChangeRow{PriceHistoryEntry: change}
+		if change.ValorAnterior != nil {
+			row.Anterior, row.TemAnterior = *change.ValorAnterior, true
+		}
+		if change.ValorNovo != nil {
+			row.Novo, row.TemNovo = *change.ValorNovo, true
+		}
+		rows = append(rows, row)
+		if !seen[change.ProdutoID] {
+			seen[change.ProdutoID] = true
+			query.Add("produto_id", change.ProdutoID.String())
+		}
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Agenda de Preços"
+	data["day"] = day.Format("2006-01-02")
+	data["today"] = today().Format("2006-01-02")
+	data["filialID"] = filialID
+	data["filiais"] = filiais
+	data["changes"] = rows
+	data["pending"] = pending
+	data["labelsURL"] = "/estoque/etiquetas?" + query.Encode()
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "precos"
+	c.HTML(http.StatusOK, "precos_agenda.html", data)
+}
+
+// HandleSchedulePriceChange agenda um novo preço (de catálogo ou de uma filial) para o
+// produto indicado pelo código de barras.
+func (h *Handler) HandleSchedulePriceChange(c *gin.Context) {
+	session := sessions.Default(c)
+	redirect := "/admin/precos/agenda"
+	fail := func(msg string) {
+		session.AddFlash(msg, "error")
+		session.Save()
+		c.Redirect(http.StatusFound, redirect)
+	}
+
+	product, err := h.Storage.GetProductDetails(strings.TrimSpace(c.PostForm("produto")))
+	if err != nil || product == nil {
+		fail("Produto não encontrado.")
+		return
+	}
+	price, err := parsePrice(c.PostForm("preco"))
+	if err != nil || price < 0 {
+		fail("Preço inválido.")
+		return
+	}
+	effective, err := time.ParseInLocation("2006-01-02", c.PostForm("data_efetiva"), time.Local)
+	if err != nil {
+		fail("Data efetiva inválida.")
+		return
+	}
+	userID, _ := uuid.Parse(session.Get("userID").(string))
+	change := models.ScheduledPriceChange{ProdutoID: product.ID, Preco: price, DataEfetiva: effective, UsuarioID: userID}
+	if value := c.PostForm("filial_id"); value != "" {
+		filialID, err := uuid.Parse(value)
+		if err != nil {
+			fail("Filial inválida.")
+			return
+		}
+		change.FilialID = &filialID
+	}
+
+	if _, err := h.Storage.SchedulePriceChange(change); err != nil {
+		log.Printf("Erro ao agendar alteração de preço: %v", err)
+		fail(fmt.Sprintf("Falha ao agendar a alteração de preço: %v", err))
+		return
+	}
+	session.AddFlash(fmt.Sprintf("Preço de '%s' agendado para %s.", product.Nome, effective.Format("02/01/2006")), "success")
+	session.Save()
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// HandleCancelScheduledPriceChange cancela uma alteração de preço ainda pendente.
+func (h *Handler) HandleCancelScheduledPriceChange(c *gin.Context) {
+	session := sessions.Default(c)
+	if err := h.Storage.CancelScheduledPriceChange(c.Param("id")); err != nil {
+		log.Printf("Erro ao cancelar alteração de preço: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao cancelar: %v", err), "error")
+	} else {
+		session.AddFlash("Alteração de preço cancelada.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/precos/agenda")
+}
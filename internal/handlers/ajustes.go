@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/models"
+)
+
+// adjustmentReason é um motivo de baixa de stock, com o código gravado na base de dados.
+type adjustmentReason struct {
+	Valor     string
+	Descricao string
+}
+
+// stockAdjustmentReasons são os motivos aceites para uma baixa de stock.
+var stockAdjustmentReasons = []adjustmentReason{
+	{Valor: "quebra", Descricao: "Quebra"},
+	{Valor: "furto", Descricao: "Furto"},
+	{Valor: "validade", Descricao: "Validade expirada"},
+	{Valor: "uso_interno", Descricao: "Uso interno"},
+}
+
+// adjustmentReasonLabel devolve a descrição de um motivo, ou "" se o código não for válido.
+func adjustmentReasonLabel(code string) string {
+	for _, r := range stockAdjustmentReasons {
+		if r.Valor == code {
+			return r.Descricao
+		}
+	}
+	return ""
+}
+
+// adjustmentLimit é o número de ajustes mostrados na lista.
+const adjustmentLimit = 100
+
+// createAdjustment valida e regista uma baixa pedida pelo utilizador da sessão.
+// Devolve a mensagem a mostrar e o erro, se houver.
+func (h *Handler) createAdjustment(session sessions.Session, productIDStr, filialIDStr string, quantity int, motivo, observacao string) (string, error) {
+	if adjustmentReasonLabel(motivo) == "" {
+		return "", fmt.Errorf("indique um motivo válido para a baixa")
+	}
+	if quantity <= 0 {
+		return "", fmt.Errorf("a quantidade da baixa tem de ser positiva")
+	}
+	productID, err := uuid.Parse(productIDStr)
+	if err != nil {
+		return "", fmt.Errorf("produto inválido")
+	}
+	if session.Get("userRole") != "admin" {
+		filialIDStr, _ = session.Get("filialID").(string)
+	}
+	filialID, err := uuid.Parse(filialIDStr)
+	if err != nil {
+		return "", fmt.Errorf("filial inválida")
+	}
+	userID, _ := uuid.Parse(session.Get("userID").(string))
+
+	status, err := h.Storage.CreateStockAdjustment(models.StockAdjustment{
+		ProdutoID:     productID,
+		FilialID:      filialID,
+		Quantidade:    quantity,
+		Motivo:        motivo,
+		Observacao:    observacao,
+		SolicitadoPor: userID,
+	})
+	if err != nil {
+		return "", err
+	}
+	if status == "pendente" {
+		return "A baixa excede o limite de aprovação e ficou pendente até um administrador a aprovar.", nil
+	}
+	return "Baixa de stock registada com sucesso!", nil
+}
+
+func (h *Handler) ShowStockAdjustmentsPage(c *gin.Context) {
+	session := sessions.Default(c)
+	userRole := session.Get("userRole")
+
+	filialID := c.Query("filial_id")
+	if userRole != "admin" {
+		sessionFilial, ok := session.Get("filialID").(string)
+		if !ok {
+			c.HTML(http.StatusForbidden, "error.html", gin.H{"title": "Erro", "ErrorMessage": "Utilizador não está associado a nenhuma filial."})
+			return
+		}
+		filialID = sessionFilial
+	}
+	status := c.Query("status")
+	days, err := strconv.Atoi(c.DefaultQuery("dias", "30"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+
+	adjustments, err := h.Storage.GetStockAdjustments(filialID, status, adjustmentLimit)
+	if err != nil {
+		log.Printf("Erro ao listar ajustes de stock: %v", err)
+	}
+	for i := range adjustments {
+		adjustments[i].MotivoDescricao = adjustmentReasonLabel(adjustments[i].Motivo)
+	}
+	allProducts, _ := h.Storage.GetAllProductsSimple()
+
+	data := getFlashes(c)
+	data["title"] = "Ajustes de Stock"
+	data["adjustments"] = adjustments
+	data["allProducts"] = allProducts
+	data["motivos"] = stockAdjustmentReasons
+	data["Status"] = status
+	data["Dias"] = days
+	data["UserRole"] = userRole
+	data["UserName"] = session.Get("userName")
+	data["FilialName"] = session.Get("filialName")
+	data["FilialID"] = filialID
+	data["ActivePage"] = "ajustes"
+	if userRole == "admin" {
+		filiais, _ := h.Storage.GetAllFiliais()
+		data["filiais"] = filiais
+
+		report, err := h.Storage.GetShrinkageReport(filialID, days)
+		if err != nil {
+			log.Printf("Erro ao gerar relatório de quebras: %v", err)
+		}
+		var totalValue float64
+		for i := range report {
+			report[i].MotivoDescricao = adjustmentReasonLabel(report[i].Motivo)
+			totalValue += report[i].Valor
+		}
+		data["shrinkage"] = report
+		data["TotalQuebras"] = totalValue
+		if empresa, err := h.Storage.GetEmpresa(); err == nil {
+			data["LimiteAprovacao"] = empresa.LimiteAprovacaoAjuste
+		}
+	}
+	c.HTML(http.StatusOK, "ajustes.html", data)
+}
+
+func (h *Handler) HandleCreateStockAdjustment(c *gin.Context) {
+	session := sessions.Default(c)
+	quantity, err := strconv.Atoi(c.PostForm("quantity"))
+	if err != nil {
+		quantity = 0
+	}
+	message, err := h.createAdjustment(session, c.PostForm("product_id"), c.PostForm("filial_id"), quantity, c.PostForm("motivo"), c.PostForm("observacao"))
+	if err != nil {
+		log.Printf("Erro ao registar ajuste de stock: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao registar a baixa: %v", err), "error")
+	} else {
+		session.AddFlash(message, "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/estoque/ajustes")
+}
+
+// HandleAPIStockAdjust é a versão JSON usada pelo modal "Ajustar Stock" do painel admin.
+func (h *Handler) HandleAPIStockAdjust(c *gin.Context) {
+	var req struct {
+		ProductID  string `json:"product_id"`
+		FilialID   string `json:"filial_id"`
+		Quantity   int    `json:"quantity"`
+		Motivo     string `json:"motivo"`
+		Observacao string `json:"observacao"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Dados do ajuste inválidos"})
+		return
+	}
+	session := sessions.Default(c)
+	message, err := h.createAdjustment(session, req.ProductID, req.FilialID, req.Quantity, req.Motivo, req.Observacao)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func (h *Handler) HandleApproveStockAdjustment(c *gin.Context) {
+	session := sessions.Default(c)
+	approverID, _ := session.Get("userID").(string)
+	if err := h.Storage.ApproveStockAdjustment(c.Param("id"), approverID); err != nil {
+		log.Printf("Erro ao aprovar ajuste de stock: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao aprovar a baixa: %v", err), "error")
+	} else {
+		session.AddFlash("Baixa aprovada e retirada do stock.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/estoque/ajustes?status=pendente")
+}
+
+func (h *Handler) HandleRejectStockAdjustment(c *gin.Context) {
+	session := sessions.Default(c)
+	approverID, _ := session.Get("userID").(string)
+	if err := h.Storage.RejectStockAdjustment(c.Param("id"), approverID); err != nil {
+		session.AddFlash(fmt.Sprintf("Falha ao rejeitar a baixa: %v", err), "error")
+	} else {
+		session.AddFlash("Baixa rejeitada. O stock não foi alterado.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/estoque/ajustes?status=pendente")
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// runArchiveAction aplica uma ação do arquivo ao registo da rota e volta à página anterior
+// com o resultado. As mensagens de erro do storage explicam o motivo ao administrador,
+// p.ex. porque é que um produto com vendas não pode ser apagado.
+func runArchiveAction(c *gin.Context, action func(id string) error, success, failure string) {
+	session := sessions.Default(c)
+	if err := action(c.Param("id")); err != nil {
+		log.Printf("%s: %v", failure, err)
+		session.AddFlash(fmt.Sprintf("%s: %v.", failure, err), "error")
+	} else {
+		session.AddFlash(success, "success")
+	}
+	session.Save()
+	back := c.Request.Header.Get("Referer")
+	if back == "" {
+		back = "/admin/dashboard"
+	}
+	c.Redirect(http.StatusFound, back)
+}
+
+// rejectOwnUser impede o administrador de arquivar ou apagar o próprio utilizador.
+func rejectOwnUser(c *gin.Context, action func(id string) error) func(id string) error {
+	return func(id string) error {
+		if userID, _ := sessions.Default(c).Get("userID").(string); userID == id {
+			return errors.New("não pode arquivar nem apagar o seu próprio utilizador")
+		}
+		return action(id)
+	}
+}
+
+func (h *Handler) HandleArchiveProduct(c *gin.Context) {
+	runArchiveAction(c, h.Storage.ArchiveProduct, "Produto arquivado. Pode restaurá-lo na lista de arquivados.", "Falha ao arquivar o produto")
+}
+
+func (h *Handler) HandleRestoreProduct(c *gin.Context) {
+	runArchiveAction(c, h.Storage.RestoreProduct, "Produto restaurado para o catálogo.", "Falha ao restaurar o produto")
+}
+
+// HandleDeleteProduct apaga definitivamente um produto arquivado, se não tiver vendas.
+func (h *Handler) HandleDeleteProduct(c *gin.Context) {
+	runArchiveAction(c, h.Storage.DeleteProductByID, "Produto apagado definitivamente.", "Não foi possível apagar o produto")
+}
+
+func (h *Handler) HandleArchiveUser(c *gin.Context) {
+	runArchiveAction(c, rejectOwnUser(c, h.Storage.ArchiveUser), "Utilizador arquivado: já não pode entrar no sistema.", "Falha ao arquivar o utilizador")
+}
+
+func (h *Handler) HandleRestoreUser(c *gin.Context) {
+	runArchiveAction(c, h.Storage.RestoreUser, "Utilizador restaurado: pode voltar a entrar no sistema.", "Falha ao restaurar o utilizador")
+}
+
+// HandleDeleteUser apaga definitivamente um utilizador arquivado, se não tiver registos.
+func (h *Handler) HandleDeleteUser(c *gin.Context) {
+	runArchiveAction(c, rejectOwnUser(c, h.Storage.DeleteUserByID), "Utilizador apagado definitivamente.", "Não foi possível apagar o utilizador")
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/pricing"
+)
+
+// parseCategoryID lê o ID de categoria de um formulário; vazio significa sem categoria.
+func parseCategoryID(value string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("categoria inválida")
+	}
+	return &id, nil
+}
+
+// subcategoriesOf devolve a categoria com o caminho indicado e as suas descendentes;
+// com o caminho vazio devolve todas.
+func subcategoriesOf(categories []models.Category, caminho string) []models.Category {
+	if caminho == "" {
+		return categories
+	}
+	var result []models.Category
+	for _, cat := range categories {
+		if cat.Caminho == caminho || strings.HasPrefix(cat.Caminho, caminho+" > ") {
+			result = append(result, cat)
+		}
+	}
+	return result
+}
+
+func (h *Handler) ShowCategoriesPage(c *gin.Context) {
+	session := sessions.Default(c)
+	categories, err := h.Storage.GetCategories()
+	if err != nil {
+		log.Printf("Erro ao obter categorias: %v", err)
+	}
+	for i, cat := range categories {
+		if cat.EstrategiaPreco != nil {
+			categories[i].EstrategiaDescricao = pricing.Describe(*cat.EstrategiaPreco)
+		}
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Categorias"
+	data["categories"] = categories
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "categorias"
+	c.HTML(http.StatusOK, "categorias.html", data)
+}
+
+// HandleAddCategory cria um departamento ou, com pai_id, uma categoria ou subcategoria.
+func (h *Handler) HandleAddCategory(c *gin.Context) {
+	session := sessions.Default(c)
+	nome := strings.TrimSpace(c.PostForm("nome"))
+	if _, err := h.Storage.AddCategory(nome, c.PostForm("pai_id")); err != nil {
+		log.Printf("Erro ao criar categoria: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao criar a categoria: %v", err), "error")
+	} else {
+		session.AddFlash(fmt.Sprintf("Categoria '%s' criada com sucesso!", nome), "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/categorias")
+}
+
+func (h *Handler) HandleRenameCategory(c *gin.Context) {
+	session := sessions.Default(c)
+	if err := h.Storage.RenameCategory(c.Param("id"), c.PostForm("nome")); err != nil {
+		log.Printf("Erro ao renomear categoria: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao renomear a categoria: %v", err), "error")
+	} else {
+		session.AddFlash("Categoria renomeada com sucesso!", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/categorias")
+}
+
+func (h *Handler) HandleDeleteCategory(c *gin.Context) {
+	session := sessions.Default(c)
+	if err := h.Storage.DeleteCategory(c.Param("id")); err != nil {
+		log.Printf("Erro ao apagar categoria: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao apagar a categoria: %v", err), "error")
+	} else {
+		session.AddFlash("Categoria apagada com sucesso!", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/categorias")
+}
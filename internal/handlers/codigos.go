@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/gtin"
+	"projeto-vendas/internal/models"
+)
+
+// ShowProductBarcodesPage lista o código principal e os códigos adicionais de um produto.
+func (h *Handler) ShowProductBarcodesPage(c *gin.Context) {
+	session := sessions.Default(c)
+	product, err := h.Storage.GetProductByID(c.Param("id"))
+	if err != nil || product == nil {
+		session.AddFlash("Produto não encontrado.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
+	}
+	codes, err := h.Storage.GetProductBarcodes(product.ID.String())
+	if err != nil {
+		log.Printf("Erro ao listar códigos de barras: %v", err)
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Códigos de barras de " + product.Nome
+	data["product"] = product
+	data["codes"] = codes
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "dashboard"
+	c.HTML(http.StatusOK, "produto_codigos.html", data)
+}
+
+// HandleAddProductBarcode associa um código adicional (EAN alternativo ou código de
+// embalagem com multiplicador) ao produto da rota.
+func (h *Handler) HandleAddProductBarcode(c *gin.Context) {
+	session := sessions.Default(c)
+	productID := c.Param("id")
+	redirect := "/admin/products/codigos/" + productID
+
+	parsedID, err := uuid.Parse(productID)
+	if err != nil {
+		session.AddFlash("Produto inválido.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
+	}
+	multiplier, err := strconv.Atoi(c.DefaultPostForm("multiplicador", "1"))
+	if err != nil || multiplier < 1 {
+		session.AddFlash("O multiplicador tem de ser um número inteiro positivo.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+	code := models.ProductBarcode{
+		Codigo:        strings.TrimSpace(c.PostForm("codigo")),
+		ProdutoID:     parsedID,
+		Multiplicador: multiplier,
+		Descricao:     c.PostForm("descricao"),
+	}
+	err = gtin.Validate(code.Codigo)
+	if err == nil {
+		err = h.Storage.AddProductBarcode(code)
+	}
+	if err != nil {
+		log.Printf("Erro ao adicionar código de barras: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao adicionar código de barras: %v", err), "error")
+	} else {
+		session.AddFlash("Código de barras adicionado com sucesso!", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// HandleDeleteProductBarcode remove um código adicional do produto da rota.
+func (h *Handler) HandleDeleteProductBarcode(c *gin.Context) {
+	session := sessions.Default(c)
+	if err := h.Storage.DeleteProductBarcode(c.PostForm("codigo")); err != nil {
+		log.Printf("Erro ao remover código de barras: %v", err)
+		session.AddFlash("Falha ao remover o código de barras.", "error")
+	} else {
+		session.AddFlash("Código de barras removido.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/products/codigos/"+c.Param("id"))
+}
+
+// barcodeFromForm valida o código de barras principal de um formulário de produto ou de
+// variante. Vazio gera um código interno, para os produtos sem código do fabricante. O
+// código já gravado (stored) passa sem validação, para que os produtos com códigos antigos
+// que não são GTIN continuem editáveis.
+func (h *Handler) barcodeFromForm(value, stored string) (string, error) {
+	code := strings.TrimSpace(value)
+	if code == "" {
+		return h.Storage.GenerateInternalBarcode()
+	}
+	if code == stored {
+		return code, nil
+	}
+	return code, gtin.Validate(code)
+}
+
+// storedBarcode devolve o código de barras gravado do produto ou variante, ou vazio se não
+// for encontrado.
+func (h *Handler) storedBarcode(productID string) string {
+	product, err := h.Storage.GetProductByID(productID)
+	if err != nil || product == nil {
+		return ""
+	}
+	return product.CodigoBarras
+}
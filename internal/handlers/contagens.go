@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/models"
+)
+
+// canAccessCount verifica se o utilizador da sessão pode operar sobre a contagem:
+// o admin acede a todas, o estoquista apenas às da sua filial.
+func canAccessCount(session sessions.Session, count *models.InventoryCount) bool {
+	if session.Get("userRole") == "admin" {
+		return true
+	}
+	filialID, _ := session.Get("filialID").(string)
+	return filialID == count.FilialID.String()
+}
+
+// loadCountForSession obtém a contagem indicada no URL e valida o acesso.
+// Em caso de erro já escreve a resposta e devolve nil.
+func (h *Handler) loadCountForSession(c *gin.Context) *models.InventoryCount {
+	session := sessions.Default(c)
+	count, err := h.Storage.GetInventoryCountByID(c.Param("id"))
+	if err != nil || count == nil {
+		if err != nil {
+			log.Printf("Erro ao obter contagem: %v", err)
+		}
+		c.HTML(http.StatusNotFound, "error.html", gin.H{"title": "Erro", "StatusCode": http.StatusNotFound, "ErrorMessage": "Contagem não encontrada."})
+		return nil
+	}
+	if !canAccessCount(session, count) {
+		c.HTML(http.StatusForbidden, "error.html", gin.H{"title": "Acesso Negado", "StatusCode": http.StatusForbidden, "ErrorMessage": "Acesso Negado"})
+		return nil
+	}
+	return count
+}
+
+func (h *Handler) ShowInventoryCountsPage(c *gin.Context) {
+	session := sessions.Default(c)
+	userRole := session.Get("userRole")
+
+	filialID := c.Query("filial_id")
+	if userRole != "admin" {
+		sessionFilial, ok := session.Get("filialID").(string)
+		if !ok {
+			c.HTML(http.StatusForbidden, "error.html", gin.H{"title": "Erro", "ErrorMessage": "Utilizador não está associado a nenhuma filial."})
+			return
+		}
+		filialID = sessionFilial
+	}
+
+	counts, err := h.Storage.GetInventoryCounts(filialID)
+	if err != nil {
+		log.Printf("Erro ao listar contagens: %v", err)
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Contagens de Inventário"
+	data["counts"] = counts
+	data["UserRole"] = userRole
+	data["UserName"] = session.Get("userName")
+	data["FilialName"] = session.Get("filialName")
+	data["FilialID"] = filialID
+	data["ActivePage"] = "contagens"
+	categories, _ := h.Storage.GetCategories()
+	data["categories"] = categories
+	if userRole == "admin" {
+		filiais, _ := h.Storage.GetAllFiliais()
+		data["filiais"] = filiais
+	}
+	c.HTML(http.StatusOK, "contagens.html", data)
+}
+
+func (h *Handler) HandleCreateInventoryCount(c *gin.Context) {
+	session := sessions.Default(c)
+	userID, _ := uuid.Parse(session.Get("userID").(string))
+
+	filialIDStr := c.PostForm("filial_id")
+	if session.Get("userRole") != "admin" {
+		filialIDStr, _ = session.Get("filialID").(string)
+	}
+	filialID, err := uuid.Parse(filialIDStr)
+	if err != nil {
+		session.AddFlash("Selecione uma filial válida para a contagem.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/estoque/contagens")
+		return
+	}
+
+	categoriaID, err := parseCategoryID(c.PostForm("categoria_id"))
+	if err != nil {
+		session.AddFlash("Selecione uma categoria válida para a contagem.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/estoque/contagens")
+		return
+	}
+
+	count := models.InventoryCount{
+		FilialID:    filialID,
+		Descricao:   c.PostForm("descricao"),
+		CategoriaID: categoriaID,
+		CriadoPor:   userID,
+	}
+	countID, err := h.Storage.CreateInventoryCount(count)
+	if err != nil {
+		log.Printf("Erro ao criar contagem: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao abrir a contagem: %v", err), "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/estoque/contagens")
+		return
+	}
+	session.AddFlash("Contagem aberta com sucesso! As quantidades esperadas foram congeladas.", "success")
+	session.Save()
+	c.Redirect(http.StatusFound, "/estoque/contagens/"+countID.String())
+}
+
+func (h *Handler) ShowInventoryCountEntryPage(c *gin.Context) {
+	session := sessions.Default(c)
+	count := h.loadCountForSession(c)
+	if count == nil {
+		return
+	}
+
+	// A folha de contagem é por categoria (com as subcategorias); numa contagem parcial
+	// só se podem escolher a categoria da contagem e as suas descendentes.
+	defaultCategory := ""
+	if count.CategoriaID != nil {
+		defaultCategory = count.CategoriaID.String()
+	}
+	categoria := c.DefaultQuery("categoria", defaultCategory)
+	categories, err := h.Storage.GetCategories()
+	if err != nil {
+		log.Printf("Erro ao obter categorias: %v", err)
+	}
+	userID, _ := session.Get("userID").(string)
+	entries, err := h.Storage.GetInventoryCountEntries(count.ID.String(), userID)
+	if err != nil {
+		log.Printf("Erro ao obter lançamentos da contagem: %v", err)
+	}
+	var sheet []models.Product
+	if categoria != "" {
+		sheet, err = h.Storage.GetInventoryCountProducts(count.ID.String(), categoria)
+		if err != nil {
+			log.Printf("Erro ao obter folha de contagem: %v", err)
+		}
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Lançar Contagem"
+	data["count"] = count
+	data["entries"] = entries
+	data["sheet"] = sheet
+	data["SheetCategory"] = categoria
+	data["categorias"] = subcategoriesOf(categories, count.Secao)
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["FilialName"] = session.Get("filialName")
+	data["ActivePage"] = "contagens"
+	c.HTML(http.StatusOK, "contagem_lancamento.html", data)
+}
+
+func (h *Handler) HandleAddInventoryCountEntry(c *gin.Context) {
+	session := sessions.Default(c)
+	count := h.loadCountForSession(c)
+	if count == nil {
+		return
+	}
+	redirectURL := "/estoque/contagens/" + count.ID.String()
+
+	quantity, err := strconv.Atoi(c.PostForm("quantity"))
+	barcode := c.PostForm("barcode")
+	if err != nil || quantity < 0 || barcode == "" {
+		session.AddFlash("Indique um código de barras e uma quantidade válida.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, redirectURL)
+		return
+	}
+
+	userID, _ := uuid.Parse(session.Get("userID").(string))
+	entry := models.InventoryCountEntry{
+		ContagemID: count.ID,
+		UsuarioID:  userID,
+		Secao:      c.PostForm("secao"),
+		Quantidade: quantity,
+	}
+	if err := h.Storage.AddInventoryCountEntry(entry, barcode); err != nil {
+		log.Printf("Erro ao lançar contagem: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao registar o lançamento: %v", err), "error")
+	} else {
+		session.AddFlash("Lançamento registado.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// HandleAddInventoryCountSheet recebe a folha de contagem de uma secção inteira.
+// Linhas com a quantidade em branco são ignoradas.
+func (h *Handler) HandleAddInventoryCountSheet(c *gin.Context) {
+	session := sessions.Default(c)
+	count := h.loadCountForSession(c)
+	if count == nil {
+		return
+	}
+	categoria := c.PostForm("categoria")
+	redirectURL := "/estoque/contagens/" + count.ID.String() + "?categoria=" + url.QueryEscape(categoria)
+
+	userID, _ := uuid.Parse(session.Get("userID").(string))
+	productIDs := c.PostFormArray("product_id")
+	quantities := c.PostFormArray("quantity")
+	registered := 0
+	var failures []string
+	for i, productIDStr := range productIDs {
+		if i >= len(quantities) || quantities[i] == "" {
+			continue
+		}
+		quantity, err := strconv.Atoi(quantities[i])
+		productID, errID := uuid.Parse(productIDStr)
+		if err != nil || errID != nil || quantity < 0 {
+			failures = append(failures, productIDStr)
+			continue
+		}
+		entry := models.InventoryCountEntry{
+			ContagemID: count.ID,
+			ProdutoID:  productID,
+			UsuarioID:  userID,
+			Secao:      c.PostForm("secao"),
+			Quantidade: quantity,
+		}
+		if err := h.Storage.AddInventoryCountEntry(entry, ""); err != nil {
+			log.Printf("Erro ao lançar contagem do produto %s: %v", productIDStr, err)
+			failures = append(failures, productIDStr)
+			continue
+		}
+		registered++
+	}
+
+	if len(failures) > 0 {
+		session.AddFlash(fmt.Sprintf("%d lançamentos registados, %d falharam.", registered, len(failures)), "error")
+	} else {
+		session.AddFlash(fmt.Sprintf("%d lançamentos registados.", registered), "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+func (h *Handler) HandleCloseInventoryCount(c *gin.Context) {
+	session := sessions.Default(c)
+	count := h.loadCountForSession(c)
+	if count == nil {
+		return
+	}
+	if err := h.Storage.CloseInventoryCount(count.ID.String()); err != nil {
+		session.AddFlash(fmt.Sprintf("Falha ao encerrar a contagem: %v", err), "error")
+	} else {
+		session.AddFlash("Contagem encerrada e enviada para aprovação do administrador.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/estoque/contagens")
+}
+
+func (h *Handler) ShowInventoryCountReviewPage(c *gin.Context) {
+	session := sessions.Default(c)
+	count := h.loadCountForSession(c)
+	if count == nil {
+		return
+	}
+
+	variances, err := h.Storage.GetInventoryCountVariances(count.ID.String())
+	if err != nil {
+		log.Printf("Erro ao calcular divergências: %v", err)
+	}
+	var totalDiff int
+	var totalValue, shortageValue, surplusValue float64
+	for _, v := range variances {
+		totalDiff += v.Diferenca
+		totalValue += v.DiferencaValor
+		if v.DiferencaValor < 0 {
+			shortageValue += v.DiferencaValor
+		} else {
+			surplusValue += v.DiferencaValor
+		}
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Revisão da Contagem"
+	data["count"] = count
+	data["variances"] = variances
+	data["TotalDiferenca"] = totalDiff
+	data["TotalValor"] = totalValue
+	data["ValorFalta"] = shortageValue
+	data["ValorSobra"] = surplusValue
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "contagens"
+	c.HTML(http.StatusOK, "contagem_revisao.html", data)
+}
+
+func (h *Handler) HandleApproveInventoryCount(c *gin.Context) {
+	session := sessions.Default(c)
+	countID := c.Param("id")
+	approverID, _ := session.Get("userID").(string)
+
+	err := h.Storage.ApproveInventoryCount(countID, approverID, c.PostForm("motivo"))
+	if err != nil {
+		log.Printf("Erro ao aprovar contagem: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao aprovar a contagem: %v", err), "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/contagens/"+countID)
+		return
+	}
+	session.AddFlash("Contagem aprovada e ajustes lançados no stock!", "success")
+	session.Save()
+	c.Redirect(http.StatusFound, "/estoque/contagens")
+}
+
+func (h *Handler) HandleCancelInventoryCount(c *gin.Context) {
+	session := sessions.Default(c)
+	if err := h.Storage.CancelInventoryCount(c.Param("id")); err != nil {
+		session.AddFlash(fmt.Sprintf("Falha ao cancelar a contagem: %v", err), "error")
+	} else {
+		session.AddFlash("Contagem cancelada.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/estoque/contagens")
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/models"
+)
+
+// ShowPurchaseOrdersPage lista os rascunhos de encomenda, um por fornecedor e filial.
+func (h *Handler) ShowPurchaseOrdersPage(c *gin.Context) {
+	session := sessions.Default(c)
+	orders, err := h.Storage.GetPurchaseOrderDrafts()
+	if err != nil {
+		log.Printf("Erro ao listar os rascunhos de encomenda: %v", err)
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Encomendas a Fornecedores"
+	data["orders"] = orders
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "encomendas"
+	c.HTML(http.StatusOK, "encomendas.html", data)
+}
+
+// HandleCreatePurchaseOrderDrafts junta as linhas marcadas na reposição aos rascunhos de
+// encomenda do fornecedor preferencial de cada produto.
+func (h *Handler) HandleCreatePurchaseOrderDrafts(c *gin.Context) {
+	session := sessions.Default(c)
+	fail := func(err error) {
+		log.Printf("Erro ao criar os rascunhos de encomenda: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao criar os rascunhos de encomenda: %v", err), "error")
+		session.Save()
+		back := c.Request.Header.Get("Referer")
+		if back == "" {
+			back = "/admin/reposicao"
+		}
+		c.Redirect(http.StatusFound, back)
+	}
+
+	lines, err := parsePurchaseOrderLines(c.PostFormArray("item"))
+	if err != nil {
+		fail(err)
+		return
+	}
+	userID, _ := session.Get("userID").(string)
+	drafts, err := h.Storage.CreatePurchaseOrderDrafts(lines, userID)
+	if err != nil {
+		fail(err)
+		return
+	}
+	session.AddFlash(fmt.Sprintf("%d produto(s) juntos a %d rascunho(s) de encomenda.", len(lines), drafts), "success")
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/encomendas")
+}
+
+// HandleDeletePurchaseOrderDraft apaga um rascunho de encomenda.
+func (h *Handler) HandleDeletePurchaseOrderDraft(c *gin.Context) {
+	session := sessions.Default(c)
+	if err := h.Storage.DeletePurchaseOrderDraft(c.Param("id")); err != nil {
+		log.Printf("Erro ao apagar o rascunho de encomenda: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao apagar o rascunho: %v", err), "error")
+	} else {
+		session.AddFlash("Rascunho de encomenda apagado.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/encomendas")
+}
+
+// parsePurchaseOrderLines lê as linhas marcadas na reposição, cada uma no formato
+// "produto:filial:quantidade" com a quantidade na unidade de venda.
+func parsePurchaseOrderLines(values []string) ([]models.PurchaseOrderLine, error) {
+	lines := make([]models.PurchaseOrderLine, 0, len(values))
+	for _, value := range values {
+		parts := strings.Split(value, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("linha de encomenda inválida: %q", value)
+		}
+		productID, err := uuid.Parse(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("produto inválido: %q", parts[0])
+		}
+		filialID, err := uuid.Parse(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("filial inválida: %q", parts[1])
+		}
+		quantity, err := strconv.Atoi(parts[2])
+		if err != nil || quantity < 1 {
+			return nil, fmt.Errorf("quantidade inválida: %q", parts[2])
+		}
+		lines = append(lines, models.PurchaseOrderLine{ProdutoID: productID, FilialID: filialID, Quantidade: quantity})
+	}
+	return lines, nil
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/pricing"
+)
+
+// Origem da estratégia usada num cálculo de preço.
+const (
+	strategyOriginProduct  = "produto"
+	strategyOriginCategory = "categoria"
+	strategyOriginDefault  = "padrão"
+)
+
+// pricingInput lê o custo, o lucro e os impostos de um formulário de produto.
+func pricingInput(get func(string) string) pricing.Input {
+	var in pricing.Input
+	in.Custo, _ = strconv.ParseFloat(get("preco_custo"), 64)
+	in.Lucro, _ = strconv.ParseFloat(get("percentual_lucro"), 64)
+	in.ImpostoEstadual, _ = strconv.ParseFloat(get("imposto_estadual"), 64)
+	in.ImpostoFederal, _ = strconv.ParseFloat(get("imposto_federal"), 64)
+	return in
+}
+
+// pricingStrategyFromForm lê a estratégia de preço dos campos estrategia_*. Sem método (a
+// opção "Herdar") devolve nil: o produto usa a da categoria e a categoria a de cima.
+func pricingStrategyFromForm(get func(string) string) (*models.PricingStrategy, error) {
+	metodo := get("estrategia_metodo")
+	if metodo == "" {
+		return nil, nil
+	}
+	strategy := models.PricingStrategy{
+		Metodo:         metodo,
+		Impostos:       get("estrategia_impostos"),
+		Arredondamento: get("estrategia_arredondamento"),
+	}
+	if raw := strings.TrimSpace(get("estrategia_margem_minima")); raw != "" {
+		v, err := strconv.ParseFloat(strings.Replace(raw, ",", ".", 1), 64)
+		if err != nil {
+			return nil, errors.New("margem mínima inválida")
+		}
+		strategy.MargemMinima = v
+	}
+	if err := pricing.Validate(strategy); err != nil {
+		return nil, err
+	}
+	return &strategy, nil
+}
+
+// resolvePrice calcula o preço sugerido com a estratégia própria do produto ou, sem ela, com
+// a herdada da categoria. Devolve também a estratégia usada e de onde veio.
+func (h *Handler) resolvePrice(own *models.PricingStrategy, categoryID *uuid.UUID, in pricing.Input) (pricing.Result, models.PricingStrategy, string, error) {
+	strategy, origin := pricing.Default, strategyOriginDefault
+	if own != nil {
+		strategy, origin = *own, strategyOriginProduct
+	} else if categoryID != nil {
+		inherited, err := h.Storage.GetCategoryPricingStrategy(categoryID.String())
+		if err != nil {
+			return pricing.Result{}, strategy, origin, err
+		}
+		if inherited != nil {
+			strategy, origin = *inherited, strategyOriginCategory
+		}
+	}
+	result, err := pricing.Calculate(strategy, in)
+	return result, strategy, origin, err
+}
+
+// productPricingCost é o custo de que parte o preço sugerido de um produto existente:
+// depois da primeira entrada com custo, o custo médio ponderado.
+func (h *Handler) productPricingCost(productID string, custo float64) float64 {
+	if custoMedio, err := h.Storage.GetProductAverageCost(productID); err == nil && custoMedio > 0 {
+		return custoMedio
+	}
+	return custo
+}
+
+// HandlePricePreview calcula o preço sugerido e a margem de um formulário de produto sem
+// gravar nada, para o administrador ver o efeito da estratégia antes de guardar. Recebe os
+// campos do formulário na query; com product_id parte do custo médio, como a edição.
+func (h *Handler) HandlePricePreview(c *gin.Context) {
+	in := pricingInput(c.Query)
+	if productID := c.Query("product_id"); productID != "" {
+		in.Custo = h.productPricingCost(productID, in.Custo)
+	}
+	strategy, err := pricingStrategyFromForm(c.Query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	categoryID, err := parseCategoryID(c.Query("categoria_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, used, origin, err := h.resolvePrice(strategy, categoryID, in)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, struct {
+		pricing.Result
+		Custo      float64 `json:"custo"`
+		Estrategia string  `json:"estrategia"`
+		Origem     string  `json:"origem"`
+	}{result, in.Custo, pricing.Describe(used), origin})
+}
+
+// HandleSetCategoryPricingStrategy grava a estratégia de preço de uma categoria, ou volta
+// a herdar a da categoria acima.
+func (h *Handler) HandleSetCategoryPricingStrategy(c *gin.Context) {
+	session := sessions.Default(c)
+	strategy, err := pricingStrategyFromForm(c.PostForm)
+	if err == nil {
+		err = h.Storage.SetCategoryPricingStrategy(c.Param("id"), strategy)
+	}
+	if err != nil {
+		log.Printf("Erro ao gravar estratégia de preço: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao gravar a estratégia de preço: %v", err), "error")
+	} else {
+		session.AddFlash("Estratégia de preço gravada. Aplica-se no próximo cálculo do preço de cada produto.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/categorias")
+}
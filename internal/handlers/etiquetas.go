@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"projeto-vendas/internal/labels"
+	"projeto-vendas/internal/models"
+)
+
+const (
+	// maxLabelProducts limita os produtos de um pedido de etiquetas (24 por folha A4).
+	maxLabelProducts = 500
+	maxLabelCopies   = 100
+)
+
+// HandleBarcodeImage devolve o código de barras de um produto em PNG (EAN-13 quando o
+// código é um EAN válido, Code 128 nos restantes casos). O módulo e a altura, em píxeis,
+// vêm da query e têm de estar dentro dos limites de labels.
+func (h *Handler) HandleBarcodeImage(c *gin.Context) {
+	moduleWidth, errModule := strconv.Atoi(c.DefaultQuery("modulo", "2"))
+	height, errHeight := strconv.Atoi(c.DefaultQuery("altura", "80"))
+	if errModule != nil || errHeight != nil ||
+		moduleWidth < labels.MinModuleWidth || moduleWidth > labels.MaxModuleWidth ||
+		height < labels.MinHeight || height > labels.MaxHeight {
+		c.String(http.StatusBadRequest, fmt.Sprintf("Dimensões inválidas: o módulo vai de %d a %d píxeis e a altura de %d a %d.",
+			labels.MinModuleWidth, labels.MaxModuleWidth, labels.MinHeight, labels.MaxHeight))
+		return
+	}
+	products, err := h.Storage.GetProductsForLabels(models.LabelFilter{ProdutoIDs: []string{c.Param("id")}}, 1)
+	if err != nil || len(products) == 0 {
+		c.String(http.StatusNotFound, "Produto não encontrado.")
+		return
+	}
+	barcode, err := labels.Encode(products[0].CodigoBarras)
+	if err != nil {
+		c.String(http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	var buf bytes.Buffer
+	if err := barcode.PNG(&buf, moduleWidth, height); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// HandlePrintLabels gera as etiquetas de um produto (produto_id) ou de um conjunto
+// filtrado (search_product, alterados_hoje) em PDF para folhas A4 ou em ZPL para
+// impressoras térmicas. O estoquista só etiqueta produtos da sua filial.
+func (h *Handler) HandlePrintLabels(c *gin.Context) {
+	session := sessions.Default(c)
+	redirectBack := func(msg string) {
+		session.AddFlash(msg, "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/estoque/dashboard")
+	}
+
+	kind, err := labels.ParseKind(c.Query("modelo"))
+	if err != nil {
+		redirectBack(err.Error())
+		return
+	}
+	format := c.DefaultQuery("formato", "pdf")
+	if format != "pdf" && format != "zpl" {
+		redirectBack(fmt.Sprintf("Formato de etiqueta desconhecido: %q.", format))
+		return
+	}
+	copies, err := strconv.Atoi(c.DefaultQuery("copias", "1"))
+	if err != nil || copies < 1 || copies > maxLabelCopies {
+		redirectBack(fmt.Sprintf("Número de cópias inválido (1 a %d).", maxLabelCopies))
+		return
+	}
+
+	filter := models.LabelFilter{ProdutoIDs: c.QueryArray("produto_id"), Busca: c.Query("search_product"), FilialID: c.Query("filial_id")}
+	if session.Get("userRole") != "admin" {
+		filter.FilialID, _ = session.Get("filialID").(string)
+	}
+	if c.Query("alterados_hoje") != "" {
+		since := today()
+		filter.PrecoAlteradoDesde = &since
+	}
+	products, err := h.Storage.GetProductsForLabels(filter, maxLabelProducts)
+	if err != nil {
+		log.Printf("Erro ao obter produtos para etiquetas: %v", err)
+		redirectBack("Falha ao obter os produtos para as etiquetas.")
+		return
+	}
+	if len(products) == 0 {
+		redirectBack("Nenhum produto corresponde ao filtro das etiquetas.")
+		return
+	}
+
+	items := make([]labels.Label, 0, len(products))
+	for _, p := range products {
+		if kind == labels.BarcodeLabel && p.CodigoBarras == "" {
+			continue
+		}
+		items = append(items, labels.Label{Nome: p.Nome, CodigoBarras: p.CodigoBarras, Preco: p.PrecoSugerido, Copias: copies})
+	}
+	if len(items) == 0 {
+		redirectBack("Nenhum dos produtos selecionados tem código de barras.")
+		return
+	}
+
+	var buf bytes.Buffer
+	contentType := "application/pdf"
+	if format == "zpl" {
+		contentType = "application/zpl"
+		err = labels.ZPL(&buf, items, kind)
+	} else {
+		err = labels.PDF(&buf, items, kind, labels.A4Sheet24)
+	}
+	if err != nil {
+		log.Printf("Erro ao gerar etiquetas: %v", err)
+		redirectBack("Falha ao gerar as etiquetas.")
+		return
+	}
+	filename := fmt.Sprintf("etiquetas_%s_%s.%s", kind, time.Now().Format("20060102_1504"), format)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
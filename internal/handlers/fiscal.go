@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"projeto-vendas/internal/fiscal"
+	"projeto-vendas/internal/models"
+)
+
+// maxNCMResults é o número de sugestões devolvidas pela pesquisa da tabela NCM.
+const maxNCMResults = 20
+
+// fiscalOptions são as tabelas das listas da classificação fiscal nos formulários de produto.
+var fiscalOptions = struct {
+	Origens   []fiscal.Option
+	ICMS      []fiscal.Option
+	PISCOFINS []fiscal.Option
+}{fiscal.Origins, fiscal.ICMSSituations, fiscal.PISCOFINSSituations}
+
+// fiscalFromForm lê e valida a classificação fiscal de um formulário de produto.
+func fiscalFromForm(get func(string) string) (models.FiscalClassification, error) {
+	origin, err := fiscal.ParseOrigin(get("origem"))
+	if err != nil {
+		return models.FiscalClassification{}, err
+	}
+	f := models.FiscalClassification{
+		NCM: get("ncm"), CEST: get("cest"), CFOP: get("cfop"), Origem: origin,
+		CSTICMS: get("cst_icms"), CSTPIS: get("cst_pis"), CSTCOFINS: get("cst_cofins"),
+	}
+	return f, fiscal.Validate(&f)
+}
+
+// HandleSearchNCM procura na tabela NCM local pelo código ou pela descrição (q), para
+// sugerir o NCM no formulário de produto.
+func (h *Handler) HandleSearchNCM(c *gin.Context) {
+	results, err := h.Storage.SearchNCM(c.Query("q"), maxNCMResults)
+	if err != nil {
+		log.Printf("Erro ao pesquisar a tabela NCM: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao pesquisar a tabela NCM"})
+		return
+	}
+	if results == nil {
+		results = []models.NCM{}
+	}
+	c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/units"
+)
+
+// ShowProductSuppliersPage lista os fornecedores de um produto com os respetivos códigos,
+// custos e condições de compra.
+func (h *Handler) ShowProductSuppliersPage(c *gin.Context) {
+	session := sessions.Default(c)
+	product, err := h.Storage.GetProductByID(c.Param("id"))
+	if err != nil || product == nil {
+		session.AddFlash("Produto não encontrado.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
+	}
+	links, err := h.Storage.GetProductSuppliers(product.ID.String())
+	if err != nil {
+		log.Printf("Erro ao listar fornecedores do produto: %v", err)
+	}
+	suppliers, err := h.Storage.GetSuppliers()
+	if err != nil {
+		log.Printf("Erro ao listar fornecedores: %v", err)
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Fornecedores de " + product.Nome
+	data["product"] = product
+	data["links"] = links
+	data["suppliers"] = suppliers
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "dashboard"
+	c.HTML(http.StatusOK, "produto_fornecedores.html", data)
+}
+
+// HandleSaveProductSupplier liga um fornecedor (existente ou novo, pelo nome) ao produto da
+// rota, ou altera as condições de um já ligado.
+func (h *Handler) HandleSaveProductSupplier(c *gin.Context) {
+	session := sessions.Default(c)
+	productID := c.Param("id")
+	redirect := "/admin/products/fornecedores/" + productID
+
+	parsedID, err := uuid.Parse(productID)
+	if err != nil {
+		session.AddFlash("Produto inválido.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
+	}
+	link, err := productSupplierFromForm(c.PostForm)
+	if err == nil {
+		link.ProdutoID = parsedID
+		err = h.Storage.SaveProductSupplier(link)
+	}
+	if err != nil {
+		log.Printf("Erro ao gravar fornecedor do produto: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao gravar o fornecedor: %v", err), "error")
+	} else {
+		session.AddFlash("Fornecedor gravado com sucesso!", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// HandleRemoveProductSupplier desliga um fornecedor do produto da rota.
+func (h *Handler) HandleRemoveProductSupplier(c *gin.Context) {
+	session := sessions.Default(c)
+	if err := h.Storage.RemoveProductSupplier(c.Param("id"), c.PostForm("fornecedor_id")); err != nil {
+		log.Printf("Erro ao remover fornecedor do produto: %v", err)
+		session.AddFlash("Falha ao remover o fornecedor.", "error")
+	} else {
+		session.AddFlash("Fornecedor removido do produto.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/products/fornecedores/"+c.Param("id"))
+}
+
+// productSupplierFromForm lê a ligação a um fornecedor do formulário; o fornecedor vem pelo
+// nome, para que um fornecedor novo seja criado ao ligá-lo ao primeiro produto.
+func productSupplierFromForm(get func(string) string) (models.ProductSupplier, error) {
+	link := models.ProductSupplier{
+		FornecedorNome:   strings.TrimSpace(get("fornecedor")),
+		CodigoFornecedor: strings.TrimSpace(get("codigo_fornecedor")),
+		Preferencial:     get("preferencial") != "",
+	}
+	if link.FornecedorNome == "" {
+		return link, errors.New("indique o fornecedor")
+	}
+	var err error
+	if v := strings.TrimSpace(get("ultimo_custo")); v != "" {
+		if link.UltimoCusto, err = strconv.ParseFloat(v, 64); err != nil || link.UltimoCusto < 0 {
+			return link, fmt.Errorf("custo inválido: %q", v)
+		}
+	}
+	if v := strings.TrimSpace(get("prazo_entrega_dias")); v != "" {
+		if link.PrazoEntregaDias, err = strconv.Atoi(v); err != nil || link.PrazoEntregaDias < 0 {
+			return link, fmt.Errorf("prazo de entrega inválido: %q (dias, a partir de 0)", v)
+		}
+	}
+	if v := strings.TrimSpace(get("quantidade_minima")); v != "" {
+		if link.QuantidadeMinima, err = strconv.Atoi(v); err != nil || link.QuantidadeMinima < 1 {
+			return link, fmt.Errorf("quantidade mínima inválida: %q (a partir de 1)", v)
+		}
+	}
+	return link, nil
+}
+
+// applySupplier completa a linha de reposição com o fornecedor a quem comprar: a sugestão
+// passa a unidades de compra inteiras e respeita a encomenda mínima dele, e a linha fica
+// urgente se o saldo não chega para o prazo de entrega.
+func applySupplier(row *models.ReplenishmentRow, u models.UnitsOfMeasure, supplier *models.ProductSupplier) {
+	row.Unidade = u.Unidade
+	if supplier != nil {
+		row.Fornecedor = supplier.FornecedorNome
+		row.CodigoFornecedor = supplier.CodigoFornecedor
+		row.PrazoEntregaDias = supplier.PrazoEntregaDias
+		row.Urgente = !row.SemPrevisao && row.CoberturaDias >= 0 && row.CoberturaDias < float64(supplier.PrazoEntregaDias)
+	}
+	if row.SugestaoCompra <= 0 {
+		return
+	}
+	factor := u.FatorCompra
+	if factor < 1 {
+		factor = 1
+	}
+	packs := int(math.Ceil(float64(row.SugestaoCompra) / float64(factor)))
+	if supplier != nil && packs < supplier.QuantidadeMinima {
+		packs = supplier.QuantidadeMinima
+	}
+	row.SugestaoCompra = packs * factor
+	row.SugestaoEmUnidadeCompra = units.InPurchaseUnit(row.SugestaoCompra, u)
+	if supplier != nil {
+		row.CustoEstimado = float64(row.SugestaoCompra) * supplier.UltimoCusto
+	}
+}
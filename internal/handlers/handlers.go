@@ -12,19 +12,24 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgconn"
 	"golang.org/x/crypto/bcrypt"
+	"projeto-vendas/internal/images"
 	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/pricing"
 	"projeto-vendas/internal/storage"
+	"projeto-vendas/internal/units"
 )
 
 const PageLimit = 10
 type Handler struct {
 	Storage storage.Store
+	Images  images.Store // armazenamento das imagens dos produtos; nil desativa o envio
 }
 func NewHandler(s storage.Store) *Handler {
 	return &Handler{Storage: s}
@@ -62,7 +67,36 @@ func (h *Handler) AuthRequired(requiredRoles ...string) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		
+
+		// Um utilizador arquivado depois de entrar perde a sessão no pedido seguinte.
+		userID, _ := session.Get("userID").(string)
+		active, err := h.Storage.IsUserActive(userID)
+		if err != nil {
+			log.Printf("Erro ao verificar o utilizador %s: %v", userID, err)
+			if isAPIRequest {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Erro interno"})
+			} else {
+				c.HTML(http.StatusInternalServerError, "error.html", gin.H{
+					"title":        "Erro",
+					"StatusCode":   http.StatusInternalServerError,
+					"ErrorMessage": "Não foi possível verificar a sessão",
+				})
+			}
+			c.Abort()
+			return
+		}
+		if !active {
+			session.Clear()
+			session.Save()
+			if isAPIRequest {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Não autorizado"})
+			} else {
+				c.Redirect(http.StatusFound, "/login")
+			}
+			c.Abort()
+			return
+		}
+
 		hasPermission := false
 		for _, role := range requiredRoles {
 			if userRole == role {
@@ -138,27 +172,35 @@ func (h *Handler) ShowAdminDashboard(c *gin.Context) {
 	pageUsers, _ := strconv.Atoi(c.DefaultQuery("page_users", "1"))
 	pageProducts, _ := strconv.Atoi(c.DefaultQuery("page_products", "1"))
 	searchQuery := c.Query("search_products")
+	// Com archived_users / archived_products as listas mostram os registos arquivados.
+	archivedUsers := c.Query("archived_users") == "1"
+	archivedProducts := c.Query("archived_products") == "1"
 	if pageUsers < 1 { pageUsers = 1 }
 	if pageProducts < 1 { pageProducts = 1 }
 
-	totalUsers, _ := h.Storage.CountUsers()
-	users, _ := h.Storage.GetUsersPaginated(PageLimit, (pageUsers-1)*PageLimit)
+	totalUsers, _ := h.Storage.CountUsers(archivedUsers)
+	users, _ := h.Storage.GetUsersPaginated(archivedUsers, PageLimit, (pageUsers-1)*PageLimit)
 	totalPagesUsers := int(math.Ceil(float64(totalUsers) / float64(PageLimit)))
 
-	totalProducts, _ := h.Storage.CountProducts(searchQuery)
-	products, _ := h.Storage.GetProductsPaginatedAndFiltered(searchQuery, PageLimit, (pageProducts-1)*PageLimit)
+	totalProducts, _ := h.Storage.CountProducts(searchQuery, archivedProducts)
+	products, _ := h.Storage.GetProductsPaginatedAndFiltered(searchQuery, archivedProducts, PageLimit, (pageProducts-1)*PageLimit)
 	totalPagesProducts := int(math.Ceil(float64(totalProducts) / float64(PageLimit)))
 
 	filiais, _ := h.Storage.GetAllFiliais()
+	categories, _ := h.Storage.GetCategories()
+	h.setImageURLs(products)
 
 	data := getFlashes(c)
 	data["title"] = "Painel do Administrador"
 	data["users"] = users
 	data["products"] = products
 	data["filiais"] = filiais
+	data["categories"] = categories
 	data["UserRole"] = session.Get("userRole")
 	data["UserName"] = session.Get("userName")
 	data["ActivePage"] = "dashboard"
+	data["FiscalOptions"] = fiscalOptions
+	data["UnitOptions"] = units.All
 	data["PaginationUsers"] = models.PaginationData{
 		HasPrev:     pageUsers > 1,
 		HasNext:     pageUsers < totalPagesUsers,
@@ -166,6 +208,7 @@ func (h *Handler) ShowAdminDashboard(c *gin.Context) {
 		NextPage:    pageUsers + 1,
 		CurrentPage: pageUsers,
 		TotalPages:  totalPagesUsers,
+		Arquivados:  archivedUsers,
 	}
 	data["PaginationProducts"] = models.PaginationData{
 		HasPrev:     pageProducts > 1,
@@ -175,6 +218,7 @@ func (h *Handler) ShowAdminDashboard(c *gin.Context) {
 		CurrentPage: pageProducts,
 		TotalPages:  totalPagesProducts,
 		SearchQuery: searchQuery,
+		Arquivados:  archivedProducts,
 	}
 	c.HTML(http.StatusOK, "admin_dashboard.html", data)
 }
@@ -186,18 +230,21 @@ func (h *Handler) ShowStockManagementPage(c *gin.Context) {
 	filialID := c.Query("filial_id")
 	if page < 1 { page = 1 }
 
-	totalItems, _ := h.Storage.CountStockItems(filialID, searchQuery)
-	stockItems, _ := h.Storage.GetStockItemsPaginated(filialID, searchQuery, PageLimit, (page-1)*PageLimit)
+	totalItems, _ := h.Storage.CountStockItems(filialID, searchQuery, "")
+	stockItems, _ := h.Storage.GetStockItemsPaginated(filialID, searchQuery, "", PageLimit, (page-1)*PageLimit)
 	totalPages := int(math.Ceil(float64(totalItems) / float64(PageLimit)))
 	
 	filiais, _ := h.Storage.GetAllFiliais()
 	allProducts, _ := h.Storage.GetAllProductsSimple()
+	suppliers, _ := h.Storage.GetSuppliers()
 
 	data := getFlashes(c)
 	data["title"] = "Gestão de Stock por Filial"
 	data["stockItems"] = stockItems
 	data["filiais"] = filiais
 	data["allProducts"] = allProducts
+	data["UnitOptions"] = units.All
+	data["fornecedores"] = suppliers
 	data["UserRole"] = session.Get("userRole")
 	data["UserName"] = session.Get("userName")
 	data["ActivePage"] = "stock"
@@ -279,16 +326,19 @@ func (h *Handler) ShowEstoquistaDashboard(c *gin.Context) {
 
 	if page < 1 { page = 1 }
 
-	totalItems, _ := h.Storage.CountStockItems(filialID, searchQuery)
-	stockItems, _ := h.Storage.GetStockItemsPaginated(filialID, searchQuery, PageLimit, (page-1)*PageLimit)
+	totalItems, _ := h.Storage.CountStockItems(filialID, searchQuery, "")
+	stockItems, _ := h.Storage.GetStockItemsPaginated(filialID, searchQuery, "", PageLimit, (page-1)*PageLimit)
 	totalPages := int(math.Ceil(float64(totalItems) / float64(PageLimit)))
 	
 	allProducts, _ := h.Storage.GetAllProductsSimple()
+	suppliers, _ := h.Storage.GetSuppliers()
 	
 	data := getFlashes(c)
 	data["title"] = "Painel de Stock"
 	data["stockItems"] = stockItems
 	data["allProducts"] = allProducts
+	data["UnitOptions"] = units.All
+	data["fornecedores"] = suppliers
 	data["UserRole"] = session.Get("userRole")
 	data["UserName"] = session.Get("userName")
 	data["FilialName"] = session.Get("filialName")
@@ -332,41 +382,69 @@ func (h *Handler) HandleAddUser(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/admin/dashboard")
 }
 
-func calculateSuggestedPrice(custo, lucro, impostoEst, impostoFed float64) float64 {
-    lucroValor := custo * (lucro / 100)
-    impostoEstValor := custo * (impostoEst / 100)
-    impostoFedValor := custo * (impostoFed / 100)
-    return custo + lucroValor + impostoEstValor + impostoFedValor
-}
-
 func (h *Handler) HandleAddProduct(c *gin.Context) {
     session := sessions.Default(c)
-    custo, _ := strconv.ParseFloat(c.PostForm("preco_custo"), 64)
-    lucro, _ := strconv.ParseFloat(c.PostForm("percentual_lucro"), 64)
-    impostoEst, _ := strconv.ParseFloat(c.PostForm("imposto_estadual"), 64)
-    impostoFed, _ := strconv.ParseFloat(c.PostForm("imposto_federal"), 64)
+    fail := func(msg string) {
+        session.AddFlash(msg, "error")
+        session.Save()
+        c.Redirect(http.StatusFound, "/admin/dashboard")
+    }
+    in := pricingInput(c.PostForm)
+    categoriaID, err := parseCategoryID(c.PostForm("categoria_id"))
+    if err != nil {
+        fail("Selecione uma categoria válida para o produto.")
+        return
+    }
+    strategy, err := pricingStrategyFromForm(c.PostForm)
+    if err != nil {
+        fail(fmt.Sprintf("Estratégia de preço inválida: %v", err))
+        return
+    }
+    price, _, _, err := h.resolvePrice(strategy, categoriaID, in)
+    if err != nil {
+        fail(fmt.Sprintf("Falha ao calcular o preço sugerido: %v", err))
+        return
+    }
+    barcode, err := h.barcodeFromForm(c.PostForm("barcode"), "")
+    if err != nil {
+        fail(fmt.Sprintf("Falha ao adicionar produto: %v", err))
+        return
+    }
+    fiscalClass, err := fiscalFromForm(c.PostForm)
+    if err != nil {
+        fail(fmt.Sprintf("Classificação fiscal inválida: %v", err))
+        return
+    }
+    unitsOfMeasure, err := unitsFromForm(c.PostForm)
+    if err != nil {
+        fail(fmt.Sprintf("Unidades de medida inválidas: %v", err))
+        return
+    }
 
     product := models.Product{
         Nome:          c.PostForm("name"),
         Descricao:     c.PostForm("description"),
-		Categoria:     c.PostForm("categoria"),
-        CodigoBarras:  c.PostForm("barcode"),
+        CategoriaID:   categoriaID,
+        CodigoBarras:  barcode,
         CodigoCNAE:    c.PostForm("codigo_cnae"),
-        PrecoCusto:    custo,
-        PercentualLucro: lucro,
-        ImpostoEstadual: impostoEst,
-        ImpostoFederal: impostoFed,
-        PrecoSugerido: calculateSuggestedPrice(custo, lucro, impostoEst, impostoFed),
+        PrecoCusto:    in.Custo,
+        PercentualLucro: in.Lucro,
+        ImpostoEstadual: in.ImpostoEstadual,
+        ImpostoFederal: in.ImpostoFederal,
+        PrecoSugerido: price.Preco,
+        EstrategiaPreco: strategy,
+        FiscalClassification: fiscalClass,
+        UnitsOfMeasure: unitsOfMeasure,
     }
 
     filialID := c.PostForm("filial_id")
     quantityStr := c.PostForm("quantity")
-    var err error
 
     if filialID != "" && quantityStr != "" {
         quantity, _ := strconv.Atoi(quantityStr)
         if quantity > 0 {
-            err = h.Storage.CreateProductWithInitialStock(product, filialID, quantity)
+            userID, _ := uuid.Parse(session.Get("userID").(string))
+            err = h.Storage.CreateProductWithInitialStock(product, filialID, quantity, models.StockLot{UsuarioID: userID})
         } else {
             err = h.Storage.AddProduct(product)
         }
@@ -378,7 +456,7 @@ func (h *Handler) HandleAddProduct(c *gin.Context) {
         log.Printf("Erro ao adicionar produto: %v", err)
         session.AddFlash(fmt.Sprintf("Falha ao adicionar produto: %v", err), "error")
     } else {
-        session.AddFlash("Produto adicionado com sucesso!", "success")
+        session.AddFlash("Produto adicionado com sucesso!"+minimumMarginNote(price), "success")
     }
     session.Save()
     c.Redirect(http.StatusFound, "/admin/dashboard")
@@ -387,56 +465,86 @@ func (h *Handler) HandleAddProduct(c *gin.Context) {
 func (h *Handler) HandleEditProduct(c *gin.Context) {
     session := sessions.Default(c)
     productID := c.Param("id")
-    
-    custo, _ := strconv.ParseFloat(c.PostForm("preco_custo"), 64)
-    lucro, _ := strconv.ParseFloat(c.PostForm("percentual_lucro"), 64)
-    impostoEst, _ := strconv.ParseFloat(c.PostForm("imposto_estadual"), 64)
-    impostoFed, _ := strconv.ParseFloat(c.PostForm("imposto_federal"), 64)
+    fail := func(msg string) {
+        session.AddFlash(msg, "error")
+        session.Save()
+        c.Redirect(http.StatusFound, "/admin/dashboard")
+    }
+    in := pricingInput(c.PostForm)
+    custo := in.Custo
+
+    // Depois da primeira entrada com custo, o preço sugerido parte do custo médio ponderado.
+    in.Custo = h.productPricingCost(productID, custo)
+    categoriaID, err := parseCategoryID(c.PostForm("categoria_id"))
+    if err != nil {
+        fail("Selecione uma categoria válida para o produto.")
+        return
+    }
+    strategy, err := pricingStrategyFromForm(c.PostForm)
+    if err != nil {
+        fail(fmt.Sprintf("Estratégia de preço inválida: %v", err))
+        return
+    }
+    price, _, _, err := h.resolvePrice(strategy, categoriaID, in)
+    if err != nil {
+        fail(fmt.Sprintf("Falha ao calcular o preço sugerido: %v", err))
+        return
+    }
+    barcode, err := h.barcodeFromForm(c.PostForm("barcode"), h.storedBarcode(productID))
+    if err != nil {
+        fail(fmt.Sprintf("Falha ao atualizar produto: %v", err))
+        return
+    }
+    fiscalClass, err := fiscalFromForm(c.PostForm)
+    if err != nil {
+        fail(fmt.Sprintf("Classificação fiscal inválida: %v", err))
+        return
+    }
+    unitsOfMeasure, err := unitsFromForm(c.PostForm)
+    if err != nil {
+        fail(fmt.Sprintf("Unidades de medida inválidas: %v", err))
+        return
+    }
 
     product := models.Product{
         Nome:          c.PostForm("name"),
         Descricao:     c.PostForm("description"),
-		Categoria:     c.PostForm("categoria"),
-        CodigoBarras:  c.PostForm("barcode"),
+        CategoriaID:   categoriaID,
+        CodigoBarras:  barcode,
         CodigoCNAE:    c.PostForm("codigo_cnae"),
         PrecoCusto:    custo,
-        PercentualLucro: lucro,
-        ImpostoEstadual: impostoEst,
-        ImpostoFederal: impostoFed,
-        PrecoSugerido: calculateSuggestedPrice(custo, lucro, impostoEst, impostoFed),
+        PercentualLucro: in.Lucro,
+        ImpostoEstadual: in.ImpostoEstadual,
+        ImpostoFederal: in.ImpostoFederal,
+        PrecoSugerido: price.Preco,
+        EstrategiaPreco: strategy,
+        FiscalClassification: fiscalClass,
+        UnitsOfMeasure: unitsOfMeasure,
     }
     
-    err := h.Storage.UpdateProduct(productID, product)
+    userID, _ := session.Get("userID").(string)
+    err = h.Storage.UpdateProduct(productID, product, userID)
     if err != nil {
         log.Printf("Erro ao atualizar produto: %v", err)
         session.AddFlash(fmt.Sprintf("Falha ao atualizar produto: %v", err), "error")
     } else {
-        session.AddFlash("Produto atualizado com sucesso!", "success")
+        session.AddFlash("Produto atualizado com sucesso!"+minimumMarginNote(price), "success")
     }
     session.Save()
     c.Redirect(http.StatusFound, "/admin/dashboard")
 }
 
-func (h *Handler) HandleDeleteUser(c *gin.Context) {
-	session := sessions.Default(c)
-	err := h.Storage.DeleteUserByID(c.Param("id"))
-	if err != nil {
-		log.Printf("Erro ao apagar utilizador: %v", err)
-		session.AddFlash(fmt.Sprintf("Falha ao apagar utilizador: %v", err), "error")
-	} else {
-		session.AddFlash("Utilizador removido com sucesso!", "success")
-	}
-	session.Save()
-	c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
+// minimumMarginNote avisa que o preço gravado subiu para cumprir a margem mínima.
+func minimumMarginNote(price pricing.Result) string {
+    if !price.MargemMinimaAplicada {
+        return ""
+    }
+    return fmt.Sprintf(" O preço foi ajustado para R$ %.2f para cumprir a margem mínima.", price.Preco)
 }
 
-func (h *Handler) HandleDeleteProduct(c *gin.Context) {
-	err := h.Storage.DeleteProductByID(c.Param("id"))
-	if err != nil { log.Printf("Erro ao apagar produto: %v", err) }
-	c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
-}
 
 func (h *Handler) HandleUpdateStock(c *gin.Context) {
+	session := sessions.Default(c)
 	productID := c.PostForm("product_id")
 	filialID := c.PostForm("filial_id")
 	newQuantity, err := strconv.Atoi(c.PostForm("quantity"))
@@ -445,13 +553,26 @@ func (h *Handler) HandleUpdateStock(c *gin.Context) {
 		c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
 		return
 	}
-	err = h.Storage.UpdateStockQuantity(productID, filialID, newQuantity)
+	version, _ := strconv.Atoi(c.PostForm("versao"))
+	err = h.Storage.UpdateStockQuantity(productID, filialID, newQuantity, version)
 	if err != nil {
 		log.Printf("Erro ao atualizar stock: %v", err)
+		session.AddFlash(stockSaveErrorMessage(err, newQuantity), "error")
+		session.Save()
 	}
 	c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
 }
 
+// stockSaveErrorMessage descreve a falha ao gravar um saldo de stock. Num conflito de
+// versão mostra o valor atual, para o utilizador decidir se ainda quer gravar o seu.
+func stockSaveErrorMessage(err error, attempted int) string {
+	var conflict *storage.StockConflictError
+	if errors.As(err, &conflict) {
+		return fmt.Sprintf("O stock foi alterado por outra operação (ex.: uma venda) enquanto editava. Quantidade atual: %d; a sua alteração para %d não foi gravada. Reveja o valor e volte a guardar.", conflict.QuantidadeAtual, attempted)
+	}
+	return fmt.Sprintf("Falha ao definir stock: %v", err)
+}
+
 func (h *Handler) HandleAddStockItem(c *gin.Context) {
 	session := sessions.Default(c)
 	addType := c.PostForm("add_type")
@@ -465,26 +586,77 @@ func (h *Handler) HandleAddStockItem(c *gin.Context) {
 		return
 	}
 
+	unitCost, _ := strconv.ParseFloat(c.PostForm("custo_unitario"), 64)
+	userID, _ := uuid.Parse(session.Get("userID").(string))
+	// A quantidade e o custo vêm na unidade escolhida; o storage converte-os para a de venda.
+	lot := models.StockLot{NumeroLote: c.PostForm("numero_lote"), CustoUnitario: unitCost, Unidade: c.PostForm("unidade"), UsuarioID: userID}
+	if validade := c.PostForm("data_validade"); validade != "" {
+		dataValidade, err := time.Parse("2006-01-02", validade)
+		if err != nil {
+			session.AddFlash("Data de validade inválida.", "error")
+			session.Save()
+			c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
+			return
+		}
+		lot.DataValidade = &dataValidade
+	}
+	if fornecedor := c.PostForm("fornecedor_id"); fornecedor != "" {
+		supplierID, err := uuid.Parse(fornecedor)
+		if err != nil {
+			session.AddFlash("Fornecedor inválido.", "error")
+			session.Save()
+			c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
+			return
+		}
+		lot.FornecedorID = &supplierID
+	}
+
 	if addType == "new" {
 		custo, _ := strconv.ParseFloat(c.PostForm("new_product_price"), 64)
         lucro, _ := strconv.ParseFloat(c.PostForm("new_product_lucro"), 64)
         impostoEst, _ := strconv.ParseFloat(c.PostForm("new_product_imposto_est"), 64)
         impostoFed, _ := strconv.ParseFloat(c.PostForm("new_product_imposto_fed"), 64)
+        // O produto criado aqui não tem categoria: o preço segue a estratégia padrão.
+        var price pricing.Result
+        price, _, _, err = h.resolvePrice(nil, nil, pricing.Input{Custo: custo, Lucro: lucro, ImpostoEstadual: impostoEst, ImpostoFederal: impostoFed})
+        if err != nil {
+            session.AddFlash(fmt.Sprintf("Falha ao calcular o preço sugerido: %v", err), "error")
+            session.Save()
+            c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
+            return
+        }
+        var barcode string
+        barcode, err = h.barcodeFromForm(c.PostForm("new_product_barcode"), "")
+        if err != nil {
+            session.AddFlash(fmt.Sprintf("Falha ao adicionar stock: %v", err), "error")
+            session.Save()
+            c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
+            return
+        }
+        var unitsOfMeasure models.UnitsOfMeasure
+        unitsOfMeasure, err = unitsFromForm(func(key string) string { return c.PostForm("new_product_" + key) })
+        if err != nil {
+            session.AddFlash(fmt.Sprintf("Unidades de medida inválidas: %v", err), "error")
+            session.Save()
+            c.Redirect(http.StatusFound, c.Request.Header.Get("Referer"))
+            return
+        }
 
 		newProduct := models.Product{
 			Nome:          c.PostForm("new_product_name"),
 			Descricao:     c.PostForm("new_product_description"),
-			CodigoBarras:  c.PostForm("new_product_barcode"),
+			CodigoBarras:  barcode,
 			PrecoCusto:    custo,
             PercentualLucro: lucro,
             ImpostoEstadual: impostoEst,
             ImpostoFederal: impostoFed,
-            PrecoSugerido: calculateSuggestedPrice(custo, lucro, impostoEst, impostoFed),
+            PrecoSugerido: price.Preco,
+            UnitsOfMeasure: unitsOfMeasure,
 		}
-		err = h.Storage.CreateProductWithInitialStock(newProduct, filialID, quantity)
+		err = h.Storage.CreateProductWithInitialStock(newProduct, filialID, quantity, lot)
 	} else {
 		productID := c.PostForm("product_id")
-		err = h.Storage.AddStockItem(productID, filialID, quantity)
+		err = h.Storage.AddStockItem(productID, filialID, quantity, lot)
 	}
 
 	if err != nil {
@@ -525,10 +697,11 @@ func (h *Handler) HandleSetStock(c *gin.Context) {
 		return
 	}
 
-	err = h.Storage.UpsertStockQuantity(productID, filialID, quantity)
+	version, _ := strconv.Atoi(c.PostForm("versao"))
+	err = h.Storage.UpsertStockQuantity(productID, filialID, quantity, version)
 	if err != nil {
 		log.Printf("Erro ao definir stock: %v", err)
-		session.AddFlash(fmt.Sprintf("Falha ao definir stock: %v", err), "error")
+		session.AddFlash(stockSaveErrorMessage(err, quantity), "error")
 	} else {
 		session.AddFlash("Stock atualizado com sucesso!", "success")
 	}
@@ -554,6 +727,7 @@ func (h *Handler) HandleSearchProductsForSale(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erro ao buscar produtos."})
 		return
 	}
+	h.setImageURLs(products)
 	c.JSON(http.StatusOK, products)
 }
 
@@ -670,7 +844,16 @@ func (h *Handler) HandleUpdateEmpresa(c *gin.Context) {
 		NomeFantasia: c.PostForm("nome_fantasia"),
 		CNPJ:         c.PostForm("cnpj"),
 		Endereco:     c.PostForm("endereco"),
+		CustoMedioPorFilial: c.PostForm("custo_medio_por_filial") == "on",
+	}
+	limit, err := strconv.ParseFloat(c.PostForm("limite_aprovacao_ajuste"), 64)
+	if err != nil || limit < 0 {
+		session.AddFlash("Limite de aprovação de ajustes inválido.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/empresa")
+		return
 	}
+	empresa.LimiteAprovacaoAjuste = limit
 
 	err = h.Storage.UpsertEmpresa(empresa)
 	if err != nil {
@@ -899,7 +1082,10 @@ func (h *Handler) ShowMonitoringDashboard(c *gin.Context) {
 	totalRevenue, totalTransactions, _ := h.Storage.GetDashboardMetrics(period)
 	topSellers, _ := h.Storage.GetTopSellers(period)
 	totalStockValue, _ := h.Storage.GetTotalStockValue()
-	stockComposition, _ := h.Storage.GetStockComposition()
+	// A composição do estoque desce na taxonomia: departamentos, ou as filhas da categoria escolhida.
+	categoriaID := c.Query("categoria_id")
+	stockComposition, _ := h.Storage.GetStockComposition(categoriaID)
+	categories, _ := h.Storage.GetCategories()
 	financialKPIs, _ := h.Storage.GetFinancialKPIs(period) // NOVO
 
 	var averageTicket float64
@@ -926,6 +1112,8 @@ func (h *Handler) ShowMonitoringDashboard(c *gin.Context) {
 	data["ActivePage"] = "monitoring"
 	data["DashboardData"] = dashboardData
 	data["CurrentPeriod"] = period
+	data["categories"] = categories
+	data["CurrentCategory"] = categoriaID
 	
 	c.HTML(http.StatusOK, "monitoring_dashboard.html", data)
 }
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -16,6 +17,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
+	"projeto-vendas/internal/forecast"
+	"projeto-vendas/internal/gtin"
 	"projeto-vendas/internal/models"
 	"projeto-vendas/internal/storage"
 )
@@ -26,15 +29,22 @@ type mockStorage struct{}
 
 var _ storage.Store = (*mockStorage)(nil)
 
+// archivedUserID é o utilizador que o mock dá como arquivado depois de ter entrado.
+var archivedUserID = uuid.New()
+
 func (m *mockStorage) GetUserByEmail(email string) (*models.User, error) {
-	if email == "admin@teste.com" || email == "vendedor@teste.com" {
+	if email == "admin@teste.com" || email == "vendedor@teste.com" || email == "arquivado@teste.com" {
 		cargo := "admin"
 		if email == "vendedor@teste.com" {
 			cargo = "vendedor"
 		}
+		id := uuid.New()
+		if email == "arquivado@teste.com" {
+			id = archivedUserID
+		}
 		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("senha123"), bcrypt.DefaultCost)
 		return &models.User{
-			ID:        uuid.New(),
+			ID:        id,
 			Nome:      "Utilizador Teste",
 			Email:     email,
 			Cargo:     cargo,
@@ -45,8 +55,6 @@ func (m *mockStorage) GetUserByEmail(email string) (*models.User, error) {
 	return nil, errors.New("utilizador não encontrado")
 }
 
-func (m *mockStorage) UpdateProduct(productID string, product models.Product) error { return nil }
-
 func (m *mockStorage) GetSalesSummary() ([]models.SalesSummary, error) {
 	return []models.SalesSummary{
 		{FilialNome: "Filial Teste", TotalVendas: 1234.56},
@@ -54,10 +62,10 @@ func (m *mockStorage) GetSalesSummary() ([]models.SalesSummary, error) {
 }
 // ATUALIZADO: O mock agora implementa todas as funções da interface.
 func (m *mockStorage) GetFilialByID(id string) (*models.Filial, error) { return &models.Filial{ID: uuid.New(), Nome: "Filial Teste"}, nil }
-func (m *mockStorage) CountUsers() (int, error) { return 1, nil }
-func (m *mockStorage) GetUsersPaginated(limit, offset int) ([]models.User, error) { return []models.User{}, nil }
-func (m *mockStorage) CountProducts(searchQuery string) (int, error) { return 0, nil }
-func (m *mockStorage) GetProductsPaginatedAndFiltered(searchQuery string, limit, offset int) ([]models.Product, error) { return []models.Product{}, nil }
+func (m *mockStorage) CountUsers(archived bool) (int, error) { return 1, nil }
+func (m *mockStorage) GetUsersPaginated(archived bool, limit, offset int) ([]models.User, error) { return []models.User{}, nil }
+func (m *mockStorage) CountProducts(searchQuery string, archived bool) (int, error) { return 0, nil }
+func (m *mockStorage) GetProductsPaginatedAndFiltered(searchQuery string, archived bool, limit, offset int) ([]models.Product, error) { return []models.Product{}, nil }
 func (m *mockStorage) GetAllFiliais() ([]models.Filial, error) { return []models.Filial{}, nil }
 func (m *mockStorage) UpdateUser(userID string, user models.User, newPassword string) error { return nil }
 func (m *mockStorage) CountSales(filialID string) (int, error) { return 0, nil }
@@ -69,16 +77,16 @@ func (m *mockStorage) SearchProductsForSale(query string, filialID uuid.UUID) ([
 	return []models.Product{}, nil
 }
 func (m *mockStorage) RegisterSale(sale models.Venda, items []models.ItemVenda) error { return nil }
-func (m *mockStorage) CreateProductWithInitialStock(product models.Product, filialID string, quantity int) error { return nil }
-func (m *mockStorage) AddStockItem(productID, filialID string, quantity int) error { return nil }
+func (m *mockStorage) CreateProductWithInitialStock(product models.Product, filialID string, quantity int, lot models.StockLot) error { return nil }
+func (m *mockStorage) AddStockItem(productID, filialID string, quantity int, lot models.StockLot) error { return nil }
 func (m *mockStorage) GetAllProductsSimple() ([]models.Product, error) { return []models.Product{}, nil }
-func (m *mockStorage) CountStockItems(filialID, searchQuery string) (int, error) { return 0, nil }
-func (m *mockStorage) GetStockItemsPaginated(filialID, searchQuery string, limit, offset int) ([]models.StockViewItem, error) { return []models.StockViewItem{}, nil }
-func (m *mockStorage) UpdateStockQuantity(productID, filialID string, newQuantity int) error { return nil }
-func (m *mockStorage) UpsertStockQuantity(productID, filialID string, quantity int) error { return nil }
+func (m *mockStorage) CountStockItems(filialID, searchQuery, abcClass string) (int, error) { return 0, nil }
+func (m *mockStorage) GetStockItemsPaginated(filialID, searchQuery, abcClass string, limit, offset int) ([]models.StockViewItem, error) { return []models.StockViewItem{}, nil }
+func (m *mockStorage) UpdateStockQuantity(productID, filialID string, newQuantity, expectedVersion int) error { return nil }
+func (m *mockStorage) UpsertStockQuantity(productID, filialID string, quantity, expectedVersion int) error { return nil }
 func (m *mockStorage) AddUser(user models.User, password string) error { return nil }
 func (m *mockStorage) AddProduct(product models.Product) error { return nil }
-func (m *mockStorage) UpdateProduct(productID string, product models.Product) error { return nil }
+func (m *mockStorage) UpdateProduct(productID string, product models.Product, userID string) error { return nil }
 func (m *mockStorage) UpdateSocio(socioID string, socio models.Socio) error { return nil }
 func (m *mockStorage) GetEmpresa() (*models.Empresa, error) { return &models.Empresa{}, nil }
 func (m *mockStorage) UpsertEmpresa(empresa models.Empresa) error { return nil }
@@ -87,17 +95,102 @@ func (m *mockStorage) AddSocio(socio models.Socio) error { return nil }
 func (m *mockStorage) DeleteSocioByID(id string) error { return nil }
 func (m *mockStorage) DeleteUserByID(id string) error { return nil }
 func (m *mockStorage) DeleteProductByID(id string) error { return nil }
+func (m *mockStorage) ArchiveProduct(id string) error { return nil }
+func (m *mockStorage) RestoreProduct(id string) error { return nil }
+func (m *mockStorage) ArchiveUser(id string) error { return nil }
+func (m *mockStorage) RestoreUser(id string) error { return nil }
+func (m *mockStorage) IsUserActive(id string) (bool, error) { return id != archivedUserID.String(), nil }
+func (m *mockStorage) SetProductImage(productID, key string) (string, error) { return "", nil }
+func (m *mockStorage) GetKitComponents(kitID string) ([]models.KitComponent, error) { return nil, nil }
+func (m *mockStorage) SetKitComponent(kitID, barcode string, quantity int) error { return nil }
+func (m *mockStorage) RemoveKitComponent(kitID, componentID string) error { return nil }
+func (m *mockStorage) GetKitAvailability(kitID string) ([]models.KitAvailability, error) { return nil, nil }
+func (m *mockStorage) GetCategoryPricingStrategy(categoryID string) (*models.PricingStrategy, error) { return nil, nil }
+func (m *mockStorage) SetCategoryPricingStrategy(categoryID string, strategy *models.PricingStrategy) error { return nil }
 func (m *mockStorage) GetProductStockByFilial(productID string) ([]models.StockDetail, error) { return []models.StockDetail{}, nil }
 func (m *mockStorage) AdjustStockQuantity(productID, filialID string, quantityToRemove int) error { return nil }
-func (m *mockStorage) GetSalesSummary() ([]models.SalesSummary, error) { return []models.SalesSummary{}, nil }
 func (m *mockStorage) FilterProducts(category string, minPrice float64) ([]models.Product, error) { return []models.Product{}, nil }
-func (m *mockStorage) GetTopSellers() ([]models.TopSeller, error) { return []models.TopSeller{}, nil }
+func (m *mockStorage) GetTopSellers(days int) ([]models.TopSeller, error) { return []models.TopSeller{}, nil }
 func (m *mockStorage) GetLowStockProducts(filialNome string, limit int) ([]models.LowStockProduct, error) { return []models.LowStockProduct{}, nil }
 func (m *mockStorage) GetTopBillingBranch(period string) (*models.TopBillingBranch, error) { return &models.TopBillingBranch{}, nil }
 func (m *mockStorage) GetSalesSummaryByBranch(period string, branchName string) (*models.BranchSalesSummary, error) { return &models.BranchSalesSummary{}, nil }
 func (m *mockStorage) GetTopSellerByPeriod(period string) (*models.TopSeller, error) { return &models.TopSeller{}, nil }
 func (m *mockStorage) GetDailySalesByBranch(days int) ([]models.DailyBranchSales, error) { return []models.DailyBranchSales{}, nil }
 func (m *mockStorage) GetDashboardMetrics(days int) (float64, int, error) { return 0.0, 0, nil }
+func (m *mockStorage) GetFinancialKPIs(days int) (models.FinancialKPIs, error) { return models.FinancialKPIs{}, nil }
+func (m *mockStorage) GetTotalStockValue() (float64, error) { return 0, nil }
+func (m *mockStorage) GetStockComposition(categoriaID string) ([]models.StockComposition, error) { return nil, nil }
+func (m *mockStorage) GetProductDetails(identifier string) (*models.Product, error) { return nil, nil }
+func (m *mockStorage) CreateInventoryCount(count models.InventoryCount) (uuid.UUID, error) { return uuid.New(), nil }
+func (m *mockStorage) GetInventoryCounts(filialID string) ([]models.InventoryCount, error) { return []models.InventoryCount{}, nil }
+func (m *mockStorage) GetInventoryCountByID(id string) (*models.InventoryCount, error) { return nil, nil }
+func (m *mockStorage) GetInventoryCountProducts(countID, categoriaID string) ([]models.Product, error) { return []models.Product{}, nil }
+func (m *mockStorage) AddInventoryCountEntry(entry models.InventoryCountEntry, barcode string) error { return nil }
+func (m *mockStorage) GetInventoryCountEntries(countID, userID string) ([]models.InventoryCountEntry, error) { return []models.InventoryCountEntry{}, nil }
+func (m *mockStorage) CloseInventoryCount(id string) error { return nil }
+func (m *mockStorage) CancelInventoryCount(id string) error { return nil }
+func (m *mockStorage) GetInventoryCountVariances(countID string) ([]models.InventoryCountVariance, error) { return []models.InventoryCountVariance{}, nil }
+func (m *mockStorage) ApproveInventoryCount(countID, approverID, reason string) error { return nil }
+func (m *mockStorage) GetExpiringLots(filialID string, days int) ([]models.ExpiringLot, error) { return nil, nil }
+func (m *mockStorage) GetProductAverageCost(productID string) (float64, error) { return 0, nil }
+func (m *mockStorage) GetStockValueAt(day time.Time) ([]models.StockSnapshot, error) { return nil, nil }
+func (m *mockStorage) CreateStockAdjustment(adj models.StockAdjustment) (string, error) { return "aprovado", nil }
+func (m *mockStorage) GetStockAdjustments(filialID, status string, limit int) ([]models.StockAdjustment, error) { return nil, nil }
+func (m *mockStorage) ApproveStockAdjustment(id, approverID string) error { return nil }
+func (m *mockStorage) RejectStockAdjustment(id, approverID string) error { return nil }
+func (m *mockStorage) GetShrinkageReport(filialID string, days int) ([]models.ShrinkageReportRow, error) { return nil, nil }
+func (m *mockStorage) GetDailyUnitSales(productID, filialID string, from time.Time, days int) ([]float64, error) { return make([]float64, days), nil }
+func (m *mockStorage) GetDailyUnitSalesByStock(productIDs []string, filialID string, from time.Time, days int) (map[string][]float64, error) { return nil, nil }
+func (m *mockStorage) GetBestSellingStockItems(filialID string, days, limit int) ([]models.StockViewItem, error) { return nil, nil }
+func (m *mockStorage) RunABCClassification(filialID string, from, to time.Time, thresholdA, thresholdB float64) (int64, error) { return 0, nil }
+func (m *mockStorage) GetABCRun(filialID string) (*models.ABCRun, error) { return nil, nil }
+func (m *mockStorage) GetABCSummary(filialID, criterio string) ([]models.ABCSummary, error) { return nil, nil }
+func (m *mockStorage) CountABCItems(filialID, criterio, classe string) (int, error) { return 0, nil }
+func (m *mockStorage) GetABCItems(filialID, criterio, classe string, limit, offset int) ([]models.ABCItem, error) { return nil, nil }
+func (m *mockStorage) GetSlowMovingStock(filialID string, days, maxUnits, limit int) ([]models.SlowMovingItem, error) { return nil, nil }
+func (m *mockStorage) GetProductsForLabels(filter models.LabelFilter, limit int) ([]models.Product, error) {
+	if len(filter.ProdutoIDs) == 1 && filter.ProdutoIDs[0] == "produto-etiqueta" {
+		return []models.Product{{Nome: "Produto Etiqueta", CodigoBarras: "4006381333931"}}, nil
+	}
+	return nil, nil
+}
+func (m *mockStorage) GetProductByID(id string) (*models.Product, error) { return nil, nil }
+func (m *mockStorage) GetVariants(parentID string) ([]models.Product, error) { return nil, nil }
+func (m *mockStorage) AddVariant(parentID string, variant models.Product) (string, error) { return "", nil }
+func (m *mockStorage) UpdateVariant(variantID string, variant models.Product, userID string) error { return nil }
+func (m *mockStorage) GetProductBarcodes(productID string) ([]models.ProductBarcode, error) { return nil, nil }
+func (m *mockStorage) AddProductBarcode(code models.ProductBarcode) error { return nil }
+func (m *mockStorage) DeleteProductBarcode(code string) error { return nil }
+func (m *mockStorage) GenerateInternalBarcode() (string, error) { return "2000000000015", nil }
+func (m *mockStorage) SearchNCM(query string, limit int) ([]models.NCM, error) {
+	return []models.NCM{{Codigo: "22021000", Descricao: "Águas minerais adicionadas de açúcar"}}, nil
+}
+func (m *mockStorage) GetSuppliers() ([]models.Supplier, error) { return nil, nil }
+func (m *mockStorage) GetProductSuppliers(productID string) ([]models.ProductSupplier, error) { return nil, nil }
+func (m *mockStorage) SaveProductSupplier(link models.ProductSupplier) error { return nil }
+func (m *mockStorage) RemoveProductSupplier(productID, supplierID string) error { return nil }
+func (m *mockStorage) GetPreferredSuppliers(productIDs []string) (map[string]models.ProductSupplier, error) { return nil, nil }
+func (m *mockStorage) CreatePurchaseOrderDrafts(lines []models.PurchaseOrderLine, userID string) (int, error) { return 0, nil }
+func (m *mockStorage) GetPurchaseOrderDrafts() ([]models.PurchaseOrder, error) { return nil, nil }
+func (m *mockStorage) DeletePurchaseOrderDraft(id string) error { return nil }
+func (m *mockStorage) SetBranchPrice(productID, filialID string, price float64, userID string) error { return nil }
+func (m *mockStorage) DeleteBranchPrice(productID, filialID, userID string) error { return nil }
+func (m *mockStorage) ApplyBranchPriceChange(filialID, categoriaID string, percent float64, userID string) (int64, error) { return 0, nil }
+func (m *mockStorage) CountBranchPriceComparison(searchQuery string) (int, error) { return 0, nil }
+func (m *mockStorage) GetBranchPriceComparison(searchQuery string, limit, offset int) ([]models.BranchPriceComparison, error) { return nil, nil }
+func (m *mockStorage) GetPriceHistory(productID string, limit int) ([]models.PriceHistoryEntry, error) { return nil, nil }
+func (m *mockStorage) GetPriceChangesOn(day time.Time, filialID string) ([]models.PriceHistoryEntry, error) { return nil, nil }
+func (m *mockStorage) SchedulePriceChange(change models.ScheduledPriceChange) (string, error) { return "", nil }
+func (m *mockStorage) CancelScheduledPriceChange(id string) error { return nil }
+func (m *mockStorage) GetPendingPriceChanges(day *time.Time) ([]models.ScheduledPriceChange, error) { return nil, nil }
+func (m *mockStorage) GetCategories() ([]models.Category, error) { return []models.Category{}, nil }
+func (m *mockStorage) AddCategory(nome, paiID string) (string, error) { return "", nil }
+func (m *mockStorage) RenameCategory(id, nome string) error { return nil }
+func (m *mockStorage) DeleteCategory(id string) error { return nil }
+func (m *mockStorage) GetProductsForExport() ([]models.Product, error) { return nil, nil }
+func (m *mockStorage) ImportProducts(rows []models.ProductImportRow, userID string, dryRun bool, chunkSize int, progress func(done int)) (models.ProductImportReport, error) {
+	return models.ProductImportReport{Simulacao: dryRun}, nil
+}
 
 
 // --- Fim do Mock ---
@@ -138,6 +231,17 @@ func setupTestRouter() *gin.Engine {
 	adminRoutes.Use(h.AuthRequired("admin"))
 	{
 		adminRoutes.GET("/dashboard", h.ShowAdminDashboard)
+		adminRoutes.GET("/api/forecast", h.HandleGetForecast)
+		adminRoutes.GET("/produtos/exportar", h.HandleProductExport)
+		adminRoutes.GET("/api/pricing/preview", h.HandlePricePreview)
+		adminRoutes.GET("/api/ncm", h.HandleSearchNCM)
+		adminRoutes.GET("/reposicao", h.ShowReplenishmentPage)
+	}
+
+	stockRoutes := router.Group("/estoque")
+	stockRoutes.Use(h.AuthRequired("estoquista", "admin"))
+	{
+		stockRoutes.GET("/etiquetas/codigo/:id", h.HandleBarcodeImage)
 	}
 
 	apiRoutes := router.Group("/api")
@@ -198,6 +302,18 @@ func TestAdminDashboardAuth(t *testing.T) {
 		assert.Equal(t, http.StatusFound, w.Code)
 		assert.Equal(t, "/login", w.Header().Get("Location"))
 	})
+
+	t.Run("Deve terminar a sessão de um utilizador arquivado depois de entrar", func(t *testing.T) {
+		cookie, err := loginAs(router, "arquivado@teste.com", "senha123")
+		assert.NoError(t, err)
+
+		req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "/login", w.Header().Get("Location"))
+	})
 }
 
 func TestAPIHandlers(t *testing.T) {
@@ -232,3 +348,266 @@ func TestAPIHandlers(t *testing.T) {
 		assert.Equal(t, http.StatusUnauthorized, w.Code)
 	})
 }
+
+func TestForecastAPI(t *testing.T) {
+	router := setupTestRouter()
+
+	sessionCookie, err := loginAs(router, "admin@teste.com", "senha123")
+	assert.NoError(t, err)
+
+	t.Run("Deve exigir o produto", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/admin/api/forecast", nil)
+		req.AddCookie(sessionCookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Deve rejeitar um método desconhecido", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/admin/api/forecast?product_id="+uuid.NewString()+"&metodo=arima", nil)
+		req.AddCookie(sessionCookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Deve prever o horizonte pedido", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/admin/api/forecast?product_id="+uuid.NewString()+"&dias=7&metodo=media_movel", nil)
+		req.AddCookie(sessionCookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var body struct {
+			Previsao forecast.Forecast `json:"previsao"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Len(t, body.Previsao.Pontos, 7)
+		assert.Equal(t, 0.0, body.Previsao.Total)
+	})
+}
+
+func TestProductExport(t *testing.T) {
+	router := setupTestRouter()
+
+	sessionCookie, err := loginAs(router, "admin@teste.com", "senha123")
+	assert.NoError(t, err)
+
+	t.Run("Deve exportar o cabeçalho em CSV por omissão", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/admin/produtos/exportar", nil)
+		req.AddCookie(sessionCookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Disposition"), ".csv")
+		assert.True(t, strings.HasPrefix(w.Body.String(), "codigo_barras;nome;"))
+	})
+
+	t.Run("Deve rejeitar um formato desconhecido", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/admin/produtos/exportar?formato=ods", nil)
+		req.AddCookie(sessionCookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestPricePreview(t *testing.T) {
+	router := setupTestRouter()
+
+	sessionCookie, err := loginAs(router, "admin@teste.com", "senha123")
+	assert.NoError(t, err)
+
+	preview := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/admin/api/pricing/preview?"+query, nil)
+		req.AddCookie(sessionCookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Deve usar a estratégia padrão sem estratégia nem categoria", func(t *testing.T) {
+		w := preview("preco_custo=10&percentual_lucro=20&imposto_estadual=10&imposto_federal=5")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, 13.5, result["preco"])
+		assert.Equal(t, "padrão", result["origem"])
+	})
+
+	t.Run("Deve aplicar a estratégia do formulário", func(t *testing.T) {
+		w := preview("preco_custo=10&percentual_lucro=20&estrategia_metodo=margem&estrategia_impostos=fora&estrategia_arredondamento=0.99")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, 12.99, result["preco"])
+		assert.Equal(t, "produto", result["origem"])
+	})
+
+	t.Run("Deve rejeitar uma estratégia impossível", func(t *testing.T) {
+		w := preview("preco_custo=10&percentual_lucro=80&imposto_estadual=30&estrategia_metodo=margem&estrategia_impostos=dentro")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "100%")
+	})
+}
+
+func TestBarcodeFromForm(t *testing.T) {
+	h := NewHandler(&mockStorage{})
+
+	code, err := h.barcodeFromForm(" 7891000100103 ", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "7891000100103", code)
+
+	code, err = h.barcodeFromForm("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "2000000000015", code, "Um código vazio deve ser gerado como código interno")
+
+	_, err = h.barcodeFromForm("7891000100104", "")
+	assert.ErrorIs(t, err, gtin.ErrInvalid)
+
+	// Um código antigo que não é GTIN continua aceite enquanto não for alterado.
+	code, err = h.barcodeFromForm("1760000000000000001", "1760000000000000001")
+	assert.NoError(t, err)
+	assert.Equal(t, "1760000000000000001", code)
+	_, err = h.barcodeFromForm("1760000000000000002", "1760000000000000001")
+	assert.ErrorIs(t, err, gtin.ErrInvalid)
+}
+
+func TestReplenishmentABCFilter(t *testing.T) {
+	router := setupTestRouter()
+	sessionCookie, err := loginAs(router, "admin@teste.com", "senha123")
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/admin/reposicao?classe_abc=A", nil)
+	req.AddCookie(sessionCookie)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<option value="A" selected>`)
+
+	req, _ = http.NewRequest("GET", "/admin/reposicao?classe_abc=X", nil)
+	req.AddCookie(sessionCookie)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/admin/reposicao", w.Header().Get("Location"))
+}
+
+func TestFiscalClassification(t *testing.T) {
+	form := url.Values{"ncm": {"2202.10.00"}, "cest": {"03.007.00"}, "cfop": {"5405"}, "origem": {"2"}, "cst_icms": {"60"}}
+	f, err := fiscalFromForm(form.Get)
+	assert.NoError(t, err)
+	assert.Equal(t, "22021000", f.NCM)
+	assert.Equal(t, 2, f.Origem)
+
+	form.Set("cfop", "1102")
+	_, err = fiscalFromForm(form.Get)
+	assert.Error(t, err, "Um CFOP de entrada não serve de padrão nas vendas")
+
+	router := setupTestRouter()
+	sessionCookie, err := loginAs(router, "admin@teste.com", "senha123")
+	assert.NoError(t, err)
+	req, _ := http.NewRequest("GET", "/admin/api/ncm?q=agua", nil)
+	req.AddCookie(sessionCookie)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var results []models.NCM
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.Len(t, results, 1)
+}
+
+func TestUnitsFromForm(t *testing.T) {
+	form := url.Values{"unidade": {"un"}, "unidade_compra": {"CX"}, "fator_compra": {"12"}}
+	u, err := unitsFromForm(form.Get)
+	assert.NoError(t, err)
+	assert.Equal(t, models.UnitsOfMeasure{Unidade: "UN", UnidadeCompra: "CX", FatorCompra: 12}, u)
+
+	// Sem unidade de compra, o fator volta a 1.
+	u, err = unitsFromForm(url.Values{"unidade": {"KG"}}.Get)
+	assert.NoError(t, err)
+	assert.Equal(t, models.UnitsOfMeasure{Unidade: "KG", FatorCompra: 1}, u)
+
+	form.Set("fator_compra", "0")
+	_, err = unitsFromForm(form.Get)
+	assert.Error(t, err, "O fator de conversão tem de ser pelo menos 1")
+}
+
+func TestProductSupplierFromForm(t *testing.T) {
+	form := url.Values{"fornecedor": {" Distribuidora Sul "}, "codigo_fornecedor": {"DS-01"}, "ultimo_custo": {"1.25"},
+		"prazo_entrega_dias": {"7"}, "quantidade_minima": {"2"}, "preferencial": {"1"}}
+	link, err := productSupplierFromForm(form.Get)
+	assert.NoError(t, err)
+	assert.Equal(t, models.ProductSupplier{FornecedorNome: "Distribuidora Sul", CodigoFornecedor: "DS-01", UltimoCusto: 1.25,
+		PrazoEntregaDias: 7, QuantidadeMinima: 2, Preferencial: true}, link)
+
+	form.Set("fornecedor", "")
+	_, err = productSupplierFromForm(form.Get)
+	assert.Error(t, err, "O fornecedor é obrigatório")
+
+	form.Set("fornecedor", "Distribuidora Sul")
+	form.Set("quantidade_minima", "0")
+	_, err = productSupplierFromForm(form.Get)
+	assert.Error(t, err, "A quantidade mínima tem de ser pelo menos 1")
+}
+
+func TestApplySupplier(t *testing.T) {
+	box := models.UnitsOfMeasure{Unidade: "UN", UnidadeCompra: "CX", FatorCompra: 12}
+	supplier := &models.ProductSupplier{FornecedorNome: "Distribuidora Sul", UltimoCusto: 1.5, PrazoEntregaDias: 10, QuantidadeMinima: 2}
+
+	// Faltam 5 UN: sobe para caixas inteiras e para a encomenda mínima de 2 CX.
+	row := models.ReplenishmentRow{SugestaoCompra: 5, CoberturaDias: 4}
+	applySupplier(&row, box, supplier)
+	assert.Equal(t, 24, row.SugestaoCompra)
+	assert.Equal(t, "2 CX", row.SugestaoEmUnidadeCompra)
+	assert.InDelta(t, 36, row.CustoEstimado, 0.001)
+	assert.Equal(t, "Distribuidora Sul", row.Fornecedor)
+	assert.True(t, row.Urgente, "4 dias de cobertura não chegam para 10 de prazo")
+
+	// Acima do mínimo arredonda só à caixa.
+	row = models.ReplenishmentRow{SugestaoCompra: 30, CoberturaDias: 20}
+	applySupplier(&row, box, supplier)
+	assert.Equal(t, 36, row.SugestaoCompra)
+	assert.False(t, row.Urgente)
+
+	// Sem fornecedor nem unidade de compra, a sugestão fica como estava.
+	row = models.ReplenishmentRow{SugestaoCompra: 5, CoberturaDias: 1}
+	applySupplier(&row, models.UnitsOfMeasure{Unidade: "UN", FatorCompra: 1}, nil)
+	assert.Equal(t, 5, row.SugestaoCompra)
+	assert.Empty(t, row.SugestaoEmUnidadeCompra)
+	assert.False(t, row.Urgente)
+}
+
+func TestParsePurchaseOrderLines(t *testing.T) {
+	productID, filialID := uuid.New(), uuid.New()
+	lines, err := parsePurchaseOrderLines([]string{productID.String() + ":" + filialID.String() + ":24"})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.PurchaseOrderLine{{ProdutoID: productID, FilialID: filialID, Quantidade: 24}}, lines)
+
+	_, err = parsePurchaseOrderLines([]string{productID.String() + ":" + filialID.String() + ":0"})
+	assert.Error(t, err, "A quantidade tem de ser positiva")
+	_, err = parsePurchaseOrderLines([]string{productID.String() + ":24"})
+	assert.Error(t, err, "Falta a filial")
+}
+
+func TestBarcodeImage(t *testing.T) {
+	router := setupTestRouter()
+	sessionCookie, err := loginAs(router, "admin@teste.com", "senha123")
+	assert.NoError(t, err)
+
+	image := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/estoque/etiquetas/codigo/produto-etiqueta?"+query, nil)
+		req.AddCookie(sessionCookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := image("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+
+	for _, query := range []string{"modulo=100000&altura=100000", "modulo=0", "modulo=11", "altura=9", "altura=501", "modulo=abc"} {
+		assert.Equal(t, http.StatusBadRequest, image(query).Code, query)
+	}
+}
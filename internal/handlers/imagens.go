@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/images"
+	"projeto-vendas/internal/models"
+)
+
+// setImageURLs preenche os URLs da imagem e da miniatura dos produtos que têm imagem.
+func (h *Handler) setImageURLs(products []models.Product) {
+	if h.Images == nil {
+		return
+	}
+	for i := range products {
+		if products[i].Imagem == "" {
+			continue
+		}
+		full, thumb := images.FileNames(products[i].Imagem)
+		products[i].ImagemURL = h.Images.URL(full)
+		products[i].MiniaturaURL = h.Images.URL(thumb)
+	}
+}
+
+// deleteImageFiles apaga a imagem e a miniatura de uma chave. Uma falha só fica no log:
+// o produto já não aponta para estes ficheiros.
+func (h *Handler) deleteImageFiles(key string) {
+	if key == "" {
+		return
+	}
+	full, thumb := images.FileNames(key)
+	for _, name := range []string{full, thumb} {
+		if err := h.Images.Delete(name); err != nil {
+			log.Printf("Erro ao apagar a imagem %s: %v", name, err)
+		}
+	}
+}
+
+// HandleUploadProductImage valida a imagem enviada no campo imagem, grava a imagem reduzida
+// e a miniatura e associa-as ao produto, substituindo a anterior. Cada envio tem uma chave
+// nova, para que os navegadores não mostrem a imagem antiga da cache.
+func (h *Handler) HandleUploadProductImage(c *gin.Context) {
+	session := sessions.Default(c)
+	productID := c.Param("id")
+	back := c.Request.Header.Get("Referer")
+	if back == "" {
+		back = "/admin/dashboard"
+	}
+	fail := func(msg string) {
+		session.AddFlash(msg, "error")
+		session.Save()
+		c.Redirect(http.StatusFound, back)
+	}
+	if _, err := uuid.Parse(productID); err != nil {
+		fail("Produto inválido.")
+		return
+	}
+	if h.Images == nil {
+		fail("O armazenamento de imagens não está configurado.")
+		return
+	}
+
+	header, err := c.FormFile("imagem")
+	if err != nil {
+		fail("Escolha uma imagem JPEG, PNG ou GIF.")
+		return
+	}
+	if header.Size > images.MaxUploadSize {
+		fail("Falha ao enviar a imagem: " + images.ErrTooLarge.Error() + ".")
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		fail("Não foi possível ler a imagem.")
+		return
+	}
+	defer file.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(file, images.MaxUploadSize+1)); err != nil {
+		fail("Não foi possível ler a imagem.")
+		return
+	}
+	processed, err := images.Process(buf.Bytes())
+	if err != nil {
+		fail("Falha ao enviar a imagem: " + err.Error() + ".")
+		return
+	}
+
+	key := productID + "-" + uuid.NewString()[:8]
+	full, thumb := images.FileNames(key)
+	if err := h.Images.Save(full, processed.Full); err != nil {
+		log.Printf("Erro ao gravar a imagem %s: %v", full, err)
+		fail("Falha ao gravar a imagem.")
+		return
+	}
+	if err := h.Images.Save(thumb, processed.Thumb); err != nil {
+		log.Printf("Erro ao gravar a miniatura %s: %v", thumb, err)
+		h.deleteImageFiles(key)
+		fail("Falha ao gravar a imagem.")
+		return
+	}
+	previous, err := h.Storage.SetProductImage(productID, key)
+	if err != nil {
+		h.deleteImageFiles(key)
+		fail("Falha ao associar a imagem ao produto: " + err.Error() + ".")
+		return
+	}
+	h.deleteImageFiles(previous)
+
+	session.AddFlash("Imagem do produto atualizada.", "success")
+	session.Save()
+	c.Redirect(http.StatusFound, back)
+}
+
+// HandleDeleteProductImage remove a imagem do produto e apaga os ficheiros.
+func (h *Handler) HandleDeleteProductImage(c *gin.Context) {
+	session := sessions.Default(c)
+	previous, err := h.Storage.SetProductImage(c.Param("id"), "")
+	if err != nil {
+		session.AddFlash("Falha ao remover a imagem: "+err.Error()+".", "error")
+	} else {
+		if h.Images != nil {
+			h.deleteImageFiles(previous)
+		}
+		session.AddFlash("Imagem do produto removida.", "success")
+	}
+	session.Save()
+	back := c.Request.Header.Get("Referer")
+	if back == "" {
+		back = "/admin/dashboard"
+	}
+	c.Redirect(http.StatusFound, back)
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"projeto-vendas/internal/catalog"
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/storage"
+)
+
+// maxImportFileSize limita o tamanho dos ficheiros de importação do catálogo.
+const maxImportFileSize = 32 << 20
+
+// importJobRetention é o tempo durante o qual o resultado de uma importação fica disponível.
+const importJobRetention = time.Hour
+
+// importJob é uma importação do catálogo a correr em segundo plano; a página consulta o
+// progresso e, no fim, o relatório.
+type importJob struct {
+	mu         sync.Mutex
+	ficheiro   string
+	total      int
+	feitas     int
+	concluida  bool
+	erro       string
+	relatorio  models.ProductImportReport
+	finishedAt time.Time
+}
+
+// importJobs guarda as importações em curso e as concluídas há menos de importJobRetention.
+var importJobs = struct {
+	sync.Mutex
+	m map[string]*importJob
+}{m: make(map[string]*importJob)}
+
+// startImportJob regista a importação e grava as linhas numa goroutine.
+func (h *Handler) startImportJob(ficheiro string, rows []models.ProductImportRow, rejected []models.ProductImportResult, userID string, dryRun bool) string {
+	job := &importJob{ficheiro: ficheiro, total: len(rows)}
+	id := uuid.NewString()
+
+	importJobs.Lock()
+	for key, old := range importJobs.m {
+		old.mu.Lock()
+		expired := old.concluida && time.Since(old.finishedAt) > importJobRetention
+		old.mu.Unlock()
+		if expired {
+			delete(importJobs.m, key)
+		}
+	}
+	importJobs.m[id] = job
+	importJobs.Unlock()
+
+	go func() {
+		progress := func(done int) {
+			job.mu.Lock()
+			job.feitas = done
+			job.mu.Unlock()
+		}
+		report, err := h.Storage.ImportProducts(rows, userID, dryRun, storage.DefaultImportChunkSize, progress)
+		catalog.AddRejected(&report, rejected)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		if err != nil {
+			log.Printf("Erro na importação do catálogo %s: %v", ficheiro, err)
+			job.erro = err.Error()
+		}
+		job.relatorio = report
+		job.concluida = true
+		job.finishedAt = time.Now()
+	}()
+	return id
+}
+
+func (h *Handler) ShowProductImportPage(c *gin.Context) {
+	session := sessions.Default(c)
+	data := getFlashes(c)
+	data["title"] = "Importar e Exportar Produtos"
+	data["job"] = c.Query("job")
+	data["columns"] = catalog.Columns
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "dashboard"
+	c.HTML(http.StatusOK, "produtos_importar.html", data)
+}
+
+// HandleProductImport valida o ficheiro enviado e inicia a importação (ou a simulação,
+// com o campo simulacao), redirecionando para a página que acompanha o progresso.
+func (h *Handler) HandleProductImport(c *gin.Context) {
+	session := sessions.Default(c)
+	fail := func(msg string) {
+		session.AddFlash(msg, "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/produtos/importar")
+	}
+
+	header, err := c.FormFile("ficheiro")
+	if err != nil {
+		fail("Escolha um ficheiro CSV ou XLSX.")
+		return
+	}
+	if header.Size > maxImportFileSize {
+		fail(fmt.Sprintf("O ficheiro excede o limite de %d MB.", maxImportFileSize>>20))
+		return
+	}
+	format, err := catalog.FormatFromName(header.Filename)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		fail("Não foi possível ler o ficheiro.")
+		return
+	}
+	defer file.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(file, maxImportFileSize)); err != nil {
+		fail("Não foi possível ler o ficheiro.")
+		return
+	}
+
+	records, err := catalog.ReadRecords(buf.Bytes(), format)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	rows, rejected, err := catalog.Parse(records)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	if len(rows) == 0 && len(rejected) == 0 {
+		fail("O ficheiro não tem produtos.")
+		return
+	}
+
+	userID, _ := session.Get("userID").(string)
+	dryRun := c.PostForm("simulacao") != ""
+	id := h.startImportJob(header.Filename, rows, rejected, userID, dryRun)
+	c.Redirect(http.StatusFound, "/admin/produtos/importar?job="+id)
+}
+
+// HandleProductImportStatus devolve em JSON o progresso de uma importação e, quando
+// termina, o relatório.
+func (h *Handler) HandleProductImportStatus(c *gin.Context) {
+	importJobs.Lock()
+	job, ok := importJobs.m[c.Param("id")]
+	importJobs.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Importação não encontrada ou expirada."})
+		return
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	response := gin.H{"ficheiro": job.ficheiro, "total": job.total, "feitas": job.feitas, "concluida": job.concluida}
+	if job.concluida {
+		response["erro"] = job.erro
+		response["relatorio"] = job.relatorio
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleProductExport descarrega o catálogo em CSV (por omissão) ou XLSX.
+func (h *Handler) HandleProductExport(c *gin.Context) {
+	format := catalog.Format(c.DefaultQuery("formato", string(catalog.CSV)))
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case catalog.CSV:
+	case catalog.XLSX:
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	default:
+		c.String(http.StatusBadRequest, "Formato inválido.")
+		return
+	}
+	products, err := h.Storage.GetProductsForExport()
+	if err != nil {
+		log.Printf("Erro ao exportar o catálogo: %v", err)
+		c.String(http.StatusInternalServerError, "Falha ao exportar o catálogo.")
+		return
+	}
+
+	filename := fmt.Sprintf("produtos_%s.%s", time.Now().Format("20060102"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	if err := catalog.Write(c.Writer, format, products); err != nil {
+		log.Printf("Erro ao escrever a exportação do catálogo: %v", err)
+	}
+}
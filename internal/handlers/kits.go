@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ShowKitPage mostra os componentes de um kit, o custo e a margem calculados a partir dos
+// componentes e quantos kits o stock permite montar em cada filial.
+func (h *Handler) ShowKitPage(c *gin.Context) {
+	session := sessions.Default(c)
+	product, err := h.Storage.GetProductByID(c.Param("id"))
+	if err != nil || product == nil {
+		session.AddFlash("Produto não encontrado.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
+	}
+	components, err := h.Storage.GetKitComponents(product.ID.String())
+	if err != nil {
+		log.Printf("Erro ao listar os componentes do kit: %v", err)
+	}
+	availability, err := h.Storage.GetKitAvailability(product.ID.String())
+	if err != nil {
+		log.Printf("Erro ao calcular a disponibilidade do kit: %v", err)
+	}
+	var cost float64
+	for _, comp := range components {
+		cost += float64(comp.Quantidade) * comp.CustoUnitario
+	}
+	margin := product.PrecoSugerido - cost
+	var marginPct float64
+	if product.PrecoSugerido > 0 {
+		marginPct = margin / product.PrecoSugerido * 100
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Kit " + product.Nome
+	data["product"] = product
+	data["components"] = components
+	data["availability"] = availability
+	data["cost"] = cost
+	data["margin"] = margin
+	data["marginPct"] = marginPct
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "dashboard"
+	c.HTML(http.StatusOK, "produto_kit.html", data)
+}
+
+// HandleSetKitComponent adiciona um componente ao kit da rota, ou altera as unidades por
+// kit de um componente que já faz parte dele.
+func (h *Handler) HandleSetKitComponent(c *gin.Context) {
+	session := sessions.Default(c)
+	kitID := c.Param("id")
+	redirect := "/admin/products/kit/" + kitID
+
+	if _, err := uuid.Parse(kitID); err != nil {
+		session.AddFlash("Produto inválido.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
+	}
+	quantity, err := strconv.Atoi(c.DefaultPostForm("quantidade", "1"))
+	if err != nil || quantity < 1 {
+		session.AddFlash("A quantidade por kit tem de ser um número inteiro positivo.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+	if err := h.Storage.SetKitComponent(kitID, c.PostForm("codigo"), quantity); err != nil {
+		log.Printf("Erro ao definir componente do kit: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao definir o componente: %v", err), "error")
+	} else {
+		session.AddFlash("Componente do kit gravado.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// HandleRemoveKitComponent tira um componente do kit da rota.
+func (h *Handler) HandleRemoveKitComponent(c *gin.Context) {
+	session := sessions.Default(c)
+	if err := h.Storage.RemoveKitComponent(c.Param("id"), c.PostForm("componente_id")); err != nil {
+		log.Printf("Erro ao remover componente do kit: %v", err)
+		session.AddFlash("Falha ao remover o componente.", "error")
+	} else {
+		session.AddFlash("Componente removido do kit.", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/products/kit/"+c.Param("id"))
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultExpiryDays é o horizonte do alerta de validade quando o utilizador não indica outro.
+const defaultExpiryDays = 30
+
+// ShowExpiryReportPage mostra os lotes que vencem nos próximos N dias.
+// O admin pode filtrar por filial; o estoquista vê apenas a sua.
+func (h *Handler) ShowExpiryReportPage(c *gin.Context) {
+	session := sessions.Default(c)
+	userRole := session.Get("userRole")
+
+	days, err := strconv.Atoi(c.DefaultQuery("dias", strconv.Itoa(defaultExpiryDays)))
+	if err != nil || days < 0 {
+		days = defaultExpiryDays
+	}
+
+	filialID := c.Query("filial_id")
+	if userRole != "admin" {
+		sessionFilial, ok := session.Get("filialID").(string)
+		if !ok {
+			c.HTML(http.StatusForbidden, "error.html", gin.H{"title": "Erro", "ErrorMessage": "Utilizador não está associado a nenhuma filial."})
+			return
+		}
+		filialID = sessionFilial
+	}
+
+	lots, err := h.Storage.GetExpiringLots(filialID, days)
+	if err != nil {
+		log.Printf("Erro ao obter lotes a vencer: %v", err)
+	}
+	var expiredQty, expiringQty int
+	var expiredValue, expiringValue float64
+	for _, l := range lots {
+		if l.DiasParaVencer < 0 {
+			expiredQty += l.Quantidade
+			expiredValue += l.ValorCusto
+		} else {
+			expiringQty += l.Quantidade
+			expiringValue += l.ValorCusto
+		}
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Alerta de Validades"
+	data["lots"] = lots
+	data["Dias"] = days
+	data["QtdVencida"] = expiredQty
+	data["ValorVencido"] = expiredValue
+	data["QtdAVencer"] = expiringQty
+	data["ValorAVencer"] = expiringValue
+	data["UserRole"] = userRole
+	data["UserName"] = session.Get("userName")
+	data["FilialName"] = session.Get("filialName")
+	data["FilialID"] = filialID
+	data["ActivePage"] = "validades"
+	if userRole == "admin" {
+		filiais, _ := h.Storage.GetAllFiliais()
+		data["filiais"] = filiais
+	}
+	c.HTML(http.StatusOK, "validades.html", data)
+}
+
+// HandleGetExpiringLots devolve em JSON os lotes que vencem nos próximos N dias.
+func (h *Handler) HandleGetExpiringLots(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("dias", strconv.Itoa(defaultExpiryDays)))
+	if err != nil || days < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parâmetro 'dias' inválido"})
+		return
+	}
+	filialID := c.Query("filial_id")
+	session := sessions.Default(c)
+	if session.Get("userRole") != "admin" {
+		filialID, _ = session.Get("filialID").(string)
+	}
+	lots, err := h.Storage.GetExpiringLots(filialID, days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao obter lotes a vencer"})
+		return
+	}
+	c.JSON(http.StatusOK, lots)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultSlowMovingDays é o período analisado quando o pedido não indica outro.
+	defaultSlowMovingDays = 90
+	// defaultSlowMovingLimit limita as linhas devolvidas à API do assistente.
+	defaultSlowMovingLimit = 10
+)
+
+// slowMovingParams lê o período ('dias') e o limite de vendas ('max_vendas') do pedido.
+func slowMovingParams(c *gin.Context) (days, maxUnits int, err error) {
+	days, err = strconv.Atoi(c.DefaultQuery("dias", strconv.Itoa(defaultSlowMovingDays)))
+	if err != nil || days <= 0 {
+		return 0, 0, fmt.Errorf("parâmetro 'dias' inválido")
+	}
+	maxUnits, err = strconv.Atoi(c.DefaultQuery("max_vendas", "0"))
+	if err != nil || maxUnits < 0 {
+		return 0, 0, fmt.Errorf("parâmetro 'max_vendas' inválido")
+	}
+	return days, maxUnits, nil
+}
+
+// ShowSlowMovingPage mostra o stock sem vendas (ou com vendas até um limite) nos últimos
+// N dias, com o valor parado e a filial sugerida para transferência.
+// O admin pode filtrar por filial; o estoquista vê apenas a sua.
+func (h *Handler) ShowSlowMovingPage(c *gin.Context) {
+	session := sessions.Default(c)
+	userRole := session.Get("userRole")
+
+	days, maxUnits, err := slowMovingParams(c)
+	if err != nil {
+		session.AddFlash(err.Error(), "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/estoque/parado")
+		return
+	}
+	filialID := c.Query("filial_id")
+	if userRole != "admin" {
+		sessionFilial, ok := session.Get("filialID").(string)
+		if !ok {
+			c.HTML(http.StatusForbidden, "error.html", gin.H{"title": "Erro", "ErrorMessage": "Utilizador não está associado a nenhuma filial."})
+			return
+		}
+		filialID = sessionFilial
+	}
+
+	items, err := h.Storage.GetSlowMovingStock(filialID, days, maxUnits, 0)
+	if err != nil {
+		log.Printf("Erro ao obter stock parado: %v", err)
+	}
+	var totalQty, withTarget int
+	var totalValue float64
+	for _, it := range items {
+		totalQty += it.Quantidade
+		totalValue += it.ValorParado
+		if it.FilialSugeridaID != "" {
+			withTarget++
+		}
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Stock Parado"
+	data["items"] = items
+	data["Dias"] = days
+	data["MaxVendas"] = maxUnits
+	data["TotalQuantidade"] = totalQty
+	data["TotalValor"] = totalValue
+	data["ComDestino"] = withTarget
+	data["UserRole"] = userRole
+	data["UserName"] = session.Get("userName")
+	data["FilialName"] = session.Get("filialName")
+	data["FilialID"] = filialID
+	data["ActivePage"] = "parado"
+	if userRole == "admin" {
+		filiais, _ := h.Storage.GetAllFiliais()
+		data["filiais"] = filiais
+	}
+	c.HTML(http.StatusOK, "estoque_parado.html", data)
+}
+
+// HandleGetSlowMovingStock devolve em JSON o stock parado com maior valor a custo.
+// Aceita a filial pelo ID ('filial_id') ou pelo nome ('filial'), como usado pelo assistente.
+func (h *Handler) HandleGetSlowMovingStock(c *gin.Context) {
+	days, maxUnits, err := slowMovingParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultSlowMovingLimit)))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parâmetro 'limit' inválido."})
+		return
+	}
+
+	filialID := c.Query("filial_id")
+	if name := c.Query("filial"); filialID == "" && name != "" {
+		filiais, err := h.Storage.GetAllFiliais()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao obter as filiais."})
+			return
+		}
+		for _, f := range filiais {
+			if strings.EqualFold(f.Nome, name) {
+				filialID = f.ID.String()
+				break
+			}
+		}
+		if filialID == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Filial '%s' não encontrada.", name)})
+			return
+		}
+	}
+	session := sessions.Default(c)
+	if session.Get("userRole") != "admin" {
+		filialID, _ = session.Get("filialID").(string)
+	}
+
+	items, err := h.Storage.GetSlowMovingStock(filialID, days, maxUnits, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao obter o stock parado."})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"projeto-vendas/internal/models"
+)
+
+// branchPriceCell é o preço de um produto numa filial na comparação de preços.
+type branchPriceCell struct {
+	FilialID  string
+	Preco     float64
+	Proprio   bool    // preço próprio da filial; caso contrário é o de catálogo
+	Diferenca float64 // % face ao preço de catálogo
+}
+
+// branchPriceRow acrescenta à comparação as células pela ordem das filiais e a amplitude.
+type branchPriceRow struct {
+	models.BranchPriceComparison
+	Celulas        []branchPriceCell
+	Minimo, Maximo float64
+}
+
+// parsePrice aceita preços com vírgula ou ponto decimal.
+func parsePrice(s string) (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", "."), 64)
+}
+
+func (h *Handler) ShowBranchPricesPage(c *gin.Context) {
+	session := sessions.Default(c)
+	searchQuery := c.Query("search_product")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+
+	filiais, _ := h.Storage.GetAllFiliais()
+	categories, _ := h.Storage.GetCategories()
+	totalItems, _ := h.Storage.CountBranchPriceComparison(searchQuery)
+	items, err := h.Storage.GetBranchPriceComparison(searchQuery, PageLimit, (page-1)*PageLimit)
+	if err != nil {
+		log.Printf("Erro ao obter a comparação de preços: %v", err)
+	}
+	rows := make([]branchPriceRow, 0, len(items))
+	for _, item := range items {
+		row := branchPriceRow{BranchPriceComparison: item, Minimo: item.PrecoCatalogo, Maximo: item.PrecoCatalogo}
+		for i, f := range filiais {
+			cell := branchPriceCell{FilialID: f.ID.String(), Preco: item.PrecoCatalogo}
+			if price, ok := item.Precos[cell.FilialID]; ok {
+				cell.Preco, cell.Proprio = price, true
+				if item.PrecoCatalogo > 0 {
+					cell.Diferenca = (price/item.PrecoCatalogo - 1) * 100
+				}
+			}
+			if i == 0 {
+				row.Minimo, row.Maximo = cell.Preco, cell.Preco
+			}
+			row.Minimo = math.Min(row.Minimo, cell.Preco)
+			row.Maximo = math.Max(row.Maximo, cell.Preco)
+			row.Celulas = append(row.Celulas, cell)
+		}
+		rows = append(rows, row)
+	}
+	totalPages := int(math.Ceil(float64(totalItems) / float64(PageLimit)))
+
+	data := getFlashes(c)
+	data["title"] = "Preços por Filial"
+	data["rows"] = rows
+	data["filiais"] = filiais
+	data["categories"] = categories
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "precos"
+	data["Pagination"] = models.PaginationData{
+		HasPrev:     page > 1,
+		HasNext:     page < totalPages,
+		PrevPage:    page - 1,
+		NextPage:    page + 1,
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		SearchQuery: searchQuery,
+	}
+	c.HTML(http.StatusOK, "precos_filiais.html", data)
+}
+
+// HandleSaveBranchPrices grava os preços de um produto em todas as filiais a partir dos
+// campos preco_<filial_id>; um campo vazio devolve a filial ao preço de catálogo.
+func (h *Handler) HandleSaveBranchPrices(c *gin.Context) {
+	session := sessions.Default(c)
+	productID := c.Param("id")
+	redirect := c.Request.Header.Get("Referer")
+	if redirect == "" {
+		redirect = "/admin/precos"
+	}
+
+	userID, _ := session.Get("userID").(string)
+	filiais, err := h.Storage.GetAllFiliais()
+	if err != nil {
+		log.Printf("Erro ao obter filiais: %v", err)
+		session.AddFlash("Falha ao obter as filiais.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, redirect)
+		return
+	}
+	for _, f := range filiais {
+		value := strings.TrimSpace(c.PostForm("preco_" + f.ID.String()))
+		if value == "" {
+			err = h.Storage.DeleteBranchPrice(productID, f.ID.String(), userID)
+		} else if price, perr := parsePrice(value); perr != nil || price < 0 {
+			err = fmt.Errorf("preço inválido para a filial %s: %q", f.Nome, value)
+		} else {
+			err = h.Storage.SetBranchPrice(productID, f.ID.String(), price, userID)
+		}
+		if err != nil {
+			log.Printf("Erro ao gravar preço da filial: %v", err)
+			session.AddFlash(fmt.Sprintf("Falha ao gravar os preços: %v", err), "error")
+			session.Save()
+			c.Redirect(http.StatusFound, redirect)
+			return
+		}
+	}
+	session.AddFlash("Preços por filial atualizados com sucesso!", "success")
+	session.Save()
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// HandleApplyBranchPriceChange aplica uma variação percentual aos preços de uma filial,
+// opcionalmente só a uma categoria e às suas subcategorias.
+func (h *Handler) HandleApplyBranchPriceChange(c *gin.Context) {
+	session := sessions.Default(c)
+	filialID := c.PostForm("filial_id")
+	percent, err := parsePrice(c.PostForm("percentual"))
+	if filialID == "" || err != nil {
+		session.AddFlash("Indique a filial e uma variação percentual válida.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/precos")
+		return
+	}
+	userID, _ := session.Get("userID").(string)
+	count, err := h.Storage.ApplyBranchPriceChange(filialID, c.PostForm("categoria_id"), percent, userID)
+	if err != nil {
+		log.Printf("Erro ao aplicar variação de preços: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao aplicar a variação de preços: %v", err), "error")
+	} else {
+		session.AddFlash(fmt.Sprintf("Variação de %+.2f%% aplicada a %d produtos da filial.", percent, count), "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/precos")
+}
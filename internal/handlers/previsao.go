@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"projeto-vendas/internal/forecast"
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/storage"
+)
+
+const (
+	// defaultForecastHorizon são os dias previstos quando o pedido não indica outro.
+	defaultForecastHorizon = 14
+	// defaultForecastHistory são os dias de vendas usados para ajustar os modelos.
+	defaultForecastHistory = 90
+	maxForecastHorizon     = 90
+	maxForecastHistory     = 730
+)
+
+// forecastMethodOptions são os modelos apresentados na vista de reposição.
+var forecastMethodOptions = []struct {
+	Valor     forecast.Method
+	Descricao string
+}{
+	{Valor: forecast.WeeklySeasonal, Descricao: "Sazonalidade semanal"},
+	{Valor: forecast.ExponentialSmoothing, Descricao: "Suavização exponencial"},
+	{Valor: forecast.MovingAverage, Descricao: "Média móvel (28 dias)"},
+}
+
+// forecastParams são os parâmetros comuns à vista de reposição e à API de previsão.
+type forecastParams struct {
+	Horizon int
+	History int
+	Method  forecast.Method
+}
+
+func parseForecastParams(c *gin.Context) (forecastParams, error) {
+	p := forecastParams{Horizon: defaultForecastHorizon, History: defaultForecastHistory}
+	var err error
+	if v := c.Query("dias"); v != "" {
+		if p.Horizon, err = strconv.Atoi(v); err != nil || p.Horizon <= 0 || p.Horizon > maxForecastHorizon {
+			return p, fmt.Errorf("parâmetro 'dias' inválido (1 a %d)", maxForecastHorizon)
+		}
+	}
+	if v := c.Query("historico"); v != "" {
+		if p.History, err = strconv.Atoi(v); err != nil || p.History < 7 || p.History > maxForecastHistory {
+			return p, fmt.Errorf("parâmetro 'historico' inválido (7 a %d)", maxForecastHistory)
+		}
+	}
+	if p.Method, err = forecast.ParseMethod(c.Query("metodo")); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// today devolve o início do dia atual; as séries de vendas terminam na véspera, para
+// que as vendas parciais de hoje não puxem a previsão para baixo.
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
+
+// ShowReplenishmentPage mostra o stock por filial com a procura prevista e a quantidade
+// sugerida a encomendar (limite superior da previsão menos o saldo atual), arredondada às
+// condições do fornecedor preferencial.
+func (h *Handler) ShowReplenishmentPage(c *gin.Context) {
+	session := sessions.Default(c)
+	params, err := parseForecastParams(c)
+	if err != nil {
+		session.AddFlash(err.Error(), "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/reposicao")
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	searchQuery := c.Query("search_product")
+	filialID := c.Query("filial_id")
+	// classe_abc limita a lista a uma classe da última classificação ABC por receita.
+	abcClass := c.Query("classe_abc")
+	if abcClass != "" && abcClass != "A" && abcClass != "B" && abcClass != "C" {
+		session.AddFlash("Classe ABC inválida (A, B ou C).", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/reposicao")
+		return
+	}
+
+	totalItems, _ := h.Storage.CountStockItems(filialID, searchQuery, abcClass)
+	stockItems, err := h.Storage.GetStockItemsPaginated(filialID, searchQuery, abcClass, PageLimit, (page-1)*PageLimit)
+	if err != nil {
+		log.Printf("Erro ao listar stock para reposição: %v", err)
+	}
+	totalPages := int(math.Ceil(float64(totalItems) / float64(PageLimit)))
+
+	var productIDs []string
+	for _, item := range stockItems {
+		productIDs = append(productIDs, item.ProdutoID.String())
+	}
+	start := today()
+	series, err := h.Storage.GetDailyUnitSalesByStock(productIDs, filialID, start.AddDate(0, 0, -params.History), params.History)
+	if err != nil {
+		log.Printf("Erro ao obter histórico de vendas para previsão: %v", err)
+	}
+
+	suppliers, err := h.Storage.GetPreferredSuppliers(productIDs)
+	if err != nil {
+		log.Printf("Erro ao obter fornecedores para reposição: %v", err)
+	}
+
+	rows := make([]models.ReplenishmentRow, 0, len(stockItems))
+	for _, item := range stockItems {
+		history := series[storage.SalesSeriesKey(item.ProdutoID.String(), item.FilialID.String())]
+		if history == nil {
+			history = make([]float64, params.History)
+		}
+		row := buildReplenishmentRow(item, history, start, params)
+		var supplier *models.ProductSupplier
+		if ps, ok := suppliers[item.ProdutoID.String()]; ok {
+			supplier = &ps
+		}
+		applySupplier(&row, item.UnitsOfMeasure, supplier)
+		rows = append(rows, row)
+	}
+
+	filiais, _ := h.Storage.GetAllFiliais()
+	data := getFlashes(c)
+	data["title"] = "Reposição de Stock"
+	data["rows"] = rows
+	data["filiais"] = filiais
+	data["metodos"] = forecastMethodOptions
+	data["Metodo"] = params.Method
+	data["Dias"] = params.Horizon
+	data["HorizonteDias"] = float64(params.Horizon) // para comparar com a cobertura no template
+	data["Historico"] = params.History
+	data["ClasseABC"] = abcClass
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "reposicao"
+	data["Pagination"] = models.PaginationData{
+		HasPrev:     page > 1,
+		HasNext:     page < totalPages,
+		PrevPage:    page - 1,
+		NextPage:    page + 1,
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		SearchQuery: searchQuery,
+		FilterID:    filialID,
+	}
+	c.HTML(http.StatusOK, "reposicao.html", data)
+}
+
+func buildReplenishmentRow(item models.StockViewItem, history []float64, start time.Time, params forecastParams) models.ReplenishmentRow {
+	row := models.ReplenishmentRow{
+		ProdutoID:     item.ProdutoID,
+		ProdutoNome:   item.ProdutoNome,
+		CodigoBarras:  item.CodigoBarras,
+		FilialID:      item.FilialID,
+		FilialNome:    item.FilialNome,
+		Quantidade:    item.Quantidade,
+		CoberturaDias: -1,
+		ClasseABC:     item.ClasseABC,
+	}
+	f, err := forecast.Predict(history, start, params.Horizon, params.Method, forecast.Options{})
+	if err != nil {
+		row.SemPrevisao = true
+		return row
+	}
+	row.Previsao = f.Total
+	row.LimiteInferior = f.TotalInferior
+	row.LimiteSuperior = f.TotalSuperior
+	if daily := f.Total / float64(params.Horizon); daily > 0 {
+		row.CoberturaDias = float64(item.Quantidade) / daily
+	}
+	if need := int(math.Ceil(f.TotalSuperior)) - item.Quantidade; need > 0 {
+		row.SugestaoCompra = need
+	}
+	return row
+}
+
+// HandleGetForecast devolve o histórico diário e a previsão de um produto, numa filial
+// ou em todas (filial_id vazio).
+func (h *Handler) HandleGetForecast(c *gin.Context) {
+	params, err := parseForecastParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	productID := c.Query("product_id")
+	if productID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "O parâmetro 'product_id' é obrigatório."})
+		return
+	}
+	filialID := c.Query("filial_id")
+	start := today()
+	history, err := h.Storage.GetDailyUnitSales(productID, filialID, start.AddDate(0, 0, -params.History), params.History)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao obter o histórico de vendas."})
+		return
+	}
+	f, err := forecast.Predict(history, start, params.Horizon, params.Method, forecast.Options{})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"produto_id":   productID,
+		"filial_id":    filialID,
+		"inicio_serie": start.AddDate(0, 0, -params.History),
+		"historico":    history,
+		"previsao":     f,
+	})
+}
+
+// HandleGetForecastBacktest avalia os modelos (ou só o pedido em 'metodo') contra o
+// histórico do produto, com origem móvel a partir de metade do histórico.
+func (h *Handler) HandleGetForecastBacktest(c *gin.Context) {
+	params, err := parseForecastParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	productID := c.Query("product_id")
+	if productID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "O parâmetro 'product_id' é obrigatório."})
+		return
+	}
+	methods := forecast.Methods
+	if c.Query("metodo") != "" {
+		methods = []forecast.Method{params.Method}
+	}
+	start := today()
+	history, err := h.Storage.GetDailyUnitSales(productID, c.Query("filial_id"), start.AddDate(0, 0, -params.History), params.History)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao obter o histórico de vendas."})
+		return
+	}
+
+	results := []forecast.Accuracy{}
+	for _, m := range methods {
+		acc, err := forecast.Backtest(history, start, params.Horizon, params.History/2, m, forecast.Options{})
+		if errors.Is(err, forecast.ErrNotEnoughHistory) {
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		results = append(results, acc)
+	}
+	if len(results) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Histórico insuficiente para o backtest; aumente 'historico' ou reduza 'dias'."})
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
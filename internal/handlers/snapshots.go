@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetStockValueAt devolve o valor do stock por filial e categoria numa data passada
+// (parâmetro "data" no formato AAAA-MM-DD; por omissão, hoje).
+func (h *Handler) HandleGetStockValueAt(c *gin.Context) {
+	day := time.Now()
+	if dataStr := c.Query("data"); dataStr != "" {
+		parsed, err := time.Parse("2006-01-02", dataStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Data inválida. Use o formato AAAA-MM-DD."})
+			return
+		}
+		day = parsed
+	}
+
+	snapshots, err := h.Storage.GetStockValueAt(day)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Falha ao obter o valor do stock"})
+		return
+	}
+	var total float64
+	for _, snap := range snapshots {
+		total += snap.Valor
+	}
+	c.JSON(http.StatusOK, gin.H{"data": day.Format("2006-01-02"), "total": total, "itens": snapshots})
+}
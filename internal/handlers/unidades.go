@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/units"
+)
+
+// unitsFromForm lê e valida as unidades de medida de um formulário de produto.
+func unitsFromForm(get func(string) string) (models.UnitsOfMeasure, error) {
+	factor, err := units.ParseFactor(get("fator_compra"))
+	if err != nil {
+		return models.UnitsOfMeasure{}, err
+	}
+	u := models.UnitsOfMeasure{Unidade: get("unidade"), UnidadeCompra: get("unidade_compra"), FatorCompra: factor}
+	return u, units.Validate(&u)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"projeto-vendas/internal/models"
+)
+
+// maxVariantAttributes é o número de pares atributo/valor mostrados nos formulários.
+const maxVariantAttributes = 3
+
+// variantFromForm lê os atributos (atributo_nome/atributo_valor, pela ordem do
+// formulário), o código de barras e o preço de uma variante; storedBarcode é o código já
+// gravado, vazio numa variante nova.
+func (h *Handler) variantFromForm(c *gin.Context, storedBarcode string) (models.Product, error) {
+	names := c.PostFormArray("atributo_nome")
+	values := c.PostFormArray("atributo_valor")
+	var attrs []models.VariantAttribute
+	for i := range values {
+		if i < len(names) {
+			attrs = append(attrs, models.VariantAttribute{Nome: names[i], Valor: values[i]})
+		}
+	}
+	price, _ := strconv.ParseFloat(c.PostForm("preco"), 64)
+	barcode, err := h.barcodeFromForm(c.PostForm("barcode"), storedBarcode)
+	return models.Product{CodigoBarras: barcode, PrecoSugerido: price, Atributos: attrs}, err
+}
+
+// ShowVariantsPage lista as variantes de um produto, com o stock de cada uma, e os
+// formulários para as criar e alterar.
+func (h *Handler) ShowVariantsPage(c *gin.Context) {
+	session := sessions.Default(c)
+	product, err := h.Storage.GetProductByID(c.Param("id"))
+	if err != nil || product == nil {
+		session.AddFlash("Produto não encontrado.", "error")
+		session.Save()
+		c.Redirect(http.StatusFound, "/admin/dashboard")
+		return
+	}
+	if product.ProdutoPaiID != nil {
+		// Só há um nível de variantes: abre o produto pai.
+		c.Redirect(http.StatusFound, "/admin/products/variantes/"+product.ProdutoPaiID.String())
+		return
+	}
+	variants, err := h.Storage.GetVariants(product.ID.String())
+	if err != nil {
+		log.Printf("Erro ao listar variantes: %v", err)
+	}
+	// Os nomes dos atributos da primeira variante servem de sugestão para a seguinte.
+	attrNames := make([]string, maxVariantAttributes)
+	if len(variants) > 0 {
+		for i, a := range variants[0].Atributos {
+			if i < maxVariantAttributes {
+				attrNames[i] = a.Nome
+			}
+		}
+	}
+	totalStock := 0
+	for _, v := range variants {
+		totalStock += v.TotalEstoque
+	}
+
+	data := getFlashes(c)
+	data["title"] = "Variantes de " + product.Nome
+	data["product"] = product
+	data["variants"] = variants
+	data["AttrNames"] = attrNames
+	data["TotalStock"] = totalStock
+	data["UserRole"] = session.Get("userRole")
+	data["UserName"] = session.Get("userName")
+	data["ActivePage"] = "dashboard"
+	c.HTML(http.StatusOK, "produto_variantes.html", data)
+}
+
+// HandleAddVariant cria uma variante do produto indicado na rota.
+func (h *Handler) HandleAddVariant(c *gin.Context) {
+	session := sessions.Default(c)
+	parentID := c.Param("id")
+	variant, err := h.variantFromForm(c, "")
+	if err == nil {
+		_, err = h.Storage.AddVariant(parentID, variant)
+	}
+	if err != nil {
+		log.Printf("Erro ao adicionar variante: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao adicionar variante: %v", err), "error")
+	} else {
+		session.AddFlash("Variante adicionada com sucesso!", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/products/variantes/"+parentID)
+}
+
+// HandleEditVariant altera os atributos, o código de barras e o preço de uma variante.
+func (h *Handler) HandleEditVariant(c *gin.Context) {
+	session := sessions.Default(c)
+	userID, _ := session.Get("userID").(string)
+	variant, err := h.variantFromForm(c, h.storedBarcode(c.Param("id")))
+	if err == nil {
+		err = h.Storage.UpdateVariant(c.Param("id"), variant, userID)
+	}
+	if err != nil {
+		log.Printf("Erro ao atualizar variante: %v", err)
+		session.AddFlash(fmt.Sprintf("Falha ao atualizar variante: %v", err), "error")
+	} else {
+		session.AddFlash("Variante atualizada com sucesso!", "success")
+	}
+	session.Save()
+	c.Redirect(http.StatusFound, "/admin/products/variantes/"+c.PostForm("produto_pai_id"))
+}
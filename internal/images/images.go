@@ -0,0 +1,143 @@
+// Package images valida e prepara as imagens dos produtos: aceita JPEG, PNG e GIF, reduz a
+// imagem para um tamanho máximo e gera a miniatura usada no terminal e nas tabelas. As
+// imagens são gravadas através de Store, que hoje é o disco local (LocalStore).
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // registo do descodificador GIF
+	"image/jpeg"
+	_ "image/png" // registo do descodificador PNG
+	"net/http"
+)
+
+const (
+	// MaxUploadSize é o tamanho máximo do ficheiro enviado.
+	MaxUploadSize = 5 << 20
+	// maxPixels protege contra imagens pequenas em bytes mas enormes depois de descodificadas.
+	maxPixels = 40_000_000
+	// FullSize e ThumbSize são o lado maior, em pixels, da imagem gravada e da miniatura.
+	FullSize  = 1200
+	ThumbSize = 200
+
+	jpegQuality = 85
+)
+
+var (
+	ErrTooLarge        = fmt.Errorf("a imagem excede o limite de %d MB", MaxUploadSize>>20)
+	ErrUnsupportedType = errors.New("formato de imagem não suportado (use JPEG, PNG ou GIF)")
+	ErrTooManyPixels   = errors.New("a imagem tem demasiados pixels")
+)
+
+// allowedTypes são os tipos aceites, identificados pelo conteúdo e não pela extensão.
+var allowedTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// Processed é uma imagem pronta a gravar: a imagem reduzida e a miniatura, ambas em JPEG.
+type Processed struct {
+	Full  []byte
+	Thumb []byte
+}
+
+// Process valida o tipo e o tamanho da imagem enviada e gera as duas versões a gravar. A
+// imagem é sempre descodificada e codificada de novo, o que descarta metadados (EXIF) e
+// qualquer conteúdo que não seja a imagem. As transparências ficam sobre fundo branco.
+func Process(data []byte) (Processed, error) {
+	if len(data) > MaxUploadSize {
+		return Processed{}, ErrTooLarge
+	}
+	if !allowedTypes[http.DetectContentType(data)] {
+		return Processed{}, ErrUnsupportedType
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Processed{}, ErrUnsupportedType
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return Processed{}, ErrTooManyPixels
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Processed{}, fmt.Errorf("imagem inválida: %w", err)
+	}
+
+	full := fit(src, FullSize)
+	var p Processed
+	if p.Full, err = encodeJPEG(full); err != nil {
+		return Processed{}, err
+	}
+	if p.Thumb, err = encodeJPEG(fit(full, ThumbSize)); err != nil {
+		return Processed{}, err
+	}
+	return p, nil
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fit devolve a imagem sobre fundo branco, reduzida para o lado maior não passar de max
+// pixels. Cada pixel de destino é a média dos pixels de origem que cobre (box filter), o
+// que dá bom resultado na redução sem depender de bibliotecas externas.
+func fit(src image.Image, max int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > max || h > max {
+		if w >= h {
+			w, h = max, b.Dy()*max/b.Dx()
+		} else {
+			w, h = b.Dx()*max/b.Dy(), max
+		}
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+	}
+
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, b.Min, draw.Over)
+	if w == b.Dx() && h == b.Dy() {
+		return flat
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*b.Dy()/h, (y+1)*b.Dy()/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x*b.Dx()/w, (x+1)*b.Dx()/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, n uint32
+			for sy := y0; sy < y1; sy++ {
+				row := flat.Pix[sy*flat.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(row[sx*4])
+					g += uint32(row[sx*4+1])
+					bl += uint32(row[sx*4+2])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(bl / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
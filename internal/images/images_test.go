@@ -0,0 +1,115 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func pngImage(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeSize(t *testing.T, data []byte) image.Point {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Esperava um JPEG válido: %v", err)
+	}
+	return img.Bounds().Size()
+}
+
+func TestProcessResizesAndKeepsAspectRatio(t *testing.T) {
+	p, err := Process(pngImage(t, 2400, 1200))
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if got := decodeSize(t, p.Full); got != (image.Point{X: FullSize, Y: 600}) {
+		t.Errorf("Tamanho da imagem inesperado: %v", got)
+	}
+	if got := decodeSize(t, p.Thumb); got != (image.Point{X: ThumbSize, Y: 100}) {
+		t.Errorf("Tamanho da miniatura inesperado: %v", got)
+	}
+}
+
+func TestProcessKeepsSmallImages(t *testing.T) {
+	p, err := Process(pngImage(t, 150, 300))
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if got := decodeSize(t, p.Full); got != (image.Point{X: 150, Y: 300}) {
+		t.Errorf("Uma imagem pequena não devia mudar de tamanho, obteve %v", got)
+	}
+	if got := decodeSize(t, p.Thumb); got != (image.Point{X: 100, Y: ThumbSize}) {
+		t.Errorf("Tamanho da miniatura inesperado: %v", got)
+	}
+}
+
+func TestProcessRejectsInvalidFiles(t *testing.T) {
+	cases := map[string]struct {
+		data []byte
+		want error
+	}{
+		"texto":            {[]byte("isto não é uma imagem"), ErrUnsupportedType},
+		"html disfarçado":  {[]byte("<html><script>alert(1)</script></html>"), ErrUnsupportedType},
+		"PNG truncado":     {pngImage(t, 10, 10)[:30], ErrUnsupportedType},
+		"demasiado grande": {append(pngImage(t, 10, 10), make([]byte, MaxUploadSize)...), ErrTooLarge},
+	}
+	for name, tc := range cases {
+		if _, err := Process(tc.data); err != tc.want {
+			t.Errorf("%s: esperava %v, obteve %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "imagens")
+	store, err := NewLocalStore(dir, "/imagens/produtos/")
+	if err != nil {
+		t.Fatalf("Erro ao criar a pasta: %v", err)
+	}
+	full, thumb := FileNames("abc-123")
+	if full != "abc-123.jpg" || thumb != "abc-123_mini.jpg" {
+		t.Errorf("Nomes inesperados: %s, %s", full, thumb)
+	}
+	if err := store.Save(full, []byte("dados")); err != nil {
+		t.Fatalf("Erro ao gravar: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, full)); err != nil || string(data) != "dados" {
+		t.Errorf("Conteúdo gravado inesperado: %q (%v)", data, err)
+	}
+	if got := store.URL(full); got != "/imagens/produtos/abc-123.jpg" {
+		t.Errorf("URL inesperado: %s", got)
+	}
+	for _, name := range []string{"../fora.jpg", "sub/dir.jpg", ".oculto", ""} {
+		if err := store.Save(name, []byte("x")); err == nil {
+			t.Errorf("Esperava erro ao gravar %q", name)
+		}
+	}
+	if err := store.Delete(full); err != nil {
+		t.Errorf("Erro ao apagar: %v", err)
+	}
+	if err := store.Delete(full); err != nil {
+		t.Errorf("Apagar uma imagem que já não existe não devia falhar: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Esperava a pasta vazia, tem %d ficheiros", len(entries))
+	}
+}
@@ -0,0 +1,86 @@
+package images
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Store grava e serve as imagens pelo nome. A aplicação só conhece esta interface, para que
+// o disco local possa ser trocado por um armazenamento de objetos (S3, GCS) sem mudar os
+// handlers: bastará outra implementação cujo URL aponte para o bucket ou a CDN.
+type Store interface {
+	Save(name string, data []byte) error
+	Delete(name string) error
+	URL(name string) string
+}
+
+// FileNames devolve os nomes da imagem e da miniatura de uma chave, que é o que fica gravado
+// no produto.
+func FileNames(key string) (full, thumb string) {
+	return key + ".jpg", key + "_mini.jpg"
+}
+
+// LocalStore grava as imagens numa pasta do disco, servida pela aplicação em BaseURL.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocalStore cria a pasta, se ainda não existir.
+func NewLocalStore(dir, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("não foi possível criar a pasta das imagens %s: %w", dir, err)
+	}
+	return &LocalStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// validName impede nomes que saiam da pasta das imagens.
+func validName(name string) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return errors.New("nome de imagem inválido")
+	}
+	return nil
+}
+
+// Save grava a imagem num ficheiro temporário e renomeia-o, para que nunca seja servido um
+// ficheiro a meio da escrita.
+func (s *LocalStore) Save(name string, data []byte) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+}
+
+// Delete apaga a imagem; uma imagem que já não existe não é erro.
+func (s *LocalStore) Delete(name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.Dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) URL(name string) string {
+	return path.Join(s.BaseURL, name)
+}
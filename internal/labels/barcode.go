@@ -0,0 +1,239 @@
+// Package labels gera etiquetas de produtos: códigos de barras EAN-13 e Code 128,
+// imagens PNG, ZPL para impressoras térmicas e folhas PDF para papel de etiquetas A4.
+package labels
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"projeto-vendas/internal/gtin"
+)
+
+// Symbology identifica o tipo de código de barras.
+type Symbology string
+
+const (
+	EAN13   Symbology = "ean13"
+	Code128 Symbology = "code128"
+)
+
+// quietZone é a margem em branco, em módulos, de cada lado do código.
+const quietZone = 10
+
+// Limites das dimensões aceites por Barcode.PNG, em píxeis: chegam para qualquer impressão
+// e impedem que um pedido obrigue a alocar uma imagem enorme.
+const (
+	MinModuleWidth = 1
+	MaxModuleWidth = 10
+	MinHeight      = 10
+	MaxHeight      = 500
+)
+
+// ErrEmptyCode é devolvido quando o produto não tem código de barras.
+var ErrEmptyCode = errors.New("código de barras vazio")
+
+// Barcode é um código codificado: Modules tem um elemento por módulo, true para barra.
+type Barcode struct {
+	Symbology Symbology
+	Text      string // texto legível impresso por baixo das barras
+	Modules   []bool
+}
+
+// Encode escolhe a simbologia pelo conteúdo: EAN-13 para 13 dígitos com dígito de
+// controlo válido (ou UPC-A de 12 dígitos, que é um EAN-13 começado por 0) e Code 128
+// para tudo o resto.
+func Encode(code string) (Barcode, error) {
+	if code == "" {
+		return Barcode{}, ErrEmptyCode
+	}
+	if isDigits(code) {
+		switch {
+		case len(code) == 13 && ean13CheckDigit(code[:12]) == code[12]:
+			return EncodeEAN13(code)
+		case len(code) == 12 && ean13CheckDigit("0"+code[:11]) == code[11]:
+			return EncodeEAN13("0" + code)
+		}
+	}
+	return EncodeCode128(code)
+}
+
+var (
+	eanL = [10]string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	eanG = [10]string{"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"}
+	eanR = [10]string{"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"}
+	// eanParity indica, para o primeiro dígito, que dígitos da metade esquerda usam o conjunto G.
+	eanParity = [10]string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+)
+
+// EncodeEAN13 codifica 12 dígitos (o dígito de controlo é calculado) ou 13 dígitos
+// com dígito de controlo válido.
+func EncodeEAN13(code string) (Barcode, error) {
+	if !isDigits(code) || (len(code) != 12 && len(code) != 13) {
+		return Barcode{}, fmt.Errorf("EAN-13 requer 12 ou 13 dígitos: %q", code)
+	}
+	check := ean13CheckDigit(code[:12])
+	if len(code) == 13 && code[12] != check {
+		return Barcode{}, fmt.Errorf("dígito de controlo EAN-13 inválido em %q (esperado %c)", code, check)
+	}
+	code = code[:12] + string(check)
+
+	pattern := "101"
+	parity := eanParity[code[0]-'0']
+	for i := 1; i <= 6; i++ {
+		d := code[i] - '0'
+		if parity[i-1] == 'G' {
+			pattern += eanG[d]
+		} else {
+			pattern += eanL[d]
+		}
+	}
+	pattern += "01010"
+	for i := 7; i <= 12; i++ {
+		pattern += eanR[code[i]-'0']
+	}
+	pattern += "101"
+	return Barcode{Symbology: EAN13, Text: code, Modules: patternModules(pattern)}, nil
+}
+
+// ean13CheckDigit calcula o dígito de controlo dos primeiros 12 dígitos de um EAN-13.
+func ean13CheckDigit(first12 string) byte {
+	return gtin.CheckDigit(first12[:12])
+}
+
+// code128Widths são as larguras (barra, espaço, ...) dos símbolos 0 a 106 do Code 128.
+var code128Widths = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128CodeC  = 99
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// EncodeCode128 codifica texto ASCII imprimível. Sequências numéricas usam o conjunto C
+// (dois dígitos por símbolo), o que encurta os códigos internos só com dígitos.
+func EncodeCode128(text string) (Barcode, error) {
+	if text == "" {
+		return Barcode{}, ErrEmptyCode
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] < 32 || text[i] > 126 {
+			return Barcode{}, fmt.Errorf("o Code 128 só aceita ASCII imprimível: %q", text)
+		}
+	}
+
+	var values []int
+	if isDigits(text) && len(text) >= 2 {
+		rest := text
+		if len(text)%2 == 1 {
+			// O primeiro dígito vai no conjunto B e os restantes aos pares no C.
+			values = append(values, code128StartB, int(text[0]-32), code128CodeC)
+			rest = text[1:]
+		} else {
+			values = append(values, code128StartC)
+		}
+		for i := 0; i < len(rest); i += 2 {
+			values = append(values, int(rest[i]-'0')*10+int(rest[i+1]-'0'))
+		}
+	} else {
+		values = append(values, code128StartB)
+		for i := 0; i < len(text); i++ {
+			values = append(values, int(text[i]-32))
+		}
+	}
+
+	checksum := values[0]
+	for i := 1; i < len(values); i++ {
+		checksum += values[i] * i
+	}
+	values = append(values, checksum%103, code128Stop)
+
+	var modules []bool
+	for _, v := range values {
+		for i, w := range code128Widths[v] {
+			for n := 0; n < int(w-'0'); n++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	return Barcode{Symbology: Code128, Text: text, Modules: modules}, nil
+}
+
+// PNG escreve o código como imagem PNG, com `moduleWidth` píxeis por módulo, `height`
+// píxeis de altura e a margem em branco obrigatória de cada lado. As dimensões têm de
+// estar entre MinModuleWidth e MaxModuleWidth e entre MinHeight e MaxHeight.
+func (b Barcode) PNG(w io.Writer, moduleWidth, height int) error {
+	if moduleWidth < MinModuleWidth || moduleWidth > MaxModuleWidth || height < MinHeight || height > MaxHeight {
+		return fmt.Errorf("dimensões inválidas: módulo %d (de %d a %d), altura %d (de %d a %d)",
+			moduleWidth, MinModuleWidth, MaxModuleWidth, height, MinHeight, MaxHeight)
+	}
+	width := (len(b.Modules) + 2*quietZone) * moduleWidth
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for i, bar := range b.Modules {
+		if !bar {
+			continue
+		}
+		x0 := (quietZone + i) * moduleWidth
+		for x := x0; x < x0+moduleWidth; x++ {
+			for y := 0; y < height; y++ {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// bars devolve as barras como pares (início, largura) em módulos, juntando módulos seguidos.
+func (b Barcode) bars() [][2]int {
+	var runs [][2]int
+	for i := 0; i < len(b.Modules); i++ {
+		if !b.Modules[i] {
+			continue
+		}
+		start := i
+		for i+1 < len(b.Modules) && b.Modules[i+1] {
+			i++
+		}
+		runs = append(runs, [2]int{start, i - start + 1})
+	}
+	return runs
+}
+
+func patternModules(pattern string) []bool {
+	modules := make([]bool, len(pattern))
+	for i := range pattern {
+		modules[i] = pattern[i] == '1'
+	}
+	return modules
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
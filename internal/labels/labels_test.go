@@ -0,0 +1,215 @@
+package labels
+
+import (
+	"bytes"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func modulesString(b Barcode) string {
+	var s strings.Builder
+	for _, m := range b.Modules {
+		if m {
+			s.WriteByte('1')
+		} else {
+			s.WriteByte('0')
+		}
+	}
+	return s.String()
+}
+
+func TestEncodeEAN13(t *testing.T) {
+	b, err := Encode("4006381333931")
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if b.Symbology != EAN13 || len(b.Modules) != 95 {
+		t.Fatalf("Esperava um EAN-13 de 95 módulos, obteve %s com %d", b.Symbology, len(b.Modules))
+	}
+	s := modulesString(b)
+	// Guardas, primeiro dígito da esquerda (0 no conjunto L, pois o 4 começa por L) e último (1 no conjunto R).
+	if s[:3] != "101" || s[45:50] != "01010" || s[92:] != "101" {
+		t.Errorf("Guardas erradas: %s", s)
+	}
+	if s[3:10] != eanL[0] || s[85:92] != eanR[1] {
+		t.Errorf("Codificação dos dígitos errada: %s", s)
+	}
+
+	if _, err := EncodeEAN13("4006381333932"); err == nil {
+		t.Error("Esperava erro com dígito de controlo inválido")
+	}
+	if b, _ := EncodeEAN13("400638133393"); b.Text != "4006381333931" {
+		t.Errorf("Esperava o dígito de controlo calculado, obteve %q", b.Text)
+	}
+	if b, _ := Encode("036000291452"); b.Symbology != EAN13 || b.Text != "0036000291452" {
+		t.Errorf("Esperava o UPC-A codificado como EAN-13, obteve %s %q", b.Symbology, b.Text)
+	}
+	if b, _ := Encode("4006381333932"); b.Symbology != Code128 {
+		t.Errorf("Um EAN com controlo inválido devia cair no Code 128, obteve %s", b.Symbology)
+	}
+}
+
+// decodeCode128 lê as larguras dos módulos e devolve os valores dos símbolos.
+func decodeCode128(t *testing.T, b Barcode) []int {
+	t.Helper()
+	lookup := make(map[string]int)
+	for v, w := range code128Widths {
+		lookup[w] = v
+	}
+	var widths []byte
+	for i := 0; i < len(b.Modules); {
+		j := i
+		for j < len(b.Modules) && b.Modules[j] == b.Modules[i] {
+			j++
+		}
+		widths = append(widths, byte('0'+j-i))
+		i = j
+	}
+	var values []int
+	for i := 0; i+6 <= len(widths); i += 6 {
+		chunk := string(widths[i : i+6])
+		if i+7 == len(widths) {
+			chunk = string(widths[i:])
+		}
+		v, ok := lookup[chunk]
+		if !ok {
+			t.Fatalf("Símbolo desconhecido %q na posição %d", chunk, i)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestEncodeCode128(t *testing.T) {
+	for v, w := range code128Widths {
+		sum := 0
+		for _, c := range w {
+			sum += int(c - '0')
+		}
+		if (v < code128Stop && sum != 11) || (v == code128Stop && sum != 13) {
+			t.Fatalf("Larguras do símbolo %d somam %d", v, sum)
+		}
+	}
+
+	tests := []struct {
+		text  string
+		start int
+		count int // símbolos de dados
+	}{
+		{"ABC-12", code128StartB, 6},
+		{"123456", code128StartC, 3},
+		{"12345", code128StartB, 4}, // "1", Code C, "23", "45"
+	}
+	for _, tt := range tests {
+		b, err := EncodeCode128(tt.text)
+		if err != nil {
+			t.Fatalf("%q: erro inesperado: %v", tt.text, err)
+		}
+		values := decodeCode128(t, b)
+		if values[0] != tt.start || values[len(values)-1] != code128Stop {
+			t.Errorf("%q: início ou fim errados: %v", tt.text, values)
+		}
+		if got := len(values) - 3; got != tt.count {
+			t.Errorf("%q: esperava %d símbolos de dados, obteve %d", tt.text, tt.count, got)
+		}
+		sum := values[0]
+		for i := 1; i < len(values)-2; i++ {
+			sum += values[i] * i
+		}
+		if sum%103 != values[len(values)-2] {
+			t.Errorf("%q: soma de controlo errada: %v", tt.text, values)
+		}
+	}
+	if _, err := EncodeCode128("café"); err == nil {
+		t.Error("Esperava erro com caracteres fora do ASCII")
+	}
+}
+
+func TestBarcodePNG(t *testing.T) {
+	b, _ := Encode("4006381333931")
+	var buf bytes.Buffer
+	if err := b.PNG(&buf, 2, 50); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("PNG inválido: %v", err)
+	}
+	if w := img.Bounds().Dx(); w != (95+2*quietZone)*2 {
+		t.Errorf("Largura inesperada: %d", w)
+	}
+	if r, _, _, _ := img.At(quietZone*2, 10).RGBA(); r != 0 {
+		t.Error("Esperava uma barra preta no início do código")
+	}
+	for _, dims := range [][2]int{{0, 50}, {MaxModuleWidth + 1, 50}, {2, MinHeight - 1}, {2, MaxHeight + 1}, {100000, 100000}} {
+		if err := b.PNG(&bytes.Buffer{}, dims[0], dims[1]); err == nil {
+			t.Errorf("Esperava erro com o módulo %d e a altura %d", dims[0], dims[1])
+		}
+	}
+}
+
+func TestZPL(t *testing.T) {
+	var buf bytes.Buffer
+	labels := []Label{
+		{Nome: "Arroz ^Tio~ 5kg", CodigoBarras: "4006381333931", Preco: 1234.5, Copias: 3},
+		{Nome: "Granel", CodigoBarras: "INT-0001", Preco: 2},
+	}
+	if err := ZPL(&buf, labels, ShelfTag); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	out := buf.String()
+	if strings.Count(out, "^XA") != 2 || strings.Count(out, "^XZ") != 2 {
+		t.Errorf("Esperava 2 etiquetas:\n%s", out)
+	}
+	for _, want := range []string{"Arroz _5ETio_7E 5kg", "R$ 1.234,50", "^BEN,50,Y,N^FD400638133393^FS", "^PQ3^XZ", "^BCN,50,Y,N,N^FH^FDINT-0001^FS", "^PQ1^XZ"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Esperava %q na saída:\n%s", want, out)
+		}
+	}
+}
+
+func TestPDF(t *testing.T) {
+	labels := []Label{{Nome: "Feijão (preto)", CodigoBarras: "4006381333931", Preco: 8.9, Copias: 30}}
+	var buf bytes.Buffer
+	if err := PDF(&buf, labels, ShelfTag, A4Sheet24); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "%PDF-1.4") || !strings.HasSuffix(out, "%%EOF\n") {
+		t.Fatal("Cabeçalho ou fim do PDF em falta")
+	}
+	if !strings.Contains(out, "/Count 2") {
+		t.Error("30 etiquetas numa folha de 24 deviam ocupar 2 páginas")
+	}
+	if strings.Count(out, "R$ 8,90") != 30 {
+		t.Errorf("Esperava 30 preços, obteve %d", strings.Count(out, "R$ 8,90"))
+	}
+	if !strings.Contains(out, "(Feij\xe3o \\(preto\\))") {
+		t.Error("Esperava o nome em WinAnsi com os parênteses escapados")
+	}
+
+	// Cada entrada da tabela xref tem de apontar para o início do objeto correspondente.
+	m := regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(out)
+	xref, _ := strconv.Atoi(m[1])
+	entries := strings.Split(out[xref:], "\n")[3:]
+	for i := 1; ; i++ {
+		off, err := strconv.Atoi(strings.TrimSpace(strings.Fields(entries[i-1])[0]))
+		if err != nil || strings.HasPrefix(entries[i-1], "trailer") {
+			break
+		}
+		if !strings.HasPrefix(out[off:], strconv.Itoa(i)+" 0 obj") {
+			t.Errorf("Entrada xref %d aponta para %q", i, out[off:off+10])
+		}
+	}
+}
+
+func TestFormatPrice(t *testing.T) {
+	for v, want := range map[float64]string{0: "R$ 0,00", 8.9: "R$ 8,90", 1234567.891: "R$ 1.234.567,89", 999: "R$ 999,00"} {
+		if got := FormatPrice(v); got != want {
+			t.Errorf("FormatPrice(%v) = %q, esperava %q", v, got, want)
+		}
+	}
+}
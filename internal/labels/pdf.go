@@ -0,0 +1,180 @@
+package labels
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Sheet descreve uma folha de etiquetas autocolantes; as medidas estão em milímetros.
+type Sheet struct {
+	Colunas, Linhas           int
+	Largura, Altura           float64 // de cada etiqueta
+	MargemEsquerda, MargemTop float64
+	EspacoH, EspacoV          float64 // entre etiquetas vizinhas
+}
+
+// A4Sheet24 é a folha A4 mais comum nas papelarias: 3 x 8 etiquetas de 70 x 37 mm.
+var A4Sheet24 = Sheet{Colunas: 3, Linhas: 8, Largura: 70, Altura: 37, MargemTop: 0.5}
+
+const (
+	a4Width  = 595.28 // pontos
+	a4Height = 841.89
+	ptPerMM  = 72 / 25.4
+	// labelPadding é a margem interior de cada etiqueta, em mm.
+	labelPadding = 2.5
+)
+
+// PDF escreve as etiquetas numa ou mais folhas A4 com a disposição indicada, cada
+// etiqueta repetida pelo número de cópias. Usa só as fontes base do PDF (Helvetica),
+// por isso os textos ficam limitados ao conjunto WinAnsi (Latin-1).
+func PDF(w io.Writer, labels []Label, kind Kind, sheet Sheet) error {
+	perPage := sheet.Colunas * sheet.Linhas
+	if perPage <= 0 || sheet.Largura <= 0 || sheet.Altura <= 0 {
+		return fmt.Errorf("disposição de folha inválida: %+v", sheet)
+	}
+
+	var expanded []Label
+	for _, l := range labels {
+		for i := 0; i < l.copies(); i++ {
+			expanded = append(expanded, l)
+		}
+	}
+	var pages []string
+	for start := 0; start < len(expanded) || start == 0; start += perPage {
+		end := start + perPage
+		if end > len(expanded) {
+			end = len(expanded)
+		}
+		var content strings.Builder
+		for i, l := range expanded[start:end] {
+			col, row := i%sheet.Colunas, i/sheet.Colunas
+			x := sheet.MargemEsquerda + float64(col)*(sheet.Largura+sheet.EspacoH)
+			y := sheet.MargemTop + float64(row)*(sheet.Altura+sheet.EspacoV)
+			drawLabel(&content, l, kind, x, y, sheet.Largura, sheet.Altura)
+		}
+		pages = append(pages, content.String())
+	}
+	return writePDF(w, pages)
+}
+
+// drawLabel desenha uma etiqueta com o canto superior esquerdo em (x, y) mm, medidos
+// a partir do topo da folha.
+func drawLabel(b *strings.Builder, l Label, kind Kind, x, y, width, height float64) {
+	inner := width - 2*labelPadding
+	barcode, barErr := Encode(l.CodigoBarras)
+	switch kind {
+	case BarcodeLabel:
+		nameSize := 8.0
+		drawText(b, fitText(l.Nome, inner, nameSize), "F1", nameSize, x+width/2, y+labelPadding+3, true)
+		if barErr == nil {
+			barHeight := height - 2*labelPadding - 11
+			drawBarcode(b, barcode, x+labelPadding, y+labelPadding+5, inner, barHeight)
+			drawText(b, barcode.Text, "F1", 7, x+width/2, y+height-labelPadding-1, true)
+		}
+	default:
+		nameSize := 9.0
+		drawText(b, fitText(l.Nome, inner, nameSize), "F1", nameSize, x+labelPadding, y+labelPadding+3.5, false)
+		priceSize := 22.0
+		drawText(b, FormatPrice(l.Preco), "F2", priceSize, x+width/2, y+height/2+3, true)
+		if barErr == nil {
+			drawBarcode(b, barcode, x+labelPadding, y+height-labelPadding-9, inner*0.6, 6)
+			drawText(b, barcode.Text, "F1", 6, x+labelPadding+inner*0.3, y+height-labelPadding-0.5, true)
+		}
+	}
+}
+
+// drawBarcode desenha as barras centradas na largura disponível, com a margem em branco
+// incluída no cálculo do módulo (limitado a 0,5 mm).
+func drawBarcode(b *strings.Builder, code Barcode, x, y, width, height float64) {
+	module := width / float64(len(code.Modules)+2*quietZone)
+	if module > 0.5 {
+		module = 0.5
+	}
+	left := x + (width-module*float64(len(code.Modules)))/2
+	for _, bar := range code.bars() {
+		bx := left + float64(bar[0])*module
+		fmt.Fprintf(b, "%.2f %.2f %.2f %.2f re\n", bx*ptPerMM, a4Height-(y+height)*ptPerMM, float64(bar[1])*module*ptPerMM, height*ptPerMM)
+	}
+	b.WriteString("f\n")
+}
+
+// drawText escreve uma linha com a base em y mm; centered alinha o centro do texto a x.
+func drawText(b *strings.Builder, text, font string, size, x, y float64, centered bool) {
+	if text == "" {
+		return
+	}
+	if centered {
+		x -= textWidth(text, size) / 2
+	}
+	fmt.Fprintf(b, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x*ptPerMM, a4Height-y*ptPerMM, pdfString(text))
+}
+
+// textWidth estima a largura em mm de um texto em Helvetica (cerca de 0,55 em por carácter).
+func textWidth(text string, size float64) float64 {
+	return float64(utf8.RuneCountInString(text)) * size * 0.55 / ptPerMM
+}
+
+// fitText corta o texto, com reticências, para caber na largura indicada.
+func fitText(text string, width, size float64) string {
+	limit := int(width * ptPerMM / (size * 0.55))
+	runes := []rune(text)
+	if len(runes) <= limit || limit < 4 {
+		return text
+	}
+	return string(runes[:limit-3]) + "..."
+}
+
+// pdfString converte para WinAnsi e escapa os delimitadores de strings do PDF.
+func pdfString(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r >= 32 && r < 127, r >= 0xA0 && r <= 0xFF:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// writePDF monta um PDF mínimo com uma página A4 por conteúdo e as fontes Helvetica
+// e Helvetica-Bold.
+func writePDF(w io.Writer, pages []string) error {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			a4Width, a4Height, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,111 @@
+package labels
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kind é o modelo de etiqueta.
+type Kind string
+
+const (
+	// BarcodeLabel é a etiqueta de produto: nome, código de barras e dígitos.
+	BarcodeLabel Kind = "codigo"
+	// ShelfTag é a etiqueta de preço de prateleira: nome, preço em destaque e um código pequeno.
+	ShelfTag Kind = "preco"
+)
+
+// ParseKind converte o valor do pedido; vazio é a etiqueta de preço.
+func ParseKind(s string) (Kind, error) {
+	switch Kind(s) {
+	case "", ShelfTag:
+		return ShelfTag, nil
+	case BarcodeLabel:
+		return BarcodeLabel, nil
+	}
+	return "", fmt.Errorf("modelo de etiqueta desconhecido: %q", s)
+}
+
+// Label é o conteúdo de uma etiqueta.
+type Label struct {
+	Nome         string
+	CodigoBarras string
+	Preco        float64
+	Copias       int // 0 conta como 1
+}
+
+func (l Label) copies() int {
+	if l.Copias < 1 {
+		return 1
+	}
+	return l.Copias
+}
+
+// Dimensões da etiqueta térmica ZPL: 50 x 30 mm a 203 dpi (8 pontos por mm).
+const (
+	zplWidth  = 400
+	zplHeight = 240
+)
+
+// ZPL escreve uma etiqueta ZPL II por produto, com ^PQ para as cópias. Os textos vão em
+// UTF-8 (^CI28) e os campos com ^FH, para que '^' e '~' no nome não sejam comandos.
+func ZPL(w io.Writer, labels []Label, kind Kind) error {
+	bw := bufio.NewWriter(w)
+	for _, l := range labels {
+		fmt.Fprintf(bw, "^XA^CI28^PW%d^LL%d\n", zplWidth, zplHeight)
+		switch kind {
+		case BarcodeLabel:
+			fmt.Fprintf(bw, "^FO20,15^A0N,26,26^FB360,2,0,C^FH^FD%s^FS\n", zplField(l.Nome))
+			writeZPLBarcode(bw, l.CodigoBarras, 80, 100)
+		default:
+			fmt.Fprintf(bw, "^FO20,12^A0N,24,24^FB360,2,0,L^FH^FD%s^FS\n", zplField(l.Nome))
+			fmt.Fprintf(bw, "^FO20,70^A0N,64,64^FB360,1,0,R^FH^FD%s^FS\n", zplField(FormatPrice(l.Preco)))
+			writeZPLBarcode(bw, l.CodigoBarras, 150, 50)
+		}
+		fmt.Fprintf(bw, "^PQ%d^XZ\n", l.copies())
+	}
+	return bw.Flush()
+}
+
+// writeZPLBarcode usa os comandos de código de barras da própria impressora (^BE para
+// EAN-13, ^BC para Code 128), que imprimem também os dígitos por baixo.
+func writeZPLBarcode(w io.Writer, code string, y, height int) {
+	b, err := Encode(code)
+	if err != nil {
+		return
+	}
+	if b.Symbology == EAN13 {
+		fmt.Fprintf(w, "^FO105,%d^BY2^BEN,%d,Y,N^FD%s^FS\n", y, height, b.Text[:12])
+		return
+	}
+	fmt.Fprintf(w, "^FO20,%d^BY2^BCN,%d,Y,N,N^FH^FD%s^FS\n", y, height, zplField(b.Text))
+}
+
+// zplField escapa os caracteres de controlo do ZPL em hexadecimal (usado com ^FH).
+func zplField(s string) string {
+	return strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E").Replace(s)
+}
+
+// FormatPrice formata um preço em reais: "R$ 1.234,50".
+func FormatPrice(v float64) string {
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	s := fmt.Sprintf("%.2f", v)
+	intPart, dec := s[:len(s)-3], s[len(s)-2:]
+	var b strings.Builder
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(r)
+	}
+	out := "R$ " + b.String() + "," + dec
+	if neg {
+		out = "-" + out
+	}
+	return out
+}
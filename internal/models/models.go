@@ -13,6 +13,7 @@ type User struct {
 	Cargo     string
 	SenhaHash string
 	FilialID  *uuid.UUID
+	Arquivado bool // arquivado: não entra no sistema nem aparece nas listas
 }
 
 // Product representa a estrutura de um produto no catálogo.
@@ -20,16 +21,88 @@ type Product struct {
 	ID                uuid.UUID
 	Nome              string
 	Descricao         string
-	Categoria       string // NOVO
+	CategoriaID       *uuid.UUID `json:"CategoriaID,omitempty"`
+	Categoria         string     // caminho completo da categoria, p.ex. "Mercearia > Bebidas"
 	CodigoBarras      string
 	CodigoCNAE        string `json:"CodigoCNAE,omitempty"`
 	PrecoCusto        float64 // NOVO
+	CustoMedio        float64 `json:"CustoMedio,omitempty"`
 	PercentualLucro   float64 // NOVO
 	ImpostoEstadual   float64 // NOVO
 	ImpostoFederal    float64 // NOVO
 	PrecoSugerido     float64
 	TotalEstoque      int
 	ValorTotalEstoque float64
+	// Variantes: ProdutoPaiID e Atributos só existem nas variantes; NumVariantes no produto pai.
+	ProdutoPaiID      *uuid.UUID         `json:"ProdutoPaiID,omitempty"`
+	NomePai           string             `json:"NomePai,omitempty"`
+	Atributos         []VariantAttribute `json:"Atributos,omitempty"`
+	NumVariantes      int                `json:"NumVariantes,omitempty"`
+	// PrecoVariante é o preço próprio da variante; nil segue o preço do produto pai.
+	PrecoVariante *float64 `json:"PrecoVariante,omitempty"`
+	// Unidades representadas pelo código pesquisado (12 no código de uma caixa de 12).
+	QuantidadeEmbalagem int `json:"QuantidadeEmbalagem,omitempty"`
+	// Arquivado: fora do terminal e das listas, mas mantido nas vendas e nos relatórios.
+	Arquivado bool `json:"Arquivado,omitempty"`
+	// Imagem é a chave gravada no produto; os URLs são preenchidos pelos handlers.
+	Imagem       string `json:"-"`
+	ImagemURL    string `json:"ImagemURL,omitempty"`
+	MiniaturaURL string `json:"MiniaturaURL,omitempty"`
+	// Kit: produto composto por outros produtos, sem stock próprio.
+	Kit bool `json:"Kit,omitempty"`
+	// Estratégia de preço própria; nil usa a da categoria.
+	EstrategiaPreco *PricingStrategy `json:"EstrategiaPreco,omitempty"`
+	// Classificação fiscal para as notas fiscais (os campos aparecem no nível do produto).
+	FiscalClassification
+	NCMDescricao string `json:"NCMDescricao,omitempty"` // descrição do NCM na tabela de referência, só nos detalhes
+	// Unidades de medida (os campos aparecem no nível do produto).
+	UnitsOfMeasure
+	// Fornecedores do produto, o preferencial primeiro; só nos detalhes.
+	Fornecedores []ProductSupplier `json:"Fornecedores,omitempty"`
+}
+
+// UnitsOfMeasure são as unidades de medida de um produto (ver o pacote units). O stock e as
+// vendas contam-se na unidade de venda; as compras podem vir na unidade de compra, que vale
+// FatorCompra unidades de venda (12 numa caixa de 12).
+type UnitsOfMeasure struct {
+	Unidade       string `json:"Unidade"`                 // unidade de venda e do stock: UN, CX, KG ou L
+	UnidadeCompra string `json:"UnidadeCompra,omitempty"` // vazio: comprado na unidade de venda
+	FatorCompra   int    `json:"FatorCompra"`             // unidades de venda por unidade de compra
+}
+
+// FiscalClassification é a classificação fiscal de um produto (ver o pacote fiscal). Os
+// códigos são gravados só com dígitos; vazio é "não preenchido".
+type FiscalClassification struct {
+	NCM       string `json:"NCM,omitempty"`       // Nomenclatura Comum do Mercosul, 8 dígitos
+	CEST      string `json:"CEST,omitempty"`      // Código Especificador da Substituição Tributária, 7 dígitos
+	CFOP      string `json:"CFOP,omitempty"`      // CFOP padrão das vendas, 4 dígitos
+	Origem    int    `json:"Origem"`              // origem da mercadoria, 0 a 8 (0 = nacional)
+	CSTICMS   string `json:"CSTICMS,omitempty"`   // CST do ICMS (regime normal) ou CSOSN (Simples Nacional)
+	CSTPIS    string `json:"CSTPIS,omitempty"`    // CST do PIS nas vendas
+	CSTCOFINS string `json:"CSTCOFINS,omitempty"` // CST da COFINS nas vendas
+}
+
+// NCM é uma linha da tabela NCM de referência. Descricao junta as descrições da posição,
+// das subposições e do item, p.ex. "Águas minerais [...] > Águas minerais".
+type NCM struct {
+	Codigo    string `json:"codigo"`
+	Descricao string `json:"descricao"`
+}
+
+// PricingStrategy é a forma de calcular o preço sugerido a partir do custo (ver o pacote
+// pricing). Um produto sem estratégia própria usa a da categoria ou de uma categoria acima;
+// sem nenhuma, usa pricing.Default.
+type PricingStrategy struct {
+	Metodo         string  `json:"metodo"`                   // markup (lucro sobre o custo) ou margem (sobre o preço)
+	Impostos       string  `json:"impostos"`                 // fora (sobre o custo) ou dentro (incluídos no preço)
+	Arredondamento string  `json:"arredondamento,omitempty"` // "", "0.99" ou "0.90"
+	MargemMinima   float64 `json:"margem_minima,omitempty"`  // percentagem mínima do preço que fica como margem
+}
+
+// VariantAttribute é um atributo de uma variante, p.ex. Tamanho = M.
+type VariantAttribute struct {
+	Nome  string `json:"nome"`
+	Valor string `json:"valor"`
 }
 
 // Filial representa uma loja ou supermercado.
@@ -43,6 +116,10 @@ type StockDetail struct {
 	FilialID   uuid.UUID
 	FilialNome string
 	Quantidade int
+	Versao     int // versão lida; devolvida ao gravar para detetar alterações concorrentes
+	// Unidades do produto e o saldo na unidade de compra (vazio sem unidade de compra).
+	UnitsOfMeasure
+	EmUnidadeCompra string `json:"EmUnidadeCompra,omitempty"`
 }
 
 // PaginationData armazena informações para renderizar controlos de paginação.
@@ -52,6 +129,7 @@ type PaginationData struct {
 	CurrentPage, TotalPages  int
 	SearchQuery              string
 	FilterID                 string
+	Arquivados               bool // a lista mostra os registos arquivados em vez dos ativos
 }
 
 // StockViewItem representa uma linha na nova tabela de gestão de stock.
@@ -64,6 +142,11 @@ type StockViewItem struct {
 	FilialID     uuid.UUID
 	FilialNome   string
 	Quantidade   int
+	Versao       int
+	// Unidades do produto e o saldo na unidade de compra (vazio sem unidade de compra).
+	UnitsOfMeasure
+	EmUnidadeCompra string
+	ClasseABC       string // classe ABC por receita na filial (ou geral); vazio se não classificado
 }
 
 // Venda representa o registo de uma transação.
@@ -99,6 +182,10 @@ type Empresa struct {
 	NomeFantasia string
 	CNPJ         string
 	Endereco     string
+	// CustoMedioPorFilial faz a valorização do stock usar o custo médio de cada filial.
+	CustoMedioPorFilial bool
+	// LimiteAprovacaoAjuste é o valor a custo acima do qual uma baixa de stock precisa de aprovação.
+	LimiteAprovacaoAjuste float64
 }
 
 // Socio representa os dados de um sócio.
@@ -151,13 +238,17 @@ type DailyBranchSales struct {
 }
 
 type StockComposition struct {
-	Category string  `json:"category"`
-	Value    float64 `json:"value"`
+	CategoryID *uuid.UUID `json:"category_id,omitempty"` // vazio nos produtos sem subcategoria
+	Category   string     `json:"category"`
+	Value      float64    `json:"value"`
 }
 
 type FinancialKPIs struct {
-	GrossProfitMargin float64 `json:"gross_profit_margin"`
-	InventoryTurnover float64 `json:"inventory_turnover"`
+	GrossProfitMargin     float64 `json:"gross_profit_margin"`
+	InventoryTurnover     float64 `json:"inventory_turnover"`
+	DaysOfInventory       float64 `json:"days_of_inventory"`
+	AverageInventoryValue float64 `json:"average_inventory_value"`
+	SnapshotDays          int     `json:"snapshot_days"` // dias do período com fotografia de stock
 }
 
 // ATUALIZADO: A struct principal do dashboard agora inclui os novos KPIs financeiros.
@@ -172,3 +263,404 @@ type MonitoringDashboardData struct {
 	TotalStockValue   float64
 	FinancialKPIs     FinancialKPIs // NOVO
 }
+
+// InventoryCount representa uma sessão de contagem física de inventário numa filial.
+// CategoriaID, quando preenchida, limita a contagem a uma categoria de produtos e às suas
+// subcategorias; Secao é o caminho dessa categoria.
+type InventoryCount struct {
+	ID            uuid.UUID
+	FilialID      uuid.UUID
+	FilialNome    string
+	Descricao     string
+	CategoriaID   *uuid.UUID
+	Secao         string
+	Status        string // aberta, em_revisao, aprovada, cancelada
+	CriadoPor     uuid.UUID
+	CriadoPorNome string
+	AprovadoPor   *uuid.UUID
+	MotivoAjuste  string
+	DataCriacao   time.Time
+	DataAprovacao *time.Time
+	TotalItens    int
+	TotalLancados int
+}
+
+// InventoryCountEntry representa um lançamento cego feito por um estoquista durante a contagem.
+type InventoryCountEntry struct {
+	ID             uuid.UUID
+	ContagemID     uuid.UUID
+	ProdutoID      uuid.UUID
+	ProdutoNome    string
+	CodigoBarras   string
+	UsuarioID      uuid.UUID
+	Secao          string
+	Quantidade     int
+	DataLancamento time.Time
+}
+
+// InventoryCountVariance representa a divergência entre o stock congelado e o contado para um produto.
+type InventoryCountVariance struct {
+	ProdutoID          uuid.UUID
+	ProdutoNome        string
+	CodigoBarras       string
+	QuantidadeEsperada int
+	QuantidadeContada  int
+	Diferenca          int
+	CustoUnitario      float64
+	DiferencaValor     float64
+	Contado            bool
+}
+
+// StockLot representa um lote de um produto recebido numa filial.
+// Os lotes detalham o saldo de estoque_filiais; DataValidade é opcional.
+type StockLot struct {
+	ID           uuid.UUID
+	ProdutoID    uuid.UUID
+	FilialID     uuid.UUID
+	NumeroLote   string
+	DataValidade *time.Time
+	// CustoUnitario é o custo de compra da entrada; 0 quando não foi indicado.
+	CustoUnitario float64
+	Quantidade    int
+	// Unidade em que a entrada foi contada; vazio é a unidade de venda. Na entrada, a
+	// quantidade e o custo são convertidos para a unidade de venda (ver units.ToStock).
+	Unidade     string
+	DataEntrada time.Time
+	// FornecedorID é o fornecedor da entrada (opcional); o custo fica como o último dele.
+	FornecedorID *uuid.UUID
+	// UsuarioID é quem registou a entrada; fica no histórico de custos.
+	UsuarioID uuid.UUID
+}
+
+// ExpiringLot representa uma linha do relatório de alerta de validade.
+type ExpiringLot struct {
+	LoteID         uuid.UUID `json:"lote_id"`
+	ProdutoID      uuid.UUID `json:"produto_id"`
+	ProdutoNome    string    `json:"produto_nome"`
+	CodigoBarras   string    `json:"codigo_barras"`
+	Categoria      string    `json:"categoria"`
+	FilialNome     string    `json:"filial_nome"`
+	NumeroLote     string    `json:"numero_lote"`
+	DataValidade   time.Time `json:"data_validade"`
+	Quantidade     int       `json:"quantidade"`
+	DiasParaVencer int       `json:"dias_para_vencer"`
+	ValorCusto     float64   `json:"valor_custo"`
+}
+
+// StockSnapshot representa a fotografia diária do valor do stock de uma categoria numa filial.
+type StockSnapshot struct {
+	Data       time.Time `json:"data"`
+	FilialID   uuid.UUID `json:"filial_id"`
+	FilialNome string    `json:"filial_nome"`
+	Categoria  string    `json:"categoria"`
+	Quantidade int64     `json:"quantidade"`
+	Valor      float64   `json:"valor"`
+}
+
+// StockAdjustment representa uma baixa de stock (quebra, furto, validade, uso interno).
+// Baixas acima do limite de aprovação ficam pendentes até um admin as aprovar.
+type StockAdjustment struct {
+	ID                uuid.UUID
+	ProdutoID         uuid.UUID
+	ProdutoNome       string
+	Categoria         string
+	FilialID          uuid.UUID
+	FilialNome        string
+	Quantidade        int
+	Motivo            string // quebra, furto, validade, uso_interno
+	MotivoDescricao   string
+	Observacao        string
+	CustoUnitario     float64
+	ValorTotal        float64
+	Status            string // pendente, aprovado, rejeitado
+	SolicitadoPor     uuid.UUID
+	SolicitadoPorNome string
+	AprovadoPor       *uuid.UUID
+	DataCriacao       time.Time
+	DataDecisao       *time.Time
+}
+
+// ShrinkageReportRow agrega as baixas aprovadas por filial, categoria e motivo.
+type ShrinkageReportRow struct {
+	FilialNome      string  `json:"filial_nome"`
+	Categoria       string  `json:"categoria"`
+	Motivo          string  `json:"motivo"`
+	MotivoDescricao string  `json:"motivo_descricao"`
+	Quantidade      int     `json:"quantidade"`
+	Valor           float64 `json:"valor"`
+}
+
+// ReplenishmentRow é uma linha da vista de reposição: saldo atual de um produto numa
+// filial, procura prevista para o horizonte escolhido e quantidade sugerida a encomendar.
+type ReplenishmentRow struct {
+	ProdutoID      uuid.UUID `json:"produto_id"`
+	ProdutoNome    string    `json:"produto_nome"`
+	CodigoBarras   string    `json:"codigo_barras"`
+	FilialID       uuid.UUID `json:"filial_id"`
+	FilialNome     string    `json:"filial_nome"`
+	Quantidade     int       `json:"quantidade"`
+	Previsao       float64   `json:"previsao"`
+	LimiteInferior float64   `json:"limite_inferior"`
+	LimiteSuperior float64   `json:"limite_superior"`
+	CoberturaDias  float64   `json:"cobertura_dias"` // dias que o saldo cobre; -1 sem procura prevista
+	SugestaoCompra int       `json:"sugestao_compra"`
+	SemPrevisao    bool      `json:"sem_previsao"` // histórico insuficiente para o método escolhido
+	// Unidade do stock e a sugestão na unidade de compra (vazio sem unidade de compra).
+	Unidade                 string `json:"unidade"`
+	SugestaoEmUnidadeCompra string `json:"sugestao_em_unidade_compra,omitempty"`
+	// Fornecedor preferencial (ou o de menor custo) e o que a sugestão custaria com ele.
+	Fornecedor       string  `json:"fornecedor,omitempty"`
+	CodigoFornecedor string  `json:"codigo_fornecedor,omitempty"`
+	PrazoEntregaDias int     `json:"prazo_entrega_dias"`
+	CustoEstimado    float64 `json:"custo_estimado"`
+	Urgente          bool    `json:"urgente"` // o saldo acaba antes de chegar uma encomenda feita hoje
+	ClasseABC        string  `json:"classe_abc,omitempty"`
+}
+
+// ABCItem é a classificação ABC de um produto num âmbito (geral ou uma filial).
+// As participações e acumulados estão em percentagem (0 a 100).
+type ABCItem struct {
+	ProdutoID           uuid.UUID `json:"produto_id"`
+	ProdutoNome         string    `json:"produto_nome"`
+	CodigoBarras        string    `json:"codigo_barras"`
+	Categoria           string    `json:"categoria"`
+	Quantidade          int64     `json:"quantidade"`
+	Receita             float64   `json:"receita"`
+	Margem              float64   `json:"margem"`
+	ParticipacaoReceita float64   `json:"participacao_receita"`
+	AcumuladoReceita    float64   `json:"acumulado_receita"`
+	ClasseReceita       string    `json:"classe_receita"`
+	ParticipacaoMargem  float64   `json:"participacao_margem"`
+	AcumuladoMargem     float64   `json:"acumulado_margem"`
+	ClasseMargem        string    `json:"classe_margem"`
+}
+
+// ABCSummary agrega os produtos de uma classe para um critério.
+type ABCSummary struct {
+	Classe   string  `json:"classe"`
+	Produtos int     `json:"produtos"`
+	Receita  float64 `json:"receita"`
+	Margem   float64 `json:"margem"`
+}
+
+// ABCRun descreve o último cálculo guardado para um âmbito.
+type ABCRun struct {
+	DataInicio  time.Time `json:"data_inicio"`
+	DataFim     time.Time `json:"data_fim"`
+	DataCalculo time.Time `json:"data_calculo"`
+	Produtos    int       `json:"produtos"`
+}
+
+// SlowMovingItem é uma linha do relatório de stock parado: um produto com saldo numa
+// filial que vendeu no máximo o limite indicado no período analisado.
+type SlowMovingItem struct {
+	ProdutoID          uuid.UUID  `json:"produto_id"`
+	ProdutoNome        string     `json:"produto_nome"`
+	CodigoBarras       string     `json:"codigo_barras"`
+	Categoria          string     `json:"categoria"`
+	FilialID           uuid.UUID  `json:"filial_id"`
+	FilialNome         string     `json:"filial_nome"`
+	Quantidade         int        `json:"quantidade"`
+	CustoUnitario      float64    `json:"custo_unitario"`
+	ValorParado        float64    `json:"valor_parado"`      // saldo a custo
+	UnidadesVendidas   int        `json:"unidades_vendidas"` // no período analisado
+	UltimaVenda        *time.Time `json:"ultima_venda"`
+	DiasSemVenda       int        `json:"dias_sem_venda"` // -1 se nunca vendeu nesta filial
+	CoberturaDias      float64    `json:"cobertura_dias"` // ao ritmo do período; -1 sem vendas
+	FilialSugeridaID   string     `json:"filial_sugerida_id,omitempty"`
+	FilialSugeridaNome string     `json:"filial_sugerida_nome,omitempty"` // filial que mais vendeu o produto no período
+	VendasSugerida     int        `json:"vendas_filial_sugerida"`
+}
+
+// LabelFilter seleciona os produtos para impressão de etiquetas. Os critérios
+// preenchidos combinam-se; sem nenhum, seleciona todos os produtos.
+type LabelFilter struct {
+	ProdutoIDs         []string
+	Busca              string     // nome ou código de barras
+	FilialID           string     // só produtos com registo de stock nesta filial
+	PrecoAlteradoDesde *time.Time // preço de venda alterado (ou produto criado) desde esta data
+}
+
+// ProductBarcode é um código de barras adicional de um produto. Multiplicador é o número
+// de unidades que cada leitura do código representa (1 para um EAN alternativo).
+type ProductBarcode struct {
+	Codigo        string
+	ProdutoID     uuid.UUID
+	Multiplicador int
+	Descricao     string
+	DataCriacao   time.Time
+}
+
+// Supplier é um fornecedor de produtos.
+type Supplier struct {
+	ID   uuid.UUID
+	Nome string
+}
+
+// ProductSupplier liga um produto a um fornecedor, com as condições de compra desse
+// fornecedor. UltimoCusto é por unidade de venda do produto (0 se nunca foi indicado);
+// QuantidadeMinima é a encomenda mínima na unidade de compra.
+type ProductSupplier struct {
+	ProdutoID        uuid.UUID
+	FornecedorID     uuid.UUID
+	FornecedorNome   string
+	CodigoFornecedor string
+	UltimoCusto      float64
+	PrazoEntregaDias int
+	QuantidadeMinima int
+	Preferencial     bool
+	DataAtualizacao  time.Time
+}
+
+// PurchaseOrderLine é uma linha a encomendar escolhida na reposição: a quantidade de um
+// produto, na unidade de venda, para uma filial.
+type PurchaseOrderLine struct {
+	ProdutoID  uuid.UUID
+	FilialID   uuid.UUID
+	Quantidade int
+}
+
+// PurchaseOrder é um rascunho de encomenda a um fornecedor para uma filial.
+type PurchaseOrder struct {
+	ID             uuid.UUID
+	FornecedorID   uuid.UUID
+	FornecedorNome string
+	FilialID       uuid.UUID
+	FilialNome     string
+	Status         string // por agora só "rascunho"
+	CriadoPorNome  string
+	DataCriacao    time.Time
+	Itens          []PurchaseOrderItem
+	Total          float64 // soma das quantidades pelo último custo
+}
+
+// PurchaseOrderItem é um produto de uma encomenda, com o código e o último custo (por
+// unidade de venda) do fornecedor quando a linha foi criada.
+type PurchaseOrderItem struct {
+	ProdutoID        uuid.UUID
+	ProdutoNome      string
+	CodigoBarras     string
+	CodigoFornecedor string
+	Quantidade       int
+	CustoUnitario    float64
+	Subtotal         float64 // Quantidade × CustoUnitario
+}
+
+// BranchPriceComparison compara o preço de catálogo de um produto com os preços próprios
+// das filiais. Precos só tem as filiais com preço próprio, indexadas pelo ID da filial.
+type BranchPriceComparison struct {
+	ProdutoID     uuid.UUID
+	Nome          string
+	CodigoBarras  string
+	Categoria     string
+	PrecoCatalogo float64
+	Precos        map[string]float64
+}
+
+// PriceHistoryEntry é uma alteração de preço ou de custo de um produto. FilialID só existe
+// nas alterações do preço próprio de uma filial; ValorAnterior/ValorNovo nil indicam que a
+// filial não tinha, ou deixou de ter, preço próprio.
+type PriceHistoryEntry struct {
+	ID            uuid.UUID
+	ProdutoID     uuid.UUID
+	ProdutoNome   string
+	CodigoBarras  string
+	FilialID      *uuid.UUID
+	FilialNome    string
+	Campo         string // "preco", "preco_custo", "custo_medio" ou "preco_filial"
+	ValorAnterior *float64
+	ValorNovo     *float64
+	UsuarioNome   string
+	Origem        string
+	DataAlteracao time.Time
+}
+
+// ScheduledPriceChange é uma alteração de preço agendada. Sem FilialID altera o preço de
+// catálogo; com FilialID, o preço próprio dessa filial.
+type ScheduledPriceChange struct {
+	ID            uuid.UUID
+	ProdutoID     uuid.UUID
+	ProdutoNome   string
+	CodigoBarras  string
+	FilialID      *uuid.UUID
+	FilialNome    string
+	Preco         float64
+	PrecoAtual    float64
+	DataEfetiva   time.Time
+	Status        string // "pendente", "aplicada", "cancelada" ou "falhou"
+	Erro          string // motivo da falha, com Status "falhou"
+	UsuarioID     uuid.UUID
+	UsuarioNome   string
+	DataCriacao   time.Time
+	DataAplicacao *time.Time
+}
+
+// Category é um nó da taxonomia de produtos: departamento (nível 1), categoria (2) ou
+// subcategoria (3).
+type Category struct {
+	ID               uuid.UUID
+	Nome             string
+	PaiID            *uuid.UUID
+	Nivel            int
+	Caminho          string // "Departamento > Categoria > Subcategoria"
+	NumProdutos      int    // produtos ligados diretamente a esta categoria
+	NumSubcategorias int
+	// Estratégia de preço própria (nil herda da categoria acima); a descrição é preenchida pelos handlers.
+	EstrategiaPreco     *PricingStrategy
+	EstrategiaDescricao string
+}
+
+// Ações de uma linha na importação do catálogo.
+const (
+	ImportActionCreate = "criar"
+	ImportActionUpdate = "atualizar"
+	ImportActionReject = "rejeitar"
+)
+
+// ProductImportRow é uma linha válida de um ficheiro de importação do catálogo. Linha é
+// a do ficheiro (a 1 é o cabeçalho) e Produto.Categoria traz o caminho indicado.
+type ProductImportRow struct {
+	Linha   int
+	Produto Product
+	// ComFiscal indica que o ficheiro tem as colunas fiscais; sem elas, a importação não
+	// mexe na classificação fiscal dos produtos existentes.
+	ComFiscal bool
+	// ErroCodigoBarras diz porque o código de barras não é um GTIN válido. Só rejeita a linha
+	// se ela criar um produto: um produto existente mantém o código que já tinha.
+	ErroCodigoBarras string
+}
+
+// ProductImportResult é o desfecho de uma linha da importação.
+type ProductImportResult struct {
+	Linha        int    `json:"linha"`
+	CodigoBarras string `json:"codigo_barras"`
+	Nome         string `json:"nome"`
+	Acao         string `json:"acao"`
+	Erro         string `json:"erro,omitempty"`
+}
+
+// ProductImportReport resume uma importação do catálogo ou a sua simulação.
+type ProductImportReport struct {
+	Simulacao   bool                  `json:"simulacao"`
+	Criados     int                   `json:"criados"`
+	Atualizados int                   `json:"atualizados"`
+	Rejeitados  int                   `json:"rejeitados"`
+	Resultados  []ProductImportResult `json:"resultados"`
+}
+
+// KitComponent é um produto que compõe um kit, com as unidades por kit e o custo unitário
+// atual do componente.
+type KitComponent struct {
+	ProdutoID     uuid.UUID
+	Nome          string
+	CodigoBarras  string
+	Quantidade    int
+	CustoUnitario float64
+}
+
+// KitAvailability é o número de kits que o stock dos componentes permite montar numa filial.
+type KitAvailability struct {
+	FilialID   uuid.UUID
+	FilialNome string
+	Disponivel int
+}
@@ -0,0 +1,190 @@
+// Package pricing calcula o preço sugerido de um produto a partir do custo, do percentual de
+// lucro e dos impostos, segundo uma estratégia (models.PricingStrategy):
+//
+//   - markup: o lucro é uma percentagem do custo; margem: o lucro é uma percentagem do preço.
+//   - impostos por fora: calculados sobre o custo e somados; por dentro: uma percentagem do
+//     próprio preço, como o ICMS.
+//   - arredondamento psicológico, sempre para cima, para x,99 ou x,90.
+//   - margem mínima: o preço sobe até a margem (preço - custo - impostos) ser pelo menos essa
+//     percentagem do preço.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"projeto-vendas/internal/models"
+)
+
+const (
+	MethodMarkup = "markup"
+	MethodMargin = "margem"
+	TaxesOutside = "fora"
+	TaxesInside  = "dentro"
+	Round99      = "0.99"
+	Round90      = "0.90"
+)
+
+// Default é a estratégia dos produtos sem estratégia própria nem na categoria: markup sobre
+// o custo com impostos por fora e sem arredondamento, a fórmula original do sistema.
+var Default = models.PricingStrategy{Metodo: MethodMarkup, Impostos: TaxesOutside}
+
+// ErrImpossible é devolvido quando a margem e os impostos sobre o preço chegam a 100%:
+// nenhum preço os cobre.
+var ErrImpossible = errors.New("a margem e os impostos sobre o preço têm de somar menos de 100%")
+
+// Input são os valores do produto usados no cálculo; as percentagens vêm em pontos (15 = 15%).
+type Input struct {
+	Custo           float64
+	Lucro           float64
+	ImpostoEstadual float64
+	ImpostoFederal  float64
+}
+
+// Result é o preço calculado e o que fica dele depois do custo e dos impostos.
+type Result struct {
+	Preco                float64 `json:"preco"`
+	Impostos             float64 `json:"impostos"`
+	Margem               float64 `json:"margem"`
+	MargemPercentual     float64 `json:"margem_percentual"`
+	MargemMinimaAplicada bool    `json:"margem_minima_aplicada"` // o preço subiu para cumprir a margem mínima
+}
+
+// Validate confirma que a estratégia só usa opções conhecidas.
+func Validate(s models.PricingStrategy) error {
+	if s.Metodo != MethodMarkup && s.Metodo != MethodMargin {
+		return fmt.Errorf("método de preço inválido: %q", s.Metodo)
+	}
+	if s.Impostos != TaxesOutside && s.Impostos != TaxesInside {
+		return fmt.Errorf("cálculo de impostos inválido: %q", s.Impostos)
+	}
+	if s.Arredondamento != "" && s.Arredondamento != Round99 && s.Arredondamento != Round90 {
+		return fmt.Errorf("arredondamento inválido: %q", s.Arredondamento)
+	}
+	if s.MargemMinima < 0 || s.MargemMinima >= 100 {
+		return errors.New("a margem mínima tem de estar entre 0 e 100%")
+	}
+	return nil
+}
+
+// Calculate devolve o preço sugerido e a margem resultante. O preço cobre o custo, os
+// impostos e o lucro: P = custo + impostos + lucro, em que os impostos e o lucro são uma
+// percentagem do custo ou do próprio preço, conforme a estratégia.
+func Calculate(s models.PricingStrategy, in Input) (Result, error) {
+	if err := Validate(s); err != nil {
+		return Result{}, err
+	}
+	if in.Custo < 0 || in.Lucro < 0 || in.ImpostoEstadual < 0 || in.ImpostoFederal < 0 {
+		return Result{}, errors.New("o custo, o lucro e os impostos não podem ser negativos")
+	}
+	c, l, t := in.Custo, in.Lucro/100, (in.ImpostoEstadual+in.ImpostoFederal)/100
+
+	// Numerador sobre o custo e parte do preço já comprometida (1 - percentagens do preço).
+	num, den := 1.0, 1.0
+	if s.Metodo == MethodMarkup {
+		num += l
+	} else {
+		den -= l
+	}
+	if s.Impostos == TaxesOutside {
+		num += t
+	} else {
+		den -= t
+	}
+	if den <= 0 {
+		return Result{}, ErrImpossible
+	}
+	price := roundCents(c * num / den)
+
+	var r Result
+	if s.MargemMinima > 0 {
+		minimum, err := minimumPrice(s, c, t)
+		if err != nil {
+			return Result{}, err
+		}
+		if price < minimum {
+			price = minimum
+			r.MargemMinimaAplicada = true
+		}
+	}
+	price = roundPsychological(s.Arredondamento, price)
+
+	taxes := c * t
+	if s.Impostos == TaxesInside {
+		taxes = price * t
+	}
+	r.Preco = price
+	r.Impostos = roundCents(taxes)
+	r.Margem = roundCents(price - c - taxes)
+	if price > 0 {
+		r.MargemPercentual = roundCents((price - c - taxes) / price * 100)
+	}
+	return r, nil
+}
+
+// minimumPrice é o menor preço, arredondado ao cêntimo para cima, em que a margem é pelo
+// menos s.MargemMinima do preço.
+func minimumPrice(s models.PricingStrategy, c, t float64) (float64, error) {
+	m := s.MargemMinima / 100
+	num, den := 1.0, 1-m
+	if s.Impostos == TaxesOutside {
+		num += t
+	} else {
+		den -= t
+	}
+	if den <= 0 {
+		return 0, errors.New("a margem mínima e os impostos sobre o preço têm de somar menos de 100%")
+	}
+	return math.Ceil(c*num/den*100-1e-6) / 100, nil
+}
+
+// roundPsychological sobe o preço para o próximo valor terminado em ,99 ou ,90.
+func roundPsychological(mode string, price float64) float64 {
+	var ending int64
+	switch mode {
+	case Round99:
+		ending = 99
+	case Round90:
+		ending = 90
+	default:
+		return price
+	}
+	if price <= 0 {
+		return price
+	}
+	cents := int64(math.Round(price * 100))
+	rounded := cents/100*100 + ending
+	if rounded < cents {
+		rounded += 100
+	}
+	return float64(rounded) / 100
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// Describe resume a estratégia em português, para as listas e a pré-visualização.
+func Describe(s models.PricingStrategy) string {
+	parts := []string{"Markup sobre o custo"}
+	if s.Metodo == MethodMargin {
+		parts[0] = "Margem sobre o preço"
+	}
+	if s.Impostos == TaxesInside {
+		parts = append(parts, "impostos por dentro")
+	} else {
+		parts = append(parts, "impostos por fora")
+	}
+	switch s.Arredondamento {
+	case Round99:
+		parts = append(parts, "arredondado para x,99")
+	case Round90:
+		parts = append(parts, "arredondado para x,90")
+	}
+	if s.MargemMinima > 0 {
+		parts = append(parts, fmt.Sprintf("margem mínima de %g%%", s.MargemMinima))
+	}
+	return strings.Join(parts, ", ")
+}
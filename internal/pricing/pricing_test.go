@@ -0,0 +1,90 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+
+	"projeto-vendas/internal/models"
+)
+
+func TestCalculateMethodsAndTaxes(t *testing.T) {
+	in := Input{Custo: 10, Lucro: 20, ImpostoEstadual: 10, ImpostoFederal: 5}
+	cases := []struct {
+		name     string
+		strategy models.PricingStrategy
+		preco    float64
+		margem   float64
+	}{
+		// 10 + 20% + 15% do custo: a fórmula original.
+		{"markup, impostos por fora", Default, 13.5, 2},
+		// (10 + 2) / (1 - 15%): os impostos são 15% do preço final.
+		{"markup, impostos por dentro", models.PricingStrategy{Metodo: MethodMarkup, Impostos: TaxesInside}, 14.12, 2},
+		// (10 + 1,5) / (1 - 20%): a margem é 20% do preço.
+		{"margem, impostos por fora", models.PricingStrategy{Metodo: MethodMargin, Impostos: TaxesOutside}, 14.38, 2.88},
+		// 10 / (1 - 20% - 15%).
+		{"margem, impostos por dentro", models.PricingStrategy{Metodo: MethodMargin, Impostos: TaxesInside}, 15.38, 3.07},
+	}
+	for _, tc := range cases {
+		r, err := Calculate(tc.strategy, in)
+		if err != nil {
+			t.Fatalf("%s: erro inesperado: %v", tc.name, err)
+		}
+		if r.Preco != tc.preco || r.Margem != tc.margem {
+			t.Errorf("%s: esperava preço %.2f e margem %.2f, obteve %+v", tc.name, tc.preco, tc.margem, r)
+		}
+	}
+}
+
+func TestCalculateRounding(t *testing.T) {
+	cases := []struct {
+		modo  string
+		custo float64
+		preco float64
+	}{
+		{Round99, 10, 12.99},    // 12,00 sobe para 12,99
+		{Round99, 10.82, 12.99}, // 12,98 sobe para 12,99
+		{Round99, 10.83, 13.99}, // 13,00 passa o ,99 de 12
+		{Round90, 10, 12.90},
+		{Round90, 10.80, 13.90}, // 12,96 passa o ,90 de 12
+	}
+	for _, tc := range cases {
+		s := models.PricingStrategy{Metodo: MethodMarkup, Impostos: TaxesOutside, Arredondamento: tc.modo}
+		r, err := Calculate(s, Input{Custo: tc.custo, Lucro: 20})
+		if err != nil {
+			t.Fatalf("Erro inesperado: %v", err)
+		}
+		if r.Preco != tc.preco {
+			t.Errorf("Arredondamento %s com custo %.2f: esperava %.2f, obteve %.2f", tc.modo, tc.custo, tc.preco, r.Preco)
+		}
+	}
+}
+
+func TestCalculateMinimumMargin(t *testing.T) {
+	s := models.PricingStrategy{Metodo: MethodMarkup, Impostos: TaxesOutside, MargemMinima: 25}
+	// Um lucro de 10% sobre o custo dá uma margem de ~9% do preço; a margem mínima sobe o preço
+	// até a margem ser 25% do preço: 10 / (1 - 25%) = 13,34.
+	r, err := Calculate(s, Input{Custo: 10, Lucro: 10})
+	if err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if !r.MargemMinimaAplicada || r.Preco != 13.34 || r.MargemPercentual < 25 {
+		t.Errorf("Esperava o preço subido para a margem mínima, obteve %+v", r)
+	}
+	// Com lucro suficiente, a margem mínima não mexe no preço.
+	if r, _ := Calculate(s, Input{Custo: 10, Lucro: 50}); r.MargemMinimaAplicada || r.Preco != 15 {
+		t.Errorf("Esperava o preço sem ajuste, obteve %+v", r)
+	}
+}
+
+func TestCalculateRejectsImpossibleStrategies(t *testing.T) {
+	s := models.PricingStrategy{Metodo: MethodMargin, Impostos: TaxesInside}
+	if _, err := Calculate(s, Input{Custo: 10, Lucro: 60, ImpostoEstadual: 40}); !errors.Is(err, ErrImpossible) {
+		t.Errorf("Esperava ErrImpossible, obteve %v", err)
+	}
+	if _, err := Calculate(models.PricingStrategy{Metodo: "desconto", Impostos: TaxesOutside}, Input{Custo: 10}); err == nil {
+		t.Error("Esperava erro para um método desconhecido")
+	}
+	if _, err := Calculate(Default, Input{Custo: -1}); err == nil {
+		t.Error("Esperava erro para um custo negativo")
+	}
+}
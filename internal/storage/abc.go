@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"projeto-vendas/internal/models"
+)
+
+// Limites por omissão da classificação ABC: os produtos que formam os primeiros 80% do
+// critério são A, os seguintes até 95% são B e os restantes (e os sem vendas) são C.
+const (
+	DefaultABCThresholdA = 0.80
+	DefaultABCThresholdB = 0.95
+)
+
+// sqlABCScope restringe classificacao_abc (alias a) ao âmbito $1: vazio é a classificação
+// geral (filial_id NULL), caso contrário a filial indicada.
+const sqlABCScope = `a.filial_id IS NOT DISTINCT FROM NULLIF($1, '')::uuid`
+
+// sqlStockABCClass é a classe ABC por receita de um registo de stock (aliases ef e p): a da
+// classificação da filial ou, sem ela, a geral. As variantes têm a classe do produto pai.
+// Vazio se o produto não foi classificado.
+const sqlStockABCClass = `COALESCE(
+	(SELECT a.classe_receita FROM classificacao_abc a WHERE a.produto_id = COALESCE(p.produto_pai_id, p.id) AND a.filial_id = ef.filial_id),
+	(SELECT a.classe_receita FROM classificacao_abc a WHERE a.produto_id = COALESCE(p.produto_pai_id, p.id) AND a.filial_id IS NULL),
+	'')`
+
+// RunABCClassification recalcula e guarda a classificação ABC do âmbito indicado
+// (filialID vazio = todas as filiais) com as vendas entre from e to, inclusive.
+// A margem usa o custo registado em cada item de venda. No âmbito geral entram todos
+// os produtos; numa filial, os que lá têm registo de stock ou vendas no período. As vendas
+// das variantes contam para o produto pai, que é o único classificado.
+// Um produto é da classe cuja fronteira ainda não tinha sido atingida antes dele,
+// por isso o produto que cruza os 80% ainda é A. Devolve o número de produtos classificados.
+func (s *Storage) RunABCClassification(filialID string, from, to time.Time, thresholdA, thresholdB float64) (int64, error) {
+	if thresholdA <= 0 || thresholdB <= thresholdA || thresholdB > 1 {
+		return 0, fmt.Errorf("limites ABC inválidos: A=%.2f, B=%.2f", thresholdA, thresholdB)
+	}
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	if _, err := tx.Exec(context.Background(), `DELETE FROM classificacao_abc a WHERE `+sqlABCScope, filialID); err != nil {
+		return 0, fmt.Errorf("falha ao limpar a classificação anterior: %w", err)
+	}
+	sql := `
+		WITH periodo AS (
+			SELECT COALESCE(p.produto_pai_id, p.id) AS produto_id,
+				SUM(iv.quantidade) AS quantidade,
+				SUM(iv.quantidade * iv.preco_unitario) AS receita,
+				SUM(iv.quantidade * (iv.preco_unitario - COALESCE(iv.custo_unitario, p.custo_medio, p.preco_custo))) AS margem
+			FROM itens_venda iv
+			JOIN vendas v ON iv.venda_id = v.id
+			JOIN produtos p ON iv.produto_id = p.id
+			WHERE v.data_venda >= $2::date AND v.data_venda < $3::date + 1
+			  AND ($1 = '' OR v.filial_id::text = $1)
+			GROUP BY COALESCE(p.produto_pai_id, p.id)
+		), base AS (
+			SELECT p.id AS produto_id, COALESCE(pe.quantidade, 0) AS quantidade,
+				COALESCE(pe.receita, 0) AS receita, COALESCE(pe.margem, 0) AS margem
+			FROM produtos p
+			LEFT JOIN periodo pe ON pe.produto_id = p.id
+			WHERE p.produto_pai_id IS NULL
+			  AND ($1 = '' OR pe.produto_id IS NOT NULL
+			   OR EXISTS (SELECT 1 FROM estoque_filiais ef JOIN produtos m ON m.id = ef.produto_id
+					WHERE (m.id = p.id OR m.produto_pai_id = p.id) AND ef.filial_id::text = $1))
+		), acumulado AS (
+			SELECT b.*,
+				COALESCE(b.receita / NULLIF(SUM(b.receita) OVER (), 0), 0) AS part_receita,
+				COALESCE(SUM(b.receita) OVER (ORDER BY b.receita DESC, b.produto_id ROWS UNBOUNDED PRECEDING)
+					/ NULLIF(SUM(b.receita) OVER (), 0), 0) AS acum_receita,
+				COALESCE(GREATEST(b.margem, 0) / NULLIF(SUM(GREATEST(b.margem, 0)) OVER (), 0), 0) AS part_margem,
+				COALESCE(SUM(GREATEST(b.margem, 0)) OVER (ORDER BY b.margem DESC, b.produto_id ROWS UNBOUNDED PRECEDING)
+					/ NULLIF(SUM(GREATEST(b.margem, 0)) OVER (), 0), 0) AS acum_margem
+			FROM base b
+		)
+		INSERT INTO classificacao_abc (produto_id, filial_id, data_inicio, data_fim, quantidade, receita, margem,
+			participacao_receita, acumulado_receita, classe_receita, participacao_margem, acumulado_margem, classe_margem)
+		SELECT produto_id, NULLIF($1, '')::uuid, $2::date, $3::date, quantidade, receita, margem,
+			part_receita, acum_receita,
+			CASE WHEN receita <= 0 THEN 'C'
+				WHEN acum_receita - part_receita < $4 THEN 'A'
+				WHEN acum_receita - part_receita < $5 THEN 'B'
+				ELSE 'C' END,
+			part_margem, acum_margem,
+			CASE WHEN margem <= 0 THEN 'C'
+				WHEN acum_margem - part_margem < $4 THEN 'A'
+				WHEN acum_margem - part_margem < $5 THEN 'B'
+				ELSE 'C' END
+		FROM acumulado
+	`
+	cmdTag, err := tx.Exec(context.Background(), sql, filialID, from.Format("2006-01-02"), to.Format("2006-01-02"), thresholdA, thresholdB)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao calcular a classificação ABC: %w", err)
+	}
+	return cmdTag.RowsAffected(), tx.Commit(context.Background())
+}
+
+// GetABCRun devolve o período e a data do último cálculo do âmbito, ou nil se nunca foi calculado.
+func (s *Storage) GetABCRun(filialID string) (*models.ABCRun, error) {
+	var run models.ABCRun
+	var dataInicio, dataFim, dataCalculo *time.Time
+	sql := `SELECT MIN(a.data_inicio), MAX(a.data_fim), MAX(a.data_calculo), COUNT(*) FROM classificacao_abc a WHERE ` + sqlABCScope
+	if err := s.Dbpool.QueryRow(context.Background(), sql, filialID).Scan(&dataInicio, &dataFim, &dataCalculo, &run.Produtos); err != nil {
+		return nil, err
+	}
+	if run.Produtos == 0 {
+		return nil, nil
+	}
+	run.DataInicio, run.DataFim, run.DataCalculo = *dataInicio, *dataFim, *dataCalculo
+	return &run, nil
+}
+
+// GetABCSummary agrega a classificação guardada por classe, segundo o critério
+// "receita" ou "margem".
+func (s *Storage) GetABCSummary(filialID, criterio string) ([]models.ABCSummary, error) {
+	var summary []models.ABCSummary
+	sql := `
+		SELECT CASE WHEN $2 = 'margem' THEN a.classe_margem ELSE a.classe_receita END AS classe,
+			COUNT(*), COALESCE(SUM(a.receita), 0), COALESCE(SUM(a.margem), 0)
+		FROM classificacao_abc a
+		WHERE ` + sqlABCScope + `
+		GROUP BY classe
+		ORDER BY classe
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, filialID, criterio)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.ABCSummary
+		if err := rows.Scan(&item.Classe, &item.Produtos, &item.Receita, &item.Margem); err != nil {
+			return nil, err
+		}
+		summary = append(summary, item)
+	}
+	return summary, rows.Err()
+}
+
+// CountABCItems conta os produtos classificados no âmbito, opcionalmente só de uma classe.
+func (s *Storage) CountABCItems(filialID, criterio, classe string) (int, error) {
+	var count int
+	sql := `
+		SELECT COUNT(*) FROM classificacao_abc a
+		WHERE ` + sqlABCScope + `
+		  AND ($3 = '' OR (CASE WHEN $2 = 'margem' THEN a.classe_margem ELSE a.classe_receita END) = $3)
+	`
+	err := s.Dbpool.QueryRow(context.Background(), sql, filialID, criterio, classe).Scan(&count)
+	return count, err
+}
+
+// GetABCItems lista a classificação guardada, do maior para o menor contributo no
+// critério escolhido, com as participações em percentagem. Com limit 0 devolve todas
+// as linhas (usado na exportação CSV).
+func (s *Storage) GetABCItems(filialID, criterio, classe string, limit, offset int) ([]models.ABCItem, error) {
+	var items []models.ABCItem
+	sql := `
+		SELECT a.produto_id, p.nome, p.codigo_barras, COALESCE(` + sqlCategoryPath + `, ''), a.quantidade, a.receita, a.margem,
+			a.participacao_receita * 100, a.acumulado_receita * 100, a.classe_receita,
+			a.participacao_margem * 100, a.acumulado_margem * 100, a.classe_margem
+		FROM classificacao_abc a
+		JOIN produtos p ON a.produto_id = p.id
+		WHERE ` + sqlABCScope + `
+		  AND ($3 = '' OR (CASE WHEN $2 = 'margem' THEN a.classe_margem ELSE a.classe_receita END) = $3)
+		ORDER BY (CASE WHEN $2 = 'margem' THEN a.margem ELSE a.receita END) DESC, p.nome
+		LIMIT NULLIF($4::int, 0) OFFSET $5
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, filialID, criterio, classe, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.ABCItem
+		if err := rows.Scan(&item.ProdutoID, &item.ProdutoNome, &item.CodigoBarras, &item.Categoria, &item.Quantidade, &item.Receita, &item.Margem,
+			&item.ParticipacaoReceita, &item.AcumuladoReceita, &item.ClasseReceita,
+			&item.ParticipacaoMargem, &item.AcumuladoMargem, &item.ClasseMargem); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"projeto-vendas/internal/models"
+)
+
+// defaultAdjustmentApprovalLimit é o limite de aprovação usado enquanto a empresa não define outro.
+const defaultAdjustmentApprovalLimit = 200.0
+
+// CreateStockAdjustment regista uma baixa de stock valorizada ao custo atual.
+// Até ao limite de aprovação da empresa a baixa é aplicada de imediato; acima dele
+// fica pendente e o stock só sai quando um admin a aprovar. Devolve o estado final.
+func (s *Storage) CreateStockAdjustment(adj models.StockAdjustment) (string, error) {
+	if adj.Quantidade <= 0 {
+		return "", errors.New("a quantidade da baixa tem de ser positiva")
+	}
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var unitCost, limit float64
+	sqlCost := `
+		SELECT ` + sqlStockUnitCost + `,
+			COALESCE((SELECT limite_aprovacao_ajuste FROM empresa LIMIT 1), $3)
+		FROM produtos p
+		LEFT JOIN estoque_filiais ef ON ef.produto_id = p.id AND ef.filial_id = $2
+		WHERE p.id = $1
+	`
+	err = tx.QueryRow(context.Background(), sqlCost, adj.ProdutoID, adj.FilialID, defaultAdjustmentApprovalLimit).Scan(&unitCost, &limit)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", errors.New("produto não encontrado")
+		}
+		return "", err
+	}
+
+	status := "aprovado"
+	if unitCost*float64(adj.Quantidade) > limit {
+		status = "pendente"
+	} else if err := removeStock(tx, adj.ProdutoID, adj.FilialID, adj.Quantidade); err != nil {
+		return "", err
+	}
+
+	sqlInsert := `
+		INSERT INTO ajustes_estoque (produto_id, filial_id, quantidade, motivo, observacao, custo_unitario, status, solicitado_por, data_decisao)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, CASE WHEN $7 = 'aprovado' THEN NOW() END)
+	`
+	_, err = tx.Exec(context.Background(), sqlInsert, adj.ProdutoID, adj.FilialID, adj.Quantidade, adj.Motivo, adj.Observacao, unitCost, status, adj.SolicitadoPor)
+	if err != nil {
+		return "", fmt.Errorf("falha ao registar o ajuste: %w", err)
+	}
+	return status, tx.Commit(context.Background())
+}
+
+// GetStockAdjustments lista os ajustes mais recentes, filtrados por filial e estado (ambos opcionais).
+func (s *Storage) GetStockAdjustments(filialID, status string, limit int) ([]models.StockAdjustment, error) {
+	var adjustments []models.StockAdjustment
+	sql := `
+		SELECT a.id, a.produto_id, p.nome, COALESCE(` + sqlCategoryPath + `, ''), a.filial_id, f.nome, a.quantidade,
+			a.motivo, COALESCE(a.observacao, ''), a.custo_unitario, a.custo_unitario * a.quantidade,
+			a.status, a.solicitado_por, u.nome, a.aprovado_por, a.data_criacao, a.data_decisao
+		FROM ajustes_estoque a
+		JOIN produtos p ON a.produto_id = p.id
+		JOIN filiais f ON a.filial_id = f.id
+		JOIN usuarios u ON a.solicitado_por = u.id
+		WHERE ($1 = '' OR a.filial_id::text = $1) AND ($2 = '' OR a.status = $2)
+		ORDER BY a.data_criacao DESC
+		LIMIT $3
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, filialID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.StockAdjustment
+		if err := rows.Scan(&a.ID, &a.ProdutoID, &a.ProdutoNome, &a.Categoria, &a.FilialID, &a.FilialNome, &a.Quantidade,
+			&a.Motivo, &a.Observacao, &a.CustoUnitario, &a.ValorTotal,
+			&a.Status, &a.SolicitadoPor, &a.SolicitadoPorNome, &a.AprovadoPor, &a.DataCriacao, &a.DataDecisao); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+	return adjustments, nil
+}
+
+// ApproveStockAdjustment aprova uma baixa pendente e retira o stock nesse momento.
+func (s *Storage) ApproveStockAdjustment(id, approverID string) error {
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var status, productID, filialID string
+	var quantity int
+	sqlLock := `SELECT status, produto_id::text, filial_id::text, quantidade FROM ajustes_estoque WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(context.Background(), sqlLock, id).Scan(&status, &productID, &filialID, &quantity)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.New("ajuste não encontrado")
+		}
+		return err
+	}
+	if status != "pendente" {
+		return fmt.Errorf("o ajuste já foi decidido (estado atual: %s)", status)
+	}
+	if err := removeStock(tx, productID, filialID, quantity); err != nil {
+		return err
+	}
+	sqlApprove := `UPDATE ajustes_estoque SET status = 'aprovado', aprovado_por = $1, data_decisao = NOW() WHERE id = $2`
+	if _, err := tx.Exec(context.Background(), sqlApprove, approverID, id); err != nil {
+		return fmt.Errorf("falha ao aprovar o ajuste: %w", err)
+	}
+	return tx.Commit(context.Background())
+}
+
+// RejectStockAdjustment rejeita uma baixa pendente; o stock não é alterado.
+func (s *Storage) RejectStockAdjustment(id, approverID string) error {
+	sql := `
+		UPDATE ajustes_estoque SET status = 'rejeitado', aprovado_por = $1, data_decisao = NOW()
+		WHERE id = $2 AND status = 'pendente'
+	`
+	cmdTag, err := s.Dbpool.Exec(context.Background(), sql, approverID, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("ajuste não encontrado ou já decidido")
+	}
+	return nil
+}
+
+// GetShrinkageReport agrega as baixas aprovadas dos últimos `days` dias por filial,
+// categoria e motivo, valorizadas ao custo registado no momento do ajuste.
+func (s *Storage) GetShrinkageReport(filialID string, days int) ([]models.ShrinkageReportRow, error) {
+	var report []models.ShrinkageReportRow
+	sql := `
+		SELECT f.nome, COALESCE(cat.caminho, 'Sem Categoria'), a.motivo,
+			SUM(a.quantidade), SUM(a.custo_unitario * a.quantidade) AS valor
+		FROM ajustes_estoque a
+		JOIN produtos p ON a.produto_id = p.id
+		LEFT JOIN categorias cat ON cat.id = p.categoria_id
+		JOIN filiais f ON a.filial_id = f.id
+		WHERE a.status = 'aprovado'
+		  AND a.data_decisao >= CURRENT_DATE - MAKE_INTERVAL(days => $1)
+		  AND ($2 = '' OR a.filial_id::text = $2)
+		GROUP BY f.nome, COALESCE(cat.caminho, 'Sem Categoria'), a.motivo
+		ORDER BY valor DESC
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, days, filialID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.ShrinkageReportRow
+		if err := rows.Scan(&r.FilialNome, &r.Categoria, &r.Motivo, &r.Quantidade, &r.Valor); err != nil {
+			return nil, err
+		}
+		report = append(report, r)
+	}
+	return report, nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Erros do arquivo; as mensagens são mostradas tal como estão ao administrador.
+var (
+	ErrNotArchived         = errors.New("só é possível apagar definitivamente um registo arquivado; arquive-o primeiro")
+	ErrProductInUse        = errors.New("o produto tem vendas registadas e não pode ser apagado definitivamente; fica arquivado e as vendas continuam nos relatórios")
+	ErrProductSoldInKit    = errors.New("o produto foi vendido como componente de kits e não pode ser apagado definitivamente; fica arquivado e as vendas continuam nos relatórios")
+	ErrProductKitComponent = errors.New("o produto é componente de um kit e não pode ser apagado definitivamente; retire-o dos kits primeiro")
+	ErrUserInUse           = errors.New("o utilizador tem vendas registadas em seu nome e não pode ser apagado definitivamente; fica arquivado e as vendas continuam nos relatórios")
+)
+
+// archiveInUse traduz a chave estrangeira que impede apagar um registo arquivado no motivo
+// mostrado ao administrador. As chaves com ON DELETE CASCADE ou SET NULL não bloqueiam.
+var archiveInUse = map[string]error{
+	"fk_produto":               ErrProductInUse,
+	"fk_item_venda_componente": ErrProductSoldInKit,
+	"fk_kit_componente":        ErrProductKitComponent,
+	"fk_usuario_venda":         ErrUserInUse,
+	"fk_criador_contagem":      errors.New("o utilizador abriu contagens de inventário e não pode ser apagado definitivamente; fica arquivado e as contagens mantêm o autor"),
+	"fk_usuario_lancamento":    errors.New("o utilizador fez lançamentos em contagens de inventário e não pode ser apagado definitivamente; fica arquivado e os lançamentos mantêm o autor"),
+	"fk_solicitante_ajuste":    errors.New("o utilizador pediu ajustes de stock e não pode ser apagado definitivamente; fica arquivado e os ajustes mantêm o autor"),
+	"fk_usuario_agendado":      errors.New("o utilizador agendou alterações de preço e não pode ser apagado definitivamente; fica arquivado e os agendamentos mantêm o autor"),
+}
+
+// ArchiveProduct arquiva o produto e as suas variantes: deixam de aparecer no terminal e nas
+// listas, mas as vendas e os relatórios mantêm-nos.
+func (s *Storage) ArchiveProduct(id string) error {
+	sql := `UPDATE produtos SET data_arquivamento = NOW() WHERE (id = $1 OR produto_pai_id = $1) AND data_arquivamento IS NULL`
+	cmdTag, err := s.Dbpool.Exec(context.Background(), sql, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("produto não encontrado ou já arquivado")
+	}
+	return nil
+}
+
+// RestoreProduct devolve ao catálogo um produto arquivado e as suas variantes. Uma variante
+// só pode ser restaurada com o produto pai ativo.
+func (s *Storage) RestoreProduct(id string) error {
+	sql := `
+		UPDATE produtos SET data_arquivamento = NULL
+		WHERE (id = $1 OR produto_pai_id = $1) AND data_arquivamento IS NOT NULL
+		  AND NOT EXISTS (
+			SELECT 1 FROM produtos v JOIN produtos pai ON pai.id = v.produto_pai_id
+			WHERE v.id = $1 AND pai.data_arquivamento IS NOT NULL
+		  )
+	`
+	cmdTag, err := s.Dbpool.Exec(context.Background(), sql, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("produto não encontrado, não arquivado ou variante de um produto arquivado")
+	}
+	return nil
+}
+
+// DeleteProductByID apaga definitivamente um produto arquivado e as suas variantes. Um
+// produto com vendas devolve ErrProductInUse; vendido dentro de kits, ErrProductSoldInKit;
+// componente de um kit, ErrProductKitComponent.
+func (s *Storage) DeleteProductByID(id string) error {
+	return s.deleteArchived(`DELETE FROM produtos WHERE id = $1 AND data_arquivamento IS NOT NULL`, `SELECT EXISTS (SELECT 1 FROM produtos WHERE id = $1)`, id,
+		"o produto tem outros registos associados e não pode ser apagado definitivamente", "produto não encontrado")
+}
+
+// ArchiveUser arquiva o utilizador: deixa de poder entrar e de aparecer nas listas, mas as
+// vendas e os registos feitos em seu nome mantêm-se.
+func (s *Storage) ArchiveUser(id string) error {
+	cmdTag, err := s.Dbpool.Exec(context.Background(), `UPDATE usuarios SET data_arquivamento = NOW() WHERE id = $1 AND data_arquivamento IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("utilizador não encontrado ou já arquivado")
+	}
+	return nil
+}
+
+// RestoreUser reativa um utilizador arquivado.
+func (s *Storage) RestoreUser(id string) error {
+	cmdTag, err := s.Dbpool.Exec(context.Background(), `UPDATE usuarios SET data_arquivamento = NULL WHERE id = $1 AND data_arquivamento IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("utilizador não encontrado ou não arquivado")
+	}
+	return nil
+}
+
+// IsUserActive indica se o utilizador existe e não está arquivado. É consultado a cada
+// pedido autenticado, para que arquivar um utilizador termine também as sessões abertas.
+func (s *Storage) IsUserActive(id string) (bool, error) {
+	var active bool
+	err := s.Dbpool.QueryRow(context.Background(), `SELECT EXISTS (SELECT 1 FROM usuarios WHERE id::text = $1 AND data_arquivamento IS NULL)`, id).Scan(&active)
+	return active, err
+}
+
+// DeleteUserByID apaga definitivamente um utilizador arquivado. Um utilizador com vendas em
+// seu nome devolve ErrUserInUse; com contagens, ajustes ou agendamentos, o motivo respetivo.
+func (s *Storage) DeleteUserByID(id string) error {
+	return s.deleteArchived(`DELETE FROM usuarios WHERE id = $1 AND data_arquivamento IS NOT NULL`, `SELECT EXISTS (SELECT 1 FROM usuarios WHERE id = $1)`, id,
+		"o utilizador tem outros registos em seu nome e não pode ser apagado definitivamente", "utilizador não encontrado")
+}
+
+// deleteArchived corre o DELETE de um registo arquivado e traduz a violação de uma chave
+// estrangeira no motivo de archiveInUse (ou em inUse, para uma chave sem motivo próprio).
+// Sem linhas apagadas, distingue o registo ativo do inexistente.
+func (s *Storage) deleteArchived(deleteSQL, existsSQL, id, inUse, notFound string) error {
+	cmdTag, err := s.Dbpool.Exec(context.Background(), deleteSQL, id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			if reason, ok := archiveInUse[pgErr.ConstraintName]; ok {
+				return reason
+			}
+			return fmt.Errorf("%s (%s)", inUse, pgErr.ConstraintName)
+		}
+		return err
+	}
+	if cmdTag.RowsAffected() > 0 {
+		return nil
+	}
+	var exists bool
+	if err := s.Dbpool.QueryRow(context.Background(), existsSQL, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrNotArchived
+	}
+	return errors.New(notFound)
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"projeto-vendas/internal/models"
+)
+
+// maxCategoryLevel é a profundidade da taxonomia: departamento > categoria > subcategoria.
+const maxCategoryLevel = 3
+
+// sqlCategoryPath é o caminho da categoria de um produto (alias p), NULL se não tiver.
+const sqlCategoryPath = `(SELECT cat.caminho FROM categorias cat WHERE cat.id = p.categoria_id)`
+
+// sqlCategoryFilter limita os produtos (alias p) à categoria indicada pelo parâmetro e às suas
+// subcategorias; com o parâmetro vazio não filtra. Usar com fmt.Sprintf e o número do parâmetro.
+const sqlCategoryFilter = `($%[1]d = '' OR p.categoria_id IN (SELECT categorias_descendentes(NULLIF($%[1]d, '')::uuid)))`
+
+// categoryWriteError traduz as violações de restrições da tabela de categorias.
+func categoryWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return errors.New("já existe uma categoria com esse nome neste nível")
+		case "23503":
+			return errors.New("a categoria tem subcategorias, produtos ou contagens associados")
+		}
+	}
+	return err
+}
+
+// GetCategories devolve a taxonomia completa ordenada pelo caminho, o que deixa cada
+// categoria logo a seguir ao seu pai.
+func (s *Storage) GetCategories() ([]models.Category, error) {
+	var categories []models.Category
+	sql := `
+		SELECT c.id, c.nome, c.pai_id, c.nivel, c.caminho,
+			(SELECT COUNT(*) FROM produtos p WHERE p.categoria_id = c.id),
+			(SELECT COUNT(*) FROM categorias f WHERE f.pai_id = c.id), c.estrategia_preco
+		FROM categorias c
+		ORDER BY lower(c.caminho)
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.Category
+		if err := rows.Scan(&c.ID, &c.Nome, &c.PaiID, &c.Nivel, &c.Caminho, &c.NumProdutos, &c.NumSubcategorias, &c.EstrategiaPreco); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// AddCategory cria um departamento (paiID vazio) ou uma categoria dentro de paiID, até
+// ao nível de subcategoria.
+func (s *Storage) AddCategory(nome, paiID string) (string, error) {
+	nome = strings.TrimSpace(nome)
+	if nome == "" {
+		return "", errors.New("o nome da categoria é obrigatório")
+	}
+	if strings.Contains(nome, ">") {
+		return "", errors.New("o nome da categoria não pode conter '>'")
+	}
+	var id string
+	if paiID == "" {
+		err := s.Dbpool.QueryRow(context.Background(), `INSERT INTO categorias (nome, nivel, caminho) VALUES ($1, 1, $1) RETURNING id`, nome).Scan(&id)
+		return id, categoryWriteError(err)
+	}
+	sql := `
+		INSERT INTO categorias (nome, pai_id, nivel, caminho)
+		SELECT $1, pai.id, pai.nivel + 1, pai.caminho || ' > ' || $1
+		FROM categorias pai
+		WHERE pai.id = $2 AND pai.nivel < $3
+		RETURNING id
+	`
+	err := s.Dbpool.QueryRow(context.Background(), sql, nome, paiID, maxCategoryLevel).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("a categoria pai não existe ou já está no último nível (%d)", maxCategoryLevel)
+	}
+	return id, categoryWriteError(err)
+}
+
+// RenameCategory muda o nome de uma categoria e recalcula o caminho dela e das descendentes.
+func (s *Storage) RenameCategory(id, nome string) error {
+	nome = strings.TrimSpace(nome)
+	if nome == "" || strings.Contains(nome, ">") {
+		return errors.New("nome de categoria inválido")
+	}
+	ctx := context.Background()
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	cmdTag, err := tx.Exec(ctx, `UPDATE categorias SET nome = $2 WHERE id = $1`, id, nome)
+	if err != nil {
+		return categoryWriteError(err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("categoria não encontrada")
+	}
+	sql := `
+		WITH RECURSIVE arvore AS (
+			SELECT c.id, COALESCE(pai.caminho || ' > ', '') || c.nome AS caminho
+			FROM categorias c
+			LEFT JOIN categorias pai ON pai.id = c.pai_id
+			WHERE c.id = $1
+			UNION ALL
+			SELECT c.id, a.caminho || ' > ' || c.nome
+			FROM categorias c
+			JOIN arvore a ON c.pai_id = a.id
+		)
+		UPDATE categorias c SET caminho = a.caminho
+		FROM arvore a
+		WHERE c.id = a.id
+	`
+	if _, err := tx.Exec(ctx, sql, id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteCategory remove uma categoria sem subcategorias nem produtos.
+func (s *Storage) DeleteCategory(id string) error {
+	cmdTag, err := s.Dbpool.Exec(context.Background(), `DELETE FROM categorias WHERE id = $1`, id)
+	if err != nil {
+		return categoryWriteError(err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("categoria não encontrada")
+	}
+	return nil
+}
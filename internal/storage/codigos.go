@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"projeto-vendas/internal/gtin"
+	"projeto-vendas/internal/models"
+)
+
+// ErrBarcodeTaken é devolvido ao gravar um código de barras, principal ou adicional, que já
+// leva a outro produto.
+var ErrBarcodeTaken = errors.New("este código de barras já está associado a um produto")
+
+// barcodeError troca a violação do gatilho verificar_codigo_barras_unico (o código principal
+// de um produto é o adicional de outro, ou vice-versa) por ErrBarcodeTaken.
+func barcodeError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "codigo_barras_repetido" {
+		return ErrBarcodeTaken
+	}
+	return err
+}
+
+// sqlResolveBarcode encontra o produto de um código ($1), seja o principal ou um dos
+// adicionais, com as unidades que uma leitura representa.
+const sqlResolveBarcode = `
+	SELECT id, 1 FROM produtos WHERE codigo_barras = $1
+	UNION ALL
+	SELECT produto_id, multiplicador FROM produto_codigos_barras WHERE codigo = $1
+	LIMIT 1
+`
+
+// resolveBarcode devolve o produto e o multiplicador de um código de barras;
+// pgx.ErrNoRows se nenhum produto o tiver.
+func resolveBarcode(ctx context.Context, tx pgx.Tx, code string) (uuid.UUID, int, error) {
+	var productID uuid.UUID
+	var multiplier int
+	err := tx.QueryRow(ctx, sqlResolveBarcode, code).Scan(&productID, &multiplier)
+	return productID, multiplier, err
+}
+
+// GetProductBarcodes lista os códigos de barras adicionais de um produto.
+func (s *Storage) GetProductBarcodes(productID string) ([]models.ProductBarcode, error) {
+	var codes []models.ProductBarcode
+	sql := `
+		SELECT codigo, produto_id, multiplicador, COALESCE(descricao, ''), data_criacao
+		FROM produto_codigos_barras
+		WHERE produto_id = $1
+		ORDER BY multiplicador, codigo
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.ProductBarcode
+		if err := rows.Scan(&c.Codigo, &c.ProdutoID, &c.Multiplicador, &c.Descricao, &c.DataCriacao); err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+// AddProductBarcode associa um código adicional a um produto. O código não pode ser o
+// principal de nenhum produto nem estar já associado.
+func (s *Storage) AddProductBarcode(code models.ProductBarcode) error {
+	code.Codigo = strings.TrimSpace(code.Codigo)
+	if code.Codigo == "" {
+		return errors.New("indique o código de barras")
+	}
+	if code.Multiplicador == 0 {
+		code.Multiplicador = 1
+	}
+	if code.Multiplicador < 0 {
+		return errors.New("o multiplicador tem de ser positivo")
+	}
+	sql := `
+		INSERT INTO produto_codigos_barras (codigo, produto_id, multiplicador, descricao)
+		SELECT $1, $2, $3, NULLIF($4, '')
+		WHERE NOT EXISTS (SELECT 1 FROM produtos WHERE codigo_barras = $1)
+	`
+	cmdTag, err := s.Dbpool.Exec(context.Background(), sql, code.Codigo, code.ProdutoID, code.Multiplicador, strings.TrimSpace(code.Descricao))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrBarcodeTaken
+		}
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("este código de barras já é o código principal de um produto")
+	}
+	return nil
+}
+
+// DeleteProductBarcode remove um código adicional.
+func (s *Storage) DeleteProductBarcode(code string) error {
+	_, err := s.Dbpool.Exec(context.Background(), "DELETE FROM produto_codigos_barras WHERE codigo = $1", code)
+	return err
+}
+
+// GenerateInternalBarcode devolve um código interno novo (EAN-13 com o prefixo reservado
+// gtin.InternalPrefix) para um produto sem código do fabricante. O número vem de uma
+// sequência, que nunca o repete; os números cujo código já foi registado à mão, como código
+// principal ou adicional, são saltados.
+func (s *Storage) GenerateInternalBarcode() (string, error) {
+	ctx := context.Background()
+	for {
+		var sequence int64
+		if err := s.Dbpool.QueryRow(ctx, `SELECT nextval('codigos_barras_internos_seq')`).Scan(&sequence); err != nil {
+			return "", fmt.Errorf("falha ao numerar o código interno: %w", err)
+		}
+		code, err := gtin.Internal(sequence)
+		if err != nil {
+			return "", err
+		}
+		var taken bool
+		err = s.Dbpool.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM produtos WHERE codigo_barras = $1)
+				OR EXISTS (SELECT 1 FROM produto_codigos_barras WHERE codigo = $1)
+		`, code).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+}
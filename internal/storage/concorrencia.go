@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// StockConflictError indica que o registo de stock foi alterado por outra operação
+// (uma venda, uma entrada, outra edição) desde que foi lido. Traz o valor atual para
+// o utilizador poder rever a edição.
+type StockConflictError struct {
+	QuantidadeAtual int
+	VersaoAtual     int
+}
+
+func (e *StockConflictError) Error() string {
+	return fmt.Sprintf("o stock foi alterado por outra operação entretanto; a quantidade atual é %d", e.QuantidadeAtual)
+}
+
+// currentStockConflict lê o estado atual de um registo de stock depois de uma gravação
+// condicionada à versão não ter afetado linhas. Devolve nil se o registo não existir.
+func currentStockConflict(tx pgx.Tx, productID, filialID string) *StockConflictError {
+	var conflict StockConflictError
+	sql := `SELECT quantidade, versao FROM estoque_filiais WHERE produto_id = $1 AND filial_id = $2`
+	err := tx.QueryRow(context.Background(), sql, productID, filialID).Scan(&conflict.QuantidadeAtual, &conflict.VersaoAtual)
+	if err != nil {
+		return nil
+	}
+	return &conflict
+}
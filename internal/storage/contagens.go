@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"projeto-vendas/internal/models"
+)
+
+// CreateInventoryCount abre uma sessão de contagem e congela as quantidades atuais da filial.
+// Se a secção (categoria) for indicada, apenas os produtos dessa categoria e das suas
+// subcategorias entram na fotografia.
+func (s *Storage) CreateInventoryCount(count models.InventoryCount) (uuid.UUID, error) {
+	var countID uuid.UUID
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return countID, fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	sqlCount := `
+		INSERT INTO contagens_inventario (filial_id, descricao, categoria_id, criado_por)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	err = tx.QueryRow(context.Background(), sqlCount, count.FilialID, count.Descricao, count.CategoriaID, count.CriadoPor).Scan(&countID)
+	if err != nil {
+		return countID, fmt.Errorf("falha ao criar a sessão de contagem: %w", err)
+	}
+
+	sqlSnapshot := `
+		INSERT INTO contagem_itens (contagem_id, produto_id, quantidade_esperada, custo_unitario)
+		SELECT $1, ef.produto_id, ef.quantidade, ` + sqlStockUnitCost + `
+		FROM estoque_filiais ef
+		JOIN produtos p ON ef.produto_id = p.id
+		WHERE ef.filial_id = $2 AND ` + fmt.Sprintf(sqlCategoryFilter, 3) + `
+	`
+	categoriaID := ""
+	if count.CategoriaID != nil {
+		categoriaID = count.CategoriaID.String()
+	}
+	_, err = tx.Exec(context.Background(), sqlSnapshot, countID, count.FilialID, categoriaID)
+	if err != nil {
+		return countID, fmt.Errorf("falha ao congelar as quantidades esperadas: %w", err)
+	}
+
+	return countID, tx.Commit(context.Background())
+}
+
+// sqlCountSection é o caminho da categoria a que a contagem (alias c) está limitada.
+const sqlCountSection = `COALESCE((SELECT cat.caminho FROM categorias cat WHERE cat.id = c.categoria_id), '')`
+
+// GetInventoryCounts lista as sessões de contagem, opcionalmente filtradas por filial.
+func (s *Storage) GetInventoryCounts(filialID string) ([]models.InventoryCount, error) {
+	var counts []models.InventoryCount
+	sql := `
+		SELECT c.id, c.filial_id, f.nome, COALESCE(c.descricao, ''), c.categoria_id, ` + sqlCountSection + `, c.status,
+			c.criado_por, u.nome, c.data_criacao, c.data_aprovacao,
+			(SELECT COUNT(*) FROM contagem_itens ci WHERE ci.contagem_id = c.id),
+			(SELECT COUNT(DISTINCT cl.produto_id) FROM contagem_lancamentos cl WHERE cl.contagem_id = c.id)
+		FROM contagens_inventario c
+		JOIN filiais f ON c.filial_id = f.id
+		JOIN usuarios u ON c.criado_por = u.id
+	`
+	var args []interface{}
+	if filialID != "" {
+		sql += " WHERE c.filial_id = $1"
+		args = append(args, filialID)
+	}
+	sql += " ORDER BY c.data_criacao DESC"
+
+	rows, err := s.Dbpool.Query(context.Background(), sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.InventoryCount
+		if err := rows.Scan(&c.ID, &c.FilialID, &c.FilialNome, &c.Descricao, &c.CategoriaID, &c.Secao, &c.Status,
+			&c.CriadoPor, &c.CriadoPorNome, &c.DataCriacao, &c.DataAprovacao, &c.TotalItens, &c.TotalLancados); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+// GetInventoryCountByID devolve uma sessão de contagem ou nil se não existir.
+func (s *Storage) GetInventoryCountByID(id string) (*models.InventoryCount, error) {
+	var c models.InventoryCount
+	sql := `
+		SELECT c.id, c.filial_id, f.nome, COALESCE(c.descricao, ''), c.categoria_id, ` + sqlCountSection + `, c.status,
+			c.criado_por, u.nome, c.aprovado_por, COALESCE(c.motivo_ajuste, ''), c.data_criacao, c.data_aprovacao,
+			(SELECT COUNT(*) FROM contagem_itens ci WHERE ci.contagem_id = c.id),
+			(SELECT COUNT(DISTINCT cl.produto_id) FROM contagem_lancamentos cl WHERE cl.contagem_id = c.id)
+		FROM contagens_inventario c
+		JOIN filiais f ON c.filial_id = f.id
+		JOIN usuarios u ON c.criado_por = u.id
+		WHERE c.id = $1
+	`
+	err := s.Dbpool.QueryRow(context.Background(), sql, id).Scan(&c.ID, &c.FilialID, &c.FilialNome, &c.Descricao, &c.CategoriaID, &c.Secao, &c.Status,
+		&c.CriadoPor, &c.CriadoPorNome, &c.AprovadoPor, &c.MotivoAjuste, &c.DataCriacao, &c.DataAprovacao, &c.TotalItens, &c.TotalLancados)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetInventoryCountProducts lista os produtos de uma contagem para a folha de contagem por secção.
+// Não devolve as quantidades esperadas, para que a contagem continue cega.
+func (s *Storage) GetInventoryCountProducts(countID, categoriaID string) ([]models.Product, error) {
+	var products []models.Product
+	sql := `
+		SELECT p.id, p.nome, COALESCE(p.codigo_barras, ''), COALESCE(` + sqlCategoryPath + `, '')
+		FROM contagem_itens ci
+		JOIN produtos p ON ci.produto_id = p.id
+		WHERE ci.contagem_id = $1 AND ` + fmt.Sprintf(sqlCategoryFilter, 2) + `
+		ORDER BY p.nome
+		LIMIT 500
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, countID, categoriaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.Product
+		if err := rows.Scan(&p.ID, &p.Nome, &p.CodigoBarras, &p.Categoria); err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, nil
+}
+
+// AddInventoryCountEntry regista um lançamento cego. Se o ProdutoID não vier preenchido,
+// o produto é resolvido pelo código de barras. Produtos encontrados fora da fotografia
+// (ex.: sem registo de stock na filial) são acrescentados com a quantidade atual como esperada
+// e o custo unitário do stock, como na fotografia.
+func (s *Storage) AddInventoryCountEntry(entry models.InventoryCountEntry, barcode string) error {
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var status, secao string
+	var filialID uuid.UUID
+	var categoriaID *uuid.UUID
+	sqlCount := `SELECT c.status, c.filial_id, c.categoria_id, ` + sqlCountSection + ` FROM contagens_inventario c WHERE c.id = $1`
+	err = tx.QueryRow(context.Background(), sqlCount, entry.ContagemID).Scan(&status, &filialID, &categoriaID, &secao)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.New("contagem não encontrada")
+		}
+		return err
+	}
+	if status != "aberta" {
+		return errors.New("a contagem já não aceita lançamentos")
+	}
+
+	var inSection bool
+	if entry.ProdutoID == uuid.Nil {
+		// Um código de embalagem (p.ex. a caixa de 12) conta as unidades que contém.
+		var multiplier int
+		entry.ProdutoID, multiplier, err = resolveBarcode(context.Background(), tx, barcode)
+		entry.Quantidade *= multiplier
+	}
+	if err == nil {
+		sqlProduct := `SELECT id, COALESCE($2::uuid IS NULL OR categoria_id IN (SELECT categorias_descendentes($2)), FALSE) FROM produtos WHERE id = $1`
+		err = tx.QueryRow(context.Background(), sqlProduct, entry.ProdutoID, categoriaID).Scan(&entry.ProdutoID, &inSection)
+	}
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			if barcode == "" {
+				return errors.New("produto não encontrado")
+			}
+			return fmt.Errorf("produto com o código '%s' não encontrado", barcode)
+		}
+		return err
+	}
+	if !inSection {
+		return fmt.Errorf("o produto não pertence à secção '%s' desta contagem", secao)
+	}
+	// Um kit conta-se pelos componentes; contá-lo criaria um stock próprio do kit.
+	if err := rejectKitStock(tx, entry.ProdutoID.String()); err != nil {
+		return err
+	}
+
+	sqlItem := `
+		INSERT INTO contagem_itens (contagem_id, produto_id, quantidade_esperada, custo_unitario)
+		SELECT $1, p.id, COALESCE(ef.quantidade, 0), ` + sqlStockUnitCost + `
+		FROM produtos p
+		LEFT JOIN estoque_filiais ef ON ef.produto_id = p.id AND ef.filial_id = $3
+		WHERE p.id = $2
+		ON CONFLICT (contagem_id, produto_id) DO NOTHING
+	`
+	if _, err = tx.Exec(context.Background(), sqlItem, entry.ContagemID, entry.ProdutoID, filialID); err != nil {
+		return fmt.Errorf("falha ao incluir o produto na contagem: %w", err)
+	}
+
+	sqlEntry := `
+		INSERT INTO contagem_lancamentos (contagem_id, produto_id, usuario_id, secao, quantidade)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	`
+	if _, err = tx.Exec(context.Background(), sqlEntry, entry.ContagemID, entry.ProdutoID, entry.UsuarioID, entry.Secao, entry.Quantidade); err != nil {
+		return fmt.Errorf("falha ao registar o lançamento: %w", err)
+	}
+	return tx.Commit(context.Background())
+}
+
+// GetInventoryCountEntries lista os lançamentos de uma contagem. Se userID for indicado,
+// devolve apenas os lançamentos desse utilizador.
+func (s *Storage) GetInventoryCountEntries(countID, userID string) ([]models.InventoryCountEntry, error) {
+	var entries []models.InventoryCountEntry
+	sql := `
+		SELECT cl.id, cl.contagem_id, cl.produto_id, p.nome, COALESCE(p.codigo_barras, ''), cl.usuario_id,
+			COALESCE(cl.secao, ''), cl.quantidade, cl.data_lancamento
+		FROM contagem_lancamentos cl
+		JOIN produtos p ON cl.produto_id = p.id
+		WHERE cl.contagem_id = $1
+	`
+	args := []interface{}{countID}
+	if userID != "" {
+		sql += " AND cl.usuario_id = $2"
+		args = append(args, userID)
+	}
+	sql += " ORDER BY cl.data_lancamento DESC"
+
+	rows, err := s.Dbpool.Query(context.Background(), sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.InventoryCountEntry
+		if err := rows.Scan(&e.ID, &e.ContagemID, &e.ProdutoID, &e.ProdutoNome, &e.CodigoBarras, &e.UsuarioID,
+			&e.Secao, &e.Quantidade, &e.DataLancamento); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// CloseInventoryCount encerra os lançamentos e envia a contagem para revisão do administrador.
+func (s *Storage) CloseInventoryCount(id string) error {
+	cmdTag, err := s.Dbpool.Exec(context.Background(), `UPDATE contagens_inventario SET status = 'em_revisao' WHERE id = $1 AND status = 'aberta'`, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("a contagem não está aberta (ID não encontrado?)")
+	}
+	return nil
+}
+
+// CancelInventoryCount cancela uma contagem que ainda não foi aprovada.
+func (s *Storage) CancelInventoryCount(id string) error {
+	cmdTag, err := s.Dbpool.Exec(context.Background(), `UPDATE contagens_inventario SET status = 'cancelada' WHERE id = $1 AND status IN ('aberta', 'em_revisao')`, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("a contagem já foi aprovada ou cancelada")
+	}
+	return nil
+}
+
+// GetInventoryCountVariances calcula a divergência em quantidade e em custo de cada produto da contagem.
+// Produtos sem qualquer lançamento são devolvidos com Contado = false e não entram no ajuste.
+func (s *Storage) GetInventoryCountVariances(countID string) ([]models.InventoryCountVariance, error) {
+	var variances []models.InventoryCountVariance
+	sql := `
+		SELECT ci.produto_id, p.nome, COALESCE(p.codigo_barras, ''), ci.quantidade_esperada,
+			COALESCE(SUM(cl.quantidade), 0), COUNT(cl.id) > 0, ci.custo_unitario
+		FROM contagem_itens ci
+		JOIN produtos p ON ci.produto_id = p.id
+		LEFT JOIN contagem_lancamentos cl ON cl.contagem_id = ci.contagem_id AND cl.produto_id = ci.produto_id
+		WHERE ci.contagem_id = $1
+		GROUP BY ci.produto_id, p.nome, p.codigo_barras, ci.quantidade_esperada, ci.custo_unitario
+		ORDER BY COUNT(cl.id) > 0 DESC, ABS(COALESCE(SUM(cl.quantidade), 0) - ci.quantidade_esperada) * ci.custo_unitario DESC, p.nome
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, countID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v models.InventoryCountVariance
+		if err := rows.Scan(&v.ProdutoID, &v.ProdutoNome, &v.CodigoBarras, &v.QuantidadeEsperada,
+			&v.QuantidadeContada, &v.Contado, &v.CustoUnitario); err != nil {
+			return nil, err
+		}
+		if v.Contado {
+			v.Diferenca = v.QuantidadeContada - v.QuantidadeEsperada
+			v.DiferencaValor = float64(v.Diferenca) * v.CustoUnitario
+		}
+		variances = append(variances, v)
+	}
+	return variances, nil
+}
+
+// ApproveInventoryCount lança no stock as divergências de uma contagem em revisão.
+// A diferença (contado - esperado) é somada ao stock atual, para que as vendas feitas
+// depois da abertura da contagem não sejam apagadas.
+func (s *Storage) ApproveInventoryCount(countID, approverID, reason string) error {
+	if reason == "" {
+		return errors.New("o motivo do ajuste é obrigatório")
+	}
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	var status string
+	var filialID uuid.UUID
+	err = tx.QueryRow(context.Background(), `SELECT status, filial_id FROM contagens_inventario WHERE id = $1 FOR UPDATE`, countID).Scan(&status, &filialID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errors.New("contagem não encontrada")
+		}
+		return err
+	}
+	if status != "em_revisao" {
+		return fmt.Errorf("a contagem não está pronta para aprovação (estado atual: %s)", status)
+	}
+
+	type delta struct {
+		produtoID uuid.UUID
+		diferenca int
+	}
+	var deltas []delta
+	sqlDeltas := `
+		SELECT ci.produto_id, SUM(cl.quantidade) - ci.quantidade_esperada
+		FROM contagem_itens ci
+		JOIN contagem_lancamentos cl ON cl.contagem_id = ci.contagem_id AND cl.produto_id = ci.produto_id
+		WHERE ci.contagem_id = $1
+		GROUP BY ci.produto_id, ci.quantidade_esperada
+		HAVING SUM(cl.quantidade) <> ci.quantidade_esperada
+	`
+	rows, err := tx.Query(context.Background(), sqlDeltas, countID)
+	if err != nil {
+		return fmt.Errorf("falha ao calcular as divergências: %w", err)
+	}
+	for rows.Next() {
+		var d delta
+		if err := rows.Scan(&d.produtoID, &d.diferenca); err != nil {
+			rows.Close()
+			return err
+		}
+		deltas = append(deltas, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sqlStock := `
+		INSERT INTO estoque_filiais (produto_id, filial_id, quantidade, data_atualizacao)
+		VALUES ($1, $2, GREATEST($3::int, 0), NOW())
+		ON CONFLICT (produto_id, filial_id)
+		DO UPDATE SET quantidade = GREATEST(estoque_filiais.quantidade + $3::int, 0), versao = estoque_filiais.versao + 1, data_atualizacao = NOW()
+	`
+	for _, d := range deltas {
+		if _, err := tx.Exec(context.Background(), sqlStock, d.produtoID, filialID, d.diferenca); err != nil {
+			return fmt.Errorf("falha ao ajustar o stock do produto %s: %w", d.produtoID, err)
+		}
+		if err := syncStockLots(tx, d.produtoID, filialID); err != nil {
+			return err
+		}
+	}
+
+	sqlApprove := `
+		UPDATE contagens_inventario
+		SET status = 'aprovada', aprovado_por = $1, motivo_ajuste = $2, data_aprovacao = NOW()
+		WHERE id = $3
+	`
+	if _, err := tx.Exec(context.Background(), sqlApprove, approverID, reason, countID); err != nil {
+		return fmt.Errorf("falha ao aprovar a contagem: %w", err)
+	}
+	return tx.Commit(context.Background())
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// sqlStockUnitCost é o custo unitário usado para valorizar o stock de uma linha de
+// estoque_filiais (alias ef) do produto (alias p). Por omissão usa o custo médio global;
+// com a opção custo_medio_por_filial ativa usa o custo médio da filial. Produtos que
+// ainda não tiveram entradas com custo continuam a usar o preco_custo introduzido.
+const sqlStockUnitCost = `
+	CASE WHEN COALESCE((SELECT custo_medio_por_filial FROM empresa LIMIT 1), FALSE)
+		THEN COALESCE(ef.custo_medio, p.custo_medio, p.preco_custo)
+		ELSE COALESCE(p.custo_medio, p.preco_custo)
+	END`
+
+// applyReceiptCost recalcula o custo médio ponderado de um produto com uma nova entrada:
+// (saldo atual * custo médio + quantidade recebida * custo de compra) / (saldo + quantidade).
+// Tem de ser chamada antes de o saldo ser somado em estoque_filiais. Só muda o custo médio:
+// o preço de venda fica como está até o produto ser editado, e a edição e a pré-visualização
+// do preço partem do custo médio para sugerir o novo.
+func applyReceiptCost(tx pgx.Tx, productID, filialID string, quantity int, unitCost float64) error {
+	if quantity <= 0 || unitCost <= 0 {
+		return nil
+	}
+
+	sqlBranch := `
+		UPDATE estoque_filiais ef
+		SET custo_medio = (GREATEST(ef.quantidade, 0) * COALESCE(ef.custo_medio, p.custo_medio, p.preco_custo) + $3::int * $4::numeric)
+			/ (GREATEST(ef.quantidade, 0) + $3::int)
+		FROM produtos p
+		WHERE p.id = ef.produto_id AND ef.produto_id = $1 AND ef.filial_id = $2
+	`
+	if _, err := tx.Exec(context.Background(), sqlBranch, productID, filialID, quantity, unitCost); err != nil {
+		return fmt.Errorf("falha ao recalcular o custo médio da filial: %w", err)
+	}
+
+	sqlGlobal := `
+		WITH saldo AS (
+			SELECT COALESCE(SUM(GREATEST(quantidade, 0)), 0) AS qtd
+			FROM estoque_filiais WHERE produto_id = $1
+		)
+		UPDATE produtos p
+		SET custo_medio = (s.qtd * COALESCE(p.custo_medio, p.preco_custo) + $2::int * $3::numeric) / (s.qtd + $2::int),
+			data_atualizacao = NOW()
+		FROM saldo s
+		WHERE p.id = $1
+	`
+	if _, err := tx.Exec(context.Background(), sqlGlobal, productID, quantity, unitCost); err != nil {
+		return fmt.Errorf("falha ao recalcular o custo médio do produto: %w", err)
+	}
+	return nil
+}
+
+// GetProductAverageCost devolve o custo médio global de um produto, ou 0 se ainda
+// não houve nenhuma entrada com custo.
+func (s *Storage) GetProductAverageCost(productID string) (float64, error) {
+	var avgCost float64
+	sql := `SELECT COALESCE(custo_medio, 0) FROM produtos WHERE id = $1`
+	err := s.Dbpool.QueryRow(context.Background(), sql, productID).Scan(&avgCost)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return avgCost, err
+}
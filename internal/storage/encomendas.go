@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"projeto-vendas/internal/models"
+)
+
+// CreatePurchaseOrderDrafts junta as linhas escolhidas na reposição aos rascunhos de
+// encomenda, um por fornecedor e filial: cada produto vai para o fornecedor preferencial (ou
+// o de menor custo), com o código e o último custo desse fornecedor. Um produto que já
+// estava no rascunho fica com a nova quantidade. Devolve o número de rascunhos alterados.
+func (s *Storage) CreatePurchaseOrderDrafts(lines []models.PurchaseOrderLine, userID string) (int, error) {
+	if len(lines) == 0 {
+		return 0, errors.New("escolha pelo menos um produto a encomendar")
+	}
+	productIDs := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line.Quantidade < 1 {
+			return 0, errors.New("a quantidade a encomendar tem de ser positiva")
+		}
+		productIDs = append(productIDs, line.ProdutoID.String())
+	}
+
+	ctx := context.Background()
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlPreferredSuppliers, productIDs)
+	if err != nil {
+		return 0, err
+	}
+	suppliers, err := scanPreferredSuppliers(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	type draftKey struct{ fornecedor, filial uuid.UUID }
+	drafts := make(map[draftKey]uuid.UUID)
+	for _, line := range lines {
+		supplier, ok := suppliers[line.ProdutoID.String()]
+		if !ok {
+			var nome string
+			tx.QueryRow(ctx, `SELECT nome FROM produtos WHERE id = $1`, line.ProdutoID).Scan(&nome)
+			return 0, fmt.Errorf("o produto '%s' não tem fornecedor; ligue-o a um fornecedor primeiro", nome)
+		}
+		key := draftKey{supplier.FornecedorID, line.FilialID}
+		orderID, ok := drafts[key]
+		if !ok {
+			err = tx.QueryRow(ctx, `
+				INSERT INTO encomendas (fornecedor_id, filial_id, criado_por) VALUES ($1, $2, $3)
+				ON CONFLICT (fornecedor_id, filial_id) WHERE status = 'rascunho' DO UPDATE SET fornecedor_id = EXCLUDED.fornecedor_id
+				RETURNING id
+			`, key.fornecedor, key.filial, userID).Scan(&orderID)
+			if err != nil {
+				return 0, fmt.Errorf("falha ao criar o rascunho de encomenda: %w", err)
+			}
+			drafts[key] = orderID
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO itens_encomenda (encomenda_id, produto_id, codigo_fornecedor, quantidade, custo_unitario)
+			VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5::numeric, 0))
+			ON CONFLICT (encomenda_id, produto_id) DO UPDATE SET
+				codigo_fornecedor = EXCLUDED.codigo_fornecedor,
+				quantidade = EXCLUDED.quantidade,
+				custo_unitario = EXCLUDED.custo_unitario
+		`, orderID, line.ProdutoID, supplier.CodigoFornecedor, line.Quantidade, supplier.UltimoCusto)
+		if err != nil {
+			return 0, fmt.Errorf("falha ao gravar a linha da encomenda: %w", err)
+		}
+	}
+	return len(drafts), tx.Commit(ctx)
+}
+
+// GetPurchaseOrderDrafts lista os rascunhos de encomenda por fornecedor e filial, cada um
+// com os produtos por nome.
+func (s *Storage) GetPurchaseOrderDrafts() ([]models.PurchaseOrder, error) {
+	ctx := context.Background()
+	sqlOrders := `
+		SELECT e.id, e.fornecedor_id, f.nome, e.filial_id, fl.nome, e.status, COALESCE(u.nome, ''), e.data_criacao
+		FROM encomendas e
+		JOIN fornecedores f ON f.id = e.fornecedor_id
+		JOIN filiais fl ON fl.id = e.filial_id
+		LEFT JOIN usuarios u ON u.id = e.criado_por
+		WHERE e.status = 'rascunho'
+		ORDER BY f.nome, fl.nome
+	`
+	rows, err := s.Dbpool.Query(ctx, sqlOrders)
+	if err != nil {
+		return nil, err
+	}
+	var orders []models.PurchaseOrder
+	index := make(map[uuid.UUID]int)
+	for rows.Next() {
+		var o models.PurchaseOrder
+		if err := rows.Scan(&o.ID, &o.FornecedorID, &o.FornecedorNome, &o.FilialID, &o.FilialNome, &o.Status, &o.CriadoPorNome, &o.DataCriacao); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[o.ID] = len(orders)
+		orders = append(orders, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return orders, nil
+	}
+
+	sqlItems := `
+		SELECT ie.encomenda_id, ie.produto_id, p.nome, COALESCE(p.codigo_barras, ''), COALESCE(ie.codigo_fornecedor, ''),
+			ie.quantidade, COALESCE(ie.custo_unitario, 0)::float8
+		FROM itens_encomenda ie
+		JOIN encomendas e ON e.id = ie.encomenda_id
+		JOIN produtos p ON p.id = ie.produto_id
+		WHERE e.status = 'rascunho'
+		ORDER BY p.nome
+	`
+	rows, err = s.Dbpool.Query(ctx, sqlItems)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var orderID uuid.UUID
+		var item models.PurchaseOrderItem
+		if err := rows.Scan(&orderID, &item.ProdutoID, &item.ProdutoNome, &item.CodigoBarras, &item.CodigoFornecedor, &item.Quantidade, &item.CustoUnitario); err != nil {
+			return nil, err
+		}
+		if i, ok := index[orderID]; ok {
+			item.Subtotal = float64(item.Quantidade) * item.CustoUnitario
+			orders[i].Itens = append(orders[i].Itens, item)
+			orders[i].Total += item.Subtotal
+		}
+	}
+	return orders, rows.Err()
+}
+
+// DeletePurchaseOrderDraft apaga um rascunho de encomenda e as suas linhas.
+func (s *Storage) DeletePurchaseOrderDraft(id string) error {
+	cmdTag, err := s.Dbpool.Exec(context.Background(), `DELETE FROM encomendas WHERE id = $1 AND status = 'rascunho'`, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("o rascunho de encomenda não existe")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/pricing"
+)
+
+// GetCategoryPricingStrategy devolve a estratégia de preço que os produtos da categoria
+// herdam: a dela ou a da categoria mais próxima acima. nil se nenhuma tiver (ou sem categoria).
+func (s *Storage) GetCategoryPricingStrategy(categoryID string) (*models.PricingStrategy, error) {
+	if categoryID == "" {
+		return nil, nil
+	}
+	var strategy *models.PricingStrategy
+	err := s.Dbpool.QueryRow(context.Background(), `SELECT estrategia_preco_categoria($1)`, categoryID).Scan(&strategy)
+	return strategy, err
+}
+
+// SetCategoryPricingStrategy grava a estratégia de preço própria de uma categoria; nil
+// volta a herdar da categoria acima. Os preços já gravados não mudam: a estratégia é usada
+// na próxima edição de cada produto.
+func (s *Storage) SetCategoryPricingStrategy(categoryID string, strategy *models.PricingStrategy) error {
+	if strategy != nil {
+		if err := pricing.Validate(*strategy); err != nil {
+			return err
+		}
+	}
+	cmdTag, err := s.Dbpool.Exec(context.Background(), `UPDATE categorias SET estrategia_preco = $2 WHERE id = $1`, categoryID, strategy)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("categoria não encontrada")
+	}
+	return nil
+}
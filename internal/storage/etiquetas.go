@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+
+	"projeto-vendas/internal/models"
+)
+
+// GetProductsForLabels devolve os produtos a etiquetar segundo o filtro, por nome, até
+// `limit` produtos. Produtos cujo preço nunca foi alterado contam a partir da criação.
+// Com filial, o preço é o da filial e conta a data do preço próprio, se o tiver.
+func (s *Storage) GetProductsForLabels(filter models.LabelFilter, limit int) ([]models.Product, error) {
+	var products []models.Product
+	sql := `
+		SELECT p.id, p.nome, COALESCE(` + sqlCategoryPath + `, ''), COALESCE(p.codigo_barras, ''), ` + sqlBranchPrice + `
+		FROM produtos p
+		LEFT JOIN precos_filiais pf ON pf.produto_id = p.id AND pf.filial_id::text = $3
+		WHERE p.data_arquivamento IS NULL AND (cardinality($1::text[]) = 0 OR p.id::text = ANY($1))
+		  AND ($2 = '' OR p.nome ILIKE '%' || $2 || '%' OR p.codigo_barras ILIKE '%' || $2 || '%')
+		  AND ($3 = '' OR EXISTS (SELECT 1 FROM estoque_filiais ef WHERE ef.produto_id = p.id AND ef.filial_id::text = $3))
+		  AND ($4::timestamptz IS NULL OR COALESCE(pf.data_atualizacao, p.data_alteracao_preco, p.data_criacao) >= $4)
+		ORDER BY p.nome
+		LIMIT $5
+	`
+	ids := filter.ProdutoIDs
+	if ids == nil {
+		ids = []string{}
+	}
+	rows, err := s.Dbpool.Query(context.Background(), sql, ids, filter.Busca, filter.FilialID, filter.PrecoAlteradoDesde, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.Product
+		if err := rows.Scan(&p.ID, &p.Nome, &p.Categoria, &p.CodigoBarras, &p.PrecoSugerido); err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"projeto-vendas/internal/fiscal"
+	"projeto-vendas/internal/models"
+)
+
+// ErrUnknownNCM é devolvido ao gravar um produto com um NCM que não está na tabela NCM.
+var ErrUnknownNCM = errors.New("o NCM não existe na tabela NCM (carregue a tabela com data_manager -carregar-ncm)")
+
+// sqlFiscalColumns são as colunas da classificação fiscal do produto (alias p), pela ordem
+// dos campos de models.FiscalClassification.
+const sqlFiscalColumns = `COALESCE(p.ncm, ''), COALESCE(p.cest, ''), COALESCE(p.cfop, ''), p.origem,
+	COALESCE(p.cst_icms, ''), COALESCE(p.cst_pis, ''), COALESCE(p.cst_cofins, '')`
+
+// fiscalError troca a violação da chave estrangeira do NCM por ErrUnknownNCM.
+func fiscalError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == "fk_produto_ncm" {
+		return ErrUnknownNCM
+	}
+	return err
+}
+
+// syncVariantsFiscal copia a classificação fiscal do produto pai para as variantes, que não
+// têm classificação própria.
+func syncVariantsFiscal(ctx context.Context, tx pgx.Tx, parentID string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE produtos v
+		SET ncm = pai.ncm, cest = pai.cest, cfop = pai.cfop, origem = pai.origem,
+			cst_icms = pai.cst_icms, cst_pis = pai.cst_pis, cst_cofins = pai.cst_cofins
+		FROM produtos pai
+		WHERE v.produto_pai_id = pai.id AND pai.id = $1
+	`, parentID)
+	return err
+}
+
+// LoadNCMTable grava a tabela NCM de referência (ver fiscal.ParseNCMTable): acrescenta os
+// códigos novos, atualiza as descrições e apaga os códigos extintos que nenhum produto usa.
+// Os extintos ainda em uso ficam, para não perder a classificação desses produtos; são
+// devolvidos para serem corrigidos.
+func (s *Storage) LoadNCMTable(table []models.NCM) (removed int, inUse []string, err error) {
+	if len(table) == 0 {
+		return 0, nil, errors.New("a tabela NCM está vazia")
+	}
+	codes := make([]string, len(table))
+	descriptions := make([]string, len(table))
+	for i, n := range table {
+		codes[i], descriptions[i] = n.Codigo, n.Descricao
+	}
+
+	ctx := context.Background()
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO ncm (codigo, descricao)
+		SELECT * FROM unnest($1::text[], $2::text[])
+		ON CONFLICT (codigo) DO UPDATE SET descricao = EXCLUDED.descricao, data_atualizacao = NOW()
+	`, codes, descriptions)
+	if err != nil {
+		return 0, nil, fmt.Errorf("falha ao gravar a tabela NCM: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `
+		DELETE FROM ncm n
+		WHERE NOT (n.codigo = ANY($1)) AND NOT EXISTS (SELECT 1 FROM produtos p WHERE p.ncm = n.codigo)
+	`, codes)
+	if err != nil {
+		return 0, nil, fmt.Errorf("falha ao apagar os NCM extintos: %w", err)
+	}
+	rows, err := tx.Query(ctx, `SELECT codigo FROM ncm WHERE NOT (codigo = ANY($1)) ORDER BY codigo`, codes)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return 0, nil, err
+		}
+		inUse = append(inUse, code)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	return int(tag.RowsAffected()), inUse, tx.Commit(ctx)
+}
+
+// SearchNCM procura na tabela NCM pelo início do código (com ou sem pontos) ou, se a
+// pesquisa tiver letras, pelas palavras da descrição, sem distinguir acentos.
+func (s *Storage) SearchNCM(query string, limit int) ([]models.NCM, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+	var where []string
+	var args []interface{}
+	if digits := fiscal.Digits(query); strings.Trim(digits, "0123456789") == "" {
+		where = append(where, "codigo LIKE $1::text || '%'")
+		args = append(args, digits)
+	} else {
+		for _, word := range strings.Fields(query) {
+			args = append(args, word)
+			where = append(where, fmt.Sprintf("sem_acentos(lower(descricao)) LIKE '%%' || sem_acentos(lower($%d::text)) || '%%'", len(args)))
+		}
+	}
+	args = append(args, limit)
+	sql := fmt.Sprintf(`SELECT codigo, descricao FROM ncm WHERE %s ORDER BY codigo LIMIT $%d`, strings.Join(where, " AND "), len(args))
+
+	rows, err := s.Dbpool.Query(context.Background(), sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []models.NCM
+	for rows.Next() {
+		var n models.NCM
+		if err := rows.Scan(&n.Codigo, &n.Descricao); err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, rows.Err()
+}
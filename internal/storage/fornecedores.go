@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"projeto-vendas/internal/models"
+)
+
+// sqlProductSupplierColumns são as colunas da ligação produto-fornecedor (alias pf, com o
+// fornecedor em f), pela ordem dos campos de models.ProductSupplier.
+const sqlProductSupplierColumns = `pf.produto_id, pf.fornecedor_id, f.nome, COALESCE(pf.codigo_fornecedor, ''),
+	COALESCE(pf.ultimo_custo, 0), pf.prazo_entrega_dias, pf.quantidade_minima, pf.preferencial, pf.data_atualizacao`
+
+func scanProductSupplier(row pgx.Row) (models.ProductSupplier, error) {
+	var ps models.ProductSupplier
+	err := row.Scan(&ps.ProdutoID, &ps.FornecedorID, &ps.FornecedorNome, &ps.CodigoFornecedor,
+		&ps.UltimoCusto, &ps.PrazoEntregaDias, &ps.QuantidadeMinima, &ps.Preferencial, &ps.DataAtualizacao)
+	return ps, err
+}
+
+// GetSuppliers lista os fornecedores por nome.
+func (s *Storage) GetSuppliers() ([]models.Supplier, error) {
+	rows, err := s.Dbpool.Query(context.Background(), `SELECT id, nome FROM fornecedores ORDER BY nome`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var suppliers []models.Supplier
+	for rows.Next() {
+		var f models.Supplier
+		if err := rows.Scan(&f.ID, &f.Nome); err != nil {
+			return nil, err
+		}
+		suppliers = append(suppliers, f)
+	}
+	return suppliers, rows.Err()
+}
+
+// GetProductSuppliers lista os fornecedores de um produto, o preferencial primeiro e os
+// restantes pelo custo.
+func (s *Storage) GetProductSuppliers(productID string) ([]models.ProductSupplier, error) {
+	sql := `
+		SELECT ` + sqlProductSupplierColumns + `
+		FROM produto_fornecedores pf
+		JOIN fornecedores f ON f.id = pf.fornecedor_id
+		WHERE pf.produto_id = $1
+		ORDER BY pf.preferencial DESC, pf.ultimo_custo NULLS LAST, f.nome
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var links []models.ProductSupplier
+	for rows.Next() {
+		ps, err := scanProductSupplier(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, ps)
+	}
+	return links, rows.Err()
+}
+
+// SaveProductSupplier cria ou altera a ligação de um produto a um fornecedor. O fornecedor
+// é o de FornecedorID ou, se vier vazio, o de FornecedorNome, criado se ainda não existir.
+// Marcar um fornecedor como preferencial tira a marca ao anterior.
+func (s *Storage) SaveProductSupplier(link models.ProductSupplier) error {
+	link.FornecedorNome = strings.TrimSpace(link.FornecedorNome)
+	link.CodigoFornecedor = strings.TrimSpace(link.CodigoFornecedor)
+	if link.QuantidadeMinima == 0 {
+		link.QuantidadeMinima = 1
+	}
+	if link.UltimoCusto < 0 || link.PrazoEntregaDias < 0 || link.QuantidadeMinima < 0 {
+		return errors.New("o custo, o prazo de entrega e a quantidade mínima não podem ser negativos")
+	}
+
+	ctx := context.Background()
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	supplierID := link.FornecedorID.String()
+	if link.FornecedorID == uuid.Nil {
+		if link.FornecedorNome == "" {
+			return errors.New("indique o fornecedor")
+		}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO fornecedores (nome) VALUES ($1)
+			ON CONFLICT (nome) DO UPDATE SET nome = EXCLUDED.nome
+			RETURNING id::text
+		`, link.FornecedorNome).Scan(&supplierID)
+		if err != nil {
+			return fmt.Errorf("falha ao gravar o fornecedor: %w", err)
+		}
+	}
+	if link.Preferencial {
+		_, err = tx.Exec(ctx, `UPDATE produto_fornecedores SET preferencial = FALSE WHERE produto_id = $1 AND fornecedor_id <> $2 AND preferencial`, link.ProdutoID, supplierID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO produto_fornecedores (produto_id, fornecedor_id, codigo_fornecedor, ultimo_custo, prazo_entrega_dias, quantidade_minima, preferencial)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4::numeric, 0), $5, $6, $7)
+		ON CONFLICT (produto_id, fornecedor_id) DO UPDATE SET
+			codigo_fornecedor = EXCLUDED.codigo_fornecedor,
+			ultimo_custo = EXCLUDED.ultimo_custo,
+			prazo_entrega_dias = EXCLUDED.prazo_entrega_dias,
+			quantidade_minima = EXCLUDED.quantidade_minima,
+			preferencial = EXCLUDED.preferencial,
+			data_atualizacao = NOW()
+	`, link.ProdutoID, supplierID, link.CodigoFornecedor, link.UltimoCusto, link.PrazoEntregaDias, link.QuantidadeMinima, link.Preferencial)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_fornecedor_codigo" {
+			return fmt.Errorf("o fornecedor já usa o código %s noutro produto", link.CodigoFornecedor)
+		}
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RemoveProductSupplier desliga um fornecedor de um produto; o fornecedor continua na lista.
+func (s *Storage) RemoveProductSupplier(productID, supplierID string) error {
+	_, err := s.Dbpool.Exec(context.Background(), `DELETE FROM produto_fornecedores WHERE produto_id = $1 AND fornecedor_id = $2`, productID, supplierID)
+	return err
+}
+
+// sqlPreferredSuppliers escolhe, para cada produto de $1 com fornecedores, o fornecedor a
+// quem comprar: o preferencial ou, sem preferencial, o de menor custo.
+const sqlPreferredSuppliers = `
+	SELECT DISTINCT ON (pf.produto_id) ` + sqlProductSupplierColumns + `
+	FROM produto_fornecedores pf
+	JOIN fornecedores f ON f.id = pf.fornecedor_id
+	WHERE pf.produto_id = ANY($1::uuid[])
+	ORDER BY pf.produto_id, pf.preferencial DESC, pf.ultimo_custo NULLS LAST, pf.prazo_entrega_dias, f.nome
+`
+
+// GetPreferredSuppliers devolve, para cada produto com fornecedores, o fornecedor a quem
+// comprar: o preferencial ou, sem preferencial, o de menor custo. A reposição usa-o para
+// arredondar a sugestão e estimar o custo, e CreatePurchaseOrderDrafts para escolher o
+// fornecedor de cada linha; a chave é o ID do produto.
+func (s *Storage) GetPreferredSuppliers(productIDs []string) (map[string]models.ProductSupplier, error) {
+	if len(productIDs) == 0 {
+		return make(map[string]models.ProductSupplier), nil
+	}
+	rows, err := s.Dbpool.Query(context.Background(), sqlPreferredSuppliers, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	return scanPreferredSuppliers(rows)
+}
+
+func scanPreferredSuppliers(rows pgx.Rows) (map[string]models.ProductSupplier, error) {
+	defer rows.Close()
+	result := make(map[string]models.ProductSupplier)
+	for rows.Next() {
+		ps, err := scanProductSupplier(rows)
+		if err != nil {
+			return nil, err
+		}
+		result[ps.ProdutoID.String()] = ps
+	}
+	return result, rows.Err()
+}
+
+// recordSupplierReceipt guarda o custo de uma entrada (já por unidade de venda) como o
+// último custo do fornecedor, ligando-o ao produto se ainda não estava.
+func recordSupplierReceipt(tx pgx.Tx, productID string, lot models.StockLot) error {
+	if lot.FornecedorID == nil {
+		return nil
+	}
+	_, err := tx.Exec(context.Background(), `
+		INSERT INTO produto_fornecedores (produto_id, fornecedor_id, ultimo_custo)
+		VALUES ($1, $2, NULLIF($3::numeric, 0))
+		ON CONFLICT (produto_id, fornecedor_id) DO UPDATE SET
+			ultimo_custo = COALESCE(EXCLUDED.ultimo_custo, produto_fornecedores.ultimo_custo),
+			data_atualizacao = NOW()
+	`, productID, *lot.FornecedorID, lot.CustoUnitario)
+	if err != nil {
+		return fmt.Errorf("falha ao registar o custo do fornecedor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"projeto-vendas/internal/models"
+)
+
+// Origens das alterações registadas em historico_precos.
+const (
+	PriceOriginManual     = "manual"
+	PriceOriginReceipt    = "entrada"
+	PriceOriginBranch     = "filial"
+	PriceOriginPercentage = "ajuste_percentual"
+	PriceOriginScheduled  = "agendamento"
+	PriceOriginImport     = "importacao"
+)
+
+// setPriceAudit indica ao trigger do histórico de preços quem faz as alterações desta
+// transação e porquê. userID vazio fica registado sem utilizador.
+func setPriceAudit(ctx context.Context, tx pgx.Tx, userID, origem string) error {
+	_, err := tx.Exec(ctx, `SELECT set_config('app.usuario_id', $1, true), set_config('app.origem_preco', $2, true)`, userID, origem)
+	if err != nil {
+		return fmt.Errorf("falha ao preparar o histórico de preços: %w", err)
+	}
+	return nil
+}
+
+// execAudited executa uma alteração de preços numa transação própria, com o utilizador
+// e a origem registados no histórico.
+func (s *Storage) execAudited(userID, origem, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx := context.Background()
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := setPriceAudit(ctx, tx, userID, origem); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	cmdTag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return cmdTag, err
+	}
+	return cmdTag, tx.Commit(ctx)
+}
+
+// sqlPriceHistory seleciona o histórico com os nomes do produto, da filial e do utilizador.
+const sqlPriceHistory = `
+	SELECT h.id, h.produto_id, p.nome, COALESCE(p.codigo_barras, ''), h.filial_id, COALESCE(f.nome, ''), h.campo,
+		h.valor_anterior::float8, h.valor_novo::float8, COALESCE(u.nome, ''), h.origem, h.data_alteracao
+	FROM historico_precos h
+	JOIN produtos p ON h.produto_id = p.id
+	LEFT JOIN filiais f ON h.filial_id = f.id
+	LEFT JOIN usuarios u ON h.usuario_id = u.id
+`
+
+func scanPriceHistory(rows pgx.Rows) ([]models.PriceHistoryEntry, error) {
+	defer rows.Close()
+	var entries []models.PriceHistoryEntry
+	for rows.Next() {
+		var e models.PriceHistoryEntry
+		if err := rows.Scan(&e.ID, &e.ProdutoID, &e.ProdutoNome, &e.CodigoBarras, &e.FilialID, &e.FilialNome, &e.Campo,
+			&e.ValorAnterior, &e.ValorNovo, &e.UsuarioNome, &e.Origem, &e.DataAlteracao); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetPriceHistory devolve as alterações de preço e de custo de um produto, das mais
+// recentes para as mais antigas.
+func (s *Storage) GetPriceHistory(productID string, limit int) ([]models.PriceHistoryEntry, error) {
+	sql := sqlPriceHistory + `
+		WHERE h.produto_id = $1
+		ORDER BY h.data_alteracao DESC, h.campo
+		LIMIT $2
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, productID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanPriceHistory(rows)
+}
+
+// GetPriceChangesOn devolve as alterações de preço de venda feitas no dia indicado, para
+// reimprimir as etiquetas. Com filial, inclui as do preço de catálogo e as dessa filial.
+func (s *Storage) GetPriceChangesOn(day time.Time, filialID string) ([]models.PriceHistoryEntry, error) {
+	sql := sqlPriceHistory + `
+		WHERE h.campo IN ('preco', 'preco_filial')
+		  AND h.data_alteracao >= $1::date AND h.data_alteracao < $1::date + 1
+		  AND ($2 = '' OR h.filial_id IS NULL OR h.filial_id::text = $2)
+		ORDER BY p.nome, h.data_alteracao
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, day, filialID)
+	if err != nil {
+		return nil, err
+	}
+	return scanPriceHistory(rows)
+}
+
+// SchedulePriceChange agenda um novo preço para uma data, hoje ou futura. A tarefa
+// periódica da API aplica-o a partir desse dia.
+func (s *Storage) SchedulePriceChange(change models.ScheduledPriceChange) (string, error) {
+	if change.Preco < 0 {
+		return "", errors.New("o preço não pode ser negativo")
+	}
+	var id string
+	sql := `
+		INSERT INTO precos_agendados (produto_id, filial_id, preco, data_efetiva, usuario_id)
+		SELECT $1, $2, ROUND($3::numeric, 2), $4::date, $5
+		WHERE $4::date >= CURRENT_DATE
+		RETURNING id
+	`
+	err := s.Dbpool.QueryRow(context.Background(), sql, change.ProdutoID, change.FilialID, change.Preco, change.DataEfetiva, change.UsuarioID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", errors.New("a data efetiva não pode ser no passado")
+	}
+	return id, err
+}
+
+// CancelScheduledPriceChange cancela uma alteração que ainda não foi aplicada; cancelar
+// uma que falhou tira-a da lista.
+func (s *Storage) CancelScheduledPriceChange(id string) error {
+	cmdTag, err := s.Dbpool.Exec(context.Background(), `UPDATE precos_agendados SET status = 'cancelada' WHERE id = $1 AND status IN ('pendente', 'falhou')`, id)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("a alteração não existe ou já foi aplicada ou cancelada")
+	}
+	return nil
+}
+
+// GetPendingPriceChanges lista as alterações pendentes e as que falharam, com o motivo, por
+// data efetiva; com day, só as desse dia.
+func (s *Storage) GetPendingPriceChanges(day *time.Time) ([]models.ScheduledPriceChange, error) {
+	var changes []models.ScheduledPriceChange
+	sql := `
+		SELECT a.id, a.produto_id, p.nome, COALESCE(p.codigo_barras, ''), a.filial_id, COALESCE(f.nome, ''),
+			a.preco, ` + sqlBranchPrice + `, a.data_efetiva, a.status, COALESCE(a.erro, ''), a.usuario_id, u.nome, a.data_criacao
+		FROM precos_agendados a
+		JOIN produtos p ON a.produto_id = p.id
+		JOIN usuarios u ON a.usuario_id = u.id
+		LEFT JOIN filiais f ON a.filial_id = f.id
+		LEFT JOIN precos_filiais pf ON pf.produto_id = a.produto_id AND pf.filial_id = a.filial_id
+		WHERE a.status IN ('pendente', 'falhou') AND ($1::date IS NULL OR a.data_efetiva = $1::date)
+		ORDER BY a.data_efetiva, p.nome
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.ScheduledPriceChange
+		if err := rows.Scan(&c.ID, &c.ProdutoID, &c.ProdutoNome, &c.CodigoBarras, &c.FilialID, &c.FilialNome,
+			&c.Preco, &c.PrecoAtual, &c.DataEfetiva, &c.Status, &c.Erro, &c.UsuarioID, &c.UsuarioNome, &c.DataCriacao); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// ApplyScheduledPriceChanges aplica as alterações pendentes com data efetiva até ao dia de
+// now, pela ordem em que foram agendadas; o histórico fica em nome de quem as agendou.
+// Uma alteração que a base de dados recusa fica com o status 'falhou' e o motivo, sem
+// impedir as restantes. Devolve o número de alterações aplicadas.
+func (s *Storage) ApplyScheduledPriceChanges(now time.Time) (int, error) {
+	ctx := context.Background()
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	sqlDue := `
+		SELECT id, produto_id, filial_id, preco::float8, usuario_id
+		FROM precos_agendados
+		WHERE status = 'pendente' AND data_efetiva <= $1::date
+		ORDER BY data_efetiva, data_criacao
+		FOR UPDATE SKIP LOCKED
+	`
+	rows, err := tx.Query(ctx, sqlDue, now)
+	if err != nil {
+		return 0, err
+	}
+	var due []models.ScheduledPriceChange
+	for rows.Next() {
+		var c models.ScheduledPriceChange
+		if err := rows.Scan(&c.ID, &c.ProdutoID, &c.FilialID, &c.Preco, &c.UsuarioID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, c := range due {
+		// Cada alteração tem o seu savepoint: uma violação de restrição só falha essa alteração.
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return 0, err
+		}
+		err = applyScheduledPriceChange(ctx, sp, c)
+		if err == nil {
+			err = sp.Commit(ctx)
+		}
+		if err != nil {
+			sp.Rollback(ctx)
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) {
+				return 0, fmt.Errorf("falha ao aplicar a alteração de preço %s: %w", c.ID, err)
+			}
+			if _, err := tx.Exec(ctx, `UPDATE precos_agendados SET status = 'falhou', erro = $2 WHERE id = $1`, c.ID, pgErr.Message); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		applied++
+	}
+	return applied, tx.Commit(ctx)
+}
+
+// applyScheduledPriceChange aplica uma alteração agendada e marca-a como aplicada.
+func applyScheduledPriceChange(ctx context.Context, tx pgx.Tx, c models.ScheduledPriceChange) error {
+	if err := setPriceAudit(ctx, tx, c.UsuarioID.String(), PriceOriginScheduled); err != nil {
+		return err
+	}
+	var err error
+	if c.FilialID == nil {
+		_, err = tx.Exec(ctx, `
+			UPDATE produtos
+			SET data_alteracao_preco = CASE WHEN preco_sugerido <> $2 THEN NOW() ELSE data_alteracao_preco END,
+				preco_sugerido = $2, data_atualizacao = NOW(),
+				preco_variante = CASE WHEN produto_pai_id IS NOT NULL THEN $2 END
+			WHERE id = $1
+		`, c.ProdutoID, c.Preco)
+	} else {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO precos_filiais (produto_id, filial_id, preco) VALUES ($1, $2, $3)
+			ON CONFLICT (produto_id, filial_id) DO UPDATE SET preco = EXCLUDED.preco, data_atualizacao = NOW()
+		`, c.ProdutoID, *c.FilialID, c.Preco)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE precos_agendados SET status = 'aplicada', data_aplicacao = NOW() WHERE id = $1`, c.ID)
+	return err
+}
+
+// auditUser converte o ID de um utilizador para setPriceAudit; uuid.Nil fica vazio.
+func auditUser(id uuid.UUID) string {
+	if id == uuid.Nil {
+		return ""
+	}
+	return id.String()
+}
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SetProductImage grava a chave da imagem do produto (vazia para a remover) e devolve a
+// chave anterior, para que os ficheiros antigos sejam apagados do armazenamento de imagens.
+func (s *Storage) SetProductImage(productID, key string) (string, error) {
+	var previous string
+	sql := `
+		UPDATE produtos p SET imagem = NULLIF($2, ''), data_atualizacao = NOW()
+		FROM (SELECT id, imagem FROM produtos WHERE id = $1 FOR UPDATE) antes
+		WHERE p.id = antes.id
+		RETURNING COALESCE(antes.imagem, '')
+	`
+	err := s.Dbpool.QueryRow(context.Background(), sql, productID, key).Scan(&previous)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", errors.New("produto não encontrado")
+	}
+	return previous, err
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"projeto-vendas/internal/models"
+)
+
+// DefaultImportChunkSize é o número de linhas gravadas em cada transação da importação.
+const DefaultImportChunkSize = 500
+
+// GetProductsForExport devolve o catálogo completo, sem as variantes (que herdam os
+// dados do produto pai) nem os produtos arquivados, ordenado pelo nome.
+func (s *Storage) GetProductsForExport() ([]models.Product, error) {
+	var products []models.Product
+	sql := `
+		SELECT p.id, p.nome, COALESCE(p.descricao, ''), p.categoria_id, COALESCE(` + sqlCategoryPath + `, ''),
+			COALESCE(p.codigo_barras, ''), COALESCE(p.codigo_cnae, ''), p.preco_custo, p.percentual_lucro,
+			p.imposto_estadual, p.imposto_federal, p.preco_sugerido, ` + sqlFiscalColumns + `
+		FROM produtos p
+		WHERE p.produto_pai_id IS NULL AND p.data_arquivamento IS NULL
+		ORDER BY p.nome
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p models.Product
+		if err := rows.Scan(&p.ID, &p.Nome, &p.Descricao, &p.CategoriaID, &p.Categoria, &p.CodigoBarras, &p.CodigoCNAE,
+			&p.PrecoCusto, &p.PercentualLucro, &p.ImpostoEstadual, &p.ImpostoFederal, &p.PrecoSugerido,
+			&p.NCM, &p.CEST, &p.CFOP, &p.Origem, &p.CSTICMS, &p.CSTPIS, &p.CSTCOFINS); err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+// ImportProducts grava as linhas do ficheiro por código de barras: cria os produtos novos
+// e atualiza os existentes. As linhas são gravadas em transações de chunkSize linhas e
+// uma linha com erro é rejeitada sem afetar as restantes. Com dryRun cada bloco é
+// desfeito no fim, por isso o relatório indica exatamente o que a importação faria.
+// progress, se indicado, recebe o número de linhas tratadas no fim de cada bloco. Um erro
+// a meio deixa gravados os blocos anteriores, que constam do relatório devolvido.
+func (s *Storage) ImportProducts(rows []models.ProductImportRow, userID string, dryRun bool, chunkSize int, progress func(done int)) (models.ProductImportReport, error) {
+	report := models.ProductImportReport{Simulacao: dryRun}
+	if chunkSize <= 0 {
+		chunkSize = DefaultImportChunkSize
+	}
+	ctx := context.Background()
+
+	categories, err := s.GetCategories()
+	if err != nil {
+		return report, fmt.Errorf("falha ao obter as categorias: %w", err)
+	}
+	categoryByPath := make(map[string]uuid.UUID, len(categories))
+	for _, c := range categories {
+		categoryByPath[strings.ToLower(c.Caminho)] = c.ID
+	}
+
+	for start := 0; start < len(rows); start += chunkSize {
+		end := start + chunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		results, err := s.importChunk(ctx, rows[start:end], categoryByPath, userID, dryRun)
+		if err != nil {
+			return report, fmt.Errorf("falha ao importar as linhas %d a %d: %w", rows[start].Linha, rows[end-1].Linha, err)
+		}
+		for _, r := range results {
+			switch r.Acao {
+			case models.ImportActionCreate:
+				report.Criados++
+			case models.ImportActionUpdate:
+				report.Atualizados++
+			default:
+				report.Rejeitados++
+			}
+		}
+		report.Resultados = append(report.Resultados, results...)
+		if progress != nil {
+			progress(end)
+		}
+	}
+	return report, nil
+}
+
+func (s *Storage) importChunk(ctx context.Context, rows []models.ProductImportRow, categoryByPath map[string]uuid.UUID, userID string, dryRun bool) ([]models.ProductImportResult, error) {
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := setPriceAudit(ctx, tx, userID, PriceOriginImport); err != nil {
+		return nil, err
+	}
+
+	results := make([]models.ProductImportResult, 0, len(rows))
+	for _, row := range rows {
+		p := row.Produto
+		result := models.ProductImportResult{Linha: row.Linha, CodigoBarras: p.CodigoBarras, Nome: p.Nome}
+		if p.Categoria != "" {
+			id, ok := categoryByPath[strings.ToLower(p.Categoria)]
+			if !ok {
+				result.Acao, result.Erro = models.ImportActionReject, fmt.Sprintf("a categoria '%s' não existe", p.Categoria)
+				results = append(results, result)
+				continue
+			}
+			p.CategoriaID = &id
+		}
+
+		// Cada linha tem o seu savepoint: uma violação de restrição só rejeita essa linha.
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		action, err := importProduct(ctx, sp, p, row)
+		if err == nil {
+			err = sp.Commit(ctx)
+		}
+		if err != nil {
+			sp.Rollback(ctx)
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) && !errors.Is(err, errImportRow) {
+				return nil, err
+			}
+			result.Acao, result.Erro = models.ImportActionReject, importRowError(err)
+		} else {
+			result.Acao = action
+		}
+		results = append(results, result)
+	}
+	if dryRun {
+		return results, nil
+	}
+	return results, tx.Commit(ctx)
+}
+
+// errImportRow marca os erros de uma linha que a rejeitam sem interromper a importação.
+var errImportRow = errors.New("linha rejeitada")
+
+// importProduct cria ou atualiza o produto com o código de barras da linha. Sem
+// row.ComFiscal (o ficheiro não tem as colunas fiscais) a classificação fiscal de um produto
+// existente fica como está. Um código que não é GTIN só é aceite num produto existente.
+func importProduct(ctx context.Context, tx pgx.Tx, p models.Product, row models.ProductImportRow) (string, error) {
+	var productID uuid.UUID
+	var isVariant bool
+	err := tx.QueryRow(ctx, `SELECT id, produto_pai_id IS NOT NULL FROM produtos WHERE codigo_barras = $1`, p.CodigoBarras).Scan(&productID, &isVariant)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		var isExtra bool
+		if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM produto_codigos_barras WHERE codigo = $1)`, p.CodigoBarras).Scan(&isExtra); err != nil {
+			return "", err
+		}
+		if isExtra {
+			return "", fmt.Errorf("%w: o código de barras é um código adicional de outro produto", errImportRow)
+		}
+		if row.ErroCodigoBarras != "" {
+			return "", fmt.Errorf("%w: %s", errImportRow, row.ErroCodigoBarras)
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO produtos (nome, descricao, categoria_id, codigo_barras, codigo_cnae, preco_custo, percentual_lucro, imposto_estadual, imposto_federal, preco_sugerido,
+				ncm, cest, cfop, origem, cst_icms, cst_pis, cst_cofins)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), NULLIF($12, ''), NULLIF($13, ''), $14, NULLIF($15, ''), NULLIF($16, ''), NULLIF($17, ''))
+		`, p.Nome, p.Descricao, p.CategoriaID, p.CodigoBarras, p.CodigoCNAE, p.PrecoCusto, p.PercentualLucro, p.ImpostoEstadual, p.ImpostoFederal, p.PrecoSugerido,
+			p.NCM, p.CEST, p.CFOP, p.Origem, p.CSTICMS, p.CSTPIS, p.CSTCOFINS)
+		return models.ImportActionCreate, err
+	case err != nil:
+		return "", err
+	case isVariant:
+		return "", fmt.Errorf("%w: o código de barras é de uma variante; altere-a na página do produto", errImportRow)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE produtos SET
+			nome = $1, descricao = $2, categoria_id = $3, codigo_cnae = $4, preco_custo = $5,
+			percentual_lucro = $6, imposto_estadual = $7, imposto_federal = $8, preco_sugerido = $9,
+			data_alteracao_preco = CASE WHEN preco_sugerido <> $9 THEN NOW() ELSE data_alteracao_preco END,
+			data_atualizacao = NOW()
+		WHERE id = $10
+	`, p.Nome, p.Descricao, p.CategoriaID, p.CodigoCNAE, p.PrecoCusto, p.PercentualLucro, p.ImpostoEstadual, p.ImpostoFederal, p.PrecoSugerido, productID)
+	if err != nil {
+		return "", err
+	}
+	if err := renameVariants(ctx, tx, productID.String()); err != nil {
+		return "", err
+	}
+	if row.ComFiscal {
+		_, err = tx.Exec(ctx, `
+			UPDATE produtos SET
+				ncm = NULLIF($2, ''), cest = NULLIF($3, ''), cfop = NULLIF($4, ''), origem = $5,
+				cst_icms = NULLIF($6, ''), cst_pis = NULLIF($7, ''), cst_cofins = NULLIF($8, '')
+			WHERE id = $1
+		`, productID, p.NCM, p.CEST, p.CFOP, p.Origem, p.CSTICMS, p.CSTPIS, p.CSTCOFINS)
+		if err != nil {
+			return "", err
+		}
+		if err := syncVariantsFiscal(ctx, tx, productID.String()); err != nil {
+			return "", err
+		}
+	}
+	return models.ImportActionUpdate, nil
+}
+
+// importRowError descreve o motivo da rejeição de uma linha.
+func importRowError(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			if pgErr.ConstraintName == "codigo_barras_repetido" {
+				return ErrBarcodeTaken.Error()
+			}
+			if strings.Contains(pgErr.ConstraintName, "nome") {
+				return "já existe outro produto com este nome"
+			}
+			return "valor repetido: " + pgErr.Detail
+		case "22001", "22003":
+			return "valor demasiado grande para o campo"
+		case "23503":
+			if pgErr.ConstraintName == "fk_produto_ncm" {
+				return ErrUnknownNCM.Error()
+			}
+		}
+		return pgErr.Message
+	}
+	return strings.TrimPrefix(err.Error(), errImportRow.Error()+": ")
+}
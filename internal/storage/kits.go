@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"projeto-vendas/internal/models"
+)
+
+// ErrKitStock é devolvido quando se tenta dar entrada de stock num kit.
+var ErrKitStock = errors.New("um kit não tem stock próprio: o stock é o dos componentes")
+
+// sqlUnitsSold são as unidades que saíram de cada produto em cada venda (colunas venda_id,
+// produto_id e quantidade): as vendidas diretamente e as consumidas como componente de um
+// kit. Substitui itens_venda onde interessa a procura de cada produto com stock.
+const sqlUnitsSold = `(
+	SELECT venda_id, produto_id, quantidade FROM itens_venda
+	UNION ALL
+	SELECT iv.venda_id, ivc.produto_id, ivc.quantidade
+	FROM itens_venda_componentes ivc
+	JOIN itens_venda iv ON iv.id = ivc.item_venda_id
+)`
+
+// sqlKitAvailable é o número de kits (alias p) que o stock dos componentes permite montar
+// na filial %s: o mínimo, entre os componentes, de stock / unidades por kit.
+const sqlKitAvailable = `(
+	SELECT COALESCE(MIN(GREATEST(COALESCE(kef.quantidade, 0), 0) / kc.quantidade), 0)
+	FROM kit_componentes kc
+	LEFT JOIN estoque_filiais kef ON kef.produto_id = kc.componente_id AND kef.filial_id = %s
+	WHERE kc.kit_id = p.id
+)`
+
+// rejectKitStock impede registos de stock próprios num kit.
+func rejectKitStock(tx pgx.Tx, productID string) error {
+	var isKit bool
+	err := tx.QueryRow(context.Background(), `SELECT kit FROM produtos WHERE id = $1`, productID).Scan(&isKit)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if isKit {
+		return ErrKitStock
+	}
+	return nil
+}
+
+// sellKitItem regista a venda de quantity kits na transação da venda: grava o item com o
+// custo somado dos componentes, para que a margem do kit seja a real, e dá baixa no stock
+// e nos lotes de cada componente. Falta de stock de um componente anula a venda inteira.
+func sellKitItem(tx pgx.Tx, saleID uuid.UUID, item models.ItemVenda, filialID uuid.UUID) error {
+	ctx := context.Background()
+	sqlItem := `
+		INSERT INTO itens_venda (venda_id, produto_id, quantidade, preco_unitario, custo_unitario)
+		SELECT $1::uuid, $2::uuid, $3::int, $4::numeric, COALESCE(SUM(kc.quantidade * ` + sqlStockUnitCost + `), 0)
+		FROM kit_componentes kc
+		JOIN produtos p ON p.id = kc.componente_id
+		LEFT JOIN estoque_filiais ef ON ef.produto_id = p.id AND ef.filial_id = $5
+		WHERE kc.kit_id = $2
+		HAVING COUNT(*) > 0
+		RETURNING id
+	`
+	var itemID uuid.UUID
+	err := tx.QueryRow(ctx, sqlItem, saleID, item.ProdutoID, item.Quantidade, item.PrecoUnitario, filialID).Scan(&itemID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("o kit %s não tem componentes", item.ProdutoID)
+	}
+	if err != nil {
+		return fmt.Errorf("erro ao inserir item %s: %w", item.ProdutoID, err)
+	}
+
+	sqlComponents := `
+		INSERT INTO itens_venda_componentes (item_venda_id, produto_id, quantidade, custo_unitario)
+		SELECT $1, p.id, kc.quantidade * $3, ` + sqlStockUnitCost + `
+		FROM kit_componentes kc
+		JOIN produtos p ON p.id = kc.componente_id
+		LEFT JOIN estoque_filiais ef ON ef.produto_id = p.id AND ef.filial_id = $4
+		WHERE kc.kit_id = $2
+		RETURNING produto_id, quantidade
+	`
+	rows, err := tx.Query(ctx, sqlComponents, itemID, item.ProdutoID, item.Quantidade, filialID)
+	if err != nil {
+		return fmt.Errorf("erro ao registar os componentes do kit %s: %w", item.ProdutoID, err)
+	}
+	type usage struct {
+		produtoID  uuid.UUID
+		quantidade int
+	}
+	var used []usage
+	for rows.Next() {
+		var u usage
+		if err := rows.Scan(&u.produtoID, &u.quantidade); err != nil {
+			rows.Close()
+			return err
+		}
+		used = append(used, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sqlStock := `
+		UPDATE estoque_filiais SET quantidade = quantidade - $1, versao = versao + 1
+		WHERE produto_id = $2 AND filial_id = $3 AND quantidade >= $1
+	`
+	for _, u := range used {
+		cmdTag, err := tx.Exec(ctx, sqlStock, u.quantidade, u.produtoID, filialID)
+		if err != nil {
+			return fmt.Errorf("erro ao dar baixa no stock do componente %s: %w", u.produtoID, err)
+		}
+		if cmdTag.RowsAffected() == 0 {
+			return fmt.Errorf("stock insuficiente do componente %s do kit %s na filial %s", u.produtoID, item.ProdutoID, filialID)
+		}
+		if err := consumeStockLots(tx, u.produtoID, filialID, u.quantidade); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetKitComponents lista os componentes de um kit com o custo unitário atual de cada um.
+func (s *Storage) GetKitComponents(kitID string) ([]models.KitComponent, error) {
+	var components []models.KitComponent
+	sql := `
+		SELECT p.id, p.nome, COALESCE(p.codigo_barras, ''), kc.quantidade, COALESCE(p.custo_medio, p.preco_custo)
+		FROM kit_componentes kc
+		JOIN produtos p ON p.id = kc.componente_id
+		WHERE kc.kit_id = $1
+		ORDER BY p.nome
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, kitID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.KitComponent
+		if err := rows.Scan(&c.ProdutoID, &c.Nome, &c.CodigoBarras, &c.Quantidade, &c.CustoUnitario); err != nil {
+			return nil, err
+		}
+		components = append(components, c)
+	}
+	return components, rows.Err()
+}
+
+// SetKitComponent define as unidades por kit do produto com o código de barras indicado;
+// um código de embalagem conta o seu multiplicador. O primeiro componente torna o produto
+// um kit, o que só é possível num produto sem stock próprio e sem variantes.
+func (s *Storage) SetKitComponent(kitID, barcode string, quantity int) error {
+	barcode = strings.TrimSpace(barcode)
+	if barcode == "" {
+		return errors.New("indique o código de barras do componente")
+	}
+	if quantity < 1 {
+		return errors.New("a quantidade por kit tem de ser positiva")
+	}
+	ctx := context.Background()
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var isVariant, hasVariants, isComponent bool
+	var stock int
+	sqlKit := `
+		SELECT p.produto_pai_id IS NOT NULL,
+			EXISTS (SELECT 1 FROM produtos v WHERE v.produto_pai_id = p.id),
+			EXISTS (SELECT 1 FROM kit_componentes kc WHERE kc.componente_id = p.id),
+			COALESCE((SELECT SUM(ef.quantidade) FROM estoque_filiais ef WHERE ef.produto_id = p.id), 0)
+		FROM produtos p
+		WHERE p.id = $1
+		FOR UPDATE
+	`
+	err = tx.QueryRow(ctx, sqlKit, kitID).Scan(&isVariant, &hasVariants, &isComponent, &stock)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("produto não encontrado")
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case isVariant || hasVariants:
+		return errors.New("uma variante ou um produto com variantes não pode ser um kit")
+	case isComponent:
+		return errors.New("este produto é componente de outro kit e não pode ser um kit")
+	case stock > 0:
+		return errors.New("o produto tem stock próprio; um kit só usa o stock dos componentes")
+	}
+
+	componentID, multiplier, err := resolveBarcode(ctx, tx, barcode)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("nenhum produto tem o código %s", barcode)
+	}
+	if err != nil {
+		return err
+	}
+	if componentID.String() == kitID {
+		return errors.New("um kit não pode ser componente de si próprio")
+	}
+	var componentIsKit, componentHasVariants, archived bool
+	sqlComponent := `
+		SELECT p.kit, EXISTS (SELECT 1 FROM produtos v WHERE v.produto_pai_id = p.id), p.data_arquivamento IS NOT NULL
+		FROM produtos p
+		WHERE p.id = $1
+	`
+	if err := tx.QueryRow(ctx, sqlComponent, componentID).Scan(&componentIsKit, &componentHasVariants, &archived); err != nil {
+		return err
+	}
+	switch {
+	case componentIsKit:
+		return errors.New("um kit não pode ser componente de outro kit")
+	case componentHasVariants:
+		return errors.New("o produto tem variantes: indique o código da variante")
+	case archived:
+		return errors.New("o produto está arquivado")
+	}
+
+	sqlUpsert := `
+		INSERT INTO kit_componentes (kit_id, componente_id, quantidade)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (kit_id, componente_id) DO UPDATE SET quantidade = EXCLUDED.quantidade
+	`
+	if _, err := tx.Exec(ctx, sqlUpsert, kitID, componentID, quantity*multiplier); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE produtos SET kit = TRUE WHERE id = $1`, kitID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RemoveKitComponent tira um componente do kit. Sem componentes, o produto deixa de ser um kit.
+func (s *Storage) RemoveKitComponent(kitID, componentID string) error {
+	ctx := context.Background()
+	tx, err := s.Dbpool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `DELETE FROM kit_componentes WHERE kit_id = $1 AND componente_id = $2`, kitID, componentID); err != nil {
+		return err
+	}
+	sql := `
+		UPDATE produtos SET kit = FALSE
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM kit_componentes WHERE kit_id = $1)
+	`
+	if _, err := tx.Exec(ctx, sql, kitID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// GetKitAvailability devolve, por filial, quantos kits o stock dos componentes permite montar.
+func (s *Storage) GetKitAvailability(kitID string) ([]models.KitAvailability, error) {
+	var availability []models.KitAvailability
+	sql := `
+		SELECT f.id, f.nome, ` + fmt.Sprintf(sqlKitAvailable, "f.id") + `
+		FROM filiais f
+		CROSS JOIN produtos p
+		WHERE p.id = $1
+		ORDER BY f.nome
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, kitID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.KitAvailability
+		if err := rows.Scan(&a.FilialID, &a.FilialNome, &a.Disponivel); err != nil {
+			return nil, err
+		}
+		availability = append(availability, a)
+	}
+	return availability, rows.Err()
+}
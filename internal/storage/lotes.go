@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"projeto-vendas/internal/models"
+)
+
+// sqlConsumeLotsFEFO retira $3 unidades dos lotes de um produto numa filial,
+// começando pelo lote que vence primeiro (lotes sem validade ficam para o fim).
+// Para cada lote, "acumulado" é a soma das quantidades até ele inclusive, logo
+// o que sobra no lote é acumulado - $3, limitado entre 0 e a quantidade do lote.
+const sqlConsumeLotsFEFO = `
+	WITH ordenados AS (
+		SELECT id, quantidade,
+			SUM(quantidade) OVER (ORDER BY data_validade ASC NULLS LAST, data_entrada, id) AS acumulado
+		FROM lotes_estoque
+		WHERE produto_id = $1 AND filial_id = $2 AND quantidade > 0
+	)
+	UPDATE lotes_estoque l
+	SET quantidade = LEAST(o.quantidade, GREATEST(o.acumulado - $3::int, 0))
+	FROM ordenados o
+	WHERE l.id = o.id AND o.acumulado - o.quantidade < $3::int
+`
+
+// insertStockLot regista a entrada de um lote dentro de uma transação que já
+// atualizou o saldo em estoque_filiais.
+func insertStockLot(tx pgx.Tx, productID, filialID string, quantity int, lot models.StockLot) error {
+	if quantity <= 0 {
+		return nil
+	}
+	sql := `
+		INSERT INTO lotes_estoque (produto_id, filial_id, numero_lote, data_validade, quantidade, custo_unitario, fornecedor_id)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6::numeric, 0), $7)
+	`
+	_, err := tx.Exec(context.Background(), sql, productID, filialID, lot.NumeroLote, lot.DataValidade, quantity, lot.CustoUnitario, lot.FornecedorID)
+	if err != nil {
+		return fmt.Errorf("falha ao registar o lote: %w", err)
+	}
+	return nil
+}
+
+// consumeStockLots dá baixa nos lotes por FEFO (first-expired-first-out).
+// O saldo sem lote (stock anterior ao controlo de lotes) é consumido por último.
+func consumeStockLots(tx pgx.Tx, productID, filialID interface{}, quantity int) error {
+	if quantity <= 0 {
+		return nil
+	}
+	if _, err := tx.Exec(context.Background(), sqlConsumeLotsFEFO, productID, filialID, quantity); err != nil {
+		return fmt.Errorf("falha ao dar baixa nos lotes: %w", err)
+	}
+	return nil
+}
+
+// syncStockLots garante que a soma dos lotes não excede o saldo em estoque_filiais
+// depois de uma alteração direta da quantidade (definição manual, acerto de contagem).
+// O excesso é retirado por FEFO, como se tivesse saído pela ordem normal.
+func syncStockLots(tx pgx.Tx, productID, filialID interface{}) error {
+	var excess int
+	sql := `
+		SELECT GREATEST(
+			COALESCE((SELECT SUM(quantidade) FROM lotes_estoque WHERE produto_id = $1 AND filial_id = $2), 0)
+			- COALESCE((SELECT quantidade FROM estoque_filiais WHERE produto_id = $1 AND filial_id = $2), 0),
+			0)
+	`
+	if err := tx.QueryRow(context.Background(), sql, productID, filialID).Scan(&excess); err != nil {
+		return fmt.Errorf("falha ao verificar os lotes: %w", err)
+	}
+	return consumeStockLots(tx, productID, filialID, excess)
+}
+
+// GetExpiringLots devolve os lotes com saldo que vencem nos próximos `days` dias,
+// incluindo os já vencidos. Se filialID estiver vazio, considera todas as filiais.
+func (s *Storage) GetExpiringLots(filialID string, days int) ([]models.ExpiringLot, error) {
+	var lots []models.ExpiringLot
+	sql := `
+		SELECT l.id, p.id, p.nome, COALESCE(p.codigo_barras, ''), COALESCE(` + sqlCategoryPath + `, ''), f.nome,
+			COALESCE(l.numero_lote, ''), l.data_validade, l.quantidade,
+			(l.data_validade - CURRENT_DATE) AS dias,
+			l.quantidade * (` + sqlStockUnitCost + `)
+		FROM lotes_estoque l
+		JOIN produtos p ON l.produto_id = p.id
+		JOIN estoque_filiais ef ON ef.produto_id = l.produto_id AND ef.filial_id = l.filial_id
+		JOIN filiais f ON l.filial_id = f.id
+		WHERE l.quantidade > 0
+		  AND l.data_validade IS NOT NULL
+		  AND l.data_validade <= CURRENT_DATE + $1::int
+		  AND ($2 = '' OR l.filial_id::text = $2)
+		ORDER BY l.data_validade ASC, f.nome, p.nome
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, days, filialID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l models.ExpiringLot
+		if err := rows.Scan(&l.LoteID, &l.ProdutoID, &l.ProdutoNome, &l.CodigoBarras, &l.Categoria, &l.FilialNome,
+			&l.NumeroLote, &l.DataValidade, &l.Quantidade, &l.DiasParaVencer, &l.ValorCusto); err != nil {
+			return nil, err
+		}
+		lots = append(lots, l)
+	}
+	return lots, nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+
+	"projeto-vendas/internal/models"
+)
+
+// GetSlowMovingStock devolve os produtos com saldo que venderam no máximo `maxUnits`
+// unidades nos últimos `days` dias na sua filial (maxUnits 0 = sem vendas), do maior
+// para o menor valor parado a custo; as unidades vendidas dentro de kits contam. Para
+// cada um indica a filial que mais vendeu o produto no mesmo período, como destino
+// sugerido de transferência. Com filialID vazio considera todas as filiais; com limit 0
+// devolve todas as linhas.
+func (s *Storage) GetSlowMovingStock(filialID string, days, maxUnits, limit int) ([]models.SlowMovingItem, error) {
+	var items []models.SlowMovingItem
+	sql := `
+		WITH vendas_periodo AS (
+			SELECT iv.produto_id, v.filial_id, SUM(iv.quantidade) AS unidades
+			FROM ` + sqlUnitsSold + ` iv
+			JOIN vendas v ON iv.venda_id = v.id
+			WHERE v.data_venda >= CURRENT_DATE - ($2::int - 1)
+			GROUP BY iv.produto_id, v.filial_id
+		), ultima_venda AS (
+			SELECT iv.produto_id, v.filial_id, MAX(v.data_venda) AS data_venda
+			FROM ` + sqlUnitsSold + ` iv
+			JOIN vendas v ON iv.venda_id = v.id
+			WHERE $1 = '' OR v.filial_id::text = $1
+			GROUP BY iv.produto_id, v.filial_id
+		)
+		SELECT p.id, p.nome, COALESCE(p.codigo_barras, ''), COALESCE(` + sqlCategoryPath + `, ''), f.id, f.nome, ef.quantidade,
+			` + sqlStockUnitCost + `, ef.quantidade * (` + sqlStockUnitCost + `) AS valor,
+			COALESCE(vp.unidades, 0), uv.data_venda, COALESCE(CURRENT_DATE - uv.data_venda::date, -1),
+			COALESCE(destino.filial_id::text, ''), COALESCE(destino.nome, ''), COALESCE(destino.unidades, 0)
+		FROM estoque_filiais ef
+		JOIN produtos p ON ef.produto_id = p.id
+		JOIN filiais f ON ef.filial_id = f.id
+		LEFT JOIN vendas_periodo vp ON vp.produto_id = ef.produto_id AND vp.filial_id = ef.filial_id
+		LEFT JOIN ultima_venda uv ON uv.produto_id = ef.produto_id AND uv.filial_id = ef.filial_id
+		LEFT JOIN LATERAL (
+			SELECT o.filial_id, fo.nome, o.unidades
+			FROM vendas_periodo o
+			JOIN filiais fo ON o.filial_id = fo.id
+			WHERE o.produto_id = ef.produto_id AND o.filial_id <> ef.filial_id
+			  AND o.unidades > COALESCE(vp.unidades, 0)
+			ORDER BY o.unidades DESC, fo.nome
+			LIMIT 1
+		) destino ON TRUE
+		WHERE ef.quantidade > 0
+		  AND ($1 = '' OR ef.filial_id::text = $1)
+		  AND COALESCE(vp.unidades, 0) <= $3
+		ORDER BY valor DESC, p.nome, f.nome
+		LIMIT NULLIF($4::int, 0)
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, filialID, days, maxUnits, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.SlowMovingItem
+		if err := rows.Scan(&item.ProdutoID, &item.ProdutoNome, &item.CodigoBarras, &item.Categoria, &item.FilialID, &item.FilialNome, &item.Quantidade,
+			&item.CustoUnitario, &item.ValorParado, &item.UnidadesVendidas, &item.UltimaVenda, &item.DiasSemVenda,
+			&item.FilialSugeridaID, &item.FilialSugeridaNome, &item.VendasSugerida); err != nil {
+			return nil, err
+		}
+		item.CoberturaDias = -1
+		if item.UnidadesVendidas > 0 && days > 0 {
+			item.CoberturaDias = float64(item.Quantidade) / (float64(item.UnidadesVendidas) / float64(days))
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// sqlProductSearch encontra um produto pelo texto pesquisado, sem distinguir acentos: pelo
+// full-text em português (coluna pesquisa, com o nome e a descrição), em que cada palavra
+// conta como prefixo e a ordem das palavras não importa, ou pela semelhança por trigramas
+// com o nome, para palavras com erros. Usar com fmt.Sprintf, o alias da tabela produtos, o
+// número do parâmetro com a tsquery de searchTSQuery e o do texto pesquisado.
+const sqlProductSearch = `(%[1]s.pesquisa @@ to_tsquery('pt_sem_acentos', $%[2]d) OR sem_acentos(lower($%[3]d)) <%% sem_acentos(lower(%[1]s.nome)))`
+
+// sqlProductSearchRank é a relevância de um produto para a pesquisa, com os mesmos
+// argumentos de sqlProductSearch; maior é mais relevante.
+const sqlProductSearchRank = `(ts_rank(%[1]s.pesquisa, to_tsquery('pt_sem_acentos', $%[2]d)) + word_similarity(sem_acentos(lower($%[3]d)), sem_acentos(lower(%[1]s.nome))))`
+
+// searchTSQuery converte o texto escrito pelo utilizador numa tsquery em que todas as palavras
+// têm de existir, cada uma como prefixo ("feij arr" → "feij:* & arr:*"), para a pesquisa
+// funcionar enquanto se escreve. A pontuação é descartada, por isso o resultado é sempre uma
+// tsquery válida; vazio se o texto não tiver letras nem algarismos.
+func searchTSQuery(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// productListSearch limita a lista de produtos (alias p, sem as variantes) aos que a pesquisa
+// encontra, diretamente ou por uma variante, pelo texto ou pelo início do código de barras.
+// Recebe o número do parâmetro com a tsquery e o do texto pesquisado.
+//
+// O início do código é um intervalo com os operadores byte a byte (~>=~ e ~<~), que usa o
+// índice idx_produtos_codigo_barras_prefixo (text_pattern_ops) com qualquer collation e
+// também no plano genérico das instruções preparadas, ao contrário de LIKE com o padrão num
+// parâmetro. O limite superior acrescenta chr(127) ao texto, o que cobre os códigos ASCII.
+func productListSearch(tsArg, textArg int) string {
+	return fmt.Sprintf(`p.id IN (
+		SELECT COALESCE(b.produto_pai_id, b.id) FROM produtos b
+		WHERE (b.codigo_barras ~>=~ $%[3]d::text AND b.codigo_barras ~<~ ($%[3]d::text || chr(127))) OR %[1]s
+	)`, fmt.Sprintf(sqlProductSearch, "b", tsArg, textArg), tsArg, textArg)
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"projeto-vendas/internal/models"
+)
+
+// sqlBranchPrice é o preço de venda efetivo de um produto (alias p) numa filial: o
+// preço próprio da filial (precos_filiais com alias pf, ligado por LEFT JOIN) ou o de catálogo.
+const sqlBranchPrice = `COALESCE(pf.preco, p.preco_sugerido)`
+
+// checkSalePrice confirma que o preço unitário enviado pelo terminal é o preço efetivo do
+// produto na filial, para que um carrinho desatualizado não venda a um preço antigo.
+func checkSalePrice(ctx context.Context, tx pgx.Tx, productID, filialID uuid.UUID, price float64) error {
+	var nome string
+	var expected float64
+	sql := `
+		SELECT p.nome, ` + sqlBranchPrice + `::float8
+		FROM produtos p
+		LEFT JOIN precos_filiais pf ON pf.produto_id = p.id AND pf.filial_id = $2
+		WHERE p.id = $1
+	`
+	err := tx.QueryRow(ctx, sql, productID, filialID).Scan(&nome, &expected)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("produto %s não encontrado", productID)
+	}
+	if err != nil {
+		return err
+	}
+	if math.Abs(expected-price) >= 0.005 {
+		return fmt.Errorf("o preço de '%s' nesta filial é R$ %.2f e não R$ %.2f; atualize o carrinho", nome, expected, price)
+	}
+	return nil
+}
+
+// SetBranchPrice grava o preço próprio de um produto numa filial.
+func (s *Storage) SetBranchPrice(productID, filialID string, price float64, userID string) error {
+	if price < 0 {
+		return errors.New("o preço não pode ser negativo")
+	}
+	sql := `
+		INSERT INTO precos_filiais (produto_id, filial_id, preco)
+		VALUES ($1, $2, ROUND($3::numeric, 2))
+		ON CONFLICT (produto_id, filial_id) DO UPDATE
+		SET preco = EXCLUDED.preco,
+			data_atualizacao = CASE WHEN precos_filiais.preco <> EXCLUDED.preco THEN NOW() ELSE precos_filiais.data_atualizacao END
+	`
+	_, err := s.execAudited(userID, PriceOriginBranch, sql, productID, filialID, price)
+	return err
+}
+
+// DeleteBranchPrice remove o preço próprio da filial, que volta ao preço de catálogo.
+func (s *Storage) DeleteBranchPrice(productID, filialID, userID string) error {
+	_, err := s.execAudited(userID, PriceOriginBranch, "DELETE FROM precos_filiais WHERE produto_id = $1 AND filial_id = $2", productID, filialID)
+	return err
+}
+
+// ApplyBranchPriceChange aplica uma variação percentual ao preço efetivo, arredondado ao
+// cêntimo, dos produtos ativos com stock registado na filial (opcionalmente só de uma
+// categoria e das suas subcategorias).
+// O resultado fica como preço próprio da filial. Devolve o número de produtos alterados.
+func (s *Storage) ApplyBranchPriceChange(filialID, categoriaID string, percent float64, userID string) (int64, error) {
+	if percent == 0 || percent <= -100 {
+		return 0, fmt.Errorf("variação percentual inválida: %.2f%%", percent)
+	}
+	sql := `
+		INSERT INTO precos_filiais (produto_id, filial_id, preco)
+		SELECT p.id, ef.filial_id, ROUND(` + sqlBranchPrice + ` * (1 + $3::numeric / 100), 2)
+		FROM produtos p
+		JOIN estoque_filiais ef ON ef.produto_id = p.id AND ef.filial_id = $1
+		LEFT JOIN precos_filiais pf ON pf.produto_id = p.id AND pf.filial_id = ef.filial_id
+		WHERE p.data_arquivamento IS NULL AND ` + fmt.Sprintf(sqlCategoryFilter, 2) + `
+		ON CONFLICT (produto_id, filial_id) DO UPDATE
+		SET preco = EXCLUDED.preco, data_atualizacao = NOW()
+	`
+	cmdTag, err := s.execAudited(userID, PriceOriginPercentage, sql, filialID, categoriaID, percent)
+	if err != nil {
+		return 0, err
+	}
+	return cmdTag.RowsAffected(), nil
+}
+
+// CountBranchPriceComparison conta os produtos da comparação de preços.
+func (s *Storage) CountBranchPriceComparison(searchQuery string) (int, error) {
+	var count int
+	sql := `SELECT COUNT(*) FROM produtos p WHERE p.data_arquivamento IS NULL AND ($1 = '' OR p.nome ILIKE '%' || $1 || '%' OR p.codigo_barras ILIKE '%' || $1 || '%')`
+	err := s.Dbpool.QueryRow(context.Background(), sql, searchQuery).Scan(&count)
+	return count, err
+}
+
+// GetBranchPriceComparison devolve uma página de produtos, por nome, com os preços
+// próprios de cada filial.
+func (s *Storage) GetBranchPriceComparison(searchQuery string, limit, offset int) ([]models.BranchPriceComparison, error) {
+	var items []models.BranchPriceComparison
+	sql := `
+		SELECT p.id, p.nome, COALESCE(p.codigo_barras, ''), COALESCE(` + sqlCategoryPath + `, ''), p.preco_sugerido,
+			COALESCE(jsonb_object_agg(pf.filial_id, pf.preco) FILTER (WHERE pf.filial_id IS NOT NULL), '{}')
+		FROM produtos p
+		LEFT JOIN precos_filiais pf ON pf.produto_id = p.id
+		WHERE p.data_arquivamento IS NULL AND ($1 = '' OR p.nome ILIKE '%' || $1 || '%' OR p.codigo_barras ILIKE '%' || $1 || '%')
+		GROUP BY p.id
+		ORDER BY p.nome
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, searchQuery, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.BranchPriceComparison
+		if err := rows.Scan(&item.ProdutoID, &item.Nome, &item.CodigoBarras, &item.Categoria, &item.PrecoCatalogo, &item.Precos); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"projeto-vendas/internal/models"
+)
+
+// SalesSeriesKey é a chave de GetDailyUnitSalesByStock para um produto numa filial.
+func SalesSeriesKey(productID, filialID string) string {
+	return productID + "/" + filialID
+}
+
+// GetDailyUnitSales devolve as unidades vendidas de um produto em cada um dos `days`
+// dias a partir de `from` (inclusive), com zero nos dias sem vendas. Conta também as
+// unidades vendidas dentro de kits. Com filialID vazio soma todas as filiais.
+func (s *Storage) GetDailyUnitSales(productID, filialID string, from time.Time, days int) ([]float64, error) {
+	series := make([]float64, days)
+	sql := `
+		SELECT (v.data_venda::date - $3::date) AS dia, SUM(iv.quantidade)::float8
+		FROM ` + sqlUnitsSold + ` iv
+		JOIN vendas v ON iv.venda_id = v.id
+		WHERE iv.produto_id = $1
+		  AND ($2 = '' OR v.filial_id::text = $2)
+		  AND v.data_venda >= $3::date AND v.data_venda < $3::date + $4::int
+		GROUP BY dia
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, productID, filialID, from.Format("2006-01-02"), days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day int
+		var qty float64
+		if err := rows.Scan(&day, &qty); err != nil {
+			return nil, err
+		}
+		if day >= 0 && day < days {
+			series[day] = qty
+		}
+	}
+	return series, rows.Err()
+}
+
+// GetDailyUnitSalesByStock faz o mesmo que GetDailyUnitSales para vários produtos de
+// uma vez, separado por filial. O resultado é indexado por SalesSeriesKey; pares sem
+// vendas no período não aparecem no mapa.
+func (s *Storage) GetDailyUnitSalesByStock(productIDs []string, filialID string, from time.Time, days int) (map[string][]float64, error) {
+	series := make(map[string][]float64)
+	if len(productIDs) == 0 {
+		return series, nil
+	}
+	sql := `
+		SELECT iv.produto_id::text, v.filial_id::text, (v.data_venda::date - $3::date) AS dia, SUM(iv.quantidade)::float8
+		FROM ` + sqlUnitsSold + ` iv
+		JOIN vendas v ON iv.venda_id = v.id
+		WHERE iv.produto_id::text = ANY($1)
+		  AND ($2 = '' OR v.filial_id::text = $2)
+		  AND v.data_venda >= $3::date AND v.data_venda < $3::date + $4::int
+		GROUP BY iv.produto_id, v.filial_id, dia
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, productIDs, filialID, from.Format("2006-01-02"), days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID, rowFilialID string
+		var day int
+		var qty float64
+		if err := rows.Scan(&productID, &rowFilialID, &day, &qty); err != nil {
+			return nil, err
+		}
+		if day < 0 || day >= days {
+			continue
+		}
+		key := SalesSeriesKey(productID, rowFilialID)
+		if series[key] == nil {
+			series[key] = make([]float64, days)
+		}
+		series[key][day] = qty
+	}
+	return series, rows.Err()
+}
+
+// GetBestSellingStockItems devolve os pares produto/filial com mais unidades vendidas
+// nos últimos `days` dias, com o saldo atual. Serve de amostra para o backtest.
+func (s *Storage) GetBestSellingStockItems(filialID string, days, limit int) ([]models.StockViewItem, error) {
+	var items []models.StockViewItem
+	sql := `
+		SELECT p.id, p.nome, p.codigo_barras, COALESCE(p.codigo_cnae, ''), COALESCE(` + sqlCategoryPath + `, ''),
+			f.id, f.nome, COALESCE(ef.quantidade, 0), COALESCE(ef.versao, 0)
+		FROM ` + sqlUnitsSold + ` iv
+		JOIN vendas v ON iv.venda_id = v.id
+		JOIN produtos p ON iv.produto_id = p.id
+		JOIN filiais f ON v.filial_id = f.id
+		LEFT JOIN estoque_filiais ef ON ef.produto_id = p.id AND ef.filial_id = f.id
+		WHERE v.data_venda >= CURRENT_DATE - MAKE_INTERVAL(days => $1)
+		  AND ($2 = '' OR v.filial_id::text = $2)
+		GROUP BY p.id, p.nome, p.codigo_barras, p.codigo_cnae, f.id, f.nome, ef.quantidade, ef.versao
+		ORDER BY SUM(iv.quantidade) DESC
+		LIMIT $3
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, days, filialID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.StockViewItem
+		if err := rows.Scan(&item.ProdutoID, &item.ProdutoNome, &item.CodigoBarras, &item.CodigoCNAE, &item.Categoria, &item.FilialID, &item.FilialNome, &item.Quantidade, &item.Versao); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"projeto-vendas/internal/models"
+)
+
+// TakeStockSnapshot grava o valor atual do stock, por filial e categoria, como a
+// fotografia do dia indicado. Se o dia já tiver fotografia, é substituída, para que
+// a última execução do dia represente o fecho. Devolve o número de linhas gravadas.
+func (s *Storage) TakeStockSnapshot(day time.Time) (int64, error) {
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+
+	// A data segue como texto para não depender do fuso horário da sessão na conversão para DATE.
+	date := day.Format("2006-01-02")
+	if _, err := tx.Exec(context.Background(), `DELETE FROM estoque_snapshots WHERE data = $1::date`, date); err != nil {
+		return 0, fmt.Errorf("falha ao limpar a fotografia do dia: %w", err)
+	}
+	sql := `
+		INSERT INTO estoque_snapshots (data, filial_id, categoria, quantidade, valor)
+		SELECT $1::date, ef.filial_id, COALESCE(cat.caminho, 'Sem Categoria'),
+			SUM(ef.quantidade), COALESCE(SUM((` + sqlStockUnitCost + `) * ef.quantidade), 0)
+		FROM estoque_filiais ef
+		JOIN produtos p ON ef.produto_id = p.id
+		LEFT JOIN categorias cat ON cat.id = p.categoria_id
+		GROUP BY ef.filial_id, COALESCE(cat.caminho, 'Sem Categoria')
+	`
+	cmdTag, err := tx.Exec(context.Background(), sql, date)
+	if err != nil {
+		return 0, fmt.Errorf("falha ao gravar a fotografia do stock: %w", err)
+	}
+	return cmdTag.RowsAffected(), tx.Commit(context.Background())
+}
+
+// GetStockValueAt devolve o valor do stock numa data passada, usando a fotografia
+// mais recente tirada até essa data (inclusive).
+func (s *Storage) GetStockValueAt(day time.Time) ([]models.StockSnapshot, error) {
+	var snapshots []models.StockSnapshot
+	sql := `
+		SELECT s.data, s.filial_id, f.nome, s.categoria, s.quantidade, s.valor
+		FROM estoque_snapshots s
+		JOIN filiais f ON s.filial_id = f.id
+		WHERE s.data = (SELECT MAX(data) FROM estoque_snapshots WHERE data <= $1::date)
+		ORDER BY f.nome, s.valor DESC
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, day.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var snap models.StockSnapshot
+		if err := rows.Scan(&snap.Data, &snap.FilialID, &snap.FilialNome, &snap.Categoria, &snap.Quantidade, &snap.Valor); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snap)
+	}
+	return snapshots, nil
+}
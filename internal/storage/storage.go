@@ -6,13 +6,14 @@ import (
 	"fmt"
 	"os"
 	"log"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/units"
 	"github.com/joho/godotenv"
 
 )
@@ -21,34 +22,32 @@ import (
 type Store interface {
 	GetUserByEmail(email string) (*models.User, error)
 	GetFilialByID(id string) (*models.Filial, error)
-	CountUsers() (int, error)
-	GetUsersPaginated(limit, offset int) ([]models.User, error)
-	CountProducts(searchQuery string) (int, error)
-	GetProductsPaginatedAndFiltered(searchQuery string, limit, offset int) ([]models.Product, error)
+	CountUsers(archived bool) (int, error)
+	GetUsersPaginated(archived bool, limit, offset int) ([]models.User, error)
+	CountProducts(searchQuery string, archived bool) (int, error)
+	GetProductsPaginatedAndFiltered(searchQuery string, archived bool, limit, offset int) ([]models.Product, error)
 	GetAllFiliais() ([]models.Filial, error)
 	UpdateUser(userID string, user models.User, newPassword string) error
 	CountSales(filialID string) (int, error)
 	GetSalesPaginated(filialID string, limit, offset int) ([]models.SaleReportItem, error)
 	SearchProductsForSale(query string, filialID uuid.UUID) ([]models.Product, error)
 	RegisterSale(sale models.Venda, items []models.ItemVenda) error
-	CreateProductWithInitialStock(product models.Product, filialID string, quantity int) error
-	AddStockItem(productID, filialID string, quantity int) error
+	CreateProductWithInitialStock(product models.Product, filialID string, quantity int, lot models.StockLot) error
+	AddStockItem(productID, filialID string, quantity int, lot models.StockLot) error
 	GetAllProductsSimple() ([]models.Product, error)
-	CountStockItems(filialID, searchQuery string) (int, error)
-	GetStockItemsPaginated(filialID, searchQuery string, limit, offset int) ([]models.StockViewItem, error)
-	UpdateStockQuantity(productID, filialID string, newQuantity int) error
-	UpsertStockQuantity(productID, filialID string, quantity int) error
+	CountStockItems(filialID, searchQuery, abcClass string) (int, error)
+	GetStockItemsPaginated(filialID, searchQuery, abcClass string, limit, offset int) ([]models.StockViewItem, error)
+	UpdateStockQuantity(productID, filialID string, newQuantity, expectedVersion int) error
+	UpsertStockQuantity(productID, filialID string, quantity, expectedVersion int) error
 	AddUser(user models.User, password string) error
 	AddProduct(product models.Product) error
-	UpdateProduct(productID string, product models.Product) error
+	UpdateProduct(productID string, product models.Product, userID string) error
 	UpdateSocio(socioID string, socio models.Socio) error
 	GetEmpresa() (*models.Empresa, error)
 	UpsertEmpresa(empresa models.Empresa) error
 	GetSocios(empresaID uuid.UUID) ([]models.Socio, error)
 	AddSocio(socio models.Socio) error
 	DeleteSocioByID(id string) error
-	DeleteUserByID(id string) error
-	DeleteProductByID(id string) error
 	GetProductStockByFilial(productID string) ([]models.StockDetail, error)
 	AdjustStockQuantity(productID, filialID string, quantityToRemove int) error
 	GetSalesSummary() ([]models.SalesSummary, error)
@@ -63,9 +62,127 @@ type Store interface {
 
 	GetTopSellers(days int) ([]models.TopSeller, error)
 	GetTotalStockValue() (float64, error)
-	GetStockComposition() ([]models.StockComposition, error)
+	GetStockComposition(categoriaID string) ([]models.StockComposition, error)
 	GetProductDetails(identifier string) (*models.Product, error)
 
+	// Contagem física de inventário
+	CreateInventoryCount(count models.InventoryCount) (uuid.UUID, error)
+	GetInventoryCounts(filialID string) ([]models.InventoryCount, error)
+	GetInventoryCountByID(id string) (*models.InventoryCount, error)
+	GetInventoryCountProducts(countID, categoriaID string) ([]models.Product, error)
+	AddInventoryCountEntry(entry models.InventoryCountEntry, barcode string) error
+	GetInventoryCountEntries(countID, userID string) ([]models.InventoryCountEntry, error)
+	CloseInventoryCount(id string) error
+	CancelInventoryCount(id string) error
+	GetInventoryCountVariances(countID string) ([]models.InventoryCountVariance, error)
+	ApproveInventoryCount(countID, approverID, reason string) error
+
+	// Lotes e validades
+	GetExpiringLots(filialID string, days int) ([]models.ExpiringLot, error)
+
+	// Custo médio ponderado
+	GetProductAverageCost(productID string) (float64, error)
+
+	// Fotografias do valor do stock
+	GetStockValueAt(day time.Time) ([]models.StockSnapshot, error)
+
+	// Ajustes de stock (quebras e perdas)
+	CreateStockAdjustment(adj models.StockAdjustment) (string, error)
+	GetStockAdjustments(filialID, status string, limit int) ([]models.StockAdjustment, error)
+	ApproveStockAdjustment(id, approverID string) error
+	RejectStockAdjustment(id, approverID string) error
+	GetShrinkageReport(filialID string, days int) ([]models.ShrinkageReportRow, error)
+
+	// Previsão de procura
+	GetDailyUnitSales(productID, filialID string, from time.Time, days int) ([]float64, error)
+	GetDailyUnitSalesByStock(productIDs []string, filialID string, from time.Time, days int) (map[string][]float64, error)
+	GetBestSellingStockItems(filialID string, days, limit int) ([]models.StockViewItem, error)
+
+	// Classificação ABC
+	RunABCClassification(filialID string, from, to time.Time, thresholdA, thresholdB float64) (int64, error)
+	GetABCRun(filialID string) (*models.ABCRun, error)
+	GetABCSummary(filialID, criterio string) ([]models.ABCSummary, error)
+	CountABCItems(filialID, criterio, classe string) (int, error)
+	GetABCItems(filialID, criterio, classe string, limit, offset int) ([]models.ABCItem, error)
+
+	// Stock parado
+	GetSlowMovingStock(filialID string, days, maxUnits, limit int) ([]models.SlowMovingItem, error)
+
+	// Etiquetas
+	GetProductsForLabels(filter models.LabelFilter, limit int) ([]models.Product, error)
+
+	// Variantes de produto
+	GetProductByID(id string) (*models.Product, error)
+	GetVariants(parentID string) ([]models.Product, error)
+	AddVariant(parentID string, variant models.Product) (string, error)
+	UpdateVariant(variantID string, variant models.Product, userID string) error
+
+	// Códigos de barras adicionais
+	GetProductBarcodes(productID string) ([]models.ProductBarcode, error)
+	AddProductBarcode(code models.ProductBarcode) error
+	DeleteProductBarcode(code string) error
+	GenerateInternalBarcode() (string, error)
+
+	// Preços por filial
+	SetBranchPrice(productID, filialID string, price float64, userID string) error
+	DeleteBranchPrice(productID, filialID, userID string) error
+	ApplyBranchPriceChange(filialID, categoriaID string, percent float64, userID string) (int64, error)
+	CountBranchPriceComparison(searchQuery string) (int, error)
+	GetBranchPriceComparison(searchQuery string, limit, offset int) ([]models.BranchPriceComparison, error)
+
+	// Histórico e agendamento de preços
+	GetPriceHistory(productID string, limit int) ([]models.PriceHistoryEntry, error)
+	GetPriceChangesOn(day time.Time, filialID string) ([]models.PriceHistoryEntry, error)
+	SchedulePriceChange(change models.ScheduledPriceChange) (string, error)
+	CancelScheduledPriceChange(id string) error
+	GetPendingPriceChanges(day *time.Time) ([]models.ScheduledPriceChange, error)
+
+	// Categorias
+	GetCategories() ([]models.Category, error)
+	AddCategory(nome, paiID string) (string, error)
+	RenameCategory(id, nome string) error
+	DeleteCategory(id string) error
+
+	// Classificação fiscal
+	SearchNCM(query string, limit int) ([]models.NCM, error)
+
+	// Fornecedores
+	GetSuppliers() ([]models.Supplier, error)
+	GetProductSuppliers(productID string) ([]models.ProductSupplier, error)
+	SaveProductSupplier(link models.ProductSupplier) error
+	RemoveProductSupplier(productID, supplierID string) error
+	GetPreferredSuppliers(productIDs []string) (map[string]models.ProductSupplier, error)
+
+	// Encomendas a fornecedores
+	CreatePurchaseOrderDrafts(lines []models.PurchaseOrderLine, userID string) (int, error)
+	GetPurchaseOrderDrafts() ([]models.PurchaseOrder, error)
+	DeletePurchaseOrderDraft(id string) error
+
+	// Importação e exportação do catálogo
+	GetProductsForExport() ([]models.Product, error)
+	ImportProducts(rows []models.ProductImportRow, userID string, dryRun bool, chunkSize int, progress func(done int)) (models.ProductImportReport, error)
+
+	// Arquivo de produtos e utilizadores
+	ArchiveProduct(id string) error
+	RestoreProduct(id string) error
+	DeleteProductByID(id string) error
+	ArchiveUser(id string) error
+	RestoreUser(id string) error
+	IsUserActive(id string) (bool, error)
+	DeleteUserByID(id string) error
+
+	// Imagens dos produtos
+	SetProductImage(productID, key string) (string, error)
+
+	// Kits
+	GetKitComponents(kitID string) ([]models.KitComponent, error)
+	SetKitComponent(kitID, barcode string, quantity int) error
+	RemoveKitComponent(kitID, componentID string) error
+	GetKitAvailability(kitID string) ([]models.KitAvailability, error)
+
+	// Estratégias de preço
+	GetCategoryPricingStrategy(categoryID string) (*models.PricingStrategy, error)
+	SetCategoryPricingStrategy(categoryID string, strategy *models.PricingStrategy) error
 }
 
 type Storage struct {
@@ -172,18 +289,36 @@ func (s *Storage) GetSalesPaginated(filialID string, limit, offset int) ([]model
 func (s *Storage) SearchProductsForSale(query string, filialID uuid.UUID) ([]models.Product, error) {
 	var products []models.Product
 	sql := `
-		SELECT p.id, p.nome, p.codigo_barras, p.preco_sugerido
+		SELECT p.id, p.nome, p.codigo_barras, ` + sqlBranchPrice + `, p.produto_pai_id, COALESCE(pai.nome, ''), p.atributos_variante,
+			COALESCE(cb.multiplicador, 1), COALESCE(p.imagem, pai.imagem, ''), p.kit
 		FROM produtos p
-		JOIN estoque_filiais ef ON p.id = ef.produto_id
-		WHERE ef.filial_id = $1 AND ef.quantidade > 0 AND (p.nome ILIKE $2 OR p.codigo_barras = $3)
-		LIMIT 10
+		LEFT JOIN estoque_filiais ef ON ef.produto_id = p.id AND ef.filial_id = $1
+		LEFT JOIN precos_filiais pf ON pf.produto_id = p.id AND pf.filial_id = $1
+		LEFT JOIN produtos pai ON p.produto_pai_id = pai.id
+		LEFT JOIN produto_codigos_barras cb ON cb.produto_id = p.id AND cb.codigo = $3
+		WHERE CASE WHEN p.kit THEN ` + fmt.Sprintf(sqlKitAvailable, "$1") + ` ELSE COALESCE(ef.quantidade, 0) END > 0
+		  AND p.data_arquivamento IS NULL
+		  AND p.id IN (
+			SELECT s.id FROM produtos s WHERE s.codigo_barras = $3 OR ` + fmt.Sprintf(sqlProductSearch, "s", 2, 3) + `
+			UNION ALL
+			SELECT produto_id FROM produto_codigos_barras WHERE codigo = $3
+		  )
+		ORDER BY (p.codigo_barras IS NOT DISTINCT FROM $3 OR cb.codigo IS NOT NULL) DESC,
+			` + fmt.Sprintf(sqlProductSearchRank, "p", 2, 3) + ` DESC, COALESCE(pai.nome, p.nome), p.nome
+		LIMIT 20
 	`
-	rows, err := s.Dbpool.Query(context.Background(), sql, filialID, "%"+query+"%", query)
+	// Um código de barras exato vem sempre primeiro; os restantes seguem pela relevância.
+	// O nome das variantes inclui o do pai, por isso são encontradas pelo nome do produto.
+	// As variantes vêm com o pai e os atributos, para o terminal as agrupar numa escolha.
+	// Um código adicional de embalagem traz o multiplicador em QuantidadeEmbalagem.
+	// O preço é o da filial, quando tem preço próprio. Uma variante sem imagem usa a do pai.
+	// Um kit aparece enquanto os componentes em stock na filial permitirem montá-lo.
+	rows, err := s.Dbpool.Query(context.Background(), sql, filialID, searchTSQuery(query), query)
 	if err != nil { return nil, err }
 	defer rows.Close()
 	for rows.Next() {
 		var p models.Product
-		if err := rows.Scan(&p.ID, &p.Nome, &p.CodigoBarras, &p.PrecoSugerido); err != nil { return nil, err }
+		if err := rows.Scan(&p.ID, &p.Nome, &p.CodigoBarras, &p.PrecoSugerido, &p.ProdutoPaiID, &p.NomePai, &p.Atributos, &p.QuantidadeEmbalagem, &p.Imagem, &p.Kit); err != nil { return nil, err }
 		products = append(products, p)
 	}
 	return products, nil
@@ -198,11 +333,33 @@ func (s *Storage) RegisterSale(sale models.Venda, items []models.ItemVenda) erro
 	err = tx.QueryRow(context.Background(), sqlVenda, sale.UsuarioID, sale.FilialID, sale.TotalVenda).Scan(&vendaID)
 	if err != nil { return fmt.Errorf("erro ao inserir venda: %w", err) }
 	for _, item := range items {
-		sqlItem := `INSERT INTO itens_venda (venda_id, produto_id, quantidade, preco_unitario) VALUES ($1, $2, $3, $4)`
-		_, err := tx.Exec(context.Background(), sqlItem, vendaID, item.ProdutoID, item.Quantidade, item.PrecoUnitario)
+		if err := checkSalePrice(context.Background(), tx, item.ProdutoID, sale.FilialID, item.PrecoUnitario); err != nil {
+			return err
+		}
+		// Um kit dá baixa no stock dos componentes, não num stock próprio.
+		var isKit bool
+		if err := tx.QueryRow(context.Background(), `SELECT kit FROM produtos WHERE id = $1`, item.ProdutoID).Scan(&isKit); err != nil {
+			return fmt.Errorf("produto %s não encontrado", item.ProdutoID)
+		}
+		if isKit {
+			if err := sellKitItem(tx, vendaID, item, sale.FilialID); err != nil {
+				return err
+			}
+			continue
+		}
+		// O custo unitário fica gravado no item para que o CMV reflita o custo médio da data da venda.
+		sqlItem := `
+			INSERT INTO itens_venda (venda_id, produto_id, quantidade, preco_unitario, custo_unitario)
+			SELECT $1::uuid, p.id, $3::int, $4::numeric, ` + sqlStockUnitCost + `
+			FROM produtos p
+			LEFT JOIN estoque_filiais ef ON ef.produto_id = p.id AND ef.filial_id = $5
+			WHERE p.id = $2
+		`
+		cmdItem, err := tx.Exec(context.Background(), sqlItem, vendaID, item.ProdutoID, item.Quantidade, item.PrecoUnitario, sale.FilialID)
 		if err != nil { return fmt.Errorf("erro ao inserir item %s: %w", item.ProdutoID, err) }
+		if cmdItem.RowsAffected() == 0 { return fmt.Errorf("produto %s não encontrado", item.ProdutoID) }
 		sqlStock := `
-			UPDATE estoque_filiais SET quantidade = quantidade - $1
+			UPDATE estoque_filiais SET quantidade = quantidade - $1, versao = versao + 1
 			WHERE produto_id = $2 AND filial_id = $3 AND quantidade >= $1
 		`
 		cmdTag, err := tx.Exec(context.Background(), sqlStock, item.Quantidade, item.ProdutoID, sale.FilialID)
@@ -210,6 +367,9 @@ func (s *Storage) RegisterSale(sale models.Venda, items []models.ItemVenda) erro
 		if cmdTag.RowsAffected() == 0 {
 			return fmt.Errorf("stock insuficiente para o produto %s na filial %s", item.ProdutoID, sale.FilialID)
 		}
+		if err := consumeStockLots(tx, item.ProdutoID, sale.FilialID, item.Quantidade); err != nil {
+			return err
+		}
 	}
 	return tx.Commit(context.Background())
 }
@@ -221,41 +381,101 @@ func (s *Storage) GetFilialByID(id string) (*models.Filial, error) {
 	return &filial, err
 }
 
-func (s *Storage) CreateProductWithInitialStock(product models.Product, filialID string, quantity int) error {
+func (s *Storage) CreateProductWithInitialStock(product models.Product, filialID string, quantity int, lot models.StockLot) error {
 	var tx pgx.Tx
 	tx, err := s.Dbpool.Begin(context.Background())
 	if err != nil {
 		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
 	}
 	defer tx.Rollback(context.Background())
+	if err := setPriceAudit(context.Background(), tx, auditUser(lot.UsuarioID), PriceOriginReceipt); err != nil {
+		return err
+	}
 	var newProductID string
-	sqlProduct := `INSERT INTO produtos (nome, descricao, categoria, codigo_barras, codigo_cnae, preco_custo, percentual_lucro, imposto_estadual, imposto_federal, preco_sugerido) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
-	err = tx.QueryRow(context.Background(), sqlProduct, product.Nome, product.Descricao, product.Categoria, product.CodigoBarras, product.CodigoCNAE, product.PrecoCusto, product.PercentualLucro, product.ImpostoEstadual, product.ImpostoFederal, product.PrecoSugerido).Scan(&newProductID)
+	// A entrada pode vir na unidade de compra do novo produto; o stock fica na de venda.
+	f, u := product.FiscalClassification, product.UnitsOfMeasure
+	quantity, err = convertReceipt(quantity, &lot, u)
 	if err != nil {
-		return fmt.Errorf("falha ao inserir o produto na transação: %w", err)
+		return err
+	}
+	// O custo médio inicial é o custo da primeira entrada (ou o custo introduzido, se não foi indicado).
+	if lot.CustoUnitario <= 0 {
+		lot.CustoUnitario = product.PrecoCusto
+	}
+	unitCost := lot.CustoUnitario
+	sqlProduct := `INSERT INTO produtos (nome, descricao, categoria_id, codigo_barras, codigo_cnae, preco_custo, percentual_lucro, imposto_estadual, imposto_federal, preco_sugerido, custo_medio, estrategia_preco,
+		ncm, cest, cfop, origem, cst_icms, cst_pis, cst_cofins, unidade, unidade_compra, fator_compra)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), NULLIF($14, ''), NULLIF($15, ''), $16, NULLIF($17, ''), NULLIF($18, ''), NULLIF($19, ''),
+		COALESCE(NULLIF($20, ''), 'UN'), NULLIF($21, ''), GREATEST($22::int, 1)) RETURNING id`
+	err = tx.QueryRow(context.Background(), sqlProduct, product.Nome, product.Descricao, product.CategoriaID, product.CodigoBarras, product.CodigoCNAE, product.PrecoCusto, product.PercentualLucro, product.ImpostoEstadual, product.ImpostoFederal, product.PrecoSugerido, unitCost, product.EstrategiaPreco,
+		f.NCM, f.CEST, f.CFOP, f.Origem, f.CSTICMS, f.CSTPIS, f.CSTCOFINS, u.Unidade, u.UnidadeCompra, u.FatorCompra).Scan(&newProductID)
+	if err != nil {
+		return fmt.Errorf("falha ao inserir o produto na transação: %w", barcodeError(fiscalError(err)))
 	}
-	sqlStock := `INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, $3)`
-	_, err = tx.Exec(context.Background(), sqlStock, newProductID, filialID, quantity)
+	sqlStock := `INSERT INTO estoque_filiais (produto_id, filial_id, quantidade, custo_medio) VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(context.Background(), sqlStock, newProductID, filialID, quantity, unitCost)
 	if err != nil {
 		return fmt.Errorf("falha ao inserir o stock na transação: %w", err)
 	}
+	if err := insertStockLot(tx, newProductID, filialID, quantity, lot); err != nil {
+		return err
+	}
+	if err := recordSupplierReceipt(tx, newProductID, lot); err != nil {
+		return err
+	}
 	return tx.Commit(context.Background())
 }
 
-func (s *Storage) AddStockItem(productID, filialID string, quantity int) error {
+// AddStockItem soma a quantidade recebida ao saldo da filial, regista o lote da entrada
+// e, se o custo de compra foi indicado, recalcula o custo médio ponderado. A quantidade e o
+// custo vêm na unidade lot.Unidade (a de compra ou a de venda do produto) e são convertidos
+// para a unidade de venda, a do stock. Com lot.FornecedorID, o custo fica como o último
+// desse fornecedor.
+func (s *Storage) AddStockItem(productID, filialID string, quantity int, lot models.StockLot) error {
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+	if err := rejectKitStock(tx, productID); err != nil {
+		return err
+	}
+	u, err := productUnits(tx, productID)
+	if err != nil {
+		return err
+	}
+	if quantity, err = convertReceipt(quantity, &lot, u); err != nil {
+		return err
+	}
+	// A alteração do custo médio fica no histórico em nome de quem registou a entrada.
+	if err := setPriceAudit(context.Background(), tx, auditUser(lot.UsuarioID), PriceOriginReceipt); err != nil {
+		return err
+	}
+	if err := applyReceiptCost(tx, productID, filialID, quantity, lot.CustoUnitario); err != nil {
+		return err
+	}
+	// Numa filial sem registo de stock, o custo médio da filial começa no custo desta entrada.
 	sql := `
-		INSERT INTO estoque_filiais (produto_id, filial_id, quantidade)
-		VALUES ($1, $2, $3)
+		INSERT INTO estoque_filiais (produto_id, filial_id, quantidade, custo_medio)
+		VALUES ($1, $2, $3, NULLIF($4::numeric, 0))
 		ON CONFLICT (produto_id, filial_id)
-		DO UPDATE SET quantidade = estoque_filiais.quantidade + EXCLUDED.quantidade;
+		DO UPDATE SET quantidade = estoque_filiais.quantidade + EXCLUDED.quantidade, versao = estoque_filiais.versao + 1;
 	`
-	_, err := s.Dbpool.Exec(context.Background(), sql, productID, filialID, quantity)
-	return err
+	if _, err := tx.Exec(context.Background(), sql, productID, filialID, quantity, lot.CustoUnitario); err != nil {
+		return err
+	}
+	if err := insertStockLot(tx, productID, filialID, quantity, lot); err != nil {
+		return err
+	}
+	if err := recordSupplierReceipt(tx, productID, lot); err != nil {
+		return err
+	}
+	return tx.Commit(context.Background())
 }
 
 func (s *Storage) GetAllProductsSimple() ([]models.Product, error) {
 	var products []models.Product
-	sql := `SELECT id, nome FROM produtos ORDER BY nome`
+	sql := `SELECT id, nome FROM produtos WHERE data_arquivamento IS NULL ORDER BY nome`
 	rows, err := s.Dbpool.Query(context.Background(), sql)
 	if err != nil { return nil, err }
 	defer rows.Close()
@@ -267,52 +487,56 @@ func (s *Storage) GetAllProductsSimple() ([]models.Product, error) {
 	return products, nil
 }
 
-func (s *Storage) CountStockItems(filialID, searchQuery string) (int, error) {
+// CountStockItems conta os registos de stock ativos, opcionalmente de uma filial, de uma
+// pesquisa e de uma classe ABC (ver sqlStockABCClass).
+func (s *Storage) CountStockItems(filialID, searchQuery, abcClass string) (int, error) {
 	var count int
 	sql := `SELECT COUNT(*) FROM estoque_filiais ef JOIN produtos p ON ef.produto_id = p.id`
 	var args []interface{}
-	whereClauses := ""
+	// Os produtos arquivados saem da lista de stock (e, por ela, da reposição).
+	whereClauses := " WHERE p.data_arquivamento IS NULL"
 	if filialID != "" {
-		whereClauses += " WHERE ef.filial_id = $1"
+		whereClauses += " AND ef.filial_id = $1"
 		args = append(args, filialID)
 	}
 	if searchQuery != "" {
-		if len(args) > 0 {
-			whereClauses += " AND"
-		} else {
-			whereClauses += " WHERE"
-		}
-		whereClauses += fmt.Sprintf(" (p.nome ILIKE $%d OR p.codigo_barras = $%d)", len(args)+1, len(args)+2)
+		whereClauses += fmt.Sprintf(" AND (p.nome ILIKE $%d OR p.codigo_barras = $%d)", len(args)+1, len(args)+2)
 		args = append(args, "%"+searchQuery+"%", searchQuery)
 	}
+	if abcClass != "" {
+		whereClauses += fmt.Sprintf(" AND %s = $%d", sqlStockABCClass, len(args)+1)
+		args = append(args, abcClass)
+	}
 	err := s.Dbpool.QueryRow(context.Background(), sql+whereClauses, args...).Scan(&count)
 	return count, err
 }
 
-func (s *Storage) GetStockItemsPaginated(filialID, searchQuery string, limit, offset int) ([]models.StockViewItem, error) {
+func (s *Storage) GetStockItemsPaginated(filialID, searchQuery, abcClass string, limit, offset int) ([]models.StockViewItem, error) {
 	var items []models.StockViewItem
 	sql := `
-		SELECT p.id, p.nome, p.codigo_barras, COALESCE(p.codigo_cnae, ''), COALESCE(p.categoria, ''), f.id, f.nome, ef.quantidade
+		SELECT p.id, p.nome, p.codigo_barras, COALESCE(p.codigo_cnae, ''), COALESCE(` + sqlCategoryPath + `, ''), f.id, f.nome, ef.quantidade, ef.versao, ` + sqlUnitColumns + `,
+			` + sqlStockABCClass + `
 		FROM estoque_filiais ef
 		JOIN produtos p ON ef.produto_id = p.id
 		JOIN filiais f ON ef.filial_id = f.id
 	`
 	args := []interface{}{limit, offset}
 	argCount := 2
-	whereClauses := ""
+	whereClauses := " WHERE p.data_arquivamento IS NULL"
 	if filialID != "" {
 		argCount++
-		whereClauses += fmt.Sprintf(" WHERE ef.filial_id = $%d", argCount)
+		whereClauses += fmt.Sprintf(" AND ef.filial_id = $%d", argCount)
 		args = append(args, filialID)
 	}
 	if searchQuery != "" {
-		if len(whereClauses) > 0 {
-			whereClauses += " AND"
-		} else {
-			whereClauses += " WHERE"
-		}
-		whereClauses += fmt.Sprintf(" (p.nome ILIKE $%d OR p.codigo_barras = $%d)", argCount+1, argCount+2)
+		whereClauses += fmt.Sprintf(" AND (p.nome ILIKE $%d OR p.codigo_barras = $%d)", argCount+1, argCount+2)
 		args = append(args, "%"+searchQuery+"%", searchQuery)
+		argCount += 2
+	}
+	if abcClass != "" {
+		argCount++
+		whereClauses += fmt.Sprintf(" AND %s = $%d", sqlStockABCClass, argCount)
+		args = append(args, abcClass)
 	}
 	sql += whereClauses + " ORDER BY p.nome, f.nome LIMIT $1 OFFSET $2"
 	rows, err := s.Dbpool.Query(context.Background(), sql, args...)
@@ -320,47 +544,71 @@ func (s *Storage) GetStockItemsPaginated(filialID, searchQuery string, limit, of
 	defer rows.Close()
 	for rows.Next() {
 		var item models.StockViewItem
-		if err := rows.Scan(&item.ProdutoID, &item.ProdutoNome, &item.CodigoBarras, &item.CodigoCNAE, &item.Categoria, &item.FilialID, &item.FilialNome, &item.Quantidade); err != nil {
+		if err := rows.Scan(&item.ProdutoID, &item.ProdutoNome, &item.CodigoBarras, &item.CodigoCNAE, &item.Categoria, &item.FilialID, &item.FilialNome, &item.Quantidade, &item.Versao,
+			&item.Unidade, &item.UnidadeCompra, &item.FatorCompra, &item.ClasseABC); err != nil {
 			return nil, err
 		}
+		item.EmUnidadeCompra = units.InPurchaseUnit(item.Quantidade, item.UnitsOfMeasure)
 		items = append(items, item)
 	}
 	return items, nil
 }
 
-func (s *Storage) UpdateStockQuantity(productID, filialID string, newQuantity int) error {
+// UpdateStockQuantity define o saldo de um registo de stock existente. Só grava se a
+// versão do registo ainda for expectedVersion; caso contrário devolve *StockConflictError.
+func (s *Storage) UpdateStockQuantity(productID, filialID string, newQuantity, expectedVersion int) error {
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
 	sql := `
-		UPDATE estoque_filiais SET quantidade = $1 
-		WHERE produto_id = $2 AND filial_id = $3
+		UPDATE estoque_filiais SET quantidade = $1, versao = versao + 1, data_atualizacao = NOW()
+		WHERE produto_id = $2 AND filial_id = $3 AND versao = $4
 	`
-	cmdTag, err := s.Dbpool.Exec(context.Background(), sql, newQuantity, productID, filialID)
+	cmdTag, err := tx.Exec(context.Background(), sql, newQuantity, productID, filialID, expectedVersion)
 	if err != nil {
 		return err
 	}
 	if cmdTag.RowsAffected() == 0 {
+		if conflict := currentStockConflict(tx, productID, filialID); conflict != nil {
+			return conflict
+		}
 		return errors.New("nenhum registo de stock foi atualizado (produto/filial não encontrado?)")
 	}
-	return nil
+	if err := syncStockLots(tx, productID, filialID); err != nil {
+		return err
+	}
+	return tx.Commit(context.Background())
 }
 
-func (s *Storage) GetProductsPaginatedAndFiltered(searchQuery string, limit, offset int) ([]models.Product, error) {
+func (s *Storage) GetProductsPaginatedAndFiltered(searchQuery string, archived bool, limit, offset int) ([]models.Product, error) {
 	var products []models.Product
 	sql := `
-		SELECT p.id, p.nome, p.descricao, COALESCE(p.categoria, ''), p.codigo_barras, COALESCE(p.codigo_cnae, ''), p.preco_custo, p.percentual_lucro,
-		p.imposto_estadual, p.imposto_federal, p.preco_sugerido,
+		SELECT p.id, p.nome, p.descricao, p.categoria_id, COALESCE(` + sqlCategoryPath + `, ''), p.codigo_barras, COALESCE(p.codigo_cnae, ''), p.preco_custo, p.percentual_lucro,
+		p.imposto_estadual, p.imposto_federal, p.preco_sugerido, p.data_arquivamento IS NOT NULL, COALESCE(p.imagem, ''), p.kit, p.estrategia_preco, ` + sqlFiscalColumns + `, ` + sqlUnitColumns + `,
 				COALESCE(SUM(ef.quantidade), 0) as total_estoque,
-				(COALESCE(SUM(ef.quantidade), 0) * p.preco_sugerido) as valor_total_estoque
+				COALESCE(SUM(ef.quantidade * m.preco_sugerido), 0) as valor_total_estoque,
+				COUNT(DISTINCT m.id) FILTER (WHERE m.produto_pai_id IS NOT NULL) as num_variantes
 		FROM produtos p
-		LEFT JOIN estoque_filiais ef ON p.id = ef.produto_id
+		LEFT JOIN produtos m ON m.id = p.id OR m.produto_pai_id = p.id
+		LEFT JOIN estoque_filiais ef ON m.id = ef.produto_id
+		WHERE p.produto_pai_id IS NULL AND (p.data_arquivamento IS NOT NULL) = $1
 `
-	var args []interface{}
-	placeholderCount := 1
+	// As variantes não aparecem na lista: o stock delas soma no produto pai, e a pesquisa
+	// encontra o pai também pelo nome ou código de barras de uma variante. Com pesquisa, o
+	// código de barras exato vem primeiro e os restantes seguem pela relevância. A lista
+	// mostra os produtos ativos ou, com archived, só os arquivados.
+	args := []interface{}{archived}
+	placeholderCount := 2
+	orderBy := "p.nome"
 	if searchQuery != "" {
-		sql += fmt.Sprintf(" WHERE p.nome ILIKE $%d OR p.codigo_barras ILIKE $%d", placeholderCount, placeholderCount+1)
-		args = append(args, "%"+searchQuery+"%", "%"+searchQuery+"%")
+		sql += " AND " + productListSearch(2, 3)
+		orderBy = "bool_or(m.codigo_barras IS NOT DISTINCT FROM $3) DESC, MAX(" + fmt.Sprintf(sqlProductSearchRank, "m", 2, 3) + ") DESC, p.nome"
+		args = append(args, searchTSQuery(searchQuery), searchQuery)
 		placeholderCount += 2
 	}
-	sql += fmt.Sprintf(" GROUP BY p.id ORDER BY p.nome LIMIT $%d OFFSET $%d", placeholderCount, placeholderCount+1)
+	sql += fmt.Sprintf(" GROUP BY p.id ORDER BY %s LIMIT $%d OFFSET $%d", orderBy, placeholderCount, placeholderCount+1)
 	args = append(args, limit, offset)
 	rows, err := s.Dbpool.Query(context.Background(), sql, args...)
 	if err != nil {
@@ -369,7 +617,8 @@ func (s *Storage) GetProductsPaginatedAndFiltered(searchQuery string, limit, off
 	defer rows.Close()
 	for rows.Next() {
 		var p models.Product
-		if err := rows.Scan(&p.ID, &p.Nome, &p.Descricao, &p.Categoria, &p.CodigoBarras, &p.CodigoCNAE, &p.PrecoCusto, &p.PercentualLucro, &p.ImpostoEstadual, &p.ImpostoFederal, &p.PrecoSugerido, &p.TotalEstoque, &p.ValorTotalEstoque); err != nil {
+		if err := rows.Scan(&p.ID, &p.Nome, &p.Descricao, &p.CategoriaID, &p.Categoria, &p.CodigoBarras, &p.CodigoCNAE, &p.PrecoCusto, &p.PercentualLucro, &p.ImpostoEstadual, &p.ImpostoFederal, &p.PrecoSugerido, &p.Arquivado, &p.Imagem, &p.Kit, &p.EstrategiaPreco,
+			&p.NCM, &p.CEST, &p.CFOP, &p.Origem, &p.CSTICMS, &p.CSTPIS, &p.CSTCOFINS, &p.Unidade, &p.UnidadeCompra, &p.FatorCompra, &p.TotalEstoque, &p.ValorTotalEstoque, &p.NumVariantes); err != nil {
 			return nil, err
 		}
 		products = append(products, p)
@@ -379,40 +628,42 @@ func (s *Storage) GetProductsPaginatedAndFiltered(searchQuery string, limit, off
 
 func (s *Storage) GetUserByEmail(email string) (*models.User, error) {
 	var user models.User
-	sql := `SELECT id, nome, email, senha_hash, cargo, filial_id FROM usuarios WHERE email = $1`
+	// Os utilizadores arquivados não entram no sistema.
+	sql := `SELECT id, nome, email, senha_hash, cargo, filial_id FROM usuarios WHERE email = $1 AND data_arquivamento IS NULL`
 	err := s.Dbpool.QueryRow(context.Background(), sql, email).Scan(&user.ID, &user.Nome, &user.Email, &user.SenhaHash, &user.Cargo, &user.FilialID)
 	return &user, err
 }
 
-func (s *Storage) CountUsers() (int, error) {
+func (s *Storage) CountUsers(archived bool) (int, error) {
 	var count int
-	err := s.Dbpool.QueryRow(context.Background(), "SELECT COUNT(*) FROM usuarios").Scan(&count)
+	err := s.Dbpool.QueryRow(context.Background(), "SELECT COUNT(*) FROM usuarios WHERE (data_arquivamento IS NOT NULL) = $1", archived).Scan(&count)
 	return count, err
 }
 
-func (s *Storage) GetUsersPaginated(limit, offset int) ([]models.User, error) {
+// GetUsersPaginated lista os utilizadores ativos ou, com archived, só os arquivados.
+func (s *Storage) GetUsersPaginated(archived bool, limit, offset int) ([]models.User, error) {
 	var users []models.User
-	sql := `SELECT id, nome, email, cargo, filial_id FROM usuarios ORDER BY nome LIMIT $1 OFFSET $2`
-	rows, err := s.Dbpool.Query(context.Background(), sql, limit, offset)
+	sql := `SELECT id, nome, email, cargo, filial_id, data_arquivamento IS NOT NULL FROM usuarios WHERE (data_arquivamento IS NOT NULL) = $1 ORDER BY nome LIMIT $2 OFFSET $3`
+	rows, err := s.Dbpool.Query(context.Background(), sql, archived, limit, offset)
 	if err != nil { return nil, err }
 	defer rows.Close()
 	for rows.Next() {
 		var u models.User
-		if err := rows.Scan(&u.ID, &u.Nome, &u.Email, &u.Cargo, &u.FilialID); err != nil { return nil, err }
+		if err := rows.Scan(&u.ID, &u.Nome, &u.Email, &u.Cargo, &u.FilialID, &u.Arquivado); err != nil { return nil, err }
 		users = append(users, u)
 	}
 	return users, nil
 }
 
-func (s *Storage) CountProducts(searchQuery string) (int, error) {
+func (s *Storage) CountProducts(searchQuery string, archived bool) (int, error) {
 	var count int
-	sql := "SELECT COUNT(*) FROM produtos"
+	sql := "SELECT COUNT(*) FROM produtos p WHERE p.produto_pai_id IS NULL AND (p.data_arquivamento IS NOT NULL) = $1"
 	var err error
 	if searchQuery != "" {
-		sql += " WHERE nome ILIKE $1 OR codigo_barras ILIKE $1"
-		err = s.Dbpool.QueryRow(context.Background(), sql, "%"+searchQuery+"%").Scan(&count)
+		sql += " AND " + productListSearch(2, 3)
+		err = s.Dbpool.QueryRow(context.Background(), sql, archived, searchTSQuery(searchQuery), searchQuery).Scan(&count)
 	} else {
-		err = s.Dbpool.QueryRow(context.Background(), sql).Scan(&count)
+		err = s.Dbpool.QueryRow(context.Background(), sql, archived).Scan(&count)
 	}
 	return count, err
 }
@@ -431,28 +682,14 @@ func (s *Storage) GetAllFiliais() ([]models.Filial, error) {
 	return filiais, nil
 }
 
-func (s *Storage) DeleteUserByID(id string) error {
-	_, err := s.Dbpool.Exec(context.Background(), "DELETE FROM usuarios WHERE id = $1", id)
-	return err
-}
-
-func (s *Storage) DeleteProductByID(id string) error {
-	_, err := s.Dbpool.Exec(context.Background(), "DELETE FROM produtos WHERE id = $1", id)
-	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
-			return fmt.Errorf("não é possível apagar o produto, pois ele está associado a vendas existentes")
-		}
-	}
-	return err
-}
 
 func (s *Storage) GetProductStockByFilial(productID string) ([]models.StockDetail, error) {
 	var details []models.StockDetail
 	sql := `
-		SELECT f.id, f.nome, COALESCE(ef.quantidade, 0) as quantidade
+		SELECT f.id, f.nome, COALESCE(ef.quantidade, 0) as quantidade, COALESCE(ef.versao, 0), ` + sqlUnitColumns + `
 		FROM filiais f
-		LEFT JOIN estoque_filiais ef ON f.id = ef.filial_id AND ef.produto_id = $1
+		JOIN produtos p ON p.id = $1
+		LEFT JOIN estoque_filiais ef ON f.id = ef.filial_id AND ef.produto_id = p.id
 		ORDER BY f.nome
 	`
 	rows, err := s.Dbpool.Query(context.Background(), sql, productID)
@@ -461,37 +698,75 @@ func (s *Storage) GetProductStockByFilial(productID string) ([]models.StockDetai
 
 	for rows.Next() {
 		var d models.StockDetail
-		if err := rows.Scan(&d.FilialID, &d.FilialNome, &d.Quantidade); err != nil { return nil, err }
+		if err := rows.Scan(&d.FilialID, &d.FilialNome, &d.Quantidade, &d.Versao, &d.Unidade, &d.UnidadeCompra, &d.FatorCompra); err != nil { return nil, err }
+		d.EmUnidadeCompra = units.InPurchaseUnit(d.Quantidade, d.UnitsOfMeasure)
 		details = append(details, d)
 	}
 	return details, nil
 }
 
-func (s *Storage) UpsertStockQuantity(productID, filialID string, quantity int) error {
+// UpsertStockQuantity define o saldo de um produto numa filial, criando o registo se
+// não existir. expectedVersion é a versão lida (0 para um registo inexistente); se o
+// registo mudou entretanto, nada é gravado e é devolvido *StockConflictError.
+func (s *Storage) UpsertStockQuantity(productID, filialID string, quantity, expectedVersion int) error {
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+	if err := rejectKitStock(tx, productID); err != nil {
+		return err
+	}
 	sql := `
 		INSERT INTO estoque_filiais (produto_id, filial_id, quantidade)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (produto_id, filial_id)
-		DO UPDATE SET quantidade = $3
+		DO UPDATE SET quantidade = $3, versao = estoque_filiais.versao + 1, data_atualizacao = NOW()
+		WHERE estoque_filiais.versao = $4
 	`
-	_, err := s.Dbpool.Exec(context.Background(), sql, productID, filialID, quantity)
-	return err
+	cmdTag, err := tx.Exec(context.Background(), sql, productID, filialID, quantity, expectedVersion)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		if conflict := currentStockConflict(tx, productID, filialID); conflict != nil {
+			return conflict
+		}
+		return errors.New("nenhum registo de stock foi atualizado")
+	}
+	if err := syncStockLots(tx, productID, filialID); err != nil {
+		return err
+	}
+	return tx.Commit(context.Background())
 }
 
 func (s *Storage) AdjustStockQuantity(productID, filialID string, quantityToRemove int) error {
+	tx, err := s.Dbpool.Begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("não foi possível iniciar a transação: %w", err)
+	}
+	defer tx.Rollback(context.Background())
+	if err := removeStock(tx, productID, filialID, quantityToRemove); err != nil {
+		return err
+	}
+	return tx.Commit(context.Background())
+}
+
+// removeStock dá baixa no saldo da filial e nos lotes (FEFO) dentro de uma transação.
+func removeStock(tx pgx.Tx, productID, filialID interface{}, quantityToRemove int) error {
 	sql := `
 		UPDATE estoque_filiais
-		SET quantidade = quantidade - $1
+		SET quantidade = quantidade - $1, versao = versao + 1
 		WHERE produto_id = $2 AND filial_id = $3 AND quantidade >= $1
 	`
-	cmdTag, err := s.Dbpool.Exec(context.Background(), sql, quantityToRemove, productID, filialID)
+	cmdTag, err := tx.Exec(context.Background(), sql, quantityToRemove, productID, filialID)
 	if err != nil {
 		return err
 	}
 	if cmdTag.RowsAffected() == 0 {
 		return errors.New("stock insuficiente para a baixa ou item/filial não encontrado")
 	}
-	return nil
+	return consumeStockLots(tx, productID, filialID, quantityToRemove)
 }
 
 func (s *Storage) AddUser(user models.User, password string) error {
@@ -505,9 +780,14 @@ func (s *Storage) AddUser(user models.User, password string) error {
 }
 
 func (s *Storage) AddProduct(product models.Product) error {
-	sql := `INSERT INTO produtos (nome, descricao, categoria, codigo_barras, codigo_cnae, preco_custo, percentual_lucro, imposto_estadual, imposto_federal, preco_sugerido) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
-	_, err := s.Dbpool.Exec(context.Background(), sql, product.Nome, product.Descricao, product.Categoria, product.CodigoBarras, product.CodigoCNAE, product.PrecoCusto, product.PercentualLucro, product.ImpostoEstadual, product.ImpostoFederal, product.PrecoSugerido)
-	return err
+	sql := `INSERT INTO produtos (nome, descricao, categoria_id, codigo_barras, codigo_cnae, preco_custo, percentual_lucro, imposto_estadual, imposto_federal, preco_sugerido, estrategia_preco,
+		ncm, cest, cfop, origem, cst_icms, cst_pis, cst_cofins, unidade, unidade_compra, fator_compra)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''), NULLIF($13, ''), NULLIF($14, ''), $15, NULLIF($16, ''), NULLIF($17, ''), NULLIF($18, ''),
+		COALESCE(NULLIF($19, ''), 'UN'), NULLIF($20, ''), GREATEST($21::int, 1))`
+	f, u := product.FiscalClassification, product.UnitsOfMeasure
+	_, err := s.Dbpool.Exec(context.Background(), sql, product.Nome, product.Descricao, product.CategoriaID, product.CodigoBarras, product.CodigoCNAE, product.PrecoCusto, product.PercentualLucro, product.ImpostoEstadual, product.ImpostoFederal, product.PrecoSugerido, product.EstrategiaPreco,
+		f.NCM, f.CEST, f.CFOP, f.Origem, f.CSTICMS, f.CSTPIS, f.CSTCOFINS, u.Unidade, u.UnidadeCompra, u.FatorCompra)
+	return barcodeError(fiscalError(err))
 }
 
 func (s *Storage) UpdateUser(userID string, user models.User, newPassword string) error {
@@ -537,11 +817,11 @@ func (s *Storage) UpdateUser(userID string, user models.User, newPassword string
 
 func (s *Storage) GetEmpresa() (*models.Empresa, error) {
 	var empresa models.Empresa
-	sql := `SELECT id, razao_social, nome_fantasia, cnpj, endereco FROM empresa LIMIT 1`
-	err := s.Dbpool.QueryRow(context.Background(), sql).Scan(&empresa.ID, &empresa.RazaoSocial, &empresa.NomeFantasia, &empresa.CNPJ, &empresa.Endereco)
+	sql := `SELECT id, razao_social, nome_fantasia, cnpj, endereco, custo_medio_por_filial, limite_aprovacao_ajuste FROM empresa LIMIT 1`
+	err := s.Dbpool.QueryRow(context.Background(), sql).Scan(&empresa.ID, &empresa.RazaoSocial, &empresa.NomeFantasia, &empresa.CNPJ, &empresa.Endereco, &empresa.CustoMedioPorFilial, &empresa.LimiteAprovacaoAjuste)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return &models.Empresa{}, nil
+			return &models.Empresa{LimiteAprovacaoAjuste: defaultAdjustmentApprovalLimit}, nil
 		}
 		return nil, err
 	}
@@ -552,15 +832,17 @@ func (s *Storage) UpsertEmpresa(empresa models.Empresa) error {
 	const fixedEmpresaID = "00000000-0000-0000-0000-000000000001"
 	
 	sql := `
-		INSERT INTO empresa (id, razao_social, nome_fantasia, cnpj, endereco)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO empresa (id, razao_social, nome_fantasia, cnpj, endereco, custo_medio_por_filial, limite_aprovacao_ajuste)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		ON CONFLICT (id) DO UPDATE SET
 			razao_social = EXCLUDED.razao_social,
 			nome_fantasia = EXCLUDED.nome_fantasia,
 			cnpj = EXCLUDED.cnpj,
-			endereco = EXCLUDED.endereco
+			endereco = EXCLUDED.endereco,
+			custo_medio_por_filial = EXCLUDED.custo_medio_por_filial,
+			limite_aprovacao_ajuste = EXCLUDED.limite_aprovacao_ajuste
 	`
-	_, err := s.Dbpool.Exec(context.Background(), sql, fixedEmpresaID, empresa.RazaoSocial, empresa.NomeFantasia, empresa.CNPJ, empresa.Endereco)
+	_, err := s.Dbpool.Exec(context.Background(), sql, fixedEmpresaID, empresa.RazaoSocial, empresa.NomeFantasia, empresa.CNPJ, empresa.Endereco, empresa.CustoMedioPorFilial, empresa.LimiteAprovacaoAjuste)
 	return err
 }
 
@@ -592,31 +874,55 @@ func (s *Storage) DeleteSocioByID(id string) error {
 	return err
 }
 
-func (s *Storage) UpdateProduct(productID string, product models.Product) error {
+// UpdateProduct grava as alterações de um produto; as de preço e custo ficam no histórico
+// em nome de userID.
+func (s *Storage) UpdateProduct(productID string, product models.Product, userID string) error {
     sql := `
         UPDATE produtos SET 
-            nome = $1, descricao = $2, categoria = $3, codigo_barras = $4, preco_custo = $5, 
+            nome = $1, descricao = $2, categoria_id = $3, codigo_barras = $4, preco_custo = $5, 
             percentual_lucro = $6, imposto_estadual = $7, imposto_federal = $8, preco_sugerido = $9, codigo_cnae = $10,
+            estrategia_preco = $12,
+            ncm = NULLIF($13, ''), cest = NULLIF($14, ''), cfop = NULLIF($15, ''), origem = $16,
+            cst_icms = NULLIF($17, ''), cst_pis = NULLIF($18, ''), cst_cofins = NULLIF($19, ''),
+            unidade = COALESCE(NULLIF($20, ''), 'UN'), unidade_compra = NULLIF($21, ''), fator_compra = GREATEST($22::int, 1),
+            data_alteracao_preco = CASE WHEN preco_sugerido <> $9 THEN NOW() ELSE data_alteracao_preco END,
             data_atualizacao = NOW()
         WHERE id = $11
 	`
-    cmdTag, err := s.Dbpool.Exec(context.Background(), sql, 
-        product.Nome, product.Descricao, product.Categoria, product.CodigoBarras, product.PrecoCusto,
+    ctx := context.Background()
+    tx, err := s.Dbpool.Begin(ctx)
+    if err != nil { return err }
+    defer tx.Rollback(ctx)
+    if err := setPriceAudit(ctx, tx, userID, PriceOriginManual); err != nil { return err }
+
+    cmdTag, err := tx.Exec(ctx, sql, 
+        product.Nome, product.Descricao, product.CategoriaID, product.CodigoBarras, product.PrecoCusto,
         product.PercentualLucro, product.ImpostoEstadual, product.ImpostoFederal, product.PrecoSugerido, product.CodigoCNAE,
-        productID)
+        productID, product.EstrategiaPreco,
+        product.NCM, product.CEST, product.CFOP, product.Origem, product.CSTICMS, product.CSTPIS, product.CSTCOFINS,
+        product.Unidade, product.UnidadeCompra, product.FatorCompra)
     
-    if err != nil { return err }
+    if err != nil { return barcodeError(fiscalError(err)) }
     if cmdTag.RowsAffected() == 0 { return errors.New("nenhum produto foi atualizado (ID não encontrado?)") }
-    return nil
+    // O nome, a classificação fiscal e as unidades das variantes derivam do pai.
+    if err := renameVariants(ctx, tx, productID); err != nil { return err }
+    if err := syncVariantsFiscal(ctx, tx, productID); err != nil { return err }
+    if err := syncVariantsUnits(ctx, tx, productID); err != nil { return err }
+    return tx.Commit(ctx)
 }
 
+// FilterProducts procura os produtos mais caros que minPrice nas categorias cujo nome
+// contém category, incluindo as respetivas subcategorias.
 func (s *Storage) FilterProducts(category string, minPrice float64) ([]models.Product, error) {
     var products []models.Product
     sql := `
-        SELECT id, nome, preco_sugerido 
-        FROM produtos 
-        WHERE nome ILIKE $1 AND preco_sugerido > $2
-        ORDER BY preco_sugerido DESC
+        SELECT p.id, p.nome, p.preco_sugerido 
+        FROM produtos p
+        WHERE p.categoria_id IN (
+                SELECT categorias_descendentes(c.id) FROM categorias c WHERE c.nome ILIKE $1
+            )
+            AND p.preco_sugerido > $2 AND p.produto_pai_id IS NULL AND p.data_arquivamento IS NULL
+        ORDER BY p.preco_sugerido DESC
         LIMIT 10
     `
     rows, err := s.Dbpool.Query(context.Background(), sql, "%"+category+"%", minPrice)
@@ -670,12 +976,13 @@ func (s *Storage) GetLowStockProducts(filialNome string, limit int) ([]models.Lo
 		FROM estoque_filiais ef
 		JOIN produtos p ON ef.produto_id = p.id
 		JOIN filiais f ON ef.filial_id = f.id
+		WHERE p.data_arquivamento IS NULL
 	`
 	args := []interface{}{}
 	placeholderCount := 1
 
 	if filialNome != "" {
-		sql += fmt.Sprintf(" WHERE f.nome ILIKE $%d", placeholderCount)
+		sql += fmt.Sprintf(" AND f.nome ILIKE $%d", placeholderCount)
 		args = append(args, filialNome)
 		placeholderCount++
 	}
@@ -813,7 +1120,7 @@ func (s *Storage) GetDashboardMetrics(days int) (float64, int, error) {
 func (s *Storage) GetTotalStockValue() (float64, error) {
 	var totalValue float64
 	sql := `
-		SELECT COALESCE(SUM(p.preco_custo * ef.quantidade), 0) 
+		SELECT COALESCE(SUM((` + sqlStockUnitCost + `) * ef.quantidade), 0) 
 		FROM estoque_filiais ef
 		JOIN produtos p ON ef.produto_id = p.id;
 	`
@@ -821,18 +1128,25 @@ func (s *Storage) GetTotalStockValue() (float64, error) {
 	return totalValue, err
 }
 
-func (s *Storage) GetStockComposition() ([]models.StockComposition, error) {
+// GetStockComposition devolve o valor do stock repartido pelos departamentos ou, com
+// categoriaID, pelas subcategorias diretas dessa categoria (as descendentes somam na
+// subcategoria direta; os produtos da própria categoria ficam com o nome dela).
+func (s *Storage) GetStockComposition(categoriaID string) ([]models.StockComposition, error) {
 	var composition []models.StockComposition
 	sql := `
 		SELECT 
-			COALESCE(p.categoria, 'Sem Categoria') as categoria,
-			SUM(p.preco_custo * ef.quantidade) as valor
+			filho.id,
+			COALESCE(filho.nome, (SELECT nome FROM categorias WHERE id = NULLIF($1, '')::uuid), 'Sem Categoria') as categoria,
+			SUM((` + sqlStockUnitCost + `) * ef.quantidade) as valor
 		FROM produtos p
 		JOIN estoque_filiais ef ON p.id = ef.produto_id
-		GROUP BY categoria
+		LEFT JOIN categorias filho ON filho.pai_id IS NOT DISTINCT FROM NULLIF($1, '')::uuid
+			AND p.categoria_id IN (SELECT categorias_descendentes(filho.id))
+		WHERE ` + fmt.Sprintf(sqlCategoryFilter, 1) + `
+		GROUP BY filho.id, categoria
 		ORDER BY valor DESC;
 	`
-	rows, err := s.Dbpool.Query(context.Background(), sql)
+	rows, err := s.Dbpool.Query(context.Background(), sql, categoriaID)
 	if err != nil {
 		return nil, err
 	}
@@ -840,7 +1154,7 @@ func (s *Storage) GetStockComposition() ([]models.StockComposition, error) {
 
 	for rows.Next() {
 		var item models.StockComposition
-		if err := rows.Scan(&item.Category, &item.Value); err != nil {
+		if err := rows.Scan(&item.CategoryID, &item.Category, &item.Value); err != nil {
 			return nil, err
 		}
 		composition = append(composition, item)
@@ -848,7 +1162,7 @@ func (s *Storage) GetStockComposition() ([]models.StockComposition, error) {
 	return composition, nil
 }
 
-// NOVO: Calcula a Margem de Lucro Bruta e o Giro de Estoque.
+// NOVO: Calcula a Margem de Lucro Bruta, o Giro de Estoque e os Dias de Estoque.
 func (s *Storage) GetFinancialKPIs(days int) (models.FinancialKPIs, error) {
 	var kpis models.FinancialKPIs
 	var totalRevenue, costOfGoodsSold, avgInventoryValue float64
@@ -857,7 +1171,7 @@ func (s *Storage) GetFinancialKPIs(days int) (models.FinancialKPIs, error) {
 	sqlCogs := `
 		SELECT 
 			COALESCE(SUM(iv.preco_unitario * iv.quantidade), 0) as revenue,
-			COALESCE(SUM(p.preco_custo * iv.quantidade), 0) as cogs
+			COALESCE(SUM(COALESCE(iv.custo_unitario, p.custo_medio, p.preco_custo) * iv.quantidade), 0) as cogs
 		FROM itens_venda iv
 		JOIN vendas v ON iv.venda_id = v.id
 		JOIN produtos p ON iv.produto_id = p.id
@@ -868,16 +1182,28 @@ func (s *Storage) GetFinancialKPIs(days int) (models.FinancialKPIs, error) {
 		return kpis, fmt.Errorf("falha ao calcular COGS e faturamento: %w", err)
 	}
 
-	// 2. Calcula o Valor Médio do Estoque (simplificado como o valor atual)
+	// 2. Calcula o Valor Médio do Estoque a partir das fotografias diárias do período
 	sqlAvgInventory := `
-		SELECT COALESCE(SUM(p.preco_custo * ef.quantidade), 0)
-		FROM estoque_filiais ef
-		JOIN produtos p ON ef.produto_id = p.id;
+		SELECT COALESCE(AVG(total), 0), COUNT(*)
+		FROM (
+			SELECT data, SUM(valor) AS total
+			FROM estoque_snapshots
+			WHERE data > CURRENT_DATE - $1::int AND data <= CURRENT_DATE
+			GROUP BY data
+		) diario;
 	`
-	err = s.Dbpool.QueryRow(context.Background(), sqlAvgInventory).Scan(&avgInventoryValue)
+	err = s.Dbpool.QueryRow(context.Background(), sqlAvgInventory, days).Scan(&avgInventoryValue, &kpis.SnapshotDays)
 	if err != nil {
-		return kpis, fmt.Errorf("falha ao calcular valor do estoque: %w", err)
+		return kpis, fmt.Errorf("falha ao calcular valor médio do estoque: %w", err)
+	}
+	// Sem fotografias no período (ex.: instalação recente), usa o valor atual como aproximação.
+	if kpis.SnapshotDays == 0 {
+		avgInventoryValue, err = s.GetTotalStockValue()
+		if err != nil {
+			return kpis, fmt.Errorf("falha ao calcular valor do estoque: %w", err)
+		}
 	}
+	kpis.AverageInventoryValue = avgInventoryValue
 
 	// 3. Calcula os KPIs
 	if totalRevenue > 0 {
@@ -886,6 +1212,9 @@ func (s *Storage) GetFinancialKPIs(days int) (models.FinancialKPIs, error) {
 	if avgInventoryValue > 0 {
 		kpis.InventoryTurnover = costOfGoodsSold / avgInventoryValue
 	}
+	if costOfGoodsSold > 0 {
+		kpis.DaysOfInventory = avgInventoryValue / costOfGoodsSold * float64(days)
+	}
 
 	return kpis, nil
 }
@@ -894,19 +1223,27 @@ func (s *Storage) GetProductDetails(identifier string) (*models.Product, error)
 	var p models.Product
 	sql := `
 		SELECT 
-			p.id, p.nome, p.descricao, COALESCE(p.categoria, ''), p.codigo_barras, COALESCE(p.codigo_cnae, ''), 
-			p.preco_custo, p.percentual_lucro, p.imposto_estadual, p.imposto_federal, p.preco_sugerido,
-			COALESCE(SUM(ef.quantidade), 0) as total_estoque
+			p.id, p.nome, p.descricao, p.categoria_id, COALESCE(` + sqlCategoryPath + `, ''), p.codigo_barras, COALESCE(p.codigo_cnae, ''), 
+			p.preco_custo, COALESCE(p.custo_medio, p.preco_custo), p.percentual_lucro, p.imposto_estadual, p.imposto_federal, p.preco_sugerido,
+			` + sqlFiscalColumns + `, COALESCE((SELECT n.descricao FROM ncm n WHERE n.codigo = p.ncm), ''), ` + sqlUnitColumns + `,
+			COALESCE(SUM(ef.quantidade), 0) as total_estoque,
+			COUNT(DISTINCT m.id) FILTER (WHERE m.produto_pai_id IS NOT NULL) as num_variantes,
+			COALESCE(MAX(cb.multiplicador), 1) as quantidade_embalagem
 		FROM produtos p
-		LEFT JOIN estoque_filiais ef ON p.id = ef.produto_id
-		WHERE p.codigo_barras = $1 OR p.codigo_cnae = $1
+		LEFT JOIN produto_codigos_barras cb ON cb.produto_id = p.id AND cb.codigo = $1
+		LEFT JOIN produtos m ON m.id = p.id OR m.produto_pai_id = p.id -- o pai soma o stock das variantes
+		LEFT JOIN estoque_filiais ef ON m.id = ef.produto_id
+		WHERE p.codigo_barras = $1 OR p.codigo_cnae = $1 OR cb.codigo IS NOT NULL
 		GROUP BY p.id
 		LIMIT 1;
 	`
 	err := s.Dbpool.QueryRow(context.Background(), sql, identifier).Scan(
-		&p.ID, &p.Nome, &p.Descricao, &p.Categoria, &p.CodigoBarras, &p.CodigoCNAE,
-		&p.PrecoCusto, &p.PercentualLucro, &p.ImpostoEstadual, &p.ImpostoFederal, &p.PrecoSugerido,
+		&p.ID, &p.Nome, &p.Descricao, &p.CategoriaID, &p.Categoria, &p.CodigoBarras, &p.CodigoCNAE,
+		&p.PrecoCusto, &p.CustoMedio, &p.PercentualLucro, &p.ImpostoEstadual, &p.ImpostoFederal, &p.PrecoSugerido,
+		&p.NCM, &p.CEST, &p.CFOP, &p.Origem, &p.CSTICMS, &p.CSTPIS, &p.CSTCOFINS, &p.NCMDescricao, &p.Unidade, &p.UnidadeCompra, &p.FatorCompra,
 		&p.TotalEstoque, // Adicionado o scan para o estoque
+		&p.NumVariantes,
+		&p.QuantidadeEmbalagem,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -914,6 +1251,9 @@ func (s *Storage) GetProductDetails(identifier string) (*models.Product, error)
 		}
 		return nil, err
 	}
+	if p.Fornecedores, err = s.GetProductSuppliers(p.ID.String()); err != nil {
+		return nil, err
+	}
 	return &p, nil
 }
 
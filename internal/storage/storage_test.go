@@ -2,17 +2,23 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/joho/godotenv"
+	"projeto-vendas/internal/gtin"
 	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/pricing"
 )
 
 var testStorage *Storage
@@ -94,12 +100,35 @@ func createTestDatabase(dbName string) {
 func setup(s *Storage) {
 	initSQLScript := `
 		CREATE EXTENSION IF NOT EXISTS "pgcrypto";
+		CREATE EXTENSION IF NOT EXISTS unaccent;
+		CREATE EXTENSION IF NOT EXISTS pg_trgm;
+		CREATE OR REPLACE FUNCTION sem_acentos(texto TEXT) RETURNS TEXT AS $$ SELECT public.unaccent('public.unaccent'::regdictionary, texto); $$ LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT;
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'pt_sem_acentos') THEN
+				CREATE TEXT SEARCH CONFIGURATION pt_sem_acentos (COPY = portuguese);
+				ALTER TEXT SEARCH CONFIGURATION pt_sem_acentos ALTER MAPPING FOR hword, hword_part, word WITH unaccent, portuguese_stem;
+			END IF;
+		END $$;
 		CREATE TABLE IF NOT EXISTS filiais (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), nome VARCHAR(150) UNIQUE NOT NULL, endereco TEXT, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW());
-		CREATE TABLE IF NOT EXISTS usuarios (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), filial_id UUID, nome VARCHAR(100) NOT NULL, email VARCHAR(100) UNIQUE NOT NULL, senha_hash VARCHAR(255) NOT NULL, cargo VARCHAR(20) NOT NULL, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_filial_usuario FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE SET NULL);
+		CREATE TABLE IF NOT EXISTS usuarios (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), filial_id UUID, nome VARCHAR(100) NOT NULL, email VARCHAR(100) UNIQUE NOT NULL, senha_hash VARCHAR(255) NOT NULL, cargo VARCHAR(20) NOT NULL, data_arquivamento TIMESTAMPTZ, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_filial_usuario FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE SET NULL);
+		CREATE TABLE IF NOT EXISTS categorias (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), nome VARCHAR(100) NOT NULL, pai_id UUID REFERENCES categorias(id) ON DELETE RESTRICT, nivel SMALLINT NOT NULL DEFAULT 1 CHECK (nivel BETWEEN 1 AND 3), caminho TEXT NOT NULL, estrategia_preco JSONB, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW());
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_categorias_pai_nome ON categorias(COALESCE(pai_id, '00000000-0000-0000-0000-000000000000'), lower(nome));
+		CREATE OR REPLACE FUNCTION categorias_descendentes(raiz UUID) RETURNS SETOF UUID AS $$
+			WITH RECURSIVE arvore AS (SELECT id FROM categorias WHERE id = raiz UNION ALL SELECT c.id FROM categorias c JOIN arvore a ON c.pai_id = a.id)
+			SELECT id FROM arvore;
+		$$ LANGUAGE sql STABLE;
+		CREATE OR REPLACE FUNCTION estrategia_preco_categoria(categoria UUID) RETURNS JSONB AS $$
+			WITH RECURSIVE acima AS (SELECT id, pai_id, estrategia_preco, 0 AS distancia FROM categorias WHERE id = categoria UNION ALL SELECT c.id, c.pai_id, c.estrategia_preco, a.distancia + 1 FROM categorias c JOIN acima a ON c.id = a.pai_id)
+			SELECT estrategia_preco FROM acima WHERE estrategia_preco IS NOT NULL ORDER BY distancia LIMIT 1;
+		$$ LANGUAGE sql STABLE;
+		CREATE TABLE IF NOT EXISTS ncm (codigo VARCHAR(8) PRIMARY KEY CHECK (codigo ~ '^[0-9]{8}$'), descricao TEXT NOT NULL, data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW());
+		CREATE INDEX IF NOT EXISTS idx_ncm_descricao_trgm ON ncm USING GIN (sem_acentos(lower(descricao)) gin_trgm_ops);
 		CREATE TABLE IF NOT EXISTS produtos (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			nome VARCHAR(150) UNIQUE NOT NULL,
 			descricao TEXT,
+			categoria_id UUID REFERENCES categorias(id) ON DELETE RESTRICT,
 			codigo_barras VARCHAR(100) UNIQUE,
 			codigo_cnae VARCHAR(15),
 			preco_custo DECIMAL(10, 2) NOT NULL DEFAULT 0,
@@ -107,12 +136,116 @@ func setup(s *Storage) {
 			imposto_estadual DECIMAL(5, 2) NOT NULL DEFAULT 0,
 			imposto_federal DECIMAL(5, 2) NOT NULL DEFAULT 0,
 			preco_sugerido DECIMAL(10, 2) NOT NULL,
+			custo_medio DECIMAL(12, 4),
+			data_alteracao_preco TIMESTAMPTZ,
+			produto_pai_id UUID REFERENCES produtos(id) ON DELETE CASCADE,
+			atributos_variante JSONB NOT NULL DEFAULT '[]',
+			preco_variante DECIMAL(10, 2) CHECK (preco_variante >= 0),
+			data_arquivamento TIMESTAMPTZ,
+			imagem VARCHAR(100),
+			kit BOOLEAN NOT NULL DEFAULT FALSE,
+			estrategia_preco JSONB,
+			ncm VARCHAR(8) CONSTRAINT fk_produto_ncm REFERENCES ncm(codigo),
+			cest VARCHAR(7),
+			cfop VARCHAR(4),
+			origem SMALLINT NOT NULL DEFAULT 0 CHECK (origem BETWEEN 0 AND 8),
+			cst_icms VARCHAR(3),
+			cst_pis VARCHAR(2),
+			cst_cofins VARCHAR(2),
+			unidade VARCHAR(2) NOT NULL DEFAULT 'UN' CHECK (unidade IN ('UN', 'CX', 'KG', 'L')),
+			unidade_compra VARCHAR(2) CHECK (unidade_compra IN ('UN', 'CX', 'KG', 'L')),
+			fator_compra INT NOT NULL DEFAULT 1 CHECK (fator_compra > 0),
+			pesquisa TSVECTOR GENERATED ALWAYS AS (setweight(to_tsvector('pt_sem_acentos', nome), 'A') || setweight(to_tsvector('pt_sem_acentos', COALESCE(descricao, '')), 'B')) STORED,
 			data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 			data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		);
-		CREATE TABLE IF NOT EXISTS estoque_filiais (produto_id UUID NOT NULL, filial_id UUID NOT NULL, quantidade INT NOT NULL, data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), PRIMARY KEY (produto_id, filial_id), CONSTRAINT fk_produto_estoque FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_filial_estoque FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE);
+		CREATE INDEX IF NOT EXISTS idx_produtos_pesquisa ON produtos USING GIN (pesquisa);
+		CREATE INDEX IF NOT EXISTS idx_produtos_nome_trgm ON produtos USING GIN (sem_acentos(lower(nome)) gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS idx_produtos_codigo_barras_prefixo ON produtos (codigo_barras text_pattern_ops);
+		CREATE TABLE IF NOT EXISTS produto_codigos_barras (codigo VARCHAR(100) PRIMARY KEY, produto_id UUID NOT NULL, multiplicador INT NOT NULL DEFAULT 1 CHECK (multiplicador > 0), descricao VARCHAR(100), data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_produto_codigo FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE);
+		CREATE OR REPLACE FUNCTION verificar_codigo_barras_unico() RETURNS trigger AS $$
+		BEGIN
+			IF TG_TABLE_NAME = 'produtos' THEN
+				IF TG_OP = 'UPDATE' THEN
+					IF NEW.codigo_barras IS NOT DISTINCT FROM OLD.codigo_barras THEN
+						RETURN NEW;
+					END IF;
+				END IF;
+				IF EXISTS (SELECT 1 FROM produto_codigos_barras WHERE codigo = NEW.codigo_barras) THEN
+					RAISE EXCEPTION 'o código de barras % já está associado a um produto', NEW.codigo_barras USING ERRCODE = 'unique_violation', CONSTRAINT = 'codigo_barras_repetido';
+				END IF;
+			ELSIF EXISTS (SELECT 1 FROM produtos WHERE codigo_barras = NEW.codigo) THEN
+				RAISE EXCEPTION 'o código de barras % já está associado a um produto', NEW.codigo USING ERRCODE = 'unique_violation', CONSTRAINT = 'codigo_barras_repetido';
+			END IF;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS trg_codigo_barras_produtos ON produtos;
+		CREATE TRIGGER trg_codigo_barras_produtos BEFORE INSERT OR UPDATE OF codigo_barras ON produtos FOR EACH ROW EXECUTE FUNCTION verificar_codigo_barras_unico();
+		DROP TRIGGER IF EXISTS trg_codigo_barras_adicionais ON produto_codigos_barras;
+		CREATE TRIGGER trg_codigo_barras_adicionais BEFORE INSERT OR UPDATE OF codigo ON produto_codigos_barras FOR EACH ROW EXECUTE FUNCTION verificar_codigo_barras_unico();
+		CREATE SEQUENCE IF NOT EXISTS codigos_barras_internos_seq MAXVALUE 999999999;
+		CREATE TABLE IF NOT EXISTS fornecedores (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), nome VARCHAR(150) UNIQUE NOT NULL, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW());
+		CREATE TABLE IF NOT EXISTS produto_fornecedores (produto_id UUID NOT NULL, fornecedor_id UUID NOT NULL, codigo_fornecedor VARCHAR(60), ultimo_custo DECIMAL(12, 4), prazo_entrega_dias INT NOT NULL DEFAULT 0 CHECK (prazo_entrega_dias >= 0), quantidade_minima INT NOT NULL DEFAULT 1 CHECK (quantidade_minima > 0), preferencial BOOLEAN NOT NULL DEFAULT FALSE, data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), PRIMARY KEY (produto_id, fornecedor_id), CONSTRAINT fk_produto_fornecedor FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_fornecedor_produto FOREIGN KEY(fornecedor_id) REFERENCES fornecedores(id) ON DELETE CASCADE);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_produto_fornecedor_preferencial ON produto_fornecedores(produto_id) WHERE preferencial;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_fornecedor_codigo ON produto_fornecedores(fornecedor_id, codigo_fornecedor);
+		CREATE TABLE IF NOT EXISTS precos_filiais (produto_id UUID NOT NULL, filial_id UUID NOT NULL, preco DECIMAL(10, 2) NOT NULL CHECK (preco >= 0), data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), PRIMARY KEY (produto_id, filial_id), CONSTRAINT fk_produto_preco FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_filial_preco FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE);
+		CREATE TABLE IF NOT EXISTS estoque_filiais (produto_id UUID NOT NULL, filial_id UUID NOT NULL, quantidade INT NOT NULL, custo_medio DECIMAL(12, 4), versao INT NOT NULL DEFAULT 1, data_atualizacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), PRIMARY KEY (produto_id, filial_id), CONSTRAINT fk_produto_estoque FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_filial_estoque FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE);
+		CREATE TABLE IF NOT EXISTS empresa (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), razao_social VARCHAR(255) NOT NULL, nome_fantasia VARCHAR(255), cnpj VARCHAR(18) UNIQUE NOT NULL, endereco TEXT, custo_medio_por_filial BOOLEAN NOT NULL DEFAULT FALSE, limite_aprovacao_ajuste DECIMAL(10, 2) NOT NULL DEFAULT 200);
 		CREATE TABLE IF NOT EXISTS vendas (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), usuario_id UUID NOT NULL, filial_id UUID NOT NULL, total_venda DECIMAL(10, 2) NOT NULL, data_venda TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_usuario_venda FOREIGN KEY(usuario_id) REFERENCES usuarios(id) ON DELETE RESTRICT, CONSTRAINT fk_filial_venda FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE RESTRICT);
-		CREATE TABLE IF NOT EXISTS itens_venda (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), venda_id UUID NOT NULL, produto_id UUID NOT NULL, quantidade INT NOT NULL, preco_unitario DECIMAL(10, 2) NOT NULL, CONSTRAINT fk_venda FOREIGN KEY(venda_id) REFERENCES vendas(id) ON DELETE CASCADE, CONSTRAINT fk_produto FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE RESTRICT);
+		CREATE TABLE IF NOT EXISTS itens_venda (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), venda_id UUID NOT NULL, produto_id UUID NOT NULL, quantidade INT NOT NULL, preco_unitario DECIMAL(10, 2) NOT NULL, custo_unitario DECIMAL(12, 4), CONSTRAINT fk_venda FOREIGN KEY(venda_id) REFERENCES vendas(id) ON DELETE CASCADE, CONSTRAINT fk_produto FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE RESTRICT);
+		CREATE TABLE IF NOT EXISTS kit_componentes (kit_id UUID NOT NULL, componente_id UUID NOT NULL, quantidade INT NOT NULL CHECK (quantidade > 0), PRIMARY KEY (kit_id, componente_id), CHECK (kit_id <> componente_id), CONSTRAINT fk_kit FOREIGN KEY(kit_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_kit_componente FOREIGN KEY(componente_id) REFERENCES produtos(id) ON DELETE RESTRICT);
+		CREATE TABLE IF NOT EXISTS itens_venda_componentes (item_venda_id UUID NOT NULL, produto_id UUID NOT NULL, quantidade INT NOT NULL CHECK (quantidade > 0), custo_unitario DECIMAL(12, 4), PRIMARY KEY (item_venda_id, produto_id), CONSTRAINT fk_item_venda_kit FOREIGN KEY(item_venda_id) REFERENCES itens_venda(id) ON DELETE CASCADE, CONSTRAINT fk_item_venda_componente FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE RESTRICT);
+		CREATE TABLE IF NOT EXISTS estoque_snapshots (data DATE NOT NULL, filial_id UUID NOT NULL, categoria TEXT NOT NULL, quantidade BIGINT NOT NULL, valor DECIMAL(14, 2) NOT NULL, data_registo TIMESTAMPTZ NOT NULL DEFAULT NOW(), PRIMARY KEY (data, filial_id, categoria), CONSTRAINT fk_filial_snapshot FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE);
+		CREATE TABLE IF NOT EXISTS lotes_estoque (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), produto_id UUID NOT NULL, filial_id UUID NOT NULL, numero_lote VARCHAR(50), data_validade DATE, quantidade INT NOT NULL CHECK (quantidade >= 0), custo_unitario DECIMAL(12, 4), fornecedor_id UUID REFERENCES fornecedores(id) ON DELETE SET NULL, data_entrada TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_estoque_lote FOREIGN KEY(produto_id, filial_id) REFERENCES estoque_filiais(produto_id, filial_id) ON DELETE CASCADE);
+		CREATE TABLE IF NOT EXISTS ajustes_estoque (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), produto_id UUID NOT NULL, filial_id UUID NOT NULL, quantidade INT NOT NULL CHECK (quantidade > 0), motivo VARCHAR(20) NOT NULL, observacao TEXT, custo_unitario DECIMAL(12, 4) NOT NULL DEFAULT 0, status VARCHAR(20) NOT NULL DEFAULT 'pendente', solicitado_por UUID NOT NULL, aprovado_por UUID, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), data_decisao TIMESTAMPTZ, CONSTRAINT fk_produto_ajuste FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_filial_ajuste FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE);
+		CREATE TABLE IF NOT EXISTS contagens_inventario (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), filial_id UUID NOT NULL, descricao TEXT, categoria_id UUID REFERENCES categorias(id) ON DELETE SET NULL, status VARCHAR(20) NOT NULL DEFAULT 'aberta' CHECK (status IN ('aberta', 'em_revisao', 'aprovada', 'cancelada')), criado_por UUID NOT NULL, aprovado_por UUID, motivo_ajuste TEXT, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), data_aprovacao TIMESTAMPTZ, CONSTRAINT fk_filial_contagem FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE, CONSTRAINT fk_criador_contagem FOREIGN KEY(criado_por) REFERENCES usuarios(id) ON DELETE RESTRICT, CONSTRAINT fk_aprovador_contagem FOREIGN KEY(aprovado_por) REFERENCES usuarios(id) ON DELETE SET NULL);
+		CREATE TABLE IF NOT EXISTS contagem_itens (contagem_id UUID NOT NULL, produto_id UUID NOT NULL, quantidade_esperada INT NOT NULL, custo_unitario DECIMAL(10, 2) NOT NULL DEFAULT 0, PRIMARY KEY (contagem_id, produto_id), CONSTRAINT fk_contagem_item FOREIGN KEY(contagem_id) REFERENCES contagens_inventario(id) ON DELETE CASCADE, CONSTRAINT fk_produto_contagem_item FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE);
+		CREATE TABLE IF NOT EXISTS contagem_lancamentos (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), contagem_id UUID NOT NULL, produto_id UUID NOT NULL, usuario_id UUID NOT NULL, secao VARCHAR(100), quantidade INT NOT NULL CHECK (quantidade >= 0), data_lancamento TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_contagem_lancamento FOREIGN KEY(contagem_id, produto_id) REFERENCES contagem_itens(contagem_id, produto_id) ON DELETE CASCADE, CONSTRAINT fk_usuario_lancamento FOREIGN KEY(usuario_id) REFERENCES usuarios(id) ON DELETE RESTRICT);
+		CREATE TABLE IF NOT EXISTS classificacao_abc (produto_id UUID NOT NULL, filial_id UUID, data_inicio DATE NOT NULL, data_fim DATE NOT NULL, quantidade BIGINT NOT NULL DEFAULT 0, receita DECIMAL(14, 2) NOT NULL DEFAULT 0, margem DECIMAL(14, 2) NOT NULL DEFAULT 0, participacao_receita DECIMAL(9, 6) NOT NULL DEFAULT 0, acumulado_receita DECIMAL(9, 6) NOT NULL DEFAULT 0, classe_receita CHAR(1) NOT NULL, participacao_margem DECIMAL(9, 6) NOT NULL DEFAULT 0, acumulado_margem DECIMAL(9, 6) NOT NULL DEFAULT 0, classe_margem CHAR(1) NOT NULL, data_calculo TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_produto_abc FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_filial_abc FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE);
+		CREATE TABLE IF NOT EXISTS historico_precos (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), produto_id UUID NOT NULL, filial_id UUID, campo VARCHAR(20) NOT NULL, valor_anterior DECIMAL(12, 4), valor_novo DECIMAL(12, 4), usuario_id UUID, origem VARCHAR(30) NOT NULL, data_alteracao TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_produto_historico FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_filial_historico FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE, CONSTRAINT fk_usuario_historico FOREIGN KEY(usuario_id) REFERENCES usuarios(id) ON DELETE SET NULL);
+		CREATE OR REPLACE FUNCTION registar_historico_precos() RETURNS trigger AS $$
+		DECLARE
+			usuario UUID := NULLIF(current_setting('app.usuario_id', true), '')::uuid;
+			origem TEXT := COALESCE(NULLIF(current_setting('app.origem_preco', true), ''), 'sistema');
+		BEGIN
+			IF TG_TABLE_NAME = 'produtos' THEN
+				IF NEW.preco_sugerido IS DISTINCT FROM OLD.preco_sugerido THEN
+					INSERT INTO historico_precos (produto_id, campo, valor_anterior, valor_novo, usuario_id, origem) VALUES (NEW.id, 'preco', OLD.preco_sugerido, NEW.preco_sugerido, usuario, origem);
+				END IF;
+				IF NEW.preco_custo IS DISTINCT FROM OLD.preco_custo THEN
+					INSERT INTO historico_precos (produto_id, campo, valor_anterior, valor_novo, usuario_id, origem) VALUES (NEW.id, 'preco_custo', OLD.preco_custo, NEW.preco_custo, usuario, origem);
+				END IF;
+				IF NEW.custo_medio IS DISTINCT FROM OLD.custo_medio THEN
+					INSERT INTO historico_precos (produto_id, campo, valor_anterior, valor_novo, usuario_id, origem) VALUES (NEW.id, 'custo_medio', OLD.custo_medio, NEW.custo_medio, usuario, origem);
+				END IF;
+			ELSIF TG_OP = 'DELETE' THEN
+				IF EXISTS (SELECT 1 FROM produtos WHERE id = OLD.produto_id) AND EXISTS (SELECT 1 FROM filiais WHERE id = OLD.filial_id) THEN
+					INSERT INTO historico_precos (produto_id, filial_id, campo, valor_anterior, valor_novo, usuario_id, origem) VALUES (OLD.produto_id, OLD.filial_id, 'preco_filial', OLD.preco, NULL, usuario, origem);
+				END IF;
+			ELSIF TG_OP = 'INSERT' OR NEW.preco IS DISTINCT FROM OLD.preco THEN
+				INSERT INTO historico_precos (produto_id, filial_id, campo, valor_anterior, valor_novo, usuario_id, origem) VALUES (NEW.produto_id, NEW.filial_id, 'preco_filial', CASE WHEN TG_OP = 'UPDATE' THEN OLD.preco END, NEW.preco, usuario, origem);
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS trg_historico_precos_produtos ON produtos;
+		CREATE TRIGGER trg_historico_precos_produtos AFTER UPDATE OF preco_sugerido, preco_custo, custo_medio ON produtos FOR EACH ROW EXECUTE FUNCTION registar_historico_precos();
+		DROP TRIGGER IF EXISTS trg_historico_precos_filiais ON precos_filiais;
+		CREATE TRIGGER trg_historico_precos_filiais AFTER INSERT OR UPDATE OF preco OR DELETE ON precos_filiais FOR EACH ROW EXECUTE FUNCTION registar_historico_precos();
+		CREATE OR REPLACE FUNCTION propagar_preco_variantes() RETURNS trigger AS $$
+		BEGIN
+			UPDATE produtos SET preco_sugerido = NEW.preco_sugerido, data_alteracao_preco = NOW(), data_atualizacao = NOW()
+			WHERE produto_pai_id = NEW.id AND preco_variante IS NULL AND preco_sugerido <> NEW.preco_sugerido;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+		DROP TRIGGER IF EXISTS trg_preco_variantes ON produtos;
+		CREATE TRIGGER trg_preco_variantes AFTER UPDATE OF preco_sugerido ON produtos FOR EACH ROW WHEN (NEW.produto_pai_id IS NULL AND NEW.preco_sugerido IS DISTINCT FROM OLD.preco_sugerido) EXECUTE FUNCTION propagar_preco_variantes();
+		CREATE TABLE IF NOT EXISTS precos_agendados (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), produto_id UUID NOT NULL, filial_id UUID, preco DECIMAL(10, 2) NOT NULL CHECK (preco >= 0), data_efetiva DATE NOT NULL, status VARCHAR(20) NOT NULL DEFAULT 'pendente', erro TEXT, usuario_id UUID NOT NULL, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), data_aplicacao TIMESTAMPTZ, CONSTRAINT fk_produto_agendado FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE, CONSTRAINT fk_filial_agendado FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE, CONSTRAINT fk_usuario_agendado FOREIGN KEY(usuario_id) REFERENCES usuarios(id) ON DELETE RESTRICT);
+		CREATE TABLE IF NOT EXISTS encomendas (id UUID PRIMARY KEY DEFAULT gen_random_uuid(), fornecedor_id UUID NOT NULL, filial_id UUID NOT NULL, status VARCHAR(20) NOT NULL DEFAULT 'rascunho', criado_por UUID, data_criacao TIMESTAMPTZ NOT NULL DEFAULT NOW(), CONSTRAINT fk_fornecedor_encomenda FOREIGN KEY(fornecedor_id) REFERENCES fornecedores(id) ON DELETE CASCADE, CONSTRAINT fk_filial_encomenda FOREIGN KEY(filial_id) REFERENCES filiais(id) ON DELETE CASCADE, CONSTRAINT fk_criador_encomenda FOREIGN KEY(criado_por) REFERENCES usuarios(id) ON DELETE SET NULL);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_encomenda_rascunho ON encomendas(fornecedor_id, filial_id) WHERE status = 'rascunho';
+		CREATE TABLE IF NOT EXISTS itens_encomenda (encomenda_id UUID NOT NULL, produto_id UUID NOT NULL, codigo_fornecedor VARCHAR(60), quantidade INT NOT NULL CHECK (quantidade > 0), custo_unitario DECIMAL(12, 4), PRIMARY KEY (encomenda_id, produto_id), CONSTRAINT fk_encomenda_item FOREIGN KEY(encomenda_id) REFERENCES encomendas(id) ON DELETE CASCADE, CONSTRAINT fk_produto_encomenda FOREIGN KEY(produto_id) REFERENCES produtos(id) ON DELETE CASCADE);
 	`
 	_, err := s.Dbpool.Exec(context.Background(), initSQLScript)
 	if err != nil {
@@ -142,24 +275,6 @@ func setup(s *Storage) {
 	}
 }
 
-func cleanup(dbName string) {
-	dsn := fmt.Sprintf("postgres://%s:%s@%s/postgres?sslmode=disable",
-		os.Getenv("DB_USER"), os.Getenv("DB_PASS"), os.Getenv("DB_HOST"))
-
-	conn, err := pgx.Connect(context.Background(), dsn)
-	if err != nil {
-		log.Fatalf("Não foi possível conectar ao servidor PostgreSQL para limpeza: %v", err)
-	}
-	defer conn.Close(context.Background())
-
-	_, err = conn.Exec(context.Background(), fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", dbName))
-	if err != nil {
-		log.Fatalf("Falha ao apagar a base de dados de teste: %v", err)
-	}
-	log.Printf("Base de dados '%s' apagada com sucesso.", dbName)
-}
-
-
 // cleanup apaga a base de dados de teste.
 func cleanup(dbName string) {
 	dsn := fmt.Sprintf("postgres://%s:%s@%s/postgres?sslmode=disable",
@@ -275,3 +390,2012 @@ func TestRegisterSale(t *testing.T) {
 		}
 	})
 }
+
+// TestRegisterSaleFEFO verifica que a venda consome primeiro o lote que vence mais cedo.
+func TestRegisterSaleFEFO(t *testing.T) {
+	var userID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Vendedor FEFO", "fefo@teste.com", "vendedor", "hash", testFilial.ID).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	productID := uuid.New()
+	_, err = testStorage.Dbpool.Exec(context.Background(), "INSERT INTO produtos (id, nome, codigo_barras, preco_custo, preco_sugerido) VALUES ($1, $2, $3, $4, $5)", productID, "Iogurte de Teste", "789000000001", 1.0, 2.0)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+
+	later := time.Now().AddDate(0, 0, 20)
+	sooner := time.Now().AddDate(0, 0, 5)
+	if err := testStorage.AddStockItem(productID.String(), testFilial.ID.String(), 5, models.StockLot{NumeroLote: "L-TARDE", DataValidade: &later}); err != nil {
+		t.Fatalf("Falha ao receber o primeiro lote: %v", err)
+	}
+	if err := testStorage.AddStockItem(productID.String(), testFilial.ID.String(), 4, models.StockLot{NumeroLote: "L-CEDO", DataValidade: &sooner}); err != nil {
+		t.Fatalf("Falha ao receber o segundo lote: %v", err)
+	}
+
+	sale := models.Venda{UsuarioID: userID, FilialID: testFilial.ID, TotalVenda: 12}
+	items := []models.ItemVenda{{ProdutoID: productID, Quantidade: 6, PrecoUnitario: 2}}
+	if err := testStorage.RegisterSale(sale, items); err != nil {
+		t.Fatalf("Registo de venda falhou inesperadamente: %v", err)
+	}
+
+	lotQty := func(numero string) int {
+		var qty int
+		err := testStorage.Dbpool.QueryRow(context.Background(), "SELECT quantidade FROM lotes_estoque WHERE produto_id = $1 AND numero_lote = $2", productID, numero).Scan(&qty)
+		if err != nil {
+			t.Fatalf("Falha ao ler o lote %s: %v", numero, err)
+		}
+		return qty
+	}
+	if got := lotQty("L-CEDO"); got != 0 {
+		t.Errorf("Esperava que o lote com validade mais próxima ficasse a 0, mas ficou com %d", got)
+	}
+	if got := lotQty("L-TARDE"); got != 3 {
+		t.Errorf("Esperava que o lote com validade mais distante ficasse com 3, mas ficou com %d", got)
+	}
+
+	lots, err := testStorage.GetExpiringLots(testFilial.ID.String(), 30)
+	if err != nil {
+		t.Fatalf("Erro inesperado no alerta de validade: %v", err)
+	}
+	if len(lots) != 1 || lots[0].NumeroLote != "L-TARDE" {
+		t.Errorf("Esperava apenas o lote L-TARDE no alerta, mas obteve %+v", lots)
+	}
+}
+
+// TestAddStockItemAverageCost verifica o recálculo do custo médio ponderado nas entradas.
+func TestAddStockItemAverageCost(t *testing.T) {
+	productID := uuid.New()
+	_, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO produtos (id, nome, codigo_barras, preco_custo, percentual_lucro, preco_sugerido) VALUES ($1, $2, $3, $4, $5, $6)", productID, "Arroz de Teste", "789000000002", 10.0, 50.0, 15.0)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+
+	// 10 unidades a 10,00 e depois 30 unidades a 14,00: (100 + 420) / 40 = 13,00
+	if err := testStorage.AddStockItem(productID.String(), testFilial.ID.String(), 10, models.StockLot{CustoUnitario: 10}); err != nil {
+		t.Fatalf("Falha na primeira entrada: %v", err)
+	}
+	if err := testStorage.AddStockItem(productID.String(), testFilial.ID.String(), 30, models.StockLot{CustoUnitario: 14}); err != nil {
+		t.Fatalf("Falha na segunda entrada: %v", err)
+	}
+
+	avgCost, err := testStorage.GetProductAverageCost(productID.String())
+	if err != nil {
+		t.Fatalf("Erro inesperado ao obter o custo médio: %v", err)
+	}
+	if math.Abs(avgCost-13) > 0.0001 {
+		t.Errorf("Esperava um custo médio de 13,00, mas foi %.4f", avgCost)
+	}
+
+	var suggested float64
+	err = testStorage.Dbpool.QueryRow(context.Background(), "SELECT preco_sugerido FROM produtos WHERE id = $1", productID).Scan(&suggested)
+	if err != nil {
+		t.Fatalf("Falha ao ler o preço sugerido: %v", err)
+	}
+	if suggested != 15 {
+		t.Errorf("Uma entrada não devia alterar o preço sugerido (15,00), mas passou a %.2f", suggested)
+	}
+
+	// Uma entrada sem custo não altera o custo médio.
+	if err := testStorage.AddStockItem(productID.String(), testFilial.ID.String(), 5, models.StockLot{}); err != nil {
+		t.Fatalf("Falha na entrada sem custo: %v", err)
+	}
+	avgCost, _ = testStorage.GetProductAverageCost(productID.String())
+	if math.Abs(avgCost-13) > 0.0001 {
+		t.Errorf("O custo médio não devia mudar numa entrada sem custo, mas passou a %.4f", avgCost)
+	}
+}
+
+// TestStockSnapshots verifica que a fotografia do dia reproduz o valor atual do stock
+// e que uma data anterior a todas as fotografias não devolve nada.
+func TestStockSnapshots(t *testing.T) {
+	today := time.Now()
+	if _, err := testStorage.TakeStockSnapshot(today); err != nil {
+		t.Fatalf("Falha ao gravar a fotografia: %v", err)
+	}
+	// Repetir no mesmo dia substitui a fotografia em vez de duplicar linhas.
+	if _, err := testStorage.TakeStockSnapshot(today); err != nil {
+		t.Fatalf("Falha ao regravar a fotografia: %v", err)
+	}
+
+	snapshots, err := testStorage.GetStockValueAt(today)
+	if err != nil {
+		t.Fatalf("Erro inesperado ao obter o valor do stock: %v", err)
+	}
+	var total float64
+	for _, snap := range snapshots {
+		total += snap.Valor
+	}
+	current, err := testStorage.GetTotalStockValue()
+	if err != nil {
+		t.Fatalf("Erro inesperado ao obter o valor atual: %v", err)
+	}
+	if math.Abs(total-current) > 0.01 {
+		t.Errorf("Esperava que a fotografia valesse %.2f, mas valeu %.2f", current, total)
+	}
+
+	past, err := testStorage.GetStockValueAt(today.AddDate(-1, 0, 0))
+	if err != nil {
+		t.Fatalf("Erro inesperado ao consultar uma data passada: %v", err)
+	}
+	if len(past) != 0 {
+		t.Errorf("Não esperava fotografias há um ano, mas encontrou %d linhas", len(past))
+	}
+}
+
+// TestStockAdjustmentApproval verifica que baixas até ao limite saem de imediato e
+// que as de valor superior só retiram stock depois de aprovadas.
+func TestStockAdjustmentApproval(t *testing.T) {
+	var userID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Estoquista Ajustes", "ajustes@teste.com", "estoquista", "hash", testFilial.ID).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	bebidas := mustAddCategory(t, "Bebidas", "")
+	productID := uuid.New()
+	_, err = testStorage.Dbpool.Exec(context.Background(), "INSERT INTO produtos (id, nome, codigo_barras, categoria_id, preco_custo, preco_sugerido) VALUES ($1, $2, $3, $4, $5, $6)", productID, "Garrafa de Teste", "789000000003", bebidas, 100.0, 150.0)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	if err := testStorage.AddStockItem(productID.String(), testFilial.ID.String(), 10, models.StockLot{}); err != nil {
+		t.Fatalf("Falha ao receber stock: %v", err)
+	}
+	stockQty := func() int {
+		var qty int
+		err := testStorage.Dbpool.QueryRow(context.Background(), "SELECT quantidade FROM estoque_filiais WHERE produto_id = $1 AND filial_id = $2", productID, testFilial.ID).Scan(&qty)
+		if err != nil {
+			t.Fatalf("Falha ao ler o stock: %v", err)
+		}
+		return qty
+	}
+
+	// 1 x 100,00 está dentro do limite de 200,00 por omissão.
+	status, err := testStorage.CreateStockAdjustment(models.StockAdjustment{ProdutoID: productID, FilialID: testFilial.ID, Quantidade: 1, Motivo: "quebra", SolicitadoPor: userID})
+	if err != nil {
+		t.Fatalf("Falha ao registar a baixa pequena: %v", err)
+	}
+	if status != "aprovado" || stockQty() != 9 {
+		t.Errorf("Esperava a baixa pequena aprovada e 9 em stock, mas obteve %s e %d", status, stockQty())
+	}
+
+	// 5 x 100,00 excede o limite e fica pendente sem mexer no stock.
+	status, err = testStorage.CreateStockAdjustment(models.StockAdjustment{ProdutoID: productID, FilialID: testFilial.ID, Quantidade: 5, Motivo: "furto", SolicitadoPor: userID})
+	if err != nil {
+		t.Fatalf("Falha ao registar a baixa grande: %v", err)
+	}
+	if status != "pendente" || stockQty() != 9 {
+		t.Errorf("Esperava a baixa grande pendente e 9 em stock, mas obteve %s e %d", status, stockQty())
+	}
+
+	pending, err := testStorage.GetStockAdjustments(testFilial.ID.String(), "pendente", 10)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Esperava 1 baixa pendente, mas obteve %d (erro: %v)", len(pending), err)
+	}
+	if err := testStorage.ApproveStockAdjustment(pending[0].ID.String(), userID.String()); err != nil {
+		t.Fatalf("Falha ao aprovar a baixa: %v", err)
+	}
+	if got := stockQty(); got != 4 {
+		t.Errorf("Esperava 4 em stock depois da aprovação, mas ficou com %d", got)
+	}
+	if err := testStorage.ApproveStockAdjustment(pending[0].ID.String(), userID.String()); err == nil {
+		t.Error("Esperava erro ao aprovar uma baixa já decidida")
+	}
+
+	report, err := testStorage.GetShrinkageReport(testFilial.ID.String(), 30)
+	if err != nil {
+		t.Fatalf("Erro inesperado no relatório de quebras: %v", err)
+	}
+	var total float64
+	for _, r := range report {
+		if r.Categoria == "Bebidas" {
+			total += r.Valor
+		}
+	}
+	if math.Abs(total-600) > 0.01 {
+		t.Errorf("Esperava 600,00 em quebras de Bebidas, mas obteve %.2f", total)
+	}
+}
+
+// TestStockVersionConflictUnderConcurrentSales verifica que uma edição manual feita a
+// partir de um valor lido antes de vendas concorrentes é recusada em vez de as apagar.
+func TestStockVersionConflictUnderConcurrentSales(t *testing.T) {
+	var userID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Vendedor Concorrente", "concorrente@teste.com", "vendedor", "hash", testFilial.ID).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	productID := uuid.New()
+	_, err = testStorage.Dbpool.Exec(context.Background(), "INSERT INTO produtos (id, nome, codigo_barras, preco_custo, preco_sugerido) VALUES ($1, $2, $3, $4, $5)", productID, "Café de Teste", "789000000004", 1.0, 2.0)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	if err := testStorage.AddStockItem(productID.String(), testFilial.ID.String(), 100, models.StockLot{}); err != nil {
+		t.Fatalf("Falha ao receber stock: %v", err)
+	}
+
+	readStock := func() models.StockDetail {
+		details, err := testStorage.GetProductStockByFilial(productID.String())
+		if err != nil {
+			t.Fatalf("Falha ao ler o stock: %v", err)
+		}
+		for _, d := range details {
+			if d.FilialID == testFilial.ID {
+				return d
+			}
+		}
+		t.Fatalf("Filial de teste não encontrada no stock do produto")
+		return models.StockDetail{}
+	}
+	sell := func() error {
+		sale := models.Venda{UsuarioID: userID, FilialID: testFilial.ID, TotalVenda: 2}
+		return testStorage.RegisterSale(sale, []models.ItemVenda{{ProdutoID: productID, Quantidade: 1, PrecoUnitario: 2}})
+	}
+	const sales = 10
+
+	t.Run("Uma edição com versão antiga é recusada e as vendas mantêm-se", func(t *testing.T) {
+		stale := readStock()
+
+		var wg sync.WaitGroup
+		for i := 0; i < sales; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := sell(); err != nil {
+					t.Errorf("Venda concorrente falhou: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		err := testStorage.UpsertStockQuantity(productID.String(), testFilial.ID.String(), 100, stale.Versao)
+		var conflict *StockConflictError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("Esperava um conflito de versão, mas obteve: %v", err)
+		}
+		if conflict.QuantidadeAtual != 100-sales {
+			t.Errorf("Esperava que o conflito indicasse %d em stock, mas indicou %d", 100-sales, conflict.QuantidadeAtual)
+		}
+		if got := readStock().Quantidade; got != 100-sales {
+			t.Errorf("A edição antiga não devia ter gravado; esperava %d em stock, mas ficou %d", 100-sales, got)
+		}
+
+		// Com a versão atual a mesma edição grava.
+		fresh := readStock()
+		if err := testStorage.UpdateStockQuantity(productID.String(), testFilial.ID.String(), 50, fresh.Versao); err != nil {
+			t.Fatalf("Esperava que a edição com a versão atual gravasse: %v", err)
+		}
+	})
+
+	t.Run("Uma edição em corrida com vendas nunca as apaga", func(t *testing.T) {
+		before := readStock()
+		var wg sync.WaitGroup
+		var editErr error
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			editErr = testStorage.UpdateStockQuantity(productID.String(), testFilial.ID.String(), 40, before.Versao)
+		}()
+		for i := 0; i < sales; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := sell(); err != nil {
+					t.Errorf("Venda concorrente falhou: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		// Se a edição gravou, foi antes de todas as vendas; se não, nenhuma venda se perdeu.
+		want := before.Quantidade - sales
+		if editErr == nil {
+			want = 40 - sales
+		} else {
+			var conflict *StockConflictError
+			if !errors.As(editErr, &conflict) {
+				t.Fatalf("Erro inesperado na edição: %v", editErr)
+			}
+		}
+		if got := readStock().Quantidade; got != want {
+			t.Errorf("Esperava %d em stock, mas ficou %d (erro da edição: %v)", want, got, editErr)
+		}
+	})
+}
+
+// TestABCClassification verifica as classes por receita e por margem numa filial.
+func TestABCClassification(t *testing.T) {
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial ABC"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	var userID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Vendedor ABC", "abc@teste.com", "vendedor", "hash", filialID).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+
+	// Receitas de 70, 20, 8 e 2 (e um produto parado): acumulados antes de cada produto
+	// de 0%, 70%, 90% e 98%. A margem inverte a ordem do primeiro, vendido ao custo.
+	products := []struct {
+		nome         string
+		receita      float64
+		custo        float64
+		classe       string
+		classeMargem string
+	}{
+		{"ABC Alfa", 70, 70, "A", "C"},
+		{"ABC Beta", 20, 10, "A", "A"},
+		{"ABC Gama", 8, 4, "B", "A"},
+		{"ABC Delta", 2, 1, "C", "B"},
+		{"ABC Parado", 0, 0, "C", "C"},
+	}
+	ids := make(map[string]uuid.UUID)
+	for i, p := range products {
+		id := uuid.New()
+		ids[p.nome] = id
+		if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO produtos (id, nome, codigo_barras, preco_custo, preco_sugerido) VALUES ($1, $2, $3, $4, $5)", id, p.nome, fmt.Sprintf("78900000010%d", i), 1.0, 2.0); err != nil {
+			t.Fatalf("Falha ao inserir produto de teste: %v", err)
+		}
+		if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, 5)", id, filialID); err != nil {
+			t.Fatalf("Falha ao inserir stock de teste: %v", err)
+		}
+		if p.receita == 0 {
+			continue
+		}
+		var vendaID uuid.UUID
+		if err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO vendas (usuario_id, filial_id, total_venda) VALUES ($1, $2, $3) RETURNING id", userID, filialID, p.receita).Scan(&vendaID); err != nil {
+			t.Fatalf("Falha ao inserir venda de teste: %v", err)
+		}
+		if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO itens_venda (venda_id, produto_id, quantidade, preco_unitario, custo_unitario) VALUES ($1, $2, 1, $3, $4)", vendaID, id, p.receita, p.custo); err != nil {
+			t.Fatalf("Falha ao inserir item de venda de teste: %v", err)
+		}
+	}
+
+	to := time.Now()
+	count, err := testStorage.RunABCClassification(filialID.String(), to.AddDate(0, 0, -29), to, DefaultABCThresholdA, DefaultABCThresholdB)
+	if err != nil {
+		t.Fatalf("Erro inesperado no cálculo ABC: %v", err)
+	}
+	if count != int64(len(products)) {
+		t.Errorf("Esperava %d produtos classificados, mas foram %d", len(products), count)
+	}
+
+	items, err := testStorage.GetABCItems(filialID.String(), "receita", "", 0, 0)
+	if err != nil {
+		t.Fatalf("Erro inesperado ao listar a classificação: %v", err)
+	}
+	byID := make(map[uuid.UUID]models.ABCItem)
+	for _, it := range items {
+		byID[it.ProdutoID] = it
+	}
+	for _, p := range products {
+		it, ok := byID[ids[p.nome]]
+		if !ok {
+			t.Errorf("%s não foi classificado", p.nome)
+			continue
+		}
+		if it.ClasseReceita != p.classe || it.ClasseMargem != p.classeMargem {
+			t.Errorf("%s: esperava classes %s/%s, obteve %s/%s", p.nome, p.classe, p.classeMargem, it.ClasseReceita, it.ClasseMargem)
+		}
+	}
+
+	// Recalcular substitui a classificação anterior em vez de a duplicar.
+	if _, err := testStorage.RunABCClassification(filialID.String(), to.AddDate(0, 0, -29), to, DefaultABCThresholdA, DefaultABCThresholdB); err != nil {
+		t.Fatalf("Erro inesperado no recálculo ABC: %v", err)
+	}
+	if n, _ := testStorage.CountABCItems(filialID.String(), "receita", "A"); n != 2 {
+		t.Errorf("Esperava 2 produtos na classe A depois do recálculo, mas foram %d", n)
+	}
+
+	// A lista de stock (e a reposição) filtra pela classe da filial.
+	stock, err := testStorage.GetStockItemsPaginated(filialID.String(), "", "A", 10, 0)
+	if err != nil {
+		t.Fatalf("Erro inesperado ao filtrar o stock pela classe ABC: %v", err)
+	}
+	if n, _ := testStorage.CountStockItems(filialID.String(), "", "A"); n != 2 || len(stock) != 2 {
+		t.Errorf("Esperava 2 registos de stock da classe A, obteve %d (contagem %d)", len(stock), n)
+	}
+	for _, item := range stock {
+		if item.ClasseABC != "A" || (item.ProdutoID != ids["ABC Alfa"] && item.ProdutoID != ids["ABC Beta"]) {
+			t.Errorf("Registo inesperado no filtro da classe A: %s (%s)", item.ProdutoNome, item.ClasseABC)
+		}
+	}
+	if n, _ := testStorage.CountStockItems(filialID.String(), "", "C"); n != 2 {
+		t.Errorf("Esperava 2 registos de stock da classe C, obteve %d", n)
+	}
+}
+
+// TestSlowMovingStock verifica o stock parado e a filial sugerida para transferência.
+func TestSlowMovingStock(t *testing.T) {
+	paradaID, ativaID := uuid.New(), uuid.New()
+	for id, nome := range map[uuid.UUID]string{paradaID: "Filial Parada", ativaID: "Filial Ativa"} {
+		if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", id, nome); err != nil {
+			t.Fatalf("Falha ao inserir filial de teste: %v", err)
+		}
+	}
+	var userID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Vendedor Parado", "parado@teste.com", "vendedor", "hash", ativaID).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	productID := uuid.New()
+	_, err = testStorage.Dbpool.Exec(context.Background(), "INSERT INTO produtos (id, nome, codigo_barras, preco_custo, preco_sugerido) VALUES ($1, $2, $3, $4, $5)", productID, "Produto Parado", "789000000200", 4.0, 8.0)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	for _, filialID := range []uuid.UUID{paradaID, ativaID} {
+		if err := testStorage.AddStockItem(productID.String(), filialID.String(), 10, models.StockLot{}); err != nil {
+			t.Fatalf("Falha ao receber stock: %v", err)
+		}
+	}
+	sale := models.Venda{UsuarioID: userID, FilialID: ativaID, TotalVenda: 24}
+	if err := testStorage.RegisterSale(sale, []models.ItemVenda{{ProdutoID: productID, Quantidade: 3, PrecoUnitario: 8}}); err != nil {
+		t.Fatalf("Registo de venda falhou inesperadamente: %v", err)
+	}
+
+	t.Run("Sem vendas na filial sugere a filial que vende", func(t *testing.T) {
+		items, err := testStorage.GetSlowMovingStock(paradaID.String(), 30, 0, 0)
+		if err != nil {
+			t.Fatalf("Erro inesperado no stock parado: %v", err)
+		}
+		if len(items) != 1 || items[0].ProdutoID != productID {
+			t.Fatalf("Esperava apenas o produto parado, mas obteve %+v", items)
+		}
+		it := items[0]
+		if it.ValorParado != 40 || it.DiasSemVenda != -1 || it.CoberturaDias != -1 {
+			t.Errorf("Esperava R$ 40 parados, sem venda e sem cobertura, mas obteve %.2f, %d dias, %.1f", it.ValorParado, it.DiasSemVenda, it.CoberturaDias)
+		}
+		if it.FilialSugeridaID != ativaID.String() || it.VendasSugerida != 3 {
+			t.Errorf("Esperava a Filial Ativa (3 un.) como destino, mas obteve %q (%d un.)", it.FilialSugeridaNome, it.VendasSugerida)
+		}
+	})
+
+	t.Run("O limite de vendas inclui produtos com poucas vendas", func(t *testing.T) {
+		items, err := testStorage.GetSlowMovingStock(ativaID.String(), 30, 0, 0)
+		if err != nil {
+			t.Fatalf("Erro inesperado no stock parado: %v", err)
+		}
+		if len(items) != 0 {
+			t.Errorf("A filial que vendeu não devia ter stock parado sem vendas, mas obteve %+v", items)
+		}
+		items, err = testStorage.GetSlowMovingStock(ativaID.String(), 30, 5, 0)
+		if err != nil {
+			t.Fatalf("Erro inesperado no stock parado: %v", err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("Esperava o produto com 3 vendas abaixo do limite de 5, mas obteve %+v", items)
+		}
+		if it := items[0]; it.DiasSemVenda != 0 || it.CoberturaDias != 70 || it.FilialSugeridaID != "" {
+			t.Errorf("Esperava venda hoje, 70 dias de cobertura e sem destino, mas obteve %d, %.1f, %q", it.DiasSemVenda, it.CoberturaDias, it.FilialSugeridaNome)
+		}
+	})
+}
+
+// TestProductsForLabelsPriceChangedToday verifica o filtro de etiquetas por preço alterado.
+func TestProductsForLabelsPriceChangedToday(t *testing.T) {
+	product := models.Product{Nome: "Etiqueta de Teste", CodigoBarras: "789000000300", PrecoSugerido: 5}
+	var productID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, codigo_barras, preco_sugerido, data_criacao) VALUES ($1, $2, $3, NOW() - INTERVAL '10 days') RETURNING id", product.Nome, product.CodigoBarras, product.PrecoSugerido).Scan(&productID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	changedToday := func() bool {
+		products, err := testStorage.GetProductsForLabels(models.LabelFilter{Busca: "Etiqueta de Teste", PrecoAlteradoDesde: &today}, 10)
+		if err != nil {
+			t.Fatalf("Erro inesperado ao obter produtos para etiquetas: %v", err)
+		}
+		return len(products) == 1
+	}
+
+	if changedToday() {
+		t.Error("Um produto antigo sem alteração de preço não devia ser etiquetado")
+	}
+	product.Descricao = "Só a descrição mudou"
+	if err := testStorage.UpdateProduct(productID.String(), product, ""); err != nil {
+		t.Fatalf("Falha ao atualizar o produto: %v", err)
+	}
+	if changedToday() {
+		t.Error("Alterar outros campos não devia marcar o preço como alterado")
+	}
+	product.PrecoSugerido = 5.5
+	if err := testStorage.UpdateProduct(productID.String(), product, ""); err != nil {
+		t.Fatalf("Falha ao atualizar o produto: %v", err)
+	}
+	if !changedToday() {
+		t.Error("Esperava o produto na lista depois de mudar o preço")
+	}
+}
+
+func TestProductVariants(t *testing.T) {
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Variantes"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	parent := models.Product{Nome: "Camiseta Teste", CodigoBarras: "789000000400", PrecoSugerido: 30}
+	var parentID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ($1, $2, $3) RETURNING id", parent.Nome, parent.CodigoBarras, parent.PrecoSugerido).Scan(&parentID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+
+	attrs := func(size string) []models.VariantAttribute {
+		return []models.VariantAttribute{{Nome: "Tamanho", Valor: size}, {Nome: "Cor", Valor: "Azul"}}
+	}
+	smallID, err := testStorage.AddVariant(parentID.String(), models.Product{CodigoBarras: "789000000401", Atributos: attrs("P")})
+	if err != nil {
+		t.Fatalf("Falha ao adicionar variante: %v", err)
+	}
+	largeID, err := testStorage.AddVariant(parentID.String(), models.Product{CodigoBarras: "789000000402", PrecoSugerido: 35, Atributos: attrs("G")})
+	if err != nil {
+		t.Fatalf("Falha ao adicionar variante: %v", err)
+	}
+	if _, err := testStorage.AddVariant(parentID.String(), models.Product{CodigoBarras: "789000000403", Atributos: attrs("P")}); err == nil {
+		t.Error("Esperava erro ao repetir os atributos de uma variante")
+	}
+	if _, err := testStorage.AddVariant(smallID, models.Product{CodigoBarras: "789000000404", Atributos: attrs("M")}); err != ErrVariantParent {
+		t.Errorf("Uma variante não pode ter variantes, obteve %v", err)
+	}
+
+	for id, qty := range map[string]int{smallID: 4, largeID: 6} {
+		if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, $3)", id, filialID, qty); err != nil {
+			t.Fatalf("Falha ao inserir stock de teste: %v", err)
+		}
+	}
+
+	variants, err := testStorage.GetVariants(parentID.String())
+	if err != nil || len(variants) != 2 {
+		t.Fatalf("Esperava 2 variantes, obteve %d (%v)", len(variants), err)
+	}
+	byName := make(map[string]models.Product)
+	for _, v := range variants {
+		byName[v.Nome] = v
+	}
+	if v, ok := byName["Camiseta Teste - P / Azul"]; !ok || v.PrecoSugerido != 30 || v.TotalEstoque != 4 {
+		t.Errorf("Variante P inesperada (preço herdado do pai): %+v", byName)
+	}
+	if v := byName["Camiseta Teste - G / Azul"]; v.PrecoSugerido != 35 {
+		t.Errorf("Esperava o preço próprio da variante G, obteve %.2f", v.PrecoSugerido)
+	}
+
+	// A lista de produtos mostra só o pai, com o stock e o valor das variantes.
+	products, err := testStorage.GetProductsPaginatedAndFiltered("789000000402", false, 10, 0)
+	if err != nil || len(products) != 1 {
+		t.Fatalf("Esperava só o produto pai na pesquisa pela variante, obteve %d (%v)", len(products), err)
+	}
+	if p := products[0]; p.ID != parentID || p.TotalEstoque != 10 || p.NumVariantes != 2 || p.ValorTotalEstoque != 4*30+6*35 {
+		t.Errorf("Totais do pai inesperados: %+v", p)
+	}
+
+	// Mudar o nome do pai renomeia as variantes.
+	parent.Nome = "T-shirt Teste"
+	if err := testStorage.UpdateProduct(parentID.String(), parent, ""); err != nil {
+		t.Fatalf("Falha ao atualizar o produto pai: %v", err)
+	}
+	results, err := testStorage.SearchProductsForSale("T-shirt Teste", filialID)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Esperava as 2 variantes no terminal, obteve %d (%v)", len(results), err)
+	}
+	for _, r := range results {
+		if r.ProdutoPaiID == nil || *r.ProdutoPaiID != parentID || r.NomePai != "T-shirt Teste" || len(r.Atributos) != 2 {
+			t.Errorf("Variante sem dados do pai ou atributos: %+v", r)
+		}
+		if r.Nome != "T-shirt Teste - "+r.Atributos[0].Valor+" / Azul" {
+			t.Errorf("Nome da variante não acompanhou o pai: %q", r.Nome)
+		}
+	}
+
+	// O preço do pai chega às variantes sem preço próprio; uma entrada com custo não mexe no
+	// preço próprio da variante.
+	parent.PrecoSugerido = 32
+	if err := testStorage.UpdateProduct(parentID.String(), parent, ""); err != nil {
+		t.Fatalf("Falha ao atualizar o preço do pai: %v", err)
+	}
+	if err := testStorage.AddStockItem(largeID, filialID.String(), 2, models.StockLot{CustoUnitario: 50}); err != nil {
+		t.Fatalf("Falha ao adicionar stock à variante: %v", err)
+	}
+	prices := func() map[string]models.Product {
+		variants, err := testStorage.GetVariants(parentID.String())
+		if err != nil {
+			t.Fatalf("Falha ao listar as variantes: %v", err)
+		}
+		byID := make(map[string]models.Product)
+		for _, v := range variants {
+			byID[v.ID.String()] = v
+		}
+		return byID
+	}
+	byID := prices()
+	if v := byID[smallID]; v.PrecoSugerido != 32 || v.PrecoVariante != nil {
+		t.Errorf("Esperava a variante P a seguir o novo preço do pai, obteve %.2f", v.PrecoSugerido)
+	}
+	if v := byID[largeID]; v.PrecoSugerido != 35 || v.PrecoVariante == nil || *v.PrecoVariante != 35 {
+		t.Errorf("Esperava o preço próprio da variante G mantido, obteve %.2f", v.PrecoSugerido)
+	}
+
+	// Sem preço, a variante volta a seguir o pai.
+	if err := testStorage.UpdateVariant(largeID, models.Product{CodigoBarras: "789000000402", Atributos: attrs("G")}, ""); err != nil {
+		t.Fatalf("Falha ao atualizar a variante: %v", err)
+	}
+	if v := prices()[largeID]; v.PrecoSugerido != 32 || v.PrecoVariante != nil {
+		t.Errorf("Esperava a variante G com o preço do pai, obteve %.2f", v.PrecoSugerido)
+	}
+}
+
+func TestProductBarcodesWithPackMultiplier(t *testing.T) {
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Códigos"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	var productID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ($1, $2, $3) RETURNING id", "Refrigerante Lata", "789000000500", 4.5).Scan(&productID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, 48)", productID, filialID); err != nil {
+		t.Fatalf("Falha ao inserir stock de teste: %v", err)
+	}
+
+	if err := testStorage.AddProductBarcode(models.ProductBarcode{Codigo: "789000000501", ProdutoID: productID}); err != nil {
+		t.Fatalf("Falha ao adicionar EAN alternativo: %v", err)
+	}
+	if err := testStorage.AddProductBarcode(models.ProductBarcode{Codigo: "17890000005007", ProdutoID: productID, Multiplicador: 12, Descricao: "Caixa"}); err != nil {
+		t.Fatalf("Falha ao adicionar código da caixa: %v", err)
+	}
+	if err := testStorage.AddProductBarcode(models.ProductBarcode{Codigo: "789000000500", ProdutoID: productID}); err == nil {
+		t.Error("Esperava erro ao repetir o código principal")
+	}
+	if err := testStorage.AddProductBarcode(models.ProductBarcode{Codigo: "789000000501", ProdutoID: productID}); err == nil {
+		t.Error("Esperava erro ao repetir um código adicional")
+	}
+
+	// O código adicional de um produto também não pode passar a ser o principal de outro.
+	if err := testStorage.AddProduct(models.Product{Nome: "Refrigerante Repetido", CodigoBarras: "789000000501", PrecoSugerido: 4}); err != ErrBarcodeTaken {
+		t.Errorf("AddProduct: esperava ErrBarcodeTaken, obteve %v", err)
+	}
+	if err := testStorage.CreateProductWithInitialStock(models.Product{Nome: "Refrigerante Repetido", CodigoBarras: "17890000005007", PrecoSugerido: 4}, filialID.String(), 5, models.StockLot{}); !errors.Is(err, ErrBarcodeTaken) {
+		t.Errorf("CreateProductWithInitialStock: esperava ErrBarcodeTaken, obteve %v", err)
+	}
+	if _, err := testStorage.AddVariant(productID.String(), models.Product{CodigoBarras: "789000000501", Atributos: []models.VariantAttribute{{Nome: "Sabor", Valor: "Laranja"}}}); err != ErrBarcodeTaken {
+		t.Errorf("AddVariant: esperava ErrBarcodeTaken, obteve %v", err)
+	}
+	var otherID uuid.UUID
+	if err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ($1, $2, $3) RETURNING id", "Refrigerante Garrafa", "789000000502", 6.0).Scan(&otherID); err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	other := models.Product{Nome: "Refrigerante Garrafa", CodigoBarras: "789000000501", PrecoSugerido: 6}
+	if err := testStorage.UpdateProduct(otherID.String(), other, ""); err != ErrBarcodeTaken {
+		t.Errorf("UpdateProduct: esperava ErrBarcodeTaken, obteve %v", err)
+	}
+	other.CodigoBarras = "789000000502"
+	if err := testStorage.UpdateProduct(otherID.String(), other, ""); err != nil {
+		t.Errorf("UpdateProduct sem mudar o código devia gravar: %v", err)
+	}
+
+	for code, want := range map[string]int{"789000000500": 1, "789000000501": 1, "17890000005007": 12} {
+		results, err := testStorage.SearchProductsForSale(code, filialID)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("%s: esperava 1 produto, obteve %d (%v)", code, len(results), err)
+		}
+		if results[0].ID != productID || results[0].QuantidadeEmbalagem != want {
+			t.Errorf("%s: esperava o produto com %d unidades, obteve %+v", code, want, results[0])
+		}
+		details, err := testStorage.GetProductDetails(code)
+		if err != nil || details == nil || details.ID != productID || details.QuantidadeEmbalagem != want || details.TotalEstoque != 48 {
+			t.Errorf("%s: detalhes inesperados: %+v (%v)", code, details, err)
+		}
+	}
+
+	codes, err := testStorage.GetProductBarcodes(productID.String())
+	if err != nil || len(codes) != 2 || codes[1].Multiplicador != 12 {
+		t.Fatalf("Esperava os 2 códigos adicionais, obteve %+v (%v)", codes, err)
+	}
+	if err := testStorage.DeleteProductBarcode("17890000005007"); err != nil {
+		t.Fatalf("Falha ao remover código: %v", err)
+	}
+	if details, _ := testStorage.GetProductDetails("17890000005007"); details != nil {
+		t.Error("O código removido não devia encontrar o produto")
+	}
+}
+
+func TestBranchPrices(t *testing.T) {
+	centro, bairro := uuid.New(), uuid.New()
+	for id, nome := range map[uuid.UUID]string{centro: "Filial Preço Centro", bairro: "Filial Preço Bairro"} {
+		if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", id, nome); err != nil {
+			t.Fatalf("Falha ao inserir filial de teste: %v", err)
+		}
+	}
+	var userID, productID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Vendedor Preços", "precos@teste.com", "vendedor", "hash", centro).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	// O produto fica numa subcategoria; a variação por departamento tem de o incluir.
+	padaria := mustAddCategory(t, "Padaria", "")
+	paes := mustAddCategory(t, "Pães", padaria)
+	mercearia := mustAddCategory(t, "Mercearia", "")
+	err = testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, codigo_barras, categoria_id, preco_sugerido) VALUES ($1, $2, $3, $4) RETURNING id", "Pão de Forma Teste", "789000000600", paes, 10.0).Scan(&productID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	for _, f := range []uuid.UUID{centro, bairro} {
+		if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, 20)", productID, f); err != nil {
+			t.Fatalf("Falha ao inserir stock de teste: %v", err)
+		}
+	}
+
+	if err := testStorage.SetBranchPrice(productID.String(), centro.String(), 12, ""); err != nil {
+		t.Fatalf("Falha ao gravar preço da filial: %v", err)
+	}
+	priceAt := func(f uuid.UUID) float64 {
+		results, err := testStorage.SearchProductsForSale("789000000600", f)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("Esperava 1 produto, obteve %d (%v)", len(results), err)
+		}
+		return results[0].PrecoSugerido
+	}
+	if got := priceAt(centro); got != 12 {
+		t.Errorf("Esperava o preço próprio de 12 no centro, obteve %.2f", got)
+	}
+	if got := priceAt(bairro); got != 10 {
+		t.Errorf("Esperava o preço de catálogo de 10 no bairro, obteve %.2f", got)
+	}
+
+	sale := func(f uuid.UUID, price float64) error {
+		return testStorage.RegisterSale(models.Venda{UsuarioID: userID, FilialID: f, TotalVenda: price}, []models.ItemVenda{{ProdutoID: productID, Quantidade: 1, PrecoUnitario: price}})
+	}
+	if err := sale(centro, 10); err == nil {
+		t.Error("Esperava recusar a venda ao preço de catálogo numa filial com preço próprio")
+	}
+	if err := sale(centro, 12); err != nil {
+		t.Errorf("Venda ao preço da filial falhou: %v", err)
+	}
+
+	// -10% no bairro parte do catálogo; no centro, do preço próprio.
+	for _, f := range []uuid.UUID{bairro, centro} {
+		if n, err := testStorage.ApplyBranchPriceChange(f.String(), padaria, -10, ""); err != nil || n != 1 {
+			t.Fatalf("Esperava 1 produto alterado, obteve %d (%v)", n, err)
+		}
+	}
+	if n, _ := testStorage.ApplyBranchPriceChange(bairro.String(), mercearia, 5, ""); n != 0 {
+		t.Errorf("A variação não devia atingir outras categorias, alterou %d", n)
+	}
+	items, err := testStorage.GetBranchPriceComparison("789000000600", 10, 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Esperava 1 linha na comparação, obteve %d (%v)", len(items), err)
+	}
+	if p := items[0].Precos; p[bairro.String()] != 9 || p[centro.String()] != 10.8 {
+		t.Errorf("Preços por filial inesperados: %v", p)
+	}
+
+	if err := testStorage.DeleteBranchPrice(productID.String(), bairro.String(), ""); err != nil {
+		t.Fatalf("Falha ao remover preço da filial: %v", err)
+	}
+	if got := priceAt(bairro); got != 10 {
+		t.Errorf("Sem preço próprio o bairro devia voltar ao catálogo, obteve %.2f", got)
+	}
+}
+
+func TestPriceHistoryAndScheduledChanges(t *testing.T) {
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Agenda"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	var userID, productID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Gestor Agenda", "agenda@teste.com", "admin", "hash", filialID).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	product := models.Product{Nome: "Café Agenda", CodigoBarras: "789000000700", PrecoSugerido: 10}
+	err = testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ($1, $2, $3) RETURNING id", product.Nome, product.CodigoBarras, product.PrecoSugerido).Scan(&productID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+
+	product.PrecoSugerido = 11
+	if err := testStorage.UpdateProduct(productID.String(), product, userID.String()); err != nil {
+		t.Fatalf("Falha ao atualizar produto: %v", err)
+	}
+	history, err := testStorage.GetPriceHistory(productID.String(), 10)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("Esperava 1 alteração no histórico, obteve %d (%v)", len(history), err)
+	}
+	if h := history[0]; h.Campo != "preco" || *h.ValorAnterior != 10 || *h.ValorNovo != 11 || h.UsuarioNome != "Gestor Agenda" || h.Origem != PriceOriginManual {
+		t.Errorf("Histórico inesperado: %+v", h)
+	}
+
+	now := time.Now()
+	schedule := func(filial *uuid.UUID, price float64, day time.Time) (string, error) {
+		return testStorage.SchedulePriceChange(models.ScheduledPriceChange{ProdutoID: productID, FilialID: filial, Preco: price, DataEfetiva: day, UsuarioID: userID})
+	}
+	if _, err := schedule(nil, 9, now.AddDate(0, 0, -1)); err == nil {
+		t.Error("Esperava recusar um agendamento com data no passado")
+	}
+	if _, err := schedule(nil, 12, now); err != nil {
+		t.Fatalf("Falha ao agendar preço de catálogo: %v", err)
+	}
+	if _, err := schedule(&filialID, 13, now.AddDate(0, 0, 1)); err != nil {
+		t.Fatalf("Falha ao agendar preço da filial: %v", err)
+	}
+	cancelled, err := schedule(nil, 20, now.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Falha ao agendar preço: %v", err)
+	}
+	if err := testStorage.CancelScheduledPriceChange(cancelled); err != nil {
+		t.Fatalf("Falha ao cancelar agendamento: %v", err)
+	}
+	if err := testStorage.CancelScheduledPriceChange(cancelled); err == nil {
+		t.Error("Esperava erro ao cancelar um agendamento já cancelado")
+	}
+
+	// Hoje só entra o preço de catálogo; no dia seguinte, o da filial.
+	if n, err := testStorage.ApplyScheduledPriceChanges(now); err != nil || n != 1 {
+		t.Fatalf("Esperava 1 alteração aplicada hoje, obteve %d (%v)", n, err)
+	}
+	if n, _ := testStorage.ApplyScheduledPriceChanges(now); n != 0 {
+		t.Errorf("Uma alteração não devia ser aplicada duas vezes, aplicou %d", n)
+	}
+	if n, err := testStorage.ApplyScheduledPriceChanges(now.AddDate(0, 0, 1)); err != nil || n != 1 {
+		t.Fatalf("Esperava 1 alteração aplicada amanhã, obteve %d (%v)", n, err)
+	}
+	if pending, _ := testStorage.GetPendingPriceChanges(nil); len(pending) != 0 {
+		t.Errorf("Não deviam restar alterações pendentes, restam %d", len(pending))
+	}
+	results, err := testStorage.SearchProductsForSale("789000000700", filialID)
+	if err != nil || len(results) != 1 || results[0].PrecoSugerido != 13 {
+		t.Fatalf("Esperava o preço agendado de 13 na filial, obteve %v (%v)", results, err)
+	}
+
+	// O relatório do dia tem a alteração manual e as duas agendadas, em nome de quem agendou.
+	changes, err := testStorage.GetPriceChangesOn(now, filialID.String())
+	if err != nil || len(changes) != 3 {
+		t.Fatalf("Esperava 3 alterações de preço no dia, obteve %d (%v)", len(changes), err)
+	}
+	for _, c := range changes[1:] {
+		if c.Origem != PriceOriginScheduled || c.UsuarioNome != "Gestor Agenda" {
+			t.Errorf("Alteração agendada registada sem origem ou utilizador: %+v", c)
+		}
+	}
+	if c := changes[2]; c.FilialID == nil || *c.FilialID != filialID || *c.ValorNovo != 13 {
+		t.Errorf("Esperava a alteração do preço da filial por último: %+v", c)
+	}
+
+	// Uma alteração recusada pela base de dados falha sozinha e fica na lista com o motivo.
+	ctx := context.Background()
+	if _, err := testStorage.Dbpool.Exec(ctx, "ALTER TABLE precos_filiais ADD CONSTRAINT chk_teste_agenda CHECK (preco <> 99.99) NOT VALID"); err != nil {
+		t.Fatalf("Falha ao criar a restrição de teste: %v", err)
+	}
+	defer testStorage.Dbpool.Exec(ctx, "ALTER TABLE precos_filiais DROP CONSTRAINT IF EXISTS chk_teste_agenda")
+	later := now.AddDate(0, 0, 2)
+	failing, err := schedule(&filialID, 99.99, later)
+	if err != nil {
+		t.Fatalf("Falha ao agendar preço da filial: %v", err)
+	}
+	if _, err := schedule(nil, 14, later); err != nil {
+		t.Fatalf("Falha ao agendar preço de catálogo: %v", err)
+	}
+	if n, err := testStorage.ApplyScheduledPriceChanges(later); err != nil || n != 1 {
+		t.Fatalf("Esperava 1 alteração aplicada e 1 falhada, obteve %d (%v)", n, err)
+	}
+	pending, err := testStorage.GetPendingPriceChanges(nil)
+	if err != nil || len(pending) != 1 || pending[0].ID.String() != failing || pending[0].Status != "falhou" || pending[0].Erro == "" {
+		t.Fatalf("Esperava só a alteração falhada na lista, com o motivo: %+v (%v)", pending, err)
+	}
+	if results, _ := testStorage.SearchProductsForSale("789000000700", filialID); len(results) != 1 || results[0].PrecoSugerido != 13 {
+		t.Errorf("A alteração falhada não devia mudar o preço da filial: %+v", results)
+	}
+	if n, _ := testStorage.ApplyScheduledPriceChanges(later); n != 0 {
+		t.Errorf("Uma alteração falhada não devia ser tentada de novo, aplicou %d", n)
+	}
+	if err := testStorage.CancelScheduledPriceChange(failing); err != nil {
+		t.Errorf("Falha ao retirar a alteração falhada: %v", err)
+	}
+}
+
+// mustAddCategory cria uma categoria de teste (departamento se paiID for vazio).
+func mustAddCategory(t *testing.T, nome, paiID string) string {
+	t.Helper()
+	id, err := testStorage.AddCategory(nome, paiID)
+	if err != nil {
+		t.Fatalf("Falha ao criar a categoria %q: %v", nome, err)
+	}
+	return id
+}
+
+// TestCategories verifica a taxonomia de três níveis, o recálculo dos caminhos e que os
+// filtros por categoria incluem as subcategorias.
+func TestCategories(t *testing.T) {
+	limpeza := mustAddCategory(t, "Limpeza Doméstica", "")
+	detergentes := mustAddCategory(t, "Detergentes", limpeza)
+	louca := mustAddCategory(t, "Loiça", detergentes)
+	if _, err := testStorage.AddCategory("Pastilhas", louca); err == nil {
+		t.Error("Esperava erro ao criar um quarto nível")
+	}
+	if _, err := testStorage.AddCategory("detergentes", limpeza); err == nil {
+		t.Error("Esperava erro ao repetir o nome no mesmo nível")
+	}
+	vazia := mustAddCategory(t, "Ambientadores", limpeza)
+
+	var productID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, codigo_barras, categoria_id, preco_custo, preco_sugerido) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Detergente Loiça Teste", "789000000800", louca, 2.0, 4.0).Scan(&productID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, 5)", productID, testFilial.ID); err != nil {
+		t.Fatalf("Falha ao inserir stock de teste: %v", err)
+	}
+
+	products, err := testStorage.FilterProducts("Limpeza Doméstica", 1)
+	if err != nil || len(products) != 1 || products[0].ID != productID {
+		t.Errorf("Esperava o produto da subcategoria ao filtrar pelo departamento, obteve %v (%v)", products, err)
+	}
+
+	findComposition := func(categoriaID string) map[string]models.StockComposition {
+		items, err := testStorage.GetStockComposition(categoriaID)
+		if err != nil {
+			t.Fatalf("Erro inesperado na composição do estoque: %v", err)
+		}
+		byName := make(map[string]models.StockComposition)
+		for _, it := range items {
+			byName[it.Category] = it
+		}
+		return byName
+	}
+	if dep, ok := findComposition("")["Limpeza Doméstica"]; !ok || dep.CategoryID == nil || dep.CategoryID.String() != limpeza || dep.Value != 10 {
+		t.Errorf("Esperava o departamento com 10,00 em estoque, obteve %+v", dep)
+	}
+	drill := findComposition(limpeza)
+	if len(drill) != 1 || drill["Detergentes"].Value != 10 {
+		t.Errorf("Esperava só a categoria Detergentes ao descer no departamento, obteve %+v", drill)
+	}
+
+	if err := testStorage.RenameCategory(limpeza, "Casa"); err != nil {
+		t.Fatalf("Falha ao renomear o departamento: %v", err)
+	}
+	product, err := testStorage.GetProductDetails("789000000800")
+	if err != nil || product == nil {
+		t.Fatalf("Falha ao obter o produto: %v", err)
+	}
+	if product.Categoria != "Casa > Detergentes > Loiça" || product.CategoriaID == nil || product.CategoriaID.String() != louca {
+		t.Errorf("Caminho inesperado depois de renomear: %q", product.Categoria)
+	}
+
+	if err := testStorage.DeleteCategory(louca); err == nil {
+		t.Error("Esperava recusar apagar uma categoria com produtos")
+	}
+	if err := testStorage.DeleteCategory(limpeza); err == nil {
+		t.Error("Esperava recusar apagar um departamento com subcategorias")
+	}
+	if err := testStorage.DeleteCategory(vazia); err != nil {
+		t.Errorf("Falha ao apagar uma categoria vazia: %v", err)
+	}
+}
+
+// TestImportProducts verifica a simulação e a importação por blocos: cria e atualiza por
+// código de barras e rejeita só as linhas com erro.
+func TestImportProducts(t *testing.T) {
+	higiene := mustAddCategory(t, "Higiene Importação", "")
+	var existingID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, codigo_barras, preco_custo, preco_sugerido) VALUES ($1, $2, $3, $4) RETURNING id", "Sabonete Importação", "789000000900", 1.0, 2.0).Scan(&existingID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	rows := []models.ProductImportRow{
+		{Linha: 2, Produto: models.Product{CodigoBarras: "789000000900", Nome: "Sabonete Importação", PrecoCusto: 1.5, PrecoSugerido: 3}},
+		{Linha: 3, Produto: models.Product{CodigoBarras: "789000000901", Nome: "Champô Importação", Categoria: "higiene importação", PrecoCusto: 4, PrecoSugerido: 7.5}},
+		{Linha: 4, Produto: models.Product{CodigoBarras: "789000000902", Nome: "Pasta Importação", Categoria: "Não Existe", PrecoSugerido: 1}},
+		{Linha: 5, Produto: models.Product{CodigoBarras: "789000000903", Nome: testProduct.Nome, PrecoSugerido: 1}},
+	}
+	price := func(barcode string) float64 {
+		var p float64
+		if err := testStorage.Dbpool.QueryRow(context.Background(), "SELECT preco_sugerido FROM produtos WHERE codigo_barras = $1", barcode).Scan(&p); err != nil {
+			return -1
+		}
+		return p
+	}
+
+	report, err := testStorage.ImportProducts(rows, "", true, 2, nil)
+	if err != nil {
+		t.Fatalf("Erro inesperado na simulação: %v", err)
+	}
+	if !report.Simulacao || report.Criados != 1 || report.Atualizados != 1 || report.Rejeitados != 2 {
+		t.Errorf("Relatório da simulação inesperado: %+v", report)
+	}
+	if price("789000000900") != 2 || price("789000000901") != -1 {
+		t.Error("A simulação não devia gravar nada")
+	}
+
+	var progress []int
+	report, err = testStorage.ImportProducts(rows, "", false, 2, func(done int) { progress = append(progress, done) })
+	if err != nil {
+		t.Fatalf("Erro inesperado na importação: %v", err)
+	}
+	if report.Criados != 1 || report.Atualizados != 1 || report.Rejeitados != 2 || len(report.Resultados) != 4 {
+		t.Errorf("Relatório da importação inesperado: %+v", report)
+	}
+	for _, r := range report.Resultados {
+		if (r.Linha == 4 || r.Linha == 5) && (r.Acao != models.ImportActionReject || r.Erro == "") {
+			t.Errorf("Esperava a linha %d rejeitada com motivo: %+v", r.Linha, r)
+		}
+	}
+	if len(progress) != 2 || progress[0] != 2 || progress[1] != 4 {
+		t.Errorf("Esperava o progresso [2 4], obteve %v", progress)
+	}
+	if price("789000000900") != 3 || price("789000000901") != 7.5 {
+		t.Errorf("Preços depois da importação inesperados: %.2f e %.2f", price("789000000900"), price("789000000901"))
+	}
+	var origem string
+	err = testStorage.Dbpool.QueryRow(context.Background(), "SELECT origem FROM historico_precos WHERE produto_id = $1 AND campo = 'preco' ORDER BY data_alteracao DESC LIMIT 1", existingID).Scan(&origem)
+	if err != nil || origem != PriceOriginImport {
+		t.Errorf("Esperava a alteração de preço registada como importação, obteve %q (%v)", origem, err)
+	}
+
+	products, err := testStorage.GetProductsForExport()
+	if err != nil {
+		t.Fatalf("Erro inesperado na exportação: %v", err)
+	}
+	found := false
+	for _, p := range products {
+		if p.CodigoBarras == "789000000901" {
+			found = true
+			if p.Categoria != "Higiene Importação" || p.CategoriaID == nil || p.CategoriaID.String() != higiene {
+				t.Errorf("Categoria exportada inesperada: %q", p.Categoria)
+			}
+		}
+	}
+	if !found {
+		t.Error("O produto importado não foi exportado")
+	}
+
+	// Um código antigo que não é GTIN atualiza o produto que já o tem, mas não cria um novo.
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ($1, $2, $3)", "Produto Código Antigo", "1760000000000000001", 2.0); err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	legacy := []models.ProductImportRow{
+		{Linha: 2, Produto: models.Product{CodigoBarras: "1760000000000000001", Nome: "Produto Código Antigo", PrecoSugerido: 4}, ErroCodigoBarras: "código de barras inválido"},
+		{Linha: 3, Produto: models.Product{CodigoBarras: "1760000000000000002", Nome: "Produto Código Novo", PrecoSugerido: 4}, ErroCodigoBarras: "código de barras inválido"},
+	}
+	report, err = testStorage.ImportProducts(legacy, "", false, 10, nil)
+	if err != nil {
+		t.Fatalf("Erro inesperado na importação: %v", err)
+	}
+	if report.Atualizados != 1 || report.Rejeitados != 1 || price("1760000000000000001") != 4 || price("1760000000000000002") != -1 {
+		t.Errorf("Esperava o código antigo atualizado e o novo rejeitado: %+v", report)
+	}
+}
+
+func TestProductSearch(t *testing.T) {
+	if got := searchTSQuery(" Feijão,  preto! "); got != "Feijão:* & preto:*" {
+		t.Errorf("tsquery inesperada: %q", got)
+	}
+	if got := searchTSQuery("%&|"); got != "" {
+		t.Errorf("Esperava tsquery vazia só com pontuação, obteve %q", got)
+	}
+
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Pesquisa"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	ids := make(map[string]uuid.UUID)
+	for _, p := range []models.Product{
+		{Nome: "Feijão Carioca Tipo 1 1kg", CodigoBarras: "789000001000", Descricao: "Feijão de grão selecionado"},
+		{Nome: "Feijão Preto 1kg", CodigoBarras: "789000001001"},
+		{Nome: "Caldo de Feijão em Pó", CodigoBarras: "789000001002"},
+		{Nome: "Macarrão Espaguete", CodigoBarras: "789000001003", Descricao: "Massa de sêmola"},
+	} {
+		var id uuid.UUID
+		err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO produtos (nome, descricao, codigo_barras, preco_sugerido) VALUES ($1, $2, $3, 10) RETURNING id", p.Nome, p.Descricao, p.CodigoBarras).Scan(&id)
+		if err != nil {
+			t.Fatalf("Falha ao inserir produto de teste: %v", err)
+		}
+		if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, 5)", id, filialID); err != nil {
+			t.Fatalf("Falha ao inserir stock de teste: %v", err)
+		}
+		ids[p.CodigoBarras] = id
+	}
+
+	names := func(products []models.Product) []string {
+		var out []string
+		for _, p := range products {
+			out = append(out, p.Nome)
+		}
+		return out
+	}
+
+	t.Run("Deve ignorar acentos e a ordem das palavras", func(t *testing.T) {
+		for _, query := range []string{"feijao", "FEIJÃO", "carioca feijao", "feij cari", "macarrao"} {
+			results, err := testStorage.SearchProductsForSale(query, filialID)
+			if err != nil || len(results) == 0 {
+				t.Errorf("Pesquisa %q sem resultados (%v)", query, err)
+			}
+		}
+		results, _ := testStorage.SearchProductsForSale("carioca feijao", filialID)
+		if len(results) != 1 || results[0].ID != ids["789000001000"] {
+			t.Errorf("Esperava só o feijão carioca, obteve %v", names(results))
+		}
+	})
+
+	t.Run("Deve tolerar erros de escrita pelos trigramas", func(t *testing.T) {
+		results, err := testStorage.SearchProductsForSale("macarao espagete", filialID)
+		if err != nil || len(results) != 1 || results[0].ID != ids["789000001003"] {
+			t.Errorf("Esperava o macarrão pela semelhança, obteve %v (%v)", names(results), err)
+		}
+	})
+
+	t.Run("Deve ordenar pela relevância", func(t *testing.T) {
+		// "Feijão" no nome pesa mais do que na descrição e o nome mais curto fica à frente.
+		results, err := testStorage.SearchProductsForSale("feijao preto", filialID)
+		if err != nil || len(results) == 0 || results[0].ID != ids["789000001001"] {
+			t.Errorf("Esperava o feijão preto primeiro, obteve %v (%v)", names(results), err)
+		}
+	})
+
+	t.Run("Deve pôr o código de barras exato primeiro", func(t *testing.T) {
+		results, err := testStorage.SearchProductsForSale("789000001002", filialID)
+		if err != nil || len(results) != 1 || results[0].ID != ids["789000001002"] {
+			t.Errorf("Esperava só o produto do código de barras, obteve %v (%v)", names(results), err)
+		}
+	})
+
+	t.Run("Deve pesquisar a lista de produtos da mesma forma", func(t *testing.T) {
+		count, err := testStorage.CountProducts("feijao", false)
+		if err != nil || count != 3 {
+			t.Errorf("Esperava 3 produtos com feijão, obteve %d (%v)", count, err)
+		}
+		products, err := testStorage.GetProductsPaginatedAndFiltered("feijao", false, 10, 0)
+		if err != nil || len(products) != 3 {
+			t.Fatalf("Esperava 3 produtos com feijão, obteve %d (%v)", len(products), err)
+		}
+		// O início do código de barras encontra os produtos; o exato vem primeiro.
+		products, err = testStorage.GetProductsPaginatedAndFiltered("78900000100", false, 10, 0)
+		if err != nil || len(products) != 4 {
+			t.Errorf("Esperava 4 produtos pelo prefixo do código, obteve %d (%v)", len(products), err)
+		}
+		products, err = testStorage.GetProductsPaginatedAndFiltered("789000001003", false, 10, 0)
+		if err != nil || len(products) != 1 || products[0].ID != ids["789000001003"] {
+			t.Errorf("Esperava o produto do código de barras exato, obteve %v (%v)", names(products), err)
+		}
+	})
+}
+
+func TestArchiveProductsAndUsers(t *testing.T) {
+	ctx := context.Background()
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Arquivo"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	insertProduct := func(nome, codigo string) uuid.UUID {
+		var id uuid.UUID
+		if err := testStorage.Dbpool.QueryRow(ctx, "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ($1, $2, 5) RETURNING id", nome, codigo).Scan(&id); err != nil {
+			t.Fatalf("Falha ao inserir produto de teste: %v", err)
+		}
+		if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, 10)", id, filialID); err != nil {
+			t.Fatalf("Falha ao inserir stock de teste: %v", err)
+		}
+		return id
+	}
+	insertUser := func(email string) uuid.UUID {
+		var id uuid.UUID
+		if err := testStorage.Dbpool.QueryRow(ctx, "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ('Arquivo', $1, 'vendedor', 'hash', $2) RETURNING id", email, filialID).Scan(&id); err != nil {
+			t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+		}
+		return id
+	}
+
+	soldID := insertProduct("Bolacha Arquivo Vendida", "789000001100")
+	unsoldID := insertProduct("Bolacha Arquivo Sem Vendas", "789000001101")
+	variantID, err := testStorage.AddVariant(soldID.String(), models.Product{CodigoBarras: "789000001102", Atributos: []models.VariantAttribute{{Nome: "Sabor", Valor: "Limão"}}})
+	if err != nil {
+		t.Fatalf("Falha ao adicionar variante: %v", err)
+	}
+	sellerID := insertUser("vendedor.arquivo@teste.com")
+	idleID := insertUser("sem.vendas.arquivo@teste.com")
+	var vendaID uuid.UUID
+	if err := testStorage.Dbpool.QueryRow(ctx, "INSERT INTO vendas (usuario_id, filial_id, total_venda) VALUES ($1, $2, 5) RETURNING id", sellerID, filialID).Scan(&vendaID); err != nil {
+		t.Fatalf("Falha ao inserir venda de teste: %v", err)
+	}
+	if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO itens_venda (venda_id, produto_id, quantidade, preco_unitario) VALUES ($1, $2, 1, 5)", vendaID, soldID); err != nil {
+		t.Fatalf("Falha ao inserir item de venda de teste: %v", err)
+	}
+
+	t.Run("Só apaga definitivamente registos arquivados", func(t *testing.T) {
+		if err := testStorage.DeleteProductByID(unsoldID.String()); err != ErrNotArchived {
+			t.Errorf("Esperava ErrNotArchived para um produto ativo, obteve %v", err)
+		}
+		if err := testStorage.DeleteUserByID(idleID.String()); err != ErrNotArchived {
+			t.Errorf("Esperava ErrNotArchived para um utilizador ativo, obteve %v", err)
+		}
+	})
+
+	t.Run("Deve esconder o produto arquivado e as variantes", func(t *testing.T) {
+		if err := testStorage.ArchiveProduct(soldID.String()); err != nil {
+			t.Fatalf("Falha ao arquivar o produto: %v", err)
+		}
+		if err := testStorage.ArchiveProduct(soldID.String()); err == nil {
+			t.Error("Esperava erro ao arquivar de novo")
+		}
+		for _, query := range []string{"Bolacha Arquivo Vendida", "789000001102"} {
+			if results, _ := testStorage.SearchProductsForSale(query, filialID); len(results) != 0 {
+				t.Errorf("O terminal não devia encontrar %q, obteve %d", query, len(results))
+			}
+		}
+		if err := testStorage.RestoreProduct(variantID); err == nil {
+			t.Error("Esperava erro ao restaurar uma variante com o pai arquivado")
+		}
+		active, _ := testStorage.GetProductsPaginatedAndFiltered("789000001100", false, 10, 0)
+		archived, _ := testStorage.GetProductsPaginatedAndFiltered("789000001100", true, 10, 0)
+		if len(active) != 0 || len(archived) != 1 || !archived[0].Arquivado {
+			t.Errorf("Esperava o produto só na lista de arquivados: ativos %d, arquivados %+v", len(active), archived)
+		}
+		all, _ := testStorage.GetAllProductsSimple()
+		for _, p := range all {
+			if p.ID == soldID || p.ID.String() == variantID {
+				t.Errorf("Produto arquivado na lista de escolha: %s", p.Nome)
+			}
+		}
+		stock, _ := testStorage.GetStockItemsPaginated(filialID.String(), "", "", 50, 0)
+		count, _ := testStorage.CountStockItems(filialID.String(), "", "")
+		if count != len(stock) {
+			t.Errorf("A contagem do stock (%d) não bate com a lista (%d)", count, len(stock))
+		}
+		for _, item := range stock {
+			if item.ProdutoID == soldID {
+				t.Errorf("Produto arquivado na lista de stock: %s", item.ProdutoNome)
+			}
+		}
+		low, _ := testStorage.GetLowStockProducts("Filial Arquivo", 50)
+		for _, p := range low {
+			if p.ProdutoNome == "Bolacha Arquivo Vendida" {
+				t.Error("Produto arquivado na lista de stock baixo")
+			}
+		}
+		if _, err := testStorage.ApplyBranchPriceChange(filialID.String(), "", 10, ""); err != nil {
+			t.Fatalf("Falha ao aplicar a variação de preço: %v", err)
+		}
+		var repriced bool
+		testStorage.Dbpool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM precos_filiais WHERE produto_id = $1)", soldID).Scan(&repriced)
+		if repriced {
+			t.Error("A variação de preço da filial não devia tocar no produto arquivado")
+		}
+		if _, err := testStorage.Dbpool.Exec(ctx, "DELETE FROM precos_filiais WHERE filial_id = $1", filialID); err != nil {
+			t.Fatalf("Falha ao limpar os preços da filial: %v", err)
+		}
+	})
+
+	t.Run("Não deve apagar um produto com vendas", func(t *testing.T) {
+		if err := testStorage.DeleteProductByID(soldID.String()); err != ErrProductInUse {
+			t.Errorf("Esperava ErrProductInUse, obteve %v", err)
+		}
+		var exists bool
+		testStorage.Dbpool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM produtos WHERE id = $1 AND data_arquivamento IS NOT NULL)", soldID).Scan(&exists)
+		if !exists {
+			t.Error("O produto com vendas devia continuar arquivado")
+		}
+	})
+
+	t.Run("Deve restaurar o produto e as variantes", func(t *testing.T) {
+		if err := testStorage.RestoreProduct(soldID.String()); err != nil {
+			t.Fatalf("Falha ao restaurar o produto: %v", err)
+		}
+		if results, _ := testStorage.SearchProductsForSale("789000001102", filialID); len(results) != 1 {
+			t.Errorf("Esperava a variante de novo no terminal, obteve %d", len(results))
+		}
+	})
+
+	t.Run("Deve apagar um produto arquivado sem vendas", func(t *testing.T) {
+		if err := testStorage.ArchiveProduct(unsoldID.String()); err != nil {
+			t.Fatalf("Falha ao arquivar o produto: %v", err)
+		}
+		if err := testStorage.DeleteProductByID(unsoldID.String()); err != nil {
+			t.Errorf("Falha ao apagar o produto arquivado: %v", err)
+		}
+	})
+
+	t.Run("Não deve apagar um componente de kit e deve dizer porquê", func(t *testing.T) {
+		componentID := insertProduct("Bolacha Arquivo Componente", "789000001103")
+		var kitID uuid.UUID
+		if err := testStorage.Dbpool.QueryRow(ctx, "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ('Cabaz Arquivo', '789000001104', 20) RETURNING id").Scan(&kitID); err != nil {
+			t.Fatalf("Falha ao inserir kit de teste: %v", err)
+		}
+		if err := testStorage.SetKitComponent(kitID.String(), "789000001103", 2); err != nil {
+			t.Fatalf("Falha ao definir o componente do kit: %v", err)
+		}
+		if err := testStorage.ArchiveProduct(componentID.String()); err != nil {
+			t.Fatalf("Falha ao arquivar o componente: %v", err)
+		}
+		if err := testStorage.DeleteProductByID(componentID.String()); err != ErrProductKitComponent {
+			t.Errorf("Esperava ErrProductKitComponent, obteve %v", err)
+		}
+	})
+
+	t.Run("Deve impedir o login de um utilizador arquivado", func(t *testing.T) {
+		if err := testStorage.ArchiveUser(sellerID.String()); err != nil {
+			t.Fatalf("Falha ao arquivar o utilizador: %v", err)
+		}
+		if _, err := testStorage.GetUserByEmail("vendedor.arquivo@teste.com"); err != pgx.ErrNoRows {
+			t.Errorf("Esperava pgx.ErrNoRows no login do utilizador arquivado, obteve %v", err)
+		}
+		if active, err := testStorage.IsUserActive(sellerID.String()); err != nil || active {
+			t.Errorf("Esperava o utilizador arquivado inativo, obteve %v (erro: %v)", active, err)
+		}
+		users, _ := testStorage.GetUsersPaginated(true, 100, 0)
+		found := false
+		for _, u := range users {
+			found = found || (u.ID == sellerID && u.Arquivado)
+		}
+		if !found {
+			t.Error("Esperava o utilizador na lista de arquivados")
+		}
+		if err := testStorage.DeleteUserByID(sellerID.String()); err != ErrUserInUse {
+			t.Errorf("Esperava ErrUserInUse para um vendedor com vendas, obteve %v", err)
+		}
+		if err := testStorage.RestoreUser(sellerID.String()); err != nil {
+			t.Fatalf("Falha ao restaurar o utilizador: %v", err)
+		}
+		if _, err := testStorage.GetUserByEmail("vendedor.arquivo@teste.com"); err != nil {
+			t.Errorf("O utilizador restaurado devia voltar a entrar: %v", err)
+		}
+		if active, err := testStorage.IsUserActive(sellerID.String()); err != nil || !active {
+			t.Errorf("Esperava o utilizador restaurado ativo, obteve %v (erro: %v)", active, err)
+		}
+	})
+
+	t.Run("Deve apagar um utilizador arquivado sem registos", func(t *testing.T) {
+		if err := testStorage.ArchiveUser(idleID.String()); err != nil {
+			t.Fatalf("Falha ao arquivar o utilizador: %v", err)
+		}
+		if err := testStorage.DeleteUserByID(idleID.String()); err != nil {
+			t.Errorf("Falha ao apagar o utilizador arquivado: %v", err)
+		}
+	})
+}
+
+func TestProductImage(t *testing.T) {
+	ctx := context.Background()
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Imagens"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	var parentID uuid.UUID
+	if err := testStorage.Dbpool.QueryRow(ctx, "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ('Chinelo Imagem', '789000001200', 20) RETURNING id").Scan(&parentID); err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	variantID, err := testStorage.AddVariant(parentID.String(), models.Product{CodigoBarras: "789000001201", Atributos: []models.VariantAttribute{{Nome: "Tamanho", Valor: "40"}}})
+	if err != nil {
+		t.Fatalf("Falha ao adicionar variante: %v", err)
+	}
+	if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO estoque_filiais (produto_id, filial_id, quantidade) VALUES ($1, $2, 3)", variantID, filialID); err != nil {
+		t.Fatalf("Falha ao inserir stock de teste: %v", err)
+	}
+
+	if previous, err := testStorage.SetProductImage(parentID.String(), "chinelo-1"); err != nil || previous != "" {
+		t.Fatalf("Esperava gravar a primeira imagem sem anterior, obteve %q (%v)", previous, err)
+	}
+	if previous, err := testStorage.SetProductImage(parentID.String(), "chinelo-2"); err != nil || previous != "chinelo-1" {
+		t.Errorf("Esperava a chave anterior chinelo-1, obteve %q (%v)", previous, err)
+	}
+	if _, err := testStorage.SetProductImage(uuid.NewString(), "x"); err == nil {
+		t.Error("Esperava erro para um produto inexistente")
+	}
+
+	// A variante sem imagem própria mostra a do produto pai no terminal.
+	results, err := testStorage.SearchProductsForSale("789000001201", filialID)
+	if err != nil || len(results) != 1 || results[0].Imagem != "chinelo-2" {
+		t.Errorf("Esperava a imagem do pai na variante, obteve %+v (%v)", results, err)
+	}
+	products, err := testStorage.GetProductsPaginatedAndFiltered("789000001200", false, 10, 0)
+	if err != nil || len(products) != 1 || products[0].Imagem != "chinelo-2" {
+		t.Errorf("Esperava a imagem na lista de produtos, obteve %+v (%v)", products, err)
+	}
+
+	if previous, err := testStorage.SetProductImage(parentID.String(), ""); err != nil || previous != "chinelo-2" {
+		t.Errorf("Esperava remover a imagem chinelo-2, obteve %q (%v)", previous, err)
+	}
+	if results, _ := testStorage.SearchProductsForSale("789000001201", filialID); len(results) != 1 || results[0].Imagem != "" {
+		t.Errorf("Esperava o produto sem imagem, obteve %+v", results)
+	}
+}
+
+func TestKits(t *testing.T) {
+	ctx := context.Background()
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Kits"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	var userID uuid.UUID
+	if err := testStorage.Dbpool.QueryRow(ctx, "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ('Vendedor Kits', 'kits@teste.com', 'vendedor', 'hash', $1) RETURNING id", filialID).Scan(&userID); err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	newProduct := func(nome, codigo string, preco float64) uuid.UUID {
+		var id uuid.UUID
+		if err := testStorage.Dbpool.QueryRow(ctx, "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ($1, $2, $3) RETURNING id", nome, codigo, preco).Scan(&id); err != nil {
+			t.Fatalf("Falha ao inserir produto de teste: %v", err)
+		}
+		return id
+	}
+	detergenteID := newProduct("Detergente Kit", "789000001300", 5)
+	esponjaID := newProduct("Esponja Kit", "789000001301", 2)
+	kitID := newProduct("Kit Limpeza Teste", "789000001302", 12)
+	if err := testStorage.AddStockItem(detergenteID.String(), filialID.String(), 5, models.StockLot{CustoUnitario: 3}); err != nil {
+		t.Fatalf("Falha ao receber stock: %v", err)
+	}
+	if err := testStorage.AddStockItem(esponjaID.String(), filialID.String(), 10, models.StockLot{CustoUnitario: 1}); err != nil {
+		t.Fatalf("Falha ao receber stock: %v", err)
+	}
+
+	if err := testStorage.SetKitComponent(kitID.String(), "789000001300", 2); err != nil {
+		t.Fatalf("Falha ao adicionar componente: %v", err)
+	}
+	if err := testStorage.SetKitComponent(kitID.String(), "789000001301", 1); err != nil {
+		t.Fatalf("Falha ao adicionar componente: %v", err)
+	}
+	if err := testStorage.SetKitComponent(detergenteID.String(), "789000001302", 1); err == nil {
+		t.Error("Esperava erro ao usar um kit como componente")
+	}
+	if err := testStorage.SetKitComponent(kitID.String(), "789000001302", 1); err == nil {
+		t.Error("Esperava erro ao usar o kit como componente de si próprio")
+	}
+	if err := testStorage.AddStockItem(kitID.String(), filialID.String(), 1, models.StockLot{}); !errors.Is(err, ErrKitStock) {
+		t.Errorf("Esperava ErrKitStock ao dar entrada de stock no kit, obteve %v", err)
+	}
+
+	components, err := testStorage.GetKitComponents(kitID.String())
+	if err != nil || len(components) != 2 {
+		t.Fatalf("Esperava 2 componentes, obteve %+v (%v)", components, err)
+	}
+	availability := func() int {
+		list, err := testStorage.GetKitAvailability(kitID.String())
+		if err != nil {
+			t.Fatalf("Erro ao calcular a disponibilidade: %v", err)
+		}
+		for _, a := range list {
+			if a.FilialID == filialID {
+				return a.Disponivel
+			}
+		}
+		t.Fatalf("Filial em falta na disponibilidade: %+v", list)
+		return 0
+	}
+	// 5 detergentes chegam para 2 kits de 2; as esponjas chegariam para 10.
+	if got := availability(); got != 2 {
+		t.Errorf("Esperava 2 kits disponíveis, obteve %d", got)
+	}
+	if results, err := testStorage.SearchProductsForSale("789000001302", filialID); err != nil || len(results) != 1 || !results[0].Kit {
+		t.Errorf("Esperava encontrar o kit no terminal, obteve %+v (%v)", results, err)
+	}
+
+	sale := models.Venda{UsuarioID: userID, FilialID: filialID, TotalVenda: 24}
+	if err := testStorage.RegisterSale(sale, []models.ItemVenda{{ProdutoID: kitID, Quantidade: 2, PrecoUnitario: 12}}); err != nil {
+		t.Fatalf("Venda do kit falhou: %v", err)
+	}
+	stock := func(id uuid.UUID) int {
+		var q int
+		if err := testStorage.Dbpool.QueryRow(ctx, "SELECT quantidade FROM estoque_filiais WHERE produto_id = $1 AND filial_id = $2", id, filialID).Scan(&q); err != nil {
+			t.Fatalf("Falha ao ler o stock: %v", err)
+		}
+		return q
+	}
+	if d, e := stock(detergenteID), stock(esponjaID); d != 1 || e != 8 {
+		t.Errorf("Esperava 1 detergente e 8 esponjas depois da venda, obteve %d e %d", d, e)
+	}
+	// O custo do kit é 2 x 3 + 1 x 1, para a margem usar o custo dos componentes.
+	var cost float64
+	if err := testStorage.Dbpool.QueryRow(ctx, "SELECT custo_unitario FROM itens_venda WHERE produto_id = $1", kitID).Scan(&cost); err != nil || cost != 7 {
+		t.Errorf("Esperava custo unitário 7 no item do kit, obteve %v (%v)", cost, err)
+	}
+	// Os componentes vendidos dentro do kit contam na procura e deixam de estar parados.
+	demand, err := testStorage.GetDailyUnitSales(detergenteID.String(), filialID.String(), time.Now(), 1)
+	if err != nil || len(demand) != 1 || demand[0] != 4 {
+		t.Errorf("Esperava 4 detergentes vendidos hoje dentro de kits, obteve %v (%v)", demand, err)
+	}
+	slow, err := testStorage.GetSlowMovingStock(filialID.String(), 30, 0, 0)
+	if err != nil {
+		t.Fatalf("Erro inesperado no stock parado: %v", err)
+	}
+	for _, item := range slow {
+		if item.ProdutoID == esponjaID {
+			t.Errorf("A esponja vendida dentro do kit não devia estar parada: %+v", item)
+		}
+	}
+
+	// Sem detergentes para mais um kit, a venda é anulada e as esponjas ficam como estavam.
+	if err := testStorage.RegisterSale(models.Venda{UsuarioID: userID, FilialID: filialID, TotalVenda: 12}, []models.ItemVenda{{ProdutoID: kitID, Quantidade: 1, PrecoUnitario: 12}}); err == nil {
+		t.Error("Esperava erro de stock insuficiente do componente")
+	}
+	if e := stock(esponjaID); e != 8 {
+		t.Errorf("Esperava as esponjas intactas depois da venda falhada, obteve %d", e)
+	}
+	if got := availability(); got != 0 {
+		t.Errorf("Esperava 0 kits disponíveis, obteve %d", got)
+	}
+	if results, _ := testStorage.SearchProductsForSale("789000001302", filialID); len(results) != 0 {
+		t.Errorf("Um kit sem componentes em stock não devia aparecer no terminal, obteve %+v", results)
+	}
+
+	if err := testStorage.RemoveKitComponent(kitID.String(), detergenteID.String()); err != nil {
+		t.Fatalf("Falha ao remover componente: %v", err)
+	}
+	if err := testStorage.RemoveKitComponent(kitID.String(), esponjaID.String()); err != nil {
+		t.Fatalf("Falha ao remover componente: %v", err)
+	}
+	var isKit bool
+	if err := testStorage.Dbpool.QueryRow(ctx, "SELECT kit FROM produtos WHERE id = $1", kitID).Scan(&isKit); err != nil || isKit {
+		t.Errorf("Sem componentes, o produto devia deixar de ser um kit (%v)", err)
+	}
+}
+
+func TestPricingStrategies(t *testing.T) {
+	departamentoID, err := testStorage.AddCategory("Departamento Estratégias", "")
+	if err != nil {
+		t.Fatalf("Falha ao criar departamento: %v", err)
+	}
+	categoriaID, err := testStorage.AddCategory("Categoria Estratégias", departamentoID)
+	if err != nil {
+		t.Fatalf("Falha ao criar categoria: %v", err)
+	}
+
+	strategy := &models.PricingStrategy{Metodo: pricing.MethodMargin, Impostos: pricing.TaxesOutside, Arredondamento: pricing.Round99}
+	if err := testStorage.SetCategoryPricingStrategy(departamentoID, strategy); err != nil {
+		t.Fatalf("Falha ao gravar a estratégia: %v", err)
+	}
+	if err := testStorage.SetCategoryPricingStrategy(categoriaID, &models.PricingStrategy{Metodo: "desconto", Impostos: pricing.TaxesOutside}); err == nil {
+		t.Error("Esperava erro para uma estratégia inválida")
+	}
+	inherited, err := testStorage.GetCategoryPricingStrategy(categoriaID)
+	if err != nil || inherited == nil || *inherited != *strategy {
+		t.Fatalf("Esperava a estratégia herdada do departamento, obteve %+v (%v)", inherited, err)
+	}
+
+	// Sem estratégia no departamento, a categoria deixa de herdar.
+	if err := testStorage.SetCategoryPricingStrategy(departamentoID, nil); err != nil {
+		t.Fatalf("Falha ao remover a estratégia: %v", err)
+	}
+	if inherited, err := testStorage.GetCategoryPricingStrategy(categoriaID); err != nil || inherited != nil {
+		t.Errorf("Esperava nenhuma estratégia herdada, obteve %+v (%v)", inherited, err)
+	}
+}
+
+func TestGenerateInternalBarcode(t *testing.T) {
+	first, err := testStorage.GenerateInternalBarcode()
+	if err != nil {
+		t.Fatalf("Falha ao gerar código interno: %v", err)
+	}
+	if !gtin.IsInternal(first) {
+		t.Fatalf("Esperava um código interno EAN-13 válido, obteve %q", first)
+	}
+
+	// Um código interno registado à mão é saltado pela numeração.
+	var sequence int64
+	fmt.Sscanf(first[len(gtin.InternalPrefix):12], "%d", &sequence)
+	taken, _ := gtin.Internal(sequence + 1)
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO produtos (nome, codigo_barras, preco_sugerido) VALUES ('Granel Manual', $1, 1)", taken); err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	next, err := testStorage.GenerateInternalBarcode()
+	if err != nil {
+		t.Fatalf("Falha ao gerar código interno: %v", err)
+	}
+	if want, _ := gtin.Internal(sequence + 2); next != want {
+		t.Errorf("Esperava %s depois do código já usado %s, obteve %s", want, taken, next)
+	}
+}
+
+func TestFiscalClassification(t *testing.T) {
+	table := []models.NCM{
+		{Codigo: "22021000", Descricao: "Águas, incluindo as águas minerais, adicionadas de açúcar"},
+		{Codigo: "22029900", Descricao: "Outras bebidas não alcoólicas > Outras"},
+	}
+	if _, _, err := testStorage.LoadNCMTable(table); err != nil {
+		t.Fatalf("Falha ao carregar a tabela NCM: %v", err)
+	}
+	if results, err := testStorage.SearchNCM("agua acucar", 10); err != nil || len(results) != 1 || results[0].Codigo != "22021000" {
+		t.Errorf("Esperava o NCM 22021000 pela descrição sem acentos, obteve %+v (%v)", results, err)
+	}
+	if results, err := testStorage.SearchNCM("2202.", 10); err != nil || len(results) != 2 {
+		t.Errorf("Esperava os 2 NCM pelo início do código, obteve %+v (%v)", results, err)
+	}
+
+	fiscalClass := models.FiscalClassification{NCM: "22021000", CEST: "0300700", CFOP: "5405", Origem: 2, CSTICMS: "60", CSTPIS: "04", CSTCOFINS: "04"}
+	product := models.Product{Nome: "Refrigerante Fiscal", CodigoBarras: "789000001500", PrecoSugerido: 5, FiscalClassification: fiscalClass}
+	if err := testStorage.AddProduct(product); err != nil {
+		t.Fatalf("Falha ao adicionar produto: %v", err)
+	}
+	details, err := testStorage.GetProductDetails("789000001500")
+	if err != nil || details == nil {
+		t.Fatalf("Falha ao obter os detalhes: %v", err)
+	}
+	if details.FiscalClassification != fiscalClass || details.NCMDescricao != table[0].Descricao {
+		t.Errorf("Classificação fiscal inesperada: %+v (%q)", details.FiscalClassification, details.NCMDescricao)
+	}
+
+	unknown := models.Product{Nome: "Produto NCM Inexistente", CodigoBarras: "789000001501", PrecoSugerido: 1, FiscalClassification: models.FiscalClassification{NCM: "99999999"}}
+	if err := testStorage.AddProduct(unknown); !errors.Is(err, ErrUnknownNCM) {
+		t.Errorf("Esperava ErrUnknownNCM, obteve %v", err)
+	}
+
+	// As variantes herdam a classificação do pai e acompanham as alterações.
+	variantID, err := testStorage.AddVariant(details.ID.String(), models.Product{CodigoBarras: "789000001502", Atributos: []models.VariantAttribute{{Nome: "Sabor", Valor: "Laranja"}}})
+	if err != nil {
+		t.Fatalf("Falha ao criar variante: %v", err)
+	}
+	product.NCM = "22029900"
+	if err := testStorage.UpdateProduct(details.ID.String(), product, ""); err != nil {
+		t.Fatalf("Falha ao atualizar produto: %v", err)
+	}
+	var variantNCM string
+	if err := testStorage.Dbpool.QueryRow(context.Background(), "SELECT ncm FROM produtos WHERE id = $1", variantID).Scan(&variantNCM); err != nil || variantNCM != "22029900" {
+		t.Errorf("Esperava o NCM do pai na variante, obteve %q (%v)", variantNCM, err)
+	}
+
+	// Uma importação sem as colunas fiscais não apaga a classificação.
+	rows := []models.ProductImportRow{{Linha: 2, Produto: models.Product{CodigoBarras: "789000001500", Nome: "Refrigerante Fiscal", PrecoSugerido: 6}}}
+	if _, err := testStorage.ImportProducts(rows, "", false, DefaultImportChunkSize, nil); err != nil {
+		t.Fatalf("Falha na importação: %v", err)
+	}
+	if details, _ := testStorage.GetProductDetails("789000001500"); details == nil || details.NCM != "22029900" || details.CFOP != "5405" {
+		t.Errorf("Esperava a classificação fiscal mantida, obteve %+v", details)
+	}
+
+	// Um NCM extinto ainda em uso fica na tabela e é reportado.
+	removed, inUse, err := testStorage.LoadNCMTable(table[:1])
+	if err != nil || removed != 0 || len(inUse) != 1 || inUse[0] != "22029900" {
+		t.Errorf("Esperava o NCM 22029900 mantido por estar em uso, obteve %d removidos e %v (%v)", removed, inUse, err)
+	}
+}
+
+func TestUnitsOfMeasure(t *testing.T) {
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Unidades"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	product := models.Product{Nome: "Água em Caixa", CodigoBarras: "789000001600", PrecoCusto: 1, PrecoSugerido: 2,
+		UnitsOfMeasure: models.UnitsOfMeasure{Unidade: "UN", UnidadeCompra: "CX", FatorCompra: 12}}
+	if err := testStorage.AddProduct(product); err != nil {
+		t.Fatalf("Falha ao adicionar produto: %v", err)
+	}
+	details, err := testStorage.GetProductDetails("789000001600")
+	if err != nil || details == nil {
+		t.Fatalf("Falha ao obter os detalhes: %v", err)
+	}
+	if details.UnitsOfMeasure != product.UnitsOfMeasure {
+		t.Errorf("Unidades inesperadas: %+v", details.UnitsOfMeasure)
+	}
+	productID := details.ID.String()
+
+	// 2 caixas a 18 cada entram como 24 unidades a 1,50.
+	if err := testStorage.AddStockItem(productID, filialID.String(), 2, models.StockLot{CustoUnitario: 18, Unidade: "CX"}); err != nil {
+		t.Fatalf("Falha ao adicionar stock em caixas: %v", err)
+	}
+	if err := testStorage.AddStockItem(productID, filialID.String(), 3, models.StockLot{}); err != nil {
+		t.Fatalf("Falha ao adicionar stock em unidades: %v", err)
+	}
+	if err := testStorage.AddStockItem(productID, filialID.String(), 1, models.StockLot{Unidade: "KG"}); err == nil {
+		t.Error("Esperava erro numa entrada numa unidade que o produto não usa")
+	}
+	var lotCost float64
+	if err := testStorage.Dbpool.QueryRow(context.Background(), "SELECT custo_unitario FROM lotes_estoque WHERE produto_id = $1 AND quantidade = 24", productID).Scan(&lotCost); err != nil || math.Abs(lotCost-1.5) > 0.0001 {
+		t.Errorf("Esperava o lote de 24 UN a 1,50, obteve %.4f (%v)", lotCost, err)
+	}
+
+	items, err := testStorage.GetStockItemsPaginated(filialID.String(), "789000001600", "", 10, 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Esperava 1 item de stock, obteve %+v (%v)", items, err)
+	}
+	if items[0].Quantidade != 27 || items[0].Unidade != "UN" || items[0].EmUnidadeCompra != "2 CX + 3 UN" {
+		t.Errorf("Stock inesperado: %d %s (%s)", items[0].Quantidade, items[0].Unidade, items[0].EmUnidadeCompra)
+	}
+	stock, err := testStorage.GetProductStockByFilial(productID)
+	if err != nil {
+		t.Fatalf("Falha ao obter o stock por filial: %v", err)
+	}
+	for _, d := range stock {
+		if d.FilialID == filialID && d.EmUnidadeCompra != "2 CX + 3 UN" {
+			t.Errorf("Esperava o saldo em caixas na filial, obteve %+v", d)
+		}
+	}
+}
+
+func TestProductSuppliers(t *testing.T) {
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(context.Background(), "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Fornecedores"); err != nil {
+		t.Fatalf("Falha ao inserir filial de teste: %v", err)
+	}
+	for i, barcode := range []string{"789000001700", "789000001701"} {
+		product := models.Product{Nome: fmt.Sprintf("Produto Fornecido %d", i), CodigoBarras: barcode, PrecoCusto: 1, PrecoSugerido: 2}
+		if err := testStorage.AddProduct(product); err != nil {
+			t.Fatalf("Falha ao adicionar produto: %v", err)
+		}
+	}
+	first, _ := testStorage.GetProductDetails("789000001700")
+	second, _ := testStorage.GetProductDetails("789000001701")
+	if first == nil || second == nil {
+		t.Fatal("Produtos de teste não encontrados")
+	}
+
+	// O fornecedor é criado pelo nome ao ligá-lo ao primeiro produto.
+	err := testStorage.SaveProductSupplier(models.ProductSupplier{ProdutoID: first.ID, FornecedorNome: "Fornecedor Norte", CodigoFornecedor: "N-1", UltimoCusto: 1.2, PrazoEntregaDias: 5, Preferencial: true})
+	if err != nil {
+		t.Fatalf("Falha ao ligar o fornecedor: %v", err)
+	}
+	err = testStorage.SaveProductSupplier(models.ProductSupplier{ProdutoID: first.ID, FornecedorNome: "Fornecedor Sul", CodigoFornecedor: "S-1", UltimoCusto: 1.0, PrazoEntregaDias: 2})
+	if err != nil {
+		t.Fatalf("Falha ao ligar o segundo fornecedor: %v", err)
+	}
+	err = testStorage.SaveProductSupplier(models.ProductSupplier{ProdutoID: second.ID, FornecedorNome: "Fornecedor Norte", CodigoFornecedor: "N-1"})
+	if err == nil {
+		t.Error("Esperava erro com o mesmo código do fornecedor noutro produto")
+	}
+
+	preferred, err := testStorage.GetPreferredSuppliers([]string{first.ID.String(), second.ID.String()})
+	if err != nil {
+		t.Fatalf("Falha ao obter os fornecedores preferenciais: %v", err)
+	}
+	if ps, ok := preferred[first.ID.String()]; !ok || ps.FornecedorNome != "Fornecedor Norte" {
+		t.Errorf("Esperava o fornecedor preferencial, obteve %+v", ps)
+	}
+	if _, ok := preferred[second.ID.String()]; ok {
+		t.Error("O segundo produto não tem fornecedores")
+	}
+
+	// Marcar outro como preferencial tira a marca ao anterior.
+	err = testStorage.SaveProductSupplier(models.ProductSupplier{ProdutoID: first.ID, FornecedorNome: "Fornecedor Sul", CodigoFornecedor: "S-1", UltimoCusto: 1.0, PrazoEntregaDias: 2, Preferencial: true})
+	if err != nil {
+		t.Fatalf("Falha ao trocar o preferencial: %v", err)
+	}
+	details, err := testStorage.GetProductDetails("789000001700")
+	if err != nil || details == nil || len(details.Fornecedores) != 2 {
+		t.Fatalf("Esperava 2 fornecedores nos detalhes, obteve %+v (%v)", details, err)
+	}
+	if !details.Fornecedores[0].Preferencial || details.Fornecedores[0].FornecedorNome != "Fornecedor Sul" || details.Fornecedores[1].Preferencial {
+		t.Errorf("Preferencial inesperado: %+v", details.Fornecedores)
+	}
+
+	// Uma entrada com fornecedor atualiza o último custo dele.
+	norte := details.Fornecedores[1].FornecedorID
+	if err := testStorage.AddStockItem(first.ID.String(), filialID.String(), 10, models.StockLot{CustoUnitario: 1.4, FornecedorID: &norte}); err != nil {
+		t.Fatalf("Falha ao adicionar stock com fornecedor: %v", err)
+	}
+	links, err := testStorage.GetProductSuppliers(first.ID.String())
+	if err != nil {
+		t.Fatalf("Falha ao listar os fornecedores: %v", err)
+	}
+	for _, l := range links {
+		if l.FornecedorID == norte && math.Abs(l.UltimoCusto-1.4) > 0.0001 {
+			t.Errorf("Esperava o último custo 1,40, obteve %.4f", l.UltimoCusto)
+		}
+	}
+
+	if err := testStorage.RemoveProductSupplier(first.ID.String(), norte.String()); err != nil {
+		t.Fatalf("Falha ao remover o fornecedor: %v", err)
+	}
+	links, _ = testStorage.GetProductSuppliers(first.ID.String())
+	if len(links) != 1 {
+		t.Errorf("Esperava 1 fornecedor depois de remover, obteve %d", len(links))
+	}
+
+	// O rascunho de encomenda usa o fornecedor preferencial, com o código e o último custo dele.
+	var userID uuid.UUID
+	if err := testStorage.Dbpool.QueryRow(context.Background(), "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ('Comprador', 'comprador@teste.com', 'admin', 'hash', $1) RETURNING id", filialID).Scan(&userID); err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	line := models.PurchaseOrderLine{ProdutoID: first.ID, FilialID: filialID, Quantidade: 24}
+	unsupplied := models.PurchaseOrderLine{ProdutoID: second.ID, FilialID: filialID, Quantidade: 6}
+	if _, err := testStorage.CreatePurchaseOrderDrafts([]models.PurchaseOrderLine{line, unsupplied}, userID.String()); err == nil {
+		t.Error("Esperava erro ao encomendar um produto sem fornecedor")
+	}
+	if n, err := testStorage.CreatePurchaseOrderDrafts([]models.PurchaseOrderLine{line}, userID.String()); err != nil || n != 1 {
+		t.Fatalf("Esperava 1 rascunho de encomenda, obteve %d (%v)", n, err)
+	}
+	// Voltar a encomendar o mesmo produto atualiza a linha do mesmo rascunho.
+	line.Quantidade = 36
+	if _, err := testStorage.CreatePurchaseOrderDrafts([]models.PurchaseOrderLine{line}, userID.String()); err != nil {
+		t.Fatalf("Falha ao atualizar o rascunho de encomenda: %v", err)
+	}
+	orders, err := testStorage.GetPurchaseOrderDrafts()
+	if err != nil {
+		t.Fatalf("Falha ao listar os rascunhos de encomenda: %v", err)
+	}
+	var order *models.PurchaseOrder
+	for i := range orders {
+		if orders[i].FilialID == filialID {
+			if order != nil {
+				t.Fatal("Esperava um só rascunho para o fornecedor e a filial")
+			}
+			order = &orders[i]
+		}
+	}
+	if order == nil || order.FornecedorNome != "Fornecedor Sul" || order.CriadoPorNome != "Comprador" || len(order.Itens) != 1 {
+		t.Fatalf("Rascunho de encomenda inesperado: %+v", order)
+	}
+	if item := order.Itens[0]; item.CodigoFornecedor != "S-1" || item.Quantidade != 36 || math.Abs(item.CustoUnitario-1.0) > 0.0001 || math.Abs(order.Total-36) > 0.0001 {
+		t.Errorf("Linha do rascunho inesperada: %+v (total %.2f)", item, order.Total)
+	}
+	if err := testStorage.DeletePurchaseOrderDraft(order.ID.String()); err != nil {
+		t.Fatalf("Falha ao apagar o rascunho de encomenda: %v", err)
+	}
+	if err := testStorage.DeletePurchaseOrderDraft(order.ID.String()); err == nil {
+		t.Error("Esperava erro ao apagar um rascunho que já não existe")
+	}
+}
+
+// TestInventoryCountApproval percorre uma contagem completa: fotografia, vendas durante a
+// contagem, lançamentos cegos e aprovação. O stock final tem de ser o contado mais os
+// movimentos feitos depois da abertura, e uma contagem cancelada não pode mexer em nada.
+func TestInventoryCountApproval(t *testing.T) {
+	ctx := context.Background()
+	filialID := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO filiais (id, nome) VALUES ($1, $2)", filialID, "Filial Contagem"); err != nil {
+		t.Fatalf("Falha ao inserir filial: %v", err)
+	}
+	var userID uuid.UUID
+	err := testStorage.Dbpool.QueryRow(ctx, "INSERT INTO usuarios (nome, email, cargo, senha_hash, filial_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", "Estoquista Contagem", "contagem@teste.com", "estoquista", "hash", filialID).Scan(&userID)
+	if err != nil {
+		t.Fatalf("Falha ao inserir utilizador de teste: %v", err)
+	}
+	leite, sumo := uuid.New(), uuid.New()
+	for id, codigo := range map[uuid.UUID]string{leite: "7890000018001", sumo: "7890000018002"} {
+		if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO produtos (id, nome, codigo_barras, preco_custo, preco_sugerido) VALUES ($1, $2, $3, $4, $5)", id, "Produto Contagem "+codigo, codigo, 1.0, 2.0); err != nil {
+			t.Fatalf("Falha ao inserir produto de teste: %v", err)
+		}
+	}
+
+	sooner := time.Now().AddDate(0, 0, 5)
+	later := time.Now().AddDate(0, 0, 30)
+	if err := testStorage.AddStockItem(leite.String(), filialID.String(), 10, models.StockLot{NumeroLote: "C-CEDO", DataValidade: &sooner}); err != nil {
+		t.Fatalf("Falha ao receber o primeiro lote: %v", err)
+	}
+	if err := testStorage.AddStockItem(leite.String(), filialID.String(), 5, models.StockLot{NumeroLote: "C-TARDE", DataValidade: &later}); err != nil {
+		t.Fatalf("Falha ao receber o segundo lote: %v", err)
+	}
+	if err := testStorage.AddStockItem(sumo.String(), filialID.String(), 8, models.StockLot{}); err != nil {
+		t.Fatalf("Falha ao receber stock: %v", err)
+	}
+
+	stockQty := func(productID uuid.UUID) int {
+		var qty int
+		err := testStorage.Dbpool.QueryRow(ctx, "SELECT quantidade FROM estoque_filiais WHERE produto_id = $1 AND filial_id = $2", productID, filialID).Scan(&qty)
+		if err != nil {
+			t.Fatalf("Falha ao ler o stock: %v", err)
+		}
+		return qty
+	}
+	lotQty := func(productID uuid.UUID, numero string) int {
+		var qty int
+		err := testStorage.Dbpool.QueryRow(ctx, "SELECT COALESCE(SUM(quantidade), 0) FROM lotes_estoque WHERE produto_id = $1 AND filial_id = $2 AND ($3 = '' OR numero_lote = $3)", productID, filialID, numero).Scan(&qty)
+		if err != nil {
+			t.Fatalf("Falha ao ler os lotes: %v", err)
+		}
+		return qty
+	}
+
+	countID, err := testStorage.CreateInventoryCount(models.InventoryCount{FilialID: filialID, Descricao: "Contagem geral", CriadoPor: userID})
+	if err != nil {
+		t.Fatalf("Falha ao abrir a contagem: %v", err)
+	}
+
+	// A venda acontece depois da fotografia: 15 esperados, 12 em stock.
+	sale := models.Venda{UsuarioID: userID, FilialID: filialID, TotalVenda: 6}
+	if err := testStorage.RegisterSale(sale, []models.ItemVenda{{ProdutoID: leite, Quantidade: 3, PrecoUnitario: 2}}); err != nil {
+		t.Fatalf("Registo de venda falhou inesperadamente: %v", err)
+	}
+
+	// Lançamentos cegos: o leite é contado em duas secções (6 + 4 = 10, faltam 5) e o sumo
+	// é lançado pelo código de barras (9, sobra 1).
+	entries := []struct {
+		entry   models.InventoryCountEntry
+		barcode string
+	}{
+		{models.InventoryCountEntry{ContagemID: countID, ProdutoID: leite, UsuarioID: userID, Secao: "Frio", Quantidade: 6}, ""},
+		{models.InventoryCountEntry{ContagemID: countID, ProdutoID: leite, UsuarioID: userID, Secao: "Armazém", Quantidade: 4}, ""},
+		{models.InventoryCountEntry{ContagemID: countID, UsuarioID: userID, Quantidade: 9}, "7890000018002"},
+	}
+	for _, e := range entries {
+		if err := testStorage.AddInventoryCountEntry(e.entry, e.barcode); err != nil {
+			t.Fatalf("Falha ao registar o lançamento: %v", err)
+		}
+	}
+
+	variances, err := testStorage.GetInventoryCountVariances(countID.String())
+	if err != nil {
+		t.Fatalf("Erro inesperado nas divergências: %v", err)
+	}
+	diffs := map[uuid.UUID]int{}
+	for _, v := range variances {
+		diffs[v.ProdutoID] = v.Diferenca
+	}
+	if diffs[leite] != -5 || diffs[sumo] != 1 {
+		t.Errorf("Esperava divergências de -5 no leite e +1 no sumo, mas obteve %d e %d", diffs[leite], diffs[sumo])
+	}
+
+	if err := testStorage.ApproveInventoryCount(countID.String(), userID.String(), "Inventário anual"); err == nil {
+		t.Error("Esperava erro ao aprovar uma contagem ainda aberta")
+	}
+	if err := testStorage.CloseInventoryCount(countID.String()); err != nil {
+		t.Fatalf("Falha ao fechar a contagem: %v", err)
+	}
+	if err := testStorage.AddInventoryCountEntry(models.InventoryCountEntry{ContagemID: countID, ProdutoID: leite, UsuarioID: userID, Quantidade: 1}, ""); err == nil {
+		t.Error("Esperava erro ao lançar numa contagem em revisão")
+	}
+	if err := testStorage.ApproveInventoryCount(countID.String(), userID.String(), ""); err == nil {
+		t.Error("Esperava erro ao aprovar sem motivo")
+	}
+	if err := testStorage.ApproveInventoryCount(countID.String(), userID.String(), "Inventário anual"); err != nil {
+		t.Fatalf("Falha ao aprovar a contagem: %v", err)
+	}
+
+	// Leite: 10 contados - 3 vendidos depois da abertura = 7; os lotes acompanham o saldo
+	// e a quebra sai primeiro do lote que vence mais cedo (a venda já tinha levado 3).
+	if got := stockQty(leite); got != 7 {
+		t.Errorf("Esperava 7 de leite em stock depois da aprovação, mas ficou com %d", got)
+	}
+	if got := lotQty(leite, ""); got != 7 {
+		t.Errorf("Esperava 7 de leite nos lotes depois da aprovação, mas ficou com %d", got)
+	}
+	if got := lotQty(leite, "C-CEDO"); got != 2 {
+		t.Errorf("Esperava 2 no lote C-CEDO, mas ficou com %d", got)
+	}
+	if got := lotQty(leite, "C-TARDE"); got != 5 {
+		t.Errorf("Esperava 5 no lote C-TARDE, mas ficou com %d", got)
+	}
+	// Sumo: sem movimentos, fica com o contado.
+	if got := stockQty(sumo); got != 9 {
+		t.Errorf("Esperava 9 de sumo em stock depois da aprovação, mas ficou com %d", got)
+	}
+	if err := testStorage.ApproveInventoryCount(countID.String(), userID.String(), "Inventário anual"); err == nil {
+		t.Error("Esperava erro ao aprovar uma contagem já aprovada")
+	}
+
+	// Uma contagem rejeitada (cancelada) não altera o stock nem os lotes.
+	rejectedID, err := testStorage.CreateInventoryCount(models.InventoryCount{FilialID: filialID, Descricao: "Contagem rejeitada", CriadoPor: userID})
+	if err != nil {
+		t.Fatalf("Falha ao abrir a segunda contagem: %v", err)
+	}
+	if err := testStorage.AddInventoryCountEntry(models.InventoryCountEntry{ContagemID: rejectedID, ProdutoID: leite, UsuarioID: userID, Quantidade: 100}, ""); err != nil {
+		t.Fatalf("Falha ao registar o lançamento: %v", err)
+	}
+	if err := testStorage.AddInventoryCountEntry(models.InventoryCountEntry{ContagemID: rejectedID, ProdutoID: sumo, UsuarioID: userID, Quantidade: 0}, ""); err != nil {
+		t.Fatalf("Falha ao registar o lançamento: %v", err)
+	}
+	if err := testStorage.CloseInventoryCount(rejectedID.String()); err != nil {
+		t.Fatalf("Falha ao fechar a segunda contagem: %v", err)
+	}
+	if err := testStorage.CancelInventoryCount(rejectedID.String()); err != nil {
+		t.Fatalf("Falha ao cancelar a contagem: %v", err)
+	}
+	if err := testStorage.ApproveInventoryCount(rejectedID.String(), userID.String(), "Inventário anual"); err == nil {
+		t.Error("Esperava erro ao aprovar uma contagem cancelada")
+	}
+	if err := testStorage.CancelInventoryCount(rejectedID.String()); err == nil {
+		t.Error("Esperava erro ao cancelar uma contagem já cancelada")
+	}
+	if stockQty(leite) != 7 || lotQty(leite, "") != 7 || stockQty(sumo) != 9 {
+		t.Errorf("A contagem cancelada alterou o stock: leite %d (lotes %d), sumo %d", stockQty(leite), lotQty(leite, ""), stockQty(sumo))
+	}
+
+	// Um produto fora da fotografia entra com o custo do stock (o médio), não o de cadastro.
+	extraID, err := testStorage.CreateInventoryCount(models.InventoryCount{FilialID: filialID, Descricao: "Contagem extra", CriadoPor: userID})
+	if err != nil {
+		t.Fatalf("Falha ao abrir a terceira contagem: %v", err)
+	}
+	manteiga := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO produtos (id, nome, codigo_barras, preco_custo, custo_medio, preco_sugerido) VALUES ($1, 'Manteiga Contagem', '7890000018003', 1, 1.5, 3)", manteiga); err != nil {
+		t.Fatalf("Falha ao inserir produto de teste: %v", err)
+	}
+	if err := testStorage.AddInventoryCountEntry(models.InventoryCountEntry{ContagemID: extraID, UsuarioID: userID, Quantidade: 2}, "7890000018003"); err != nil {
+		t.Fatalf("Falha ao registar o lançamento: %v", err)
+	}
+	var unitCost float64
+	if err := testStorage.Dbpool.QueryRow(ctx, "SELECT custo_unitario FROM contagem_itens WHERE contagem_id = $1 AND produto_id = $2", extraID, manteiga).Scan(&unitCost); err != nil || unitCost != 1.5 {
+		t.Errorf("Esperava o custo médio 1,50 no produto fora da fotografia, obteve %.2f (%v)", unitCost, err)
+	}
+
+	// Um kit não se conta: o stock dele é o dos componentes.
+	cesto := uuid.New()
+	if _, err := testStorage.Dbpool.Exec(ctx, "INSERT INTO produtos (id, nome, codigo_barras, preco_sugerido, kit) VALUES ($1, 'Cesto Contagem', '7890000018004', 10, TRUE)", cesto); err != nil {
+		t.Fatalf("Falha ao inserir kit de teste: %v", err)
+	}
+	if err := testStorage.AddInventoryCountEntry(models.InventoryCountEntry{ContagemID: extraID, ProdutoID: cesto, UsuarioID: userID, Quantidade: 1}, ""); !errors.Is(err, ErrKitStock) {
+		t.Errorf("Esperava ErrKitStock ao contar um kit, obteve %v", err)
+	}
+}
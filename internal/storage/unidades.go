@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"projeto-vendas/internal/models"
+	"projeto-vendas/internal/units"
+)
+
+// sqlUnitColumns são as colunas das unidades de medida do produto (alias p), pela ordem dos
+// campos de models.UnitsOfMeasure.
+const sqlUnitColumns = `p.unidade, COALESCE(p.unidade_compra, ''), p.fator_compra`
+
+// productUnits lê as unidades de medida de um produto dentro da transação.
+func productUnits(tx pgx.Tx, productID string) (models.UnitsOfMeasure, error) {
+	var u models.UnitsOfMeasure
+	sql := `SELECT ` + sqlUnitColumns + ` FROM produtos p WHERE p.id = $1`
+	err := tx.QueryRow(context.Background(), sql, productID).Scan(&u.Unidade, &u.UnidadeCompra, &u.FatorCompra)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, errors.New("produto não encontrado")
+	}
+	return u, err
+}
+
+// convertReceipt passa uma entrada contada em lot.Unidade para a unidade de venda: devolve
+// a quantidade do stock e deixa o custo do lote por unidade de venda.
+func convertReceipt(quantity int, lot *models.StockLot, u models.UnitsOfMeasure) (int, error) {
+	stockQuantity, err := units.ToStock(quantity, lot.Unidade, u)
+	if err != nil {
+		return 0, fmt.Errorf("unidade da entrada inválida: %w", err)
+	}
+	if stockQuantity != quantity && stockQuantity > 0 {
+		lot.CustoUnitario = lot.CustoUnitario * float64(quantity) / float64(stockQuantity)
+	}
+	lot.Unidade = ""
+	return stockQuantity, nil
+}
+
+// syncVariantsUnits copia as unidades de medida do produto pai para as variantes: o stock
+// das variantes soma no pai e tem de estar na mesma unidade.
+func syncVariantsUnits(ctx context.Context, tx pgx.Tx, parentID string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE produtos v
+		SET unidade = pai.unidade, unidade_compra = pai.unidade_compra, fator_compra = pai.fator_compra
+		FROM produtos pai
+		WHERE v.produto_pai_id = pai.id AND pai.id = $1
+	`, parentID)
+	return err
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"projeto-vendas/internal/models"
+)
+
+// sqlVariantName monta o nome de uma variante a partir do nome do produto pai (alias pai)
+// e dos atributos em %s: "Camiseta - M / Azul". Como produtos.nome é único, duas
+// variantes do mesmo produto não podem ter os mesmos valores.
+const sqlVariantName = `pai.nome || ' - ' || (
+	SELECT string_agg(a.atributo ->> 'valor', ' / ' ORDER BY a.ordem)
+	FROM jsonb_array_elements(%s) WITH ORDINALITY AS a(atributo, ordem))`
+
+// ErrVariantParent é devolvido quando o produto pai não existe ou já é uma variante.
+var ErrVariantParent = errors.New("produto pai não encontrado ou já é uma variante")
+
+// validateVariant limpa os atributos e exige pelo menos um com valor, um código de
+// barras próprio (é por ele que o terminal identifica a variante) e um preço não negativo.
+func validateVariant(variant models.Product) ([]models.VariantAttribute, error) {
+	if strings.TrimSpace(variant.CodigoBarras) == "" {
+		return nil, errors.New("a variante precisa de um código de barras próprio")
+	}
+	if variant.PrecoSugerido < 0 {
+		return nil, errors.New("o preço da variante não pode ser negativo")
+	}
+	var clean []models.VariantAttribute
+	for _, a := range variant.Atributos {
+		a.Nome, a.Valor = strings.TrimSpace(a.Nome), strings.TrimSpace(a.Valor)
+		if a.Valor == "" {
+			continue
+		}
+		if a.Nome == "" {
+			return nil, fmt.Errorf("o atributo com valor %q não tem nome", a.Valor)
+		}
+		clean = append(clean, a)
+	}
+	if len(clean) == 0 {
+		return nil, errors.New("indique pelo menos um atributo da variante (p.ex. Tamanho = M)")
+	}
+	return clean, nil
+}
+
+// variantWriteError traduz as violações de unicidade do nome ou do código de barras.
+func variantWriteError(err error) error {
+	if err = barcodeError(err); err == ErrBarcodeTaken {
+		return err
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return errors.New("já existe uma variante com estes atributos ou um produto com este código de barras")
+	}
+	return err
+}
+
+// sqlVariantPrice é o preço efetivo de uma variante: o preço próprio ($4, 0 para nenhum) ou
+// o do produto pai (alias pai).
+const sqlVariantPrice = `COALESCE(NULLIF($4::numeric, 0), pai.preco_sugerido)`
+
+// AddVariant cria uma variante do produto pai. Herda do pai a descrição, a categoria,
+// o custo, os impostos, a estratégia de preço, a classificação fiscal e as unidades de
+// medida; o preço de venda é o preço próprio da variante ou, se for 0, o do pai, que a
+// variante passa a seguir.
+func (s *Storage) AddVariant(parentID string, variant models.Product) (string, error) {
+	attrs, err := validateVariant(variant)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	sql := `
+		INSERT INTO produtos (nome, descricao, categoria_id, codigo_barras, codigo_cnae, preco_custo, percentual_lucro,
+			imposto_estadual, imposto_federal, preco_sugerido, preco_variante, produto_pai_id, atributos_variante, estrategia_preco,
+			ncm, cest, cfop, origem, cst_icms, cst_pis, cst_cofins, unidade, unidade_compra, fator_compra)
+		SELECT ` + fmt.Sprintf(sqlVariantName, "$2::jsonb") + `, pai.descricao, pai.categoria_id, $3, pai.codigo_cnae,
+			pai.preco_custo, pai.percentual_lucro, pai.imposto_estadual, pai.imposto_federal,
+			` + sqlVariantPrice + `, NULLIF($4::numeric, 0), pai.id, $2::jsonb, pai.estrategia_preco,
+			pai.ncm, pai.cest, pai.cfop, pai.origem, pai.cst_icms, pai.cst_pis, pai.cst_cofins,
+			pai.unidade, pai.unidade_compra, pai.fator_compra
+		FROM produtos pai
+		WHERE pai.id = $1 AND pai.produto_pai_id IS NULL
+		RETURNING id
+	`
+	err = s.Dbpool.QueryRow(context.Background(), sql, parentID, attrs, strings.TrimSpace(variant.CodigoBarras), variant.PrecoSugerido).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrVariantParent
+	}
+	if err != nil {
+		return "", variantWriteError(err)
+	}
+	return id, nil
+}
+
+// UpdateVariant altera os atributos, o código de barras e o preço próprio de uma variante
+// (0 volta a seguir o preço do pai); o nome é recalculado a partir do produto pai.
+func (s *Storage) UpdateVariant(variantID string, variant models.Product, userID string) error {
+	attrs, err := validateVariant(variant)
+	if err != nil {
+		return err
+	}
+	sql := `
+		UPDATE produtos v
+		SET nome = ` + fmt.Sprintf(sqlVariantName, "$2::jsonb") + `,
+			atributos_variante = $2::jsonb,
+			codigo_barras = $3,
+			data_alteracao_preco = CASE WHEN v.preco_sugerido <> ` + sqlVariantPrice + `
+				THEN NOW() ELSE v.data_alteracao_preco END,
+			preco_sugerido = ` + sqlVariantPrice + `,
+			preco_variante = NULLIF($4::numeric, 0),
+			data_atualizacao = NOW()
+		FROM produtos pai
+		WHERE v.id = $1 AND pai.id = v.produto_pai_id
+	`
+	cmdTag, err := s.execAudited(userID, PriceOriginManual, sql, variantID, attrs, strings.TrimSpace(variant.CodigoBarras), variant.PrecoSugerido)
+	if err != nil {
+		return variantWriteError(err)
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("variante não encontrada")
+	}
+	return nil
+}
+
+// renameVariants recalcula o nome das variantes depois de o produto pai mudar de nome.
+func renameVariants(ctx context.Context, tx pgx.Tx, parentID string) error {
+	sql := `
+		UPDATE produtos v
+		SET nome = ` + fmt.Sprintf(sqlVariantName, "v.atributos_variante") + `
+		FROM produtos pai
+		WHERE v.produto_pai_id = pai.id AND pai.id = $1
+	`
+	_, err := tx.Exec(ctx, sql, parentID)
+	return err
+}
+
+// GetVariants lista as variantes de um produto com o stock total de cada uma e o preço
+// próprio das que o têm.
+func (s *Storage) GetVariants(parentID string) ([]models.Product, error) {
+	var variants []models.Product
+	sql := `
+		SELECT v.id, v.nome, v.codigo_barras, v.preco_sugerido, v.preco_variante, v.produto_pai_id, v.atributos_variante,
+			COALESCE(SUM(ef.quantidade), 0)
+		FROM produtos v
+		LEFT JOIN estoque_filiais ef ON ef.produto_id = v.id
+		WHERE v.produto_pai_id = $1
+		GROUP BY v.id
+		ORDER BY v.nome
+	`
+	rows, err := s.Dbpool.Query(context.Background(), sql, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v models.Product
+		if err := rows.Scan(&v.ID, &v.Nome, &v.CodigoBarras, &v.PrecoSugerido, &v.PrecoVariante, &v.ProdutoPaiID, &v.Atributos, &v.TotalEstoque); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
+
+// GetProductByID devolve um produto do catálogo, com o número de variantes se for pai.
+func (s *Storage) GetProductByID(id string) (*models.Product, error) {
+	var p models.Product
+	sql := `
+		SELECT p.id, p.nome, COALESCE(p.descricao, ''), COALESCE(` + sqlCategoryPath + `, ''), COALESCE(p.codigo_barras, ''),
+			p.preco_custo, p.preco_sugerido, p.produto_pai_id, p.atributos_variante,
+			(SELECT COUNT(*) FROM produtos v WHERE v.produto_pai_id = p.id), ` + sqlUnitColumns + `
+		FROM produtos p
+		WHERE p.id = $1
+	`
+	err := s.Dbpool.QueryRow(context.Background(), sql, id).Scan(&p.ID, &p.Nome, &p.Descricao, &p.Categoria, &p.CodigoBarras,
+		&p.PrecoCusto, &p.PrecoSugerido, &p.ProdutoPaiID, &p.Atributos, &p.NumVariantes,
+		&p.Unidade, &p.UnidadeCompra, &p.FatorCompra)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
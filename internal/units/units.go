@@ -0,0 +1,124 @@
+// Package units trata as unidades de medida dos produtos. O stock e as vendas contam-se
+// sempre na unidade de venda do produto; as compras podem vir numa unidade de compra (p.ex.
+// a caixa), convertida na entrada pelo fator de conversão.
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"projeto-vendas/internal/models"
+)
+
+// Unidades de medida aceites.
+const (
+	Unit     = "UN"
+	Box      = "CX"
+	Kilogram = "KG"
+	Liter    = "L"
+)
+
+// Option é uma unidade com o seu nome, para as listas dos formulários.
+type Option struct {
+	Codigo    string
+	Descricao string
+}
+
+// All são as unidades de medida, pela ordem das listas.
+var All = []Option{
+	{Unit, "Unidade"},
+	{Box, "Caixa"},
+	{Kilogram, "Quilograma"},
+	{Liter, "Litro"},
+}
+
+// Normalize escreve a unidade em maiúsculas e sem espaços; vazio continua vazio.
+func Normalize(unit string) string {
+	return strings.ToUpper(strings.TrimSpace(unit))
+}
+
+// Valid diz se a unidade é uma das aceites.
+func Valid(unit string) bool {
+	for _, o := range All {
+		if o.Codigo == unit {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate normaliza e confirma as unidades do produto. Sem unidade de venda, o produto
+// conta-se em UN; sem unidade de compra, é comprado na unidade de venda (fator 1).
+func Validate(u *models.UnitsOfMeasure) error {
+	u.Unidade, u.UnidadeCompra = Normalize(u.Unidade), Normalize(u.UnidadeCompra)
+	if u.Unidade == "" {
+		u.Unidade = Unit
+	}
+	if !Valid(u.Unidade) {
+		return fmt.Errorf("unidade de venda inválida: %q (UN, CX, KG ou L)", u.Unidade)
+	}
+	if u.UnidadeCompra == "" || u.UnidadeCompra == u.Unidade {
+		if u.FatorCompra > 1 {
+			return fmt.Errorf("o fator de conversão %d exige uma unidade de compra diferente de %s", u.FatorCompra, u.Unidade)
+		}
+		u.UnidadeCompra, u.FatorCompra = "", 1
+		return nil
+	}
+	if !Valid(u.UnidadeCompra) {
+		return fmt.Errorf("unidade de compra inválida: %q (UN, CX, KG ou L)", u.UnidadeCompra)
+	}
+	if u.FatorCompra < 1 {
+		return fmt.Errorf("indique quantos %s tem cada %s (fator de conversão)", u.Unidade, u.UnidadeCompra)
+	}
+	return nil
+}
+
+// ParseFactor lê o fator de conversão de um formulário; vazio é 1.
+func ParseFactor(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 1, nil
+	}
+	factor, err := strconv.Atoi(s)
+	if err != nil || factor < 1 {
+		return 0, fmt.Errorf("fator de conversão inválido: %q (um número inteiro a partir de 1)", s)
+	}
+	return factor, nil
+}
+
+// ToStock converte uma quantidade recebida na unidade indicada para a unidade de venda do
+// produto, a do stock. Vazio é a unidade de venda.
+func ToStock(quantity int, unit string, u models.UnitsOfMeasure) (int, error) {
+	unit = Normalize(unit)
+	switch {
+	case unit == "" || unit == u.Unidade || (u.Unidade == "" && unit == Unit):
+		return quantity, nil
+	case unit == u.UnidadeCompra && u.FatorCompra > 0:
+		return quantity * u.FatorCompra, nil
+	}
+	if u.UnidadeCompra == "" {
+		return 0, fmt.Errorf("o produto só é comprado em %s, não em %s", stockUnit(u), unit)
+	}
+	return 0, fmt.Errorf("o produto é comprado em %s ou %s, não em %s", u.UnidadeCompra, stockUnit(u), unit)
+}
+
+// InPurchaseUnit escreve o saldo na unidade de compra, p.ex. "2 CX + 3 UN" para 27 UN em
+// caixas de 12. Devolve vazio se o produto não tem unidade de compra ou o saldo é negativo.
+func InPurchaseUnit(quantity int, u models.UnitsOfMeasure) string {
+	if u.UnidadeCompra == "" || u.FatorCompra <= 1 || quantity < 0 {
+		return ""
+	}
+	packs, rest := quantity/u.FatorCompra, quantity%u.FatorCompra
+	if rest == 0 {
+		return fmt.Sprintf("%d %s", packs, u.UnidadeCompra)
+	}
+	return fmt.Sprintf("%d %s + %d %s", packs, u.UnidadeCompra, rest, stockUnit(u))
+}
+
+func stockUnit(u models.UnitsOfMeasure) string {
+	if u.Unidade == "" {
+		return Unit
+	}
+	return u.Unidade
+}
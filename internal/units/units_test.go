@@ -0,0 +1,66 @@
+package units
+
+import (
+	"testing"
+
+	"projeto-vendas/internal/models"
+)
+
+func TestValidate(t *testing.T) {
+	u := models.UnitsOfMeasure{Unidade: " un", UnidadeCompra: "cx", FatorCompra: 12}
+	if err := Validate(&u); err != nil {
+		t.Fatalf("Erro inesperado: %v", err)
+	}
+	if u.Unidade != Unit || u.UnidadeCompra != Box {
+		t.Errorf("Esperava as unidades normalizadas, obteve %+v", u)
+	}
+	// Sem unidades, o produto conta-se e compra-se em UN.
+	empty := models.UnitsOfMeasure{}
+	if err := Validate(&empty); err != nil || empty != (models.UnitsOfMeasure{Unidade: Unit, FatorCompra: 1}) {
+		t.Errorf("Esperava UN com fator 1, obteve %+v (%v)", empty, err)
+	}
+
+	invalid := map[string]models.UnitsOfMeasure{
+		"unidade de venda desconhecida":  {Unidade: "M"},
+		"unidade de compra desconhecida": {Unidade: Unit, UnidadeCompra: "PCT", FatorCompra: 6},
+		"compra sem fator":               {Unidade: Unit, UnidadeCompra: Box},
+		"fator sem unidade de compra":    {Unidade: Unit, FatorCompra: 12},
+	}
+	for name, u := range invalid {
+		if err := Validate(&u); err == nil {
+			t.Errorf("%s: esperava erro", name)
+		}
+	}
+}
+
+func TestToStock(t *testing.T) {
+	u := models.UnitsOfMeasure{Unidade: Unit, UnidadeCompra: Box, FatorCompra: 12}
+	cases := []struct {
+		unit string
+		want int
+	}{{"", 3}, {"UN", 3}, {"cx", 36}}
+	for _, c := range cases {
+		if got, err := ToStock(3, c.unit, u); err != nil || got != c.want {
+			t.Errorf("ToStock(3, %q) = %d, %v; esperava %d", c.unit, got, err, c.want)
+		}
+	}
+	if _, err := ToStock(3, Kilogram, u); err == nil {
+		t.Error("Esperava erro numa unidade que não é a de venda nem a de compra")
+	}
+	if _, err := ToStock(3, Box, models.UnitsOfMeasure{Unidade: Unit, FatorCompra: 1}); err == nil {
+		t.Error("Esperava erro numa entrada em CX de um produto sem unidade de compra")
+	}
+}
+
+func TestInPurchaseUnit(t *testing.T) {
+	u := models.UnitsOfMeasure{Unidade: Unit, UnidadeCompra: Box, FatorCompra: 12}
+	cases := map[int]string{24: "2 CX", 27: "2 CX + 3 UN", 5: "0 CX + 5 UN", -1: ""}
+	for quantity, want := range cases {
+		if got := InPurchaseUnit(quantity, u); got != want {
+			t.Errorf("InPurchaseUnit(%d) = %q; esperava %q", quantity, got, want)
+		}
+	}
+	if got := InPurchaseUnit(24, models.UnitsOfMeasure{Unidade: Unit, FatorCompra: 1}); got != "" {
+		t.Errorf("Esperava vazio sem unidade de compra, obteve %q", got)
+	}
+}
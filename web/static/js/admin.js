@@ -42,14 +42,34 @@ async function openAdjustStockModal(productId, productName) {
             form.innerHTML = `
                 <input type="hidden" name="product_id" value="${productId}">
                 <input type="hidden" name="filial_id" value="${stock.FilialID}">
+                <input type="hidden" name="versao" value="${stock.Versao}">
                 <span class="flex-1">${stock.FilialNome}</span>
                 <div class="flex items-center">
                     <label class="text-sm mr-2">Qtd:</label>
                     <input type="number" name="quantity" value="${stock.Quantidade}" min="0" class="w-24 text-right border rounded p-1">
+                    <span class="text-sm ml-2">${stock.Unidade}</span>
+                    ${stock.EmUnidadeCompra ? `<span class="text-xs text-gray-500 ml-2">(${stock.EmUnidadeCompra})</span>` : ''}
                 </div>
                 <button type="submit" class="bg-green-500 text-white px-3 py-1 rounded text-sm hover:bg-green-600">Guardar</button>
             `;
             contentDiv.appendChild(form);
+
+            // Linha de baixa: retira stock com motivo, sujeita ao limite de aprovação
+            const writeOff = document.createElement('div');
+            writeOff.className = 'flex items-center justify-end space-x-2 p-2 border-b bg-gray-50';
+            writeOff.innerHTML = `
+                <label class="text-sm">Baixa:</label>
+                <input type="number" id="adjust-qty-${stock.FilialID}" min="1" placeholder="Qtd" class="w-20 text-right border rounded p-1">
+                <select id="adjust-reason-${stock.FilialID}" class="border rounded p-1 text-sm bg-white">
+                    <option value="quebra">Quebra</option>
+                    <option value="furto">Furto</option>
+                    <option value="validade">Validade expirada</option>
+                    <option value="uso_interno">Uso interno</option>
+                </select>
+                <input type="text" id="adjust-note-${stock.FilialID}" placeholder="Observação" class="w-40 border rounded p-1 text-sm">
+                <button type="button" onclick="submitStockAdjustment('${productId}', '${stock.FilialID}')" class="bg-red-500 text-white px-3 py-1 rounded text-sm hover:bg-red-600">Dar Baixa</button>
+            `;
+            contentDiv.appendChild(writeOff);
         });
 
     } catch (error) {
@@ -74,7 +94,9 @@ async function submitStockAdjustment(productId, filialId) {
             body: JSON.stringify({
                 product_id: productId,
                 filial_id: filialId,
-                quantity: quantity
+                quantity: quantity,
+                motivo: document.getElementById(`adjust-reason-${filialId}`).value,
+                observacao: document.getElementById(`adjust-note-${filialId}`).value
             })
         });
 
@@ -84,7 +106,7 @@ async function submitStockAdjustment(productId, filialId) {
             throw new Error(result.error || 'Erro desconhecido.');
         }
         
-        alert('Baixa no stock realizada com sucesso!');
+        alert(result.message || 'Baixa no stock realizada com sucesso!');
         location.reload();
 
     } catch (error) {
@@ -124,8 +146,96 @@ document.addEventListener('DOMContentLoaded', () => {
     if (addTypeSelector) {
         toggleNewProductFields(addTypeSelector.value);
     }
+    document.querySelectorAll('[data-price-preview]').forEach(box => setupPricePreview(box.closest('form'), box));
+    document.querySelectorAll('[data-ncm-search]').forEach(setupNCMSearch);
 });
 
+// Sugestões de NCM da tabela local: o código ou parte da descrição enchem a lista do
+// campo, e a descrição do NCM escolhido aparece por baixo.
+function setupNCMSearch(input) {
+    const list = document.getElementById(input.getAttribute('list'));
+    const description = input.closest('form').querySelector('[data-ncm-descricao]');
+    const known = new Map();
+    let timer;
+    let seq = 0;
+    const describe = () => {
+        const code = input.value.replace(/\D/g, '');
+        if (description) description.textContent = known.get(code) || '';
+    };
+    const search = async () => {
+        const query = input.value.trim();
+        if (query.length < 2) return;
+        const current = ++seq;
+        try {
+            const response = await fetch(`/admin/api/ncm?q=${encodeURIComponent(query)}`);
+            if (!response.ok) return;
+            const results = await response.json();
+            if (current !== seq || !list) return;
+            list.innerHTML = '';
+            results.forEach(ncm => {
+                known.set(ncm.codigo, ncm.descricao);
+                const option = document.createElement('option');
+                option.value = `${ncm.codigo.slice(0, 4)}.${ncm.codigo.slice(4, 6)}.${ncm.codigo.slice(6)}`;
+                option.label = ncm.descricao;
+                list.appendChild(option);
+            });
+            describe();
+        } catch (error) {
+            console.error('Erro ao pesquisar o NCM:', error);
+        }
+    };
+    input.addEventListener('input', () => {
+        describe();
+        clearTimeout(timer);
+        timer = setTimeout(search, 250);
+    });
+    input.searchNCM = search;
+}
+
+// Pré-visualização do preço sugerido nos formulários de produto: a cada alteração pede ao
+// servidor o preço e a margem com a estratégia escolhida, antes de guardar.
+const pricePreviewFields = ['preco_custo', 'percentual_lucro', 'imposto_estadual', 'imposto_federal', 'categoria_id',
+    'estrategia_metodo', 'estrategia_impostos', 'estrategia_arredondamento', 'estrategia_margem_minima'];
+const pricePreviewOrigins = { produto: 'estratégia do produto', categoria: 'estratégia da categoria', 'padrão': 'estratégia padrão' };
+
+function setupPricePreview(form, box) {
+    if (!form) return;
+    let timer;
+    let seq = 0;
+    const update = async () => {
+        const data = new FormData(form);
+        if (!data.get('preco_custo')) {
+            box.textContent = 'Preencha o custo para ver o preço sugerido.';
+            return;
+        }
+        const params = new URLSearchParams();
+        pricePreviewFields.forEach(name => params.set(name, data.get(name) || ''));
+        if (form.dataset.productId) params.set('product_id', form.dataset.productId);
+        const current = ++seq;
+        try {
+            const response = await fetch(`/admin/api/pricing/preview?${params}`);
+            const result = await response.json();
+            if (current !== seq) return; // já há um pedido mais recente
+            if (!response.ok) throw new Error(result.error || 'Erro ao calcular o preço.');
+            box.classList.toggle('bg-yellow-100', result.margem_minima_aplicada);
+            let text = `Preço sugerido: R$ ${result.preco.toFixed(2)} · margem R$ ${result.margem.toFixed(2)} (${result.margem_percentual.toFixed(1)}%) · impostos R$ ${result.impostos.toFixed(2)}`;
+            text += `\n${result.estrategia} (${pricePreviewOrigins[result.origem] || result.origem}), a partir do custo de R$ ${result.custo.toFixed(2)}.`;
+            if (result.margem_minima_aplicada) text += ' O preço subiu para cumprir a margem mínima.';
+            box.textContent = text;
+            box.style.whiteSpace = 'pre-line';
+        } catch (error) {
+            if (current === seq) box.textContent = error.message;
+        }
+    };
+    const schedule = () => {
+        clearTimeout(timer);
+        timer = setTimeout(update, 300);
+    };
+    form.addEventListener('input', schedule);
+    form.addEventListener('change', schedule);
+    form.updatePricePreview = update;
+}
+
 // Função para abrir e preencher o modal de edição de utilizador.
 function openEditUserModal(userId, name, email, role, filialId) {
     const modal = document.getElementById('editUserModal');
@@ -152,7 +262,7 @@ function openEditProductModal(productJson) {
     modal.querySelector('form').action = `/admin/products/edit/${product.ID}`;
     modal.querySelector('input[name="name"]').value = product.Nome;
     modal.querySelector('input[name="barcode"]').value = product.CodigoBarras;
-    modal.querySelector('select[name="categoria"]').value = product.Categoria || ''; // CORREÇÃO
+    modal.querySelector('select[name="categoria_id"]').value = product.CategoriaID || '';
     modal.querySelector('input[name="codigo_cnae"]').value = product.CodigoCNAE || ''; // ATUALIZADO    
     modal.querySelector('textarea[name="description"]').value = product.Descricao;
     modal.querySelector('input[name="preco_custo"]').value = product.PrecoCusto;
@@ -160,6 +270,27 @@ function openEditProductModal(productJson) {
     modal.querySelector('input[name="imposto_estadual"]').value = product.ImpostoEstadual;
     modal.querySelector('input[name="imposto_federal"]').value = product.ImpostoFederal;
 
+    const strategy = product.EstrategiaPreco || {};
+    modal.querySelector('select[name="estrategia_metodo"]').value = strategy.metodo || '';
+    modal.querySelector('select[name="estrategia_impostos"]').value = strategy.impostos || 'fora';
+    modal.querySelector('select[name="estrategia_arredondamento"]').value = strategy.arredondamento || '';
+    modal.querySelector('input[name="estrategia_margem_minima"]').value = strategy.margem_minima || '';
+    modal.querySelector('input[name="ncm"]').value = product.NCM || '';
+    modal.querySelector('input[name="cest"]').value = product.CEST || '';
+    modal.querySelector('input[name="cfop"]').value = product.CFOP || '';
+    modal.querySelector('select[name="origem"]').value = String(product.Origem || 0);
+    modal.querySelector('select[name="cst_icms"]').value = product.CSTICMS || '';
+    modal.querySelector('select[name="cst_pis"]').value = product.CSTPIS || '';
+    modal.querySelector('select[name="cst_cofins"]').value = product.CSTCOFINS || '';
+    const ncmInput = modal.querySelector('input[name="ncm"]');
+    if (ncmInput.searchNCM) ncmInput.searchNCM();
+    modal.querySelector('select[name="unidade"]').value = product.Unidade || 'UN';
+    modal.querySelector('select[name="unidade_compra"]').value = product.UnidadeCompra || '';
+    modal.querySelector('input[name="fator_compra"]').value = product.FatorCompra || 1;
+    const form = modal.querySelector('form');
+    form.dataset.productId = product.ID;
+    if (form.updatePricePreview) form.updatePricePreview();
+
     openModal('editProductModal');
 }
 
@@ -178,3 +309,24 @@ function openEditSocioModal(socio) {
 
     openModal('editSocioModal');
 }
+
+function openProductImageModal(productId, productName, imageUrl) {
+    const modal = document.getElementById('productImageModal');
+    if (!modal) return;
+
+    document.getElementById('productImageName').textContent = productName;
+    document.getElementById('productImageUploadForm').action = `/admin/products/imagem/${productId}`;
+    document.getElementById('productImageUploadForm').reset();
+    const deleteForm = document.getElementById('productImageDeleteForm');
+    deleteForm.action = `/admin/products/imagem/${productId}/delete`;
+
+    const preview = document.getElementById('productImagePreview');
+    const empty = document.getElementById('productImageEmpty');
+    preview.classList.toggle('hidden', !imageUrl);
+    deleteForm.classList.toggle('hidden', !imageUrl);
+    empty.classList.toggle('hidden', !!imageUrl);
+    preview.src = imageUrl || '';
+    preview.alt = productName;
+
+    openModal('productImageModal');
+}
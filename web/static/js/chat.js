@@ -112,6 +112,13 @@ document.addEventListener('DOMContentLoaded', () => {
         },
         async getProductDetails(identifier) {
             return await fetch(`/api/products/details?identifier=${identifier}`).then(res => res.json());
+        },
+        async getSlowMovingStock(dias, filial, limit, max_vendas) {
+            let url = `/api/stock/slow-moving?dias=${dias || 90}&limit=${limit || 10}&max_vendas=${max_vendas || 0}`;
+            if (filial) {
+                url += `&filial=${encodeURIComponent(filial)}`;
+            }
+            return await fetch(url).then(res => res.json());
         }
     };
 
@@ -139,7 +146,7 @@ document.addEventListener('DOMContentLoaded', () => {
                     parameters: {
                         type: "OBJECT",
                         properties: {
-                            category: { type: "STRING", description: "A categoria do produto a ser pesquisada." },
+                            category: { type: "STRING", description: "O departamento, categoria ou subcategoria a pesquisar; inclui as subcategorias." },
                             min_price: { type: "NUMBER", description: "O preço mínimo para o filtro." }
                         },
                         required: ["category", "min_price"]
@@ -168,6 +175,19 @@ document.addEventListener('DOMContentLoaded', () => {
                         },
                         required: ["identifier"]
                     }
+                },
+                {
+                    name: "getSlowMovingStock",
+                    description: "Lista o stock parado: produtos com saldo sem vendas (ou com poucas vendas) nos últimos N dias, com o valor parado a custo, os dias desde a última venda, a cobertura em dias e a filial sugerida para transferência.",
+                    parameters: {
+                        type: "OBJECT",
+                        properties: {
+                            dias: { type: "NUMBER", description: "O período analisado em dias. Padrão é 90." },
+                            filial: { type: "STRING", description: "O nome da filial para filtrar. Se omitido, busca em todas as filiais." },
+                            limit: { type: "NUMBER", description: "O número de produtos a retornar, do maior para o menor valor parado. Padrão é 10." },
+                            max_vendas: { type: "NUMBER", description: "Máximo de unidades vendidas no período para o produto contar como parado. Padrão é 0 (sem vendas)." }
+                        }
+                    }
                 }
             ]
         }];
@@ -218,7 +238,7 @@ document.addEventListener('DOMContentLoaded', () => {
                 role: "system",
                 parts: [{ text: `
                     Você é um assistente de negócios. Se o utilizador perguntar "quem é você?", apresente-se e descreva as suas capacidades com base nas ferramentas que conhece.
-                    As suas ferramentas são: getSalesSummary, filterProducts, getTopSellers, getLowStockProducts, e getProductDetails. Se for admin ou vendedor, também tem acesso a getTopBillingBranch, getSalesSummaryByBranch, e getTopSellerByPeriod.
+                    As suas ferramentas são: getSalesSummary, filterProducts, getTopSellers, getLowStockProducts, getProductDetails e getSlowMovingStock. Se for admin ou vendedor, também tem acesso a getTopBillingBranch, getSalesSummaryByBranch, e getTopSellerByPeriod.
                 `}]
             }
         };
@@ -249,6 +269,8 @@ document.addEventListener('DOMContentLoaded', () => {
                 toolResult = await tools.getSalesSummaryByBranch(args.period, args.branch);
             } else if (name === 'getProductDetails') {
                 toolResult = await tools.getProductDetails(args.identifier);
+            } else if (name === 'getSlowMovingStock') {
+                toolResult = await tools.getSlowMovingStock(args.dias, args.filial, args.limit, args.max_vendas);
             }
             
             chatHistory.push({ role: "model", parts: [{ functionCall: { name, args } }] });
@@ -274,14 +296,15 @@ document.addEventListener('DOMContentLoaded', () => {
             3. getTopSellers()
             4. getLowStockProducts(limit: number, filial?: string)
             5. getProductDetails(identifier: string)
+            6. getSlowMovingStock(dias?: number, filial?: string, limit?: number, max_vendas?: number) -> stock parado, sem vendas ou com vendas até max_vendas nos últimos dias.
         `;
 
         if (userRole === 'admin' || userRole === 'vendedor') {
             systemPrompt += `
             Ferramentas adicionais para si:
-            6. getTopBillingBranch(period: string) -> period pode ser 'day', 'week', 'month'.
-            7. getSalesSummaryByBranch(period: string, branch: string)
-            8. getTopSellerByPeriod(period: string)
+            7. getTopBillingBranch(period: string) -> period pode ser 'day', 'week', 'month'.
+            8. getSalesSummaryByBranch(period: string, branch: string)
+            9. getTopSellerByPeriod(period: string)
             `;
         }
 
@@ -292,6 +315,7 @@ document.addEventListener('DOMContentLoaded', () => {
             - Para getTopSellers, responda APENAS com: {"functionCall": "getTopSellers"}
             - Para getLowStockProducts, responda APENAS com: {"functionCall": "getLowStockProducts", "limit": ..., "filial": "..."}
             - Para getProductDetails, responda APENAS com: {"functionCall": "getProductDetails", "identifier": "..."}
+            - Para getSlowMovingStock, responda APENAS com: {"functionCall": "getSlowMovingStock", "dias": ..., "filial": "...", "limit": ..., "max_vendas": ...}
             - Para getTopBillingBranch, responda APENAS com: {"functionCall": "getTopBillingBranch", "period": "..."}
             - Para getSalesSummaryByBranch, responda APENAS com: {"functionCall": "getSalesSummaryByBranch", "period": "...", "branch": "..."}
             - Para getTopSellerByPeriod, responda APENAS com: {"functionCall": "getTopSellerByPeriod", "period": "..."}
@@ -424,10 +448,36 @@ document.addEventListener('DOMContentLoaded', () => {
                             `- Preço de Venda: R$ ${product.PrecoSugerido.toFixed(2)}\n` +
                             `- Margem de Lucro: ${product.PercentualLucro.toFixed(2)}%\n` +
                             `- Estoque Total (todas as filiais): ${product.TotalEstoque} unidades`;
+                        if (product.QuantidadeEmbalagem > 1) {
+                            dataPrompt += `\n- O código '${identifier}' é de uma embalagem com ${product.QuantidadeEmbalagem} unidades`;
+                        }
                     }
                     toolCalled = true;
                     break;
                 }
+                case 'getSlowMovingStock': {
+                    const { dias, filial, limit, max_vendas } = parsedResponse;
+                    const items = await tools.getSlowMovingStock(dias, filial, limit, max_vendas);
+                    if (!items || items.length === 0) {
+                        dataPrompt = `Não encontrei stock parado nos últimos ${dias || 90} dias para os filtros selecionados.`;
+                    } else if (items.error) {
+                        dataPrompt = items.error;
+                    } else {
+                        dataPrompt = `Aqui está o stock parado (últimos ${dias || 90} dias), do maior para o menor valor a custo:\n`;
+                        items.forEach(item => {
+                            const ultima = item.dias_sem_venda < 0 ? 'nunca vendeu' : `última venda há ${item.dias_sem_venda} dias`;
+                            const cobertura = item.cobertura_dias < 0 ? 'sem vendas no período' : `cobertura de ${item.cobertura_dias.toFixed(0)} dias`;
+                            dataPrompt += `- ${item.produto_nome} (${item.filial_nome}): ${item.quantidade} un., R$ ${item.valor_parado.toFixed(2)} parados, ${ultima}, ${cobertura}`;
+                            if (item.filial_sugerida_nome) {
+                                dataPrompt += `; sugerido transferir para '${item.filial_sugerida_nome}' (${item.vendas_filial_sugerida} un. vendidas)`;
+                            }
+                            dataPrompt += '\n';
+                        });
+                    }
+                    dataPrompt += "\nApresente esta informação de forma clara ao utilizador.";
+                    toolCalled = true;
+                    break;
+                }
             }
 
             if (toolCalled) {
@@ -0,0 +1,76 @@
+document.addEventListener('DOMContentLoaded', () => {
+    const container = document.getElementById('import-job');
+    if (!container) return;
+
+    const jobId = container.dataset.job;
+    const title = document.getElementById('import-title');
+    const status = document.getElementById('import-status');
+    const progress = document.getElementById('import-progress');
+    const onlyRejected = document.getElementById('import-only-rejected');
+    const body = document.getElementById('import-results-body');
+    const actionLabels = { criar: 'Criar', atualizar: 'Atualizar', rejeitar: 'Rejeitar' };
+    let results = [];
+
+    const renderResults = () => {
+        body.innerHTML = '';
+        const rows = onlyRejected.checked ? results.filter(r => r.acao === 'rejeitar') : results;
+        rows.forEach(r => {
+            const row = document.createElement('tr');
+            row.className = 'border-b hover:bg-gray-50' + (r.acao === 'rejeitar' ? ' text-red-700' : '');
+            [r.linha, r.codigo_barras, r.nome, actionLabels[r.acao] || r.acao, r.erro || ''].forEach(text => {
+                const cell = document.createElement('td');
+                cell.className = 'py-2 px-4 text-sm';
+                cell.textContent = text;
+                row.appendChild(cell);
+            });
+            body.appendChild(row);
+        });
+        if (rows.length === 0) {
+            const row = document.createElement('tr');
+            row.innerHTML = '<td colspan="5" class="text-center py-4">Nenhuma linha a mostrar.</td>';
+            body.appendChild(row);
+        }
+    };
+    onlyRejected.addEventListener('change', renderResults);
+
+    const showReport = (job) => {
+        const report = job.relatorio || {};
+        const simulation = report.simulacao;
+        title.textContent = simulation ? `Simulação de ${job.ficheiro}` : `Importação de ${job.ficheiro}`;
+        if (job.erro) {
+            status.textContent = `A importação parou com um erro: ${job.erro}. Os blocos anteriores ficaram gravados.`;
+            status.className = 'text-red-600 mb-2';
+        } else {
+            status.textContent = simulation
+                ? 'Simulação concluída: nada foi gravado. Importe de novo sem a simulação para aplicar.'
+                : 'Importação concluída.';
+        }
+        document.getElementById('import-created').textContent = report.criados || 0;
+        document.getElementById('import-updated').textContent = report.atualizados || 0;
+        document.getElementById('import-rejected').textContent = report.rejeitados || 0;
+        document.getElementById('import-summary').classList.remove('hidden');
+        document.getElementById('import-results').classList.remove('hidden');
+        results = report.resultados || [];
+        renderResults();
+    };
+
+    const poll = async () => {
+        try {
+            const response = await fetch(`/admin/api/produtos/importar/${encodeURIComponent(jobId)}`);
+            const job = await response.json();
+            if (!response.ok) throw new Error(job.error || 'Falha ao obter o progresso.');
+            const percent = job.total > 0 ? Math.round(job.feitas * 100 / job.total) : 100;
+            progress.style.width = `${job.concluida ? 100 : percent}%`;
+            if (job.concluida) {
+                showReport(job);
+                return;
+            }
+            status.textContent = `${job.feitas} de ${job.total} linhas tratadas (${percent}%).`;
+            setTimeout(poll, 1000);
+        } catch (error) {
+            status.textContent = error.message;
+            status.className = 'text-red-600 mb-2';
+        }
+    };
+    poll();
+});
@@ -11,6 +11,8 @@ document.addEventListener('DOMContentLoaded', () => {
     const kpiTransactions = document.getElementById('kpi-transactions');
     const kpiAvgTicket = document.getElementById('kpi-avg-ticket');
     const kpiStockValue = document.getElementById('kpi-stock-value');
+    const kpiDaysInventory = document.getElementById('kpi-days-inventory');
+    const kpiAvgInventory = document.getElementById('kpi-avg-inventory');
 
     const formatCurrency = (value) => (value || 0).toLocaleString('pt-BR', { style: 'currency', currency: 'BRL' });
     const formatPercent = (value) => `${(value || 0).toFixed(2)}%`;
@@ -26,6 +28,55 @@ document.addEventListener('DOMContentLoaded', () => {
     if (dashboardData.FinancialKPIs) {
         if (kpiProfitMargin) kpiProfitMargin.textContent = formatPercent(dashboardData.FinancialKPIs.gross_profit_margin);
         if (kpiInventoryTurnover) kpiInventoryTurnover.textContent = formatTurnover(dashboardData.FinancialKPIs.inventory_turnover);
+        if (kpiDaysInventory) kpiDaysInventory.textContent = `${Math.round(dashboardData.FinancialKPIs.days_of_inventory || 0)} dias`;
+        if (kpiAvgInventory) {
+            const kpis = dashboardData.FinancialKPIs;
+            kpiAvgInventory.textContent = kpis.snapshot_days > 0
+                ? `Estoque médio: ${formatCurrency(kpis.average_inventory_value)} (${kpis.snapshot_days} dias com fotografia)`
+                : `Sem fotografias no período: usado o valor atual (${formatCurrency(kpis.average_inventory_value)})`;
+        }
+    }
+
+    // --- Valor do estoque numa data passada (fotografias diárias) ---
+    const stockValueBtn = document.getElementById('stock-value-btn');
+    const stockValueDate = document.getElementById('stock-value-date');
+    if (stockValueBtn && stockValueDate) {
+        stockValueDate.valueAsDate = new Date();
+        stockValueBtn.addEventListener('click', async () => {
+            const summary = document.getElementById('stock-value-summary');
+            const body = document.getElementById('stock-value-body');
+            body.innerHTML = '';
+            try {
+                const response = await fetch(`/admin/api/stock/value?data=${encodeURIComponent(stockValueDate.value)}`);
+                const result = await response.json();
+                if (!response.ok) throw new Error(result.error || 'Falha ao obter o valor do estoque.');
+                const items = result.itens || [];
+                if (items.length === 0) {
+                    summary.textContent = 'Não existe nenhuma fotografia do estoque até esta data.';
+                    return;
+                }
+                summary.textContent = `Fotografia de ${new Date(items[0].data).toLocaleDateString('pt-BR', { timeZone: 'UTC' })}: ${formatCurrency(result.total)} no total.`;
+                items.forEach(item => {
+                    const row = document.createElement('tr');
+                    row.className = 'border-b hover:bg-gray-50';
+                    [item.filial_nome, item.categoria].forEach(text => {
+                        const cell = document.createElement('td');
+                        cell.className = 'py-2 px-4';
+                        cell.textContent = text;
+                        row.appendChild(cell);
+                    });
+                    [formatNumber(item.quantidade), formatCurrency(item.valor)].forEach(text => {
+                        const cell = document.createElement('td');
+                        cell.className = 'py-2 px-4 text-right font-mono';
+                        cell.textContent = text;
+                        row.appendChild(cell);
+                    });
+                    body.appendChild(row);
+                });
+            } catch (error) {
+                summary.textContent = error.message;
+            }
+        });
     }
     
     // --- Fim da atualização ---
@@ -33,10 +84,22 @@ document.addEventListener('DOMContentLoaded', () => {
     // Seletor de Período
     const periodSelector = document.getElementById('period-select');
     periodSelector.addEventListener('change', (e) => {
-        const selectedPeriod = e.target.value;
-        window.location.search = `?period=${selectedPeriod}`;
+        const params = new URLSearchParams(window.location.search);
+        params.set('period', e.target.value);
+        window.location.search = params.toString();
     });
 
+    // Composição do estoque por categoria: escolher uma categoria mostra as suas subcategorias.
+    const showCategory = (categoryId) => {
+        const params = new URLSearchParams(window.location.search);
+        if (categoryId) params.set('categoria_id', categoryId); else params.delete('categoria_id');
+        window.location.search = params.toString();
+    };
+    const categorySelector = document.getElementById('category-select');
+    if (categorySelector) {
+        categorySelector.addEventListener('change', (e) => showCategory(e.target.value));
+    }
+
     // Gráfico 1: Vendas por Filial
     const salesCtx = document.getElementById('salesByBranchChart');
     if (salesCtx && dashboardData.SalesByBranch) {
@@ -87,7 +150,16 @@ document.addEventListener('DOMContentLoaded', () => {
                     backgroundColor: ['#3B82F6', '#10B981', '#F59E0B', '#8B5CF6', '#EF4444', '#6B7280'],
                 }]
             },
-            options: { responsive: true, maintainAspectRatio: false }
+            options: {
+                responsive: true,
+                maintainAspectRatio: false,
+                // Clicar numa fatia desce para as subcategorias dessa categoria.
+                onClick: (event, elements) => {
+                    if (elements.length === 0) return;
+                    const item = stockComp[elements[0].index];
+                    if (item && item.category_id) showCategory(item.category_id);
+                }
+            }
         });
     }
 });
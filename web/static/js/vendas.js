@@ -3,6 +3,7 @@ document.addEventListener('DOMContentLoaded', () => {
     const searchResults = document.getElementById('search-results');
     const filialSelector = document.getElementById('filial-selector');
     let debounceTimer;
+    let searchController;
     let cart = [];
 
     // Adiciona um listener para o seletor de filial, se ele existir
@@ -23,7 +24,7 @@ document.addEventListener('DOMContentLoaded', () => {
             } else {
                 searchResults.classList.add('hidden');
             }
-        }, 300);
+        }, 150);
     });
 
     // CORREÇÃO: Nova função auxiliar para obter o ID da filial de forma segura.
@@ -45,12 +46,17 @@ document.addEventListener('DOMContentLoaded', () => {
             console.error("Nenhuma filial selecionada.");
             return;
         }
+        // Cancela a pesquisa anterior, para que uma resposta atrasada não substitua a atual.
+        if (searchController) searchController.abort();
+        searchController = new AbortController();
         try {
-            const response = await fetch(`/api/products/search?q=${query}&filial_id=${selectedFilialId}`);
+            const params = new URLSearchParams({ q: query, filial_id: selectedFilialId });
+            const response = await fetch(`/api/products/search?${params}`, { signal: searchController.signal });
             if (!response.ok) throw new Error('Erro na busca');
             const products = await response.json();
             displaySearchResults(products);
         } catch (error) {
+            if (error.name === 'AbortError') return;
             console.error('Falha ao buscar produtos:', error);
             searchResults.innerHTML = '<div class="p-3 text-red-500">Erro ao buscar.</div>';
             searchResults.classList.remove('hidden');
@@ -62,26 +68,91 @@ document.addEventListener('DOMContentLoaded', () => {
         if (!products || products.length === 0) {
             searchResults.innerHTML = '<div class="p-3 text-gray-500">Nenhum produto encontrado.</div>';
         } else {
+            // As variantes do mesmo produto aparecem numa só linha, com um botão por variante.
+            const groups = new Map();
             products.forEach(product => {
-                const div = document.createElement('div');
-                div.className = 'p-3 hover:bg-gray-100 cursor-pointer border-b';
-                div.textContent = `${product.Nome} - R$ ${product.PrecoSugerido.toFixed(2)}`;
-                div.onclick = () => addProductToCart(product);
-                searchResults.appendChild(div);
+                const key = product.ProdutoPaiID || product.ID;
+                if (!groups.has(key)) groups.set(key, []);
+                groups.get(key).push(product);
+            });
+            groups.forEach(items => {
+                if (items.length === 1 && !items[0].ProdutoPaiID) {
+                    const product = items[0];
+                    const div = document.createElement('div');
+                    div.className = 'p-3 hover:bg-gray-100 cursor-pointer border-b flex items-center';
+                    const label = document.createElement('span');
+                    label.textContent = `${product.Nome} - R$ ${product.PrecoSugerido.toFixed(2)}`;
+                    if (product.QuantidadeEmbalagem > 1) {
+                        label.textContent += ` (embalagem de ${product.QuantidadeEmbalagem})`;
+                    }
+                    if (product.Kit) {
+                        label.textContent += ' (kit)';
+                    }
+                    div.append(productThumbnail(product), label);
+                    div.onclick = () => addProductToCart(product);
+                    searchResults.appendChild(div);
+                    return;
+                }
+                searchResults.appendChild(renderVariantChoice(items));
             });
         }
         searchResults.classList.remove('hidden');
     }
 
+    function variantLabel(product) {
+        if (!product.Atributos || product.Atributos.length === 0) return product.Nome;
+        return product.Atributos.map(a => a.valor).join(' / ');
+    }
+
+    // Miniatura do produto, para distinguir artigos parecidos; sem imagem fica um espaço vazio
+    // do mesmo tamanho, para os nomes ficarem alinhados.
+    function productThumbnail(product) {
+        const box = document.createElement('span');
+        box.className = 'w-10 h-10 mr-3 flex-shrink-0 inline-flex items-center justify-center rounded bg-gray-100 overflow-hidden';
+        if (product.MiniaturaURL) {
+            const img = document.createElement('img');
+            img.src = product.MiniaturaURL;
+            img.alt = '';
+            img.className = 'w-full h-full object-contain';
+            box.appendChild(img);
+        }
+        return box;
+    }
+
+    function renderVariantChoice(variants) {
+        const div = document.createElement('div');
+        div.className = 'p-3 border-b';
+        const title = document.createElement('div');
+        title.className = 'font-semibold mb-2 flex items-center';
+        const text = document.createElement('span');
+        text.textContent = `${variants[0].NomePai} - escolha a variante:`;
+        title.append(productThumbnail(variants[0]), text);
+        div.appendChild(title);
+        const options = document.createElement('div');
+        options.className = 'flex flex-wrap gap-2';
+        variants.forEach(variant => {
+            const btn = document.createElement('button');
+            btn.type = 'button';
+            btn.className = 'px-3 py-1 border rounded hover:bg-blue-100 text-sm';
+            btn.textContent = `${variantLabel(variant)} - R$ ${variant.PrecoSugerido.toFixed(2)}`;
+            btn.onclick = () => addProductToCart(variant);
+            options.appendChild(btn);
+        });
+        div.appendChild(options);
+        return div;
+    }
+
     function addProductToCart(product) {
         searchInput.value = '';
         searchResults.classList.add('hidden');
 
+        // O código de uma embalagem (p.ex. a caixa de 12) acrescenta todas as unidades dela.
+        const units = product.QuantidadeEmbalagem || 1;
         const existingItem = cart.find(item => item.ID === product.ID);
         if (existingItem) {
-            existingItem.quantity++;
+            existingItem.quantity += units;
         } else {
-            cart.push({ ...product, quantity: 1 });
+            cart.push({ ...product, quantity: units });
         }
         renderCart();
     }